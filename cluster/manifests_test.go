@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// TestManifestsParse makes sure that every manifest can be applied, since a single invalid
+// document fails all the documents of the folder.
+func TestManifestsParse(t *testing.T) {
+	err := filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".yml") {
+			return nil
+		}
+
+		t.Run(path, func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+			for i := 0; ; i++ {
+				doc, err := reader.Read()
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.TrimSpace(string(doc)) == "" {
+					continue
+				}
+
+				var object map[string]interface{}
+				if err := yaml.UnmarshalStrict(doc, &object); err != nil {
+					t.Fatalf("document %d is invalid: %v", i, err)
+				}
+				if object == nil {
+					continue
+				}
+				if object["apiVersion"] == nil || object["kind"] == nil {
+					t.Errorf("document %d has no apiVersion or kind", i)
+				}
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
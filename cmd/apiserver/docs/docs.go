@@ -252,6 +252,20 @@ const docTemplate = `{
               "schema": {
                 "$ref": "#/components/schemas/Bulks"
               }
+            },
+            {
+              "name": "cascade",
+              "in": "query",
+              "description": "How the calculations of the bulk are deleted, orphan keeps them",
+              "required": false,
+              "schema": {
+                "type": "string",
+                "enum": [
+                  "orphan",
+                  "background",
+                  "foreground"
+                ]
+              }
             }
           ],
           "responses": {
@@ -371,6 +385,20 @@ const docTemplate = `{
               "schema": {
                 "$ref": "#/components/schemas/WorkerPool"
               }
+            },
+            {
+              "name": "cascade",
+              "in": "query",
+              "description": "How the dependents of the workerpool are deleted, orphan keeps them",
+              "required": false,
+              "schema": {
+                "type": "string",
+                "enum": [
+                  "orphan",
+                  "background",
+                  "foreground"
+                ]
+              }
             }
           ],
           "responses": {
@@ -382,6 +410,46 @@ const docTemplate = `{
             }
           }
         }
+      },
+      "/workerpool/{workerPoolId}/drain/{node}": {
+        "post": {
+          "tags": [
+            "WorkerPools"
+          ],
+          "summary": "Drain a worker",
+          "description": "Set the worker on the node to draining, so it finishes its calculation and leaves the workerpool",
+          "parameters": [
+            {
+              "name": "workerPoolId",
+              "in": "path",
+              "description": "A workerpool name",
+              "required": true,
+              "schema": {
+                "type": "string"
+              }
+            },
+            {
+              "name": "node",
+              "in": "path",
+              "description": "The node of the worker",
+              "required": true,
+              "schema": {
+                "type": "string"
+              }
+            }
+          ],
+          "responses": {
+            "200": {
+              "description": "The worker is draining"
+            },
+            "400": {
+              "description": "Invalid request"
+            },
+            "404": {
+              "description": "The workerpool or the worker doesn't exist"
+            }
+          }
+        }
       }
     }
   }`
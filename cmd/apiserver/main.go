@@ -17,6 +17,7 @@ import (
 	_ "github.com/vega-project/ccb-operator/cmd/apiserver/docs"
 
 	"github.com/vega-project/ccb-operator/pkg/grpc"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
 	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
@@ -25,6 +26,7 @@ type options struct {
 	namespace string
 
 	grpcClientOptions grpc.Options
+	tracingOptions    tracing.Options
 }
 
 func gatherOptions() options {
@@ -34,10 +36,14 @@ func gatherOptions() options {
 	fs.IntVar(&o.port, "port", 8080, "Port number where the server will listen to")
 	fs.StringVar(&o.namespace, "namespace", "vega", "The namespace where the calculations exist.")
 	o.grpcClientOptions.Bind(fs)
+	o.tracingOptions.Bind(fs)
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		logrus.WithError(err).Fatal("couldn't parse arguments")
 	}
+	if err := o.tracingOptions.Validate(); err != nil {
+		logrus.WithError(err).Fatal("invalid options")
+	}
 	return o
 }
 
@@ -47,6 +53,12 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shutdownTracing, err := o.tracingOptions.Setup(ctx, "vega-apiserver")
+	if err != nil {
+		logrus.WithError(err).Fatal("couldn't set up tracing")
+	}
+	defer shutdownTracing(context.Background())
+
 	clusterConfig, err := util.LoadClusterConfig()
 	if err != nil {
 		logrus.WithError(err).Fatal("could not load cluster clusterConfig")
@@ -57,7 +69,7 @@ func main() {
 		logrus.WithError(err).Fatal("failed to create client")
 	}
 
-	grpcClient, err := grpc.NewClient(o.grpcClientOptions.Address())
+	grpcClient, err := grpc.NewClient(o.grpcClientOptions)
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to construct grpc client")
 	}
@@ -90,6 +102,7 @@ func main() {
 	r.GET("/workerpool/:id", s.getWorkerPoolByName)
 	r.POST("workerpool/create", s.createWorkerPool)
 	r.DELETE("/workerpools/delete/:id", s.deleteWorkerPool)
+	r.POST("/workerpool/:id/drain/:worker", s.drainWorker)
 	r.POST("/results", s.getResults)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -7,9 +7,14 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -21,6 +26,8 @@ import (
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
 	"github.com/vega-project/ccb-operator/pkg/grpc"
+	"github.com/vega-project/ccb-operator/pkg/results"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
 	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
@@ -49,6 +56,12 @@ func (s *server) createCalculationBulk(c *gin.Context) {
 		responseError(c, "couldn't unmarshal body", err)
 	}
 
+	// The trace of the bulk starts here, and is continued by the components that reconcile it.
+	ctx, span := tracing.Tracer().Start(c.Request.Context(), "CreateCalculationBulk", trace.WithAttributes(
+		attribute.String("vega.bulk", bulkName),
+		attribute.Int("vega.calculations", len(bulkCalcs.Calculations)),
+	))
+
 	s.logger.Info("Creating calculation bulk...")
 	bulk := &bulkv1.CalculationBulk{
 		ObjectMeta:   metav1.ObjectMeta{Name: bulkName, Namespace: s.namespace},
@@ -56,8 +69,11 @@ func (s *server) createCalculationBulk(c *gin.Context) {
 		Calculations: bulkCalcs.Calculations,
 		Status:       bulkv1.CalculationBulkStatus{State: bulkv1.CalculationBulkAvailableState},
 	}
+	tracing.Inject(ctx, bulk)
 
-	if err := s.client.Create(s.ctx, bulk); err != nil {
+	err = s.client.Create(ctx, bulk)
+	tracing.End(span, err)
+	if err != nil {
 		responseError(c, "couldn't create calculation bulk", err)
 	} else {
 		c.JSON(http.StatusOK, gin.H{"data": bulk})
@@ -93,8 +109,33 @@ func (s *server) createWorkerPool(c *gin.Context) {
 
 }
 
+// deleteOptions returns the options of the deletion from the cascade query parameter, which is
+// the propagation policy of the deletion to the dependents of the object: orphan, background or
+// foreground. The default policy of the cluster is used if it's missing.
+func deleteOptions(c *gin.Context) ([]ctrlruntimeclient.DeleteOption, error) {
+	var policy metav1.DeletionPropagation
+	switch cascade := c.Query("cascade"); cascade {
+	case "":
+		return nil, nil
+	case "orphan":
+		policy = metav1.DeletePropagationOrphan
+	case "background":
+		policy = metav1.DeletePropagationBackground
+	case "foreground":
+		policy = metav1.DeletePropagationForeground
+	default:
+		return nil, fmt.Errorf("invalid cascade %q, expected orphan, background or foreground", cascade)
+	}
+	return []ctrlruntimeclient.DeleteOption{ctrlruntimeclient.PropagationPolicy(policy)}, nil
+}
+
 func (s *server) deleteCalculationBulk(c *gin.Context) {
 	calcBulkName := c.Param("id")
+	opts, err := deleteOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response(err.Error(), http.StatusBadRequest))
+		return
+	}
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		bulk := &bulkv1.CalculationBulk{}
 		err := s.client.Get(s.ctx, ctrlruntimeclient.ObjectKey{Namespace: s.namespace, Name: calcBulkName}, bulk)
@@ -102,7 +143,7 @@ func (s *server) deleteCalculationBulk(c *gin.Context) {
 			return err
 		}
 
-		if err := s.client.Delete(s.ctx, bulk); err != nil {
+		if err := s.client.Delete(s.ctx, bulk, opts...); err != nil {
 			responseError(c, fmt.Sprintf("failed to delete the calculation bulk %s", calcBulkName), err)
 			return err
 		} else {
@@ -116,6 +157,11 @@ func (s *server) deleteCalculationBulk(c *gin.Context) {
 
 func (s *server) deleteWorkerPool(c *gin.Context) {
 	workerPoolName := c.Param("id")
+	opts, err := deleteOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response(err.Error(), http.StatusBadRequest))
+		return
+	}
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		workerpool := &workersv1.WorkerPool{}
 		err := s.client.Get(s.ctx, ctrlruntimeclient.ObjectKey{Namespace: s.namespace, Name: workerPoolName}, workerpool)
@@ -123,7 +169,7 @@ func (s *server) deleteWorkerPool(c *gin.Context) {
 			return err
 		}
 
-		if err := s.client.Delete(s.ctx, workerpool); err != nil {
+		if err := s.client.Delete(s.ctx, workerpool, opts...); err != nil {
 			responseError(c, fmt.Sprintf("failed to delete the workerpool %s", workerPoolName), err)
 			return err
 		} else {
@@ -135,15 +181,52 @@ func (s *server) deleteWorkerPool(c *gin.Context) {
 	}
 }
 
+// drainWorker sets the worker on the node to draining in the pool. The dispatcher stops assigning
+// calculations to it, and the worker leaves the pool once its calculation finished.
+func (s *server) drainWorker(c *gin.Context) {
+	workerPoolName, node := c.Param("id"), c.Param("worker")
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool := &workersv1.WorkerPool{}
+		if err := s.client.Get(s.ctx, ctrlruntimeclient.ObjectKey{Namespace: s.namespace, Name: workerPoolName}, pool); err != nil {
+			return err
+		}
+		worker, exists := pool.Spec.Workers[node]
+		if !exists {
+			return kerrors.NewNotFound(workersv1.Resource("workers"), node)
+		}
+		if worker.State == workersv1.WorkerDrainingState {
+			return nil
+		}
+		worker.State = workersv1.WorkerDrainingState
+		worker.LastUpdateTime = &metav1.Time{Time: time.Now()}
+		pool.Spec.Workers[node] = worker
+		util.SetWorkerPoolConditions(pool)
+		return s.client.Update(s.ctx, pool)
+	})
+	switch {
+	case kerrors.IsNotFound(err):
+		c.JSON(http.StatusNotFound, response(err.Error(), http.StatusNotFound))
+	case err != nil:
+		responseError(c, fmt.Sprintf("failed to drain the worker %s", node), err)
+	default:
+		c.JSON(http.StatusOK, response(fmt.Sprintf("worker %s of workerpool %s is draining", node, workerPoolName), http.StatusOK))
+	}
+}
+
 func (s *server) deleteCalculation(c *gin.Context) {
 	calcID := c.Param("id")
+	opts, err := deleteOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response(err.Error(), http.StatusBadRequest))
+		return
+	}
 	calc := &v1.Calculation{}
-	err := s.client.Get(s.ctx, ctrlruntimeclient.ObjectKey{Namespace: s.namespace, Name: calcID}, calc)
+	err = s.client.Get(s.ctx, ctrlruntimeclient.ObjectKey{Namespace: s.namespace, Name: calcID}, calc)
 	if err != nil {
 		responseError(c, fmt.Sprintf("failed to get calculation %s", calcID), err)
 	}
 
-	if err := s.client.Delete(s.ctx, calc); err != nil {
+	if err := s.client.Delete(s.ctx, calc, opts...); err != nil {
 		responseError(c, "couldn't delete calculation", err)
 	} else {
 		c.JSON(http.StatusOK, response(fmt.Sprintf("calculation %q has been deleted", calcID), http.StatusOK))
@@ -253,15 +336,32 @@ func (s *server) getResults(c *gin.Context) {
 		return
 	}
 
-	res, err := s.grpcClient.GetData(parameters)
+	if err := results.ValidateParameters(parameters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := s.grpcClient.GetData(c.Request.Context(), parameters)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(grpcErrorStatusCode(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, res)
 }
 
+// grpcErrorStatusCode maps the status code of a gRPC error to an HTTP status code.
+func grpcErrorStatusCode(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func response(message string, statusCode int) gin.H {
 	return gin.H{
 		"message":     message,
@@ -15,6 +15,7 @@ import (
 	cmpopts "github.com/google/go-cmp/cmp/cmpopts"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
@@ -23,6 +24,7 @@ import (
 	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
 	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
@@ -1088,6 +1090,57 @@ func TestDeleteCalculationBulk(t *testing.T) {
 	}
 }
 
+func TestDeleteOptions(t *testing.T) {
+	testCases := []struct {
+		cascade       string
+		expected      []ctrlruntimeclient.DeleteOption
+		errorExpected bool
+	}{
+		{cascade: ""},
+		{cascade: "orphan", expected: []ctrlruntimeclient.DeleteOption{ctrlruntimeclient.PropagationPolicy(metav1.DeletePropagationOrphan)}},
+		{cascade: "background", expected: []ctrlruntimeclient.DeleteOption{ctrlruntimeclient.PropagationPolicy(metav1.DeletePropagationBackground)}},
+		{cascade: "foreground", expected: []ctrlruntimeclient.DeleteOption{ctrlruntimeclient.PropagationPolicy(metav1.DeletePropagationForeground)}},
+		{cascade: "everything", errorExpected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.cascade, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("DELETE", fmt.Sprintf("/bulks/delete/bulk?cascade=%s", tc.cascade), nil)
+
+			opts, err := deleteOptions(c)
+			if (err != nil) != tc.errorExpected {
+				t.Fatalf("expected error: %t, got %v", tc.errorExpected, err)
+			}
+			if diff := cmp.Diff(tc.expected, opts); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestDeleteCalculationBulkInvalidCascade(t *testing.T) {
+	bulk := &bulkv1.CalculationBulk{ObjectMeta: metav1.ObjectMeta{Name: "bulk-remains"}}
+	fakeClient := fakectrlruntimeclient.NewClientBuilder().WithObjects(bulk).Build()
+	s := server{
+		logger: logrus.WithField("test-name", t.Name()),
+		ctx:    context.Background(),
+		client: fakeClient,
+	}
+
+	r := gin.Default()
+	r.DELETE("/bulks/delete/:id", s.deleteCalculationBulk)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("DELETE", "/bulks/delete/bulk-remains?cascade=everything", nil))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if err := fakeClient.Get(s.ctx, ctrlruntimeclient.ObjectKeyFromObject(bulk), &bulkv1.CalculationBulk{}); err != nil {
+		t.Fatalf("expected the bulk to remain, got %v", err)
+	}
+}
+
 func TestDeleteWorkerPool(t *testing.T) {
 	testCases := []struct {
 		id                 string
@@ -1201,3 +1254,125 @@ func TestDeleteWorkerPool(t *testing.T) {
 		})
 	}
 }
+
+func TestDrainWorker(t *testing.T) {
+	pool := func(state workersv1.WorkerState) *workersv1.WorkerPool {
+		return &workersv1.WorkerPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "workerpool-1"},
+			Spec: workersv1.WorkerPoolSpec{
+				Workers: map[string]workersv1.Worker{
+					"node-1": {Name: "worker-1", Node: "node-1", State: state},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		id             string
+		url            string
+		initialPools   []ctrlruntimeclient.Object
+		expectedStatus int
+		expectedState  workersv1.WorkerState
+	}{
+		{
+			id:             "the worker is set to draining",
+			url:            "/workerpool/workerpool-1/drain/node-1",
+			initialPools:   []ctrlruntimeclient.Object{pool(workersv1.WorkerProcessingState)},
+			expectedStatus: http.StatusOK,
+			expectedState:  workersv1.WorkerDrainingState,
+		},
+		{
+			id:             "a draining worker keeps draining",
+			url:            "/workerpool/workerpool-1/drain/node-1",
+			initialPools:   []ctrlruntimeclient.Object{pool(workersv1.WorkerDrainingState)},
+			expectedStatus: http.StatusOK,
+			expectedState:  workersv1.WorkerDrainingState,
+		},
+		{
+			id:             "the worker isn't in the pool",
+			url:            "/workerpool/workerpool-1/drain/node-2",
+			initialPools:   []ctrlruntimeclient.Object{pool(workersv1.WorkerAvailableState)},
+			expectedStatus: http.StatusNotFound,
+			expectedState:  workersv1.WorkerAvailableState,
+		},
+		{
+			id:             "the pool doesn't exist",
+			url:            "/workerpool/workerpool-2/drain/node-1",
+			initialPools:   []ctrlruntimeclient.Object{pool(workersv1.WorkerAvailableState)},
+			expectedStatus: http.StatusNotFound,
+			expectedState:  workersv1.WorkerAvailableState,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			fakeClient := fakectrlruntimeclient.NewClientBuilder().WithObjects(tc.initialPools...).Build()
+
+			s := server{
+				logger: logrus.WithField("test-name", tc.id),
+				ctx:    context.Background(),
+				client: fakeClient,
+			}
+
+			req, err := http.NewRequest("POST", tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := gin.Default()
+			r.POST("/workerpool/:id/drain/:worker", s.drainWorker)
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectedStatus, rr.Code, rr.Body)
+			}
+
+			actual := &workersv1.WorkerPool{}
+			if err := fakeClient.Get(s.ctx, ctrlruntimeclient.ObjectKey{Name: "workerpool-1"}, actual); err != nil {
+				t.Fatal(err)
+			}
+			if state := actual.Spec.Workers["node-1"].State; state != tc.expectedState {
+				t.Fatalf("expected the worker to be %s, got %s", tc.expectedState, state)
+			}
+		})
+	}
+}
+
+func TestCreateCalculationBulkStartsTrace(t *testing.T) {
+	exporter, restore := tracing.SetupInMemory()
+	defer restore()
+
+	fakeClient := fakectrlruntimeclient.NewClientBuilder().Build()
+	s := server{
+		logger: logrus.WithField("test-name", t.Name()),
+		ctx:    context.Background(),
+		client: fakeClient,
+	}
+
+	body := `{"worker_pool": "vega-pool", "calculations": {"calc-test-1": {"params": {"log_g": 4, "teff": 10100}}}}`
+	req, err := http.NewRequest("POST", "/bulk/create", bytes.NewBuffer([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.Default()
+	r.POST("/bulk/create", s.createCalculationBulk)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "CreateCalculationBulk" {
+		t.Fatalf("expected a single CreateCalculationBulk span, got %v", spans.Snapshots())
+	}
+
+	var bulkList bulkv1.CalculationBulkList
+	if err := fakeClient.List(s.ctx, &bulkList); err != nil {
+		t.Fatal(err)
+	}
+	if len(bulkList.Items) != 1 {
+		t.Fatalf("expected a single bulk, got %d", len(bulkList.Items))
+	}
+	spanContext := trace.SpanContextFromContext(tracing.Extract(context.Background(), &bulkList.Items[0]))
+	if spanContext.TraceID() != spans[0].SpanContext.TraceID() || spanContext.SpanID() != spans[0].SpanContext.SpanID() {
+		t.Fatalf("expected the bulk to carry the span of its creation, got annotations %v", bulkList.Items[0].Annotations)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 	"os"
@@ -8,6 +9,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	controllerruntime "sigs.k8s.io/controller-runtime"
@@ -15,18 +17,21 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
-	"github.com/vega-project/ccb-operator/pkg/dispatcher/bulks"
-	"github.com/vega-project/ccb-operator/pkg/dispatcher/calculations"
-	"github.com/vega-project/ccb-operator/pkg/dispatcher/factory"
-	"github.com/vega-project/ccb-operator/pkg/dispatcher/workers"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/dispatcher"
 	"github.com/vega-project/ccb-operator/pkg/grpc"
+	"github.com/vega-project/ccb-operator/pkg/leaderelection"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
 	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
 type options struct {
-	namespace         string
-	nfsPath           string
-	grpcClientOptions grpc.Options
+	namespace             string
+	nfsPath               string
+	grpcClientOptions     grpc.Options
+	artifactOptions       artifacts.Options
+	tracingOptions        tracing.Options
+	leaderElectionOptions leaderelection.Options
 }
 
 func gatherOptions() (options, error) {
@@ -34,13 +39,22 @@ func gatherOptions() (options, error) {
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 	fs.StringVar(&o.namespace, "namespace", "vega", "Namespace where the calculations exists.")
-	fs.StringVar(&o.nfsPath, "nfs-path", "/var/tmp/nfs", "Path of the mounted nfs storage.")
+	fs.StringVar(&o.nfsPath, "nfs-path", "/var/tmp/nfs", "Path of the mounted nfs storage, used by the filesystem artifact store.")
 	o.grpcClientOptions.Bind(fs)
+	o.artifactOptions.Bind(fs)
+	o.tracingOptions.Bind(fs)
+	o.leaderElectionOptions.Bind(fs)
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		return o, err
 	}
-	return o, nil
+	if err := o.tracingOptions.Validate(); err != nil {
+		return o, err
+	}
+	if err := o.leaderElectionOptions.Validate(); err != nil {
+		return o, err
+	}
+	return o, o.artifactOptions.Validate()
 }
 
 func main() {
@@ -64,8 +78,7 @@ func main() {
 		}
 	}()
 
-	calculationCh := make(chan v1.Calculation)
-	mgr, err := controllerruntime.NewManager(clusterConfig, controllerruntime.Options{
+	mgrOptions := controllerruntime.Options{
 		Cache: cache.Options{
 			DefaultNamespaces: map[string]cache.Config{
 				o.namespace: {},
@@ -77,7 +90,9 @@ func main() {
 			}
 			return cache.New(cfg, opts)
 		},
-	})
+	}
+	o.leaderElectionOptions.Apply(&mgrOptions, "vega-dispatcher", o.namespace)
+	mgr, err := controllerruntime.NewManager(clusterConfig, mgrOptions)
 
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to construct manager")
@@ -92,25 +107,24 @@ func main() {
 	}
 
 	ctx := controllerruntime.SetupSignalHandler()
-	if err := calculations.AddToManager(ctx, mgr, o.namespace); err != nil {
-		logrus.WithError(err).Fatal("Failed to add calculations controller to manager")
-	}
-
-	if err := workers.AddToManager(mgr, o.namespace); err != nil {
-		logrus.WithError(err).Fatal("Failed to add workers controller to manager")
+	shutdownTracing, err := o.tracingOptions.Setup(ctx, "vega-dispatcher")
+	if err != nil {
+		logrus.WithError(err).Fatal("couldn't set up tracing")
 	}
+	defer shutdownTracing(context.Background())
 
-	grpcClient, err := grpc.NewClient(o.grpcClientOptions.Address())
+	grpcClient, err := grpc.NewClient(o.grpcClientOptions)
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to construct grpc client")
 	}
 
-	if err := bulks.AddToManager(ctx, mgr, o.namespace, calculationCh, grpcClient); err != nil {
-		logrus.WithError(err).Fatal("Failed to add workerpools controller to manager")
+	artifactStore, err := o.artifactOptions.NewArtifactStore(o.nfsPath)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to construct the artifact store")
 	}
 
-	if err := factory.AddToManager(ctx, mgr, o.namespace, calculationCh, o.nfsPath); err != nil {
-		logrus.WithError(err).Fatal("Failed to add workerpools controller to manager")
+	if err := dispatcher.AddToManager(ctx, mgr, o.namespace, grpcClient, artifactStore, prometheus.DefaultRegisterer); err != nil {
+		logrus.WithError(err).Fatal("Failed to add the dispatcher controllers to manager")
 	}
 
 	if err := mgr.Start(ctx); err != nil {
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/janitor"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+const (
+	queryCommand   = "query"
+	restoreCommand = "restore"
+
+	dayLayout = "2006-01-02"
+)
+
+// archiveOptions are the options of the subcommands that read the archive.
+type archiveOptions struct {
+	from, to        string
+	namespace       string
+	nfsPath         string
+	artifactOptions artifacts.Options
+	archiveOptions  janitor.ArchiveOptions
+
+	fromDay, toDay time.Time
+}
+
+func (o *archiveOptions) bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.from, "from", "", "First day, as YYYY-MM-DD, of the archived objects. Defaults to the first day of the archive")
+	fs.StringVar(&o.to, "to", "", "Last day, as YYYY-MM-DD, of the archived objects. Defaults to the last day of the archive")
+	fs.StringVar(&o.nfsPath, "nfs-path", "/var/tmp/nfs", "Path of the mounted nfs storage, used by the filesystem artifact store")
+	o.artifactOptions.Bind(fs)
+	o.archiveOptions.Bind(fs)
+}
+
+func (o *archiveOptions) validate() error {
+	var err error
+	if o.from != "" {
+		if o.fromDay, err = time.Parse(dayLayout, o.from); err != nil {
+			return fmt.Errorf("--from: %w", err)
+		}
+	}
+	if o.to != "" {
+		if o.toDay, err = time.Parse(dayLayout, o.to); err != nil {
+			return fmt.Errorf("--to: %w", err)
+		}
+	}
+	if !o.archiveOptions.Enabled() {
+		return fmt.Errorf("--archive-dir or --archive-folder must be specified")
+	}
+	if err := o.archiveOptions.Validate(); err != nil {
+		return err
+	}
+	return o.artifactOptions.Validate()
+}
+
+func (o *archiveOptions) archive() (janitor.Archive, error) {
+	store, err := o.artifactOptions.NewArtifactStore(o.nfsPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the artifact store: %w", err)
+	}
+	return o.archiveOptions.NewArchive(store), nil
+}
+
+// runQuery prints the archived objects that match the flags as JSON lines.
+func runQuery(args []string, logger *logrus.Entry) {
+	o := archiveOptions{}
+	filter := janitor.Filter{}
+	fs := flag.NewFlagSet(queryCommand, flag.ExitOnError)
+	o.bind(fs)
+	fs.StringVar(&filter.Kind, "kind", "", "Kind of the archived objects, one of Calculation, CalculationBulk, CalculationBulkFactory")
+	fs.StringVar(&filter.Namespace, "namespace", "", "Namespace of the archived objects")
+	fs.StringVar(&filter.Name, "name", "", "Name of the archived objects")
+	fs.StringVar(&filter.Bulk, "bulk", "", "Name of the archived bulk, whose calculations are included")
+	if err := fs.Parse(args); err != nil {
+		logger.WithError(err).Fatal("couldn't parse arguments")
+	}
+	if err := o.validate(); err != nil {
+		logger.WithError(err).Fatal("validation error")
+	}
+	filter.From, filter.To = o.fromDay, o.toDay
+
+	archive, err := o.archive()
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't open the archive")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	if err := janitor.Query(controllerruntime.SetupSignalHandler(), archive, filter, func(record janitor.Record) error {
+		return encoder.Encode(record)
+	}); err != nil {
+		logger.WithError(err).Fatal("couldn't query the archive")
+	}
+}
+
+// runRestore recreates an archived bulk with its calculations in the cluster.
+func runRestore(args []string, logger *logrus.Entry) {
+	o := archiveOptions{}
+	var bulk string
+	var dryRun bool
+	fs := flag.NewFlagSet(restoreCommand, flag.ExitOnError)
+	o.bind(fs)
+	fs.StringVar(&o.namespace, "namespace", "vega", "Namespace of the archived bulk, where it's restored")
+	fs.StringVar(&bulk, "bulk", "", "Name of the archived bulk")
+	fs.BoolVar(&dryRun, "dry-run", false, "Report what would be restored without creating anything")
+	if err := fs.Parse(args); err != nil {
+		logger.WithError(err).Fatal("couldn't parse arguments")
+	}
+	if err := o.validate(); err != nil {
+		logger.WithError(err).Fatal("validation error")
+	}
+	if bulk == "" {
+		logger.Fatal("--bulk must be specified")
+	}
+
+	archive, err := o.archive()
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't open the archive")
+	}
+	clusterConfig, err := util.LoadClusterConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("could not load cluster clusterConfig")
+	}
+	client, err := ctrlruntimeclient.New(clusterConfig, ctrlruntimeclient.Options{})
+	if err != nil {
+		logger.WithError(err).Fatal("failed to create client")
+	}
+
+	if err := janitor.Restore(controllerruntime.SetupSignalHandler(), client, archive, o.namespace, bulk, o.fromDay, o.toDay, dryRun, logger); err != nil {
+		logger.WithError(err).Fatal("couldn't restore the bulk")
+	}
+}
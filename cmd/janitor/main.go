@@ -1,34 +1,61 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
-	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
-	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/janitor"
+	"github.com/vega-project/ccb-operator/pkg/leaderelection"
 	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
 type options struct {
-	retention       time.Duration
-	retentionString string
+	retention             time.Duration
+	retentionString       string
+	policyFile            string
+	dryRun                bool
+	once                  bool
+	pageSize              int64
+	artifactInterval      time.Duration
+	namespace             string
+	nfsPath               string
+	metricsPort           int
+	pushgatewayURL        string
+	artifactOptions       artifacts.Options
+	archiveOptions        janitor.ArchiveOptions
+	leaderElectionOptions leaderelection.Options
+
+	policy *janitor.Policy
 }
 
 func gatherOptions() options {
 	o := options{}
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
-	fs.StringVar(&o.retentionString, "retention", "24h", "How long calculations will be allow to exist in the cluster")
+	fs.StringVar(&o.retentionString, "retention", "24h", "How long completed calculations whose results were collected will be allow to exist in the cluster. Ignored when --policy-file is set")
+	fs.StringVar(&o.policyFile, "policy-file", "", "YAML file with the retention policy of the calculations, the bulks, the factories and the artifacts")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Report what would be deleted without deleting anything")
+	fs.BoolVar(&o.once, "once", false, "Clean up once and exit, instead of watching the objects. Meant to run as a CronJob")
+	fs.Int64Var(&o.pageSize, "page-size", 500, "Number of objects listed per request with --once")
+	fs.DurationVar(&o.artifactInterval, "artifact-interval", time.Hour, "How often the artifact folders of the objects that are gone are removed. Ignored with --once")
+	fs.StringVar(&o.namespace, "namespace", "", "Namespace of the objects that are cleaned up, all the namespaces if not specified")
+	fs.StringVar(&o.nfsPath, "nfs-path", "/var/tmp/nfs", "Path of the mounted nfs storage, used by the filesystem artifact store")
+	fs.IntVar(&o.metricsPort, "metrics-port", 9090, "Port number where the prometheus metrics are served")
+	fs.StringVar(&o.pushgatewayURL, "pushgateway-url", "", "URL of a Prometheus Pushgateway where the metrics are pushed with --once, which doesn't serve them")
+	o.artifactOptions.Bind(fs)
+	o.archiveOptions.Bind(fs)
+	o.leaderElectionOptions.Bind(fs)
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		logrus.WithError(err).Fatal("couldn't parse arguments")
@@ -44,78 +71,57 @@ func (o *options) validate() error {
 			return fmt.Errorf("couldn't parse duration: %v", err)
 		}
 	}
-	return nil
-}
-
-type controller struct {
-	ctx       context.Context
-	client    ctrlruntimeclient.Client
-	retention time.Duration
-	logger    *logrus.Entry
-}
 
-func (c *controller) Start(stopChan <-chan struct{}, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	c.logger.Info("Starting controller")
-	runChan := make(chan struct{})
-
-	go func() {
-		for {
-			runChan <- struct{}{}
-			time.Sleep(30 * time.Second)
+	if o.policyFile != "" {
+		policy, err := janitor.LoadPolicy(o.policyFile)
+		if err != nil {
+			return err
 		}
-	}()
-
-	for {
-		select {
-		case <-stopChan:
-			c.logger.Info("Stopping controller")
-			return
-		case <-runChan:
-			start := time.Now()
-			if err := c.clean(); err != nil {
-				c.logger.WithError(err).Error("Errors occurred while cleaning the calculations")
-			}
-
-			c.logger.Infof("Sync time: %v", time.Since(start))
+		o.policy = policy
+	} else {
+		o.policy = janitor.DefaultPolicy(o.retention)
+		if err := o.policy.Validate(); err != nil {
+			return fmt.Errorf("--retention: %w", err)
 		}
 	}
-}
 
-func (c *controller) clean() error {
-	var calculations v1.CalculationList
-	if err := c.client.List(c.ctx, &calculations); err != nil {
-		return fmt.Errorf("couldn't list calculations")
+	if o.metricsPort <= 0 {
+		return fmt.Errorf("--metrics-port must be positive")
+	}
+	if o.pushgatewayURL != "" && !o.once {
+		return fmt.Errorf("--pushgateway-url requires --once")
+	}
+	if o.pageSize < 0 {
+		return fmt.Errorf("--page-size must not be negative")
+	}
+	if o.artifactInterval <= 0 {
+		return fmt.Errorf("--artifact-interval must be positive")
+	}
+	if err := o.leaderElectionOptions.Validate(); err != nil {
+		return err
+	}
+	if err := o.archiveOptions.Validate(); err != nil {
+		return err
 	}
 
-	var errs []error
-	for _, calc := range calculations.Items {
-		logger := c.logger.WithField("calculation", calc.Name)
-		if calc.Phase != v1.CompletedPhase {
-			continue
-		}
-
-		if time.Since(calc.Status.StartTime.Time) <= c.retention {
-			continue
-		}
+	return o.artifactOptions.Validate()
+}
 
-		if _, ok := calc.Labels[util.ResultsCollected]; !ok {
-			continue
-		}
+func main() {
+	logger := logrus.WithField("component", "janitor")
+	controllerruntime.SetLogger(zap.New(zap.UseDevMode(true)))
 
-		if err := c.client.Delete(c.ctx, &calc); err != nil {
-			errs = append(errs, fmt.Errorf("couldn't delete calculation %s: %w", calc.Name, err))
-			continue
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case queryCommand:
+			runQuery(os.Args[2:], logger)
+			return
+		case restoreCommand:
+			runRestore(os.Args[2:], logger)
+			return
 		}
-
-		logger.Info("Calculation deleted...")
 	}
-	return utilerrors.NewAggregate(errs)
-}
 
-func main() {
-	logger := logrus.WithField("component", "janitor")
 	o := gatherOptions()
 	if err := o.validate(); err != nil {
 		logger.WithError(err).Fatal("validation error")
@@ -126,36 +132,52 @@ func main() {
 		logger.WithError(err).Fatal("could not load cluster clusterConfig")
 	}
 
-	client, err := ctrlruntimeclient.New(clusterConfig, ctrlruntimeclient.Options{})
-	if err != nil {
-		logrus.WithError(err).Fatal("failed to create client")
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	c := controller{
-		ctx:       ctx,
-		logger:    logger,
-		retention: o.retention,
-		client:    client,
-	}
-
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go c.Start(stopCh, &wg)
-
-	sigTerm := make(chan os.Signal, 1)
-	signal.Notify(sigTerm, syscall.SIGTERM)
-	signal.Notify(sigTerm, syscall.SIGINT)
-	for {
-		select {
-		case <-sigTerm:
-			logger.Infof("Shutdown signal received, exiting...")
-			close(stopCh)
-			wg.Wait()
-			os.Exit(0)
+	var artifactStore artifacts.ArtifactStore
+	if o.policy.Artifacts != nil || o.archiveOptions.Enabled() {
+		if artifactStore, err = o.artifactOptions.NewArtifactStore(o.nfsPath); err != nil {
+			logger.WithError(err).Fatal("couldn't create the artifact store")
+		}
+	}
+
+	ctx := controllerruntime.SetupSignalHandler()
+
+	if o.once {
+		client, err := ctrlruntimeclient.New(clusterConfig, ctrlruntimeclient.Options{})
+		if err != nil {
+			logger.WithError(err).Fatal("failed to create client")
+		}
+		// The cleanup report is logged, since no metrics are served before the janitor exits.
+		_, err = janitor.New(client, artifactStore, o.archiveOptions.NewArchive(artifactStore), o.policy, o.namespace, o.dryRun, o.pageSize, logger).Clean(ctx)
+		if o.pushgatewayURL != "" {
+			if err := janitor.PushMetrics(o.pushgatewayURL); err != nil {
+				logger.WithError(err).Error("Couldn't push the metrics to the Pushgateway")
+			}
+		}
+		if err != nil {
+			logger.WithError(err).Fatal("Errors occurred while cleaning up")
 		}
+		return
+	}
+
+	mgrOptions := controllerruntime.Options{
+		Metrics: metricsserver.Options{BindAddress: fmt.Sprintf(":%d", o.metricsPort)},
+	}
+	if o.namespace != "" {
+		mgrOptions.Cache = cache.Options{DefaultNamespaces: map[string]cache.Config{o.namespace: {}}}
+	}
+	o.leaderElectionOptions.Apply(&mgrOptions, "vega-janitor", o.namespace)
+	mgr, err := controllerruntime.NewManager(clusterConfig, mgrOptions)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to construct manager")
+	}
+
+	// The cache of the manager doesn't support the pagination, and doesn't need it.
+	j := janitor.New(mgr.GetClient(), artifactStore, o.archiveOptions.NewArchive(artifactStore), o.policy, o.namespace, o.dryRun, 0, logger)
+	if err := janitor.AddToManager(ctx, mgr, j, o.artifactInterval); err != nil {
+		logger.WithError(err).Fatal("Failed to add the janitor controllers to manager")
+	}
+
+	if err := mgr.Start(ctx); err != nil {
+		logger.WithError(err).Error("Manager ended with error")
 	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/collector"
+	"github.com/vega-project/ccb-operator/pkg/db"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+// newResultsCollector returns a manager that runs the results collector on the calculations of the namespace.
+func newResultsCollector(o options, store db.CalculationResultsStore) (manager.Manager, error) {
+	registry, ok := store.(db.OutputRegistry)
+	if !ok {
+		return nil, fmt.Errorf("the results store can't register output files")
+	}
+
+	controllerruntime.SetLogger(zap.New(zap.UseDevMode(true)))
+	clusterConfig, err := util.LoadClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load cluster config: %w", err)
+	}
+
+	mgr, err := controllerruntime.NewManager(clusterConfig, controllerruntime.Options{
+		Cache: cache.Options{
+			DefaultNamespaces: map[string]cache.Config{
+				o.namespace: {},
+			},
+		},
+		// The metrics of the results-handler are served on --metrics-port.
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct manager: %w", err)
+	}
+
+	if err := v1.AddToScheme(mgr.GetScheme()); err != nil {
+		return nil, fmt.Errorf("failed to add calculationv1 to scheme: %w", err)
+	}
+
+	if err := collector.AddToManager(mgr, o.namespace, o.nfsPath, registry); err != nil {
+		return nil, fmt.Errorf("failed to add the results collector to manager: %w", err)
+	}
+	return mgr, nil
+}
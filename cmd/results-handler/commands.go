@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/vega-project/ccb-operator/pkg/db"
+)
+
+// commands are the subcommands of the results-handler. Without a subcommand, the gRPC server is started.
+var commands = map[string]func(args []string) error{
+	"migrate": migrate,
+	"archive": archive,
+	"restore": restore,
+}
+
+// migrate applies or reverts the schema migrations of the results database.
+func migrate(args []string) error {
+	var databaseOptions db.Options
+	var targetVersion int
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	databaseOptions.Bind(fs)
+	fs.IntVar(&targetVersion, "target-version", -1, "Schema version to migrate to. Versions lower than the current one revert the newer migrations. Defaults to the latest version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := databaseOptions.Validate(); err != nil {
+		return err
+	}
+
+	target := db.LatestSchemaVersion()
+	if targetVersion >= 0 {
+		target = uint(targetVersion)
+	}
+
+	migrator, err := databaseOptions.NewMigrator()
+	if err != nil {
+		return err
+	}
+	if err := migrator.Migrate(target); err != nil {
+		return err
+	}
+
+	logrus.Infof("Database schema is at version %d", target)
+	return nil
+}
+
+// archive archives the results that match the retention options once.
+func archive(args []string) error {
+	o := options{}
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	fs.StringVar(&o.namespace, "namespace", "vega", "The namespace where the calculation bulks exist, used to find the results of deleted bulks.")
+	o.databaseOptions.Bind(fs)
+	o.retentionOptions.Bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := utilerrors.NewAggregate([]error{o.databaseOptions.Validate(), o.retentionOptions.Validate()}); err != nil {
+		return err
+	}
+	if !o.retentionOptions.Enabled() {
+		return fmt.Errorf("neither --retention-days nor --archive-deleted-bulks is specified")
+	}
+
+	resultstore, err := o.databaseOptions.NewCalculationResultsStore()
+	if err != nil {
+		return err
+	}
+
+	r, err := newRetentionArchiver(o, resultstore)
+	if err != nil {
+		return err
+	}
+	return r.run(context.Background())
+}
+
+// restore stores the results of an archive file back to the results database.
+func restore(args []string) error {
+	var databaseOptions db.Options
+	var archivePath string
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	databaseOptions.Bind(fs)
+	fs.StringVar(&archivePath, "archive", "", "Path of the archive file to restore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var errs []error
+	if err := databaseOptions.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if archivePath == "" {
+		errs = append(errs, fmt.Errorf("--archive is not specified"))
+	} else if _, err := os.Stat(archivePath); err != nil {
+		errs = append(errs, fmt.Errorf("--archive: %w", err))
+	}
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return err
+	}
+
+	resultstore, err := databaseOptions.NewCalculationResultsStore()
+	if err != nil {
+		return err
+	}
+
+	archiver, err := db.NewArchiver(resultstore, "")
+	if err != nil {
+		return err
+	}
+
+	restored, err := archiver.Restore(context.Background(), archivePath)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Restored %d results from %s", restored, archivePath)
+	return nil
+}
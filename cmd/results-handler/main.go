@@ -1,64 +1,218 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 
 	"github.com/sirupsen/logrus"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
 	"github.com/vega-project/ccb-operator/pkg/db"
 	vega_grpc "github.com/vega-project/ccb-operator/pkg/grpc"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
+	"github.com/vega-project/ccb-operator/pkg/util"
 	proto "github.com/vega-project/ccb-operator/proto"
 )
 
 type options struct {
-	port            int
-	databaseOptions db.Options
+	port                int
+	metricsPort         int
+	healthCheckInterval time.Duration
+	drainTimeout        time.Duration
+	collectResults      bool
+	nfsPath             string
+
+	namespace        string
+	databaseOptions  db.Options
+	serverOptions    vega_grpc.ServerOptions
+	retentionOptions db.RetentionOptions
+	tracingOptions   tracing.Options
 }
 
-func gatherOptions() options {
+func gatherOptions(args []string) options {
 	o := options{}
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 	fs.IntVar(&o.port, "port", 50051, "Port number where the gRPC server will listen to")
+	fs.IntVar(&o.metricsPort, "metrics-port", 9090, "Port number where the prometheus metrics are served")
+	fs.DurationVar(&o.healthCheckInterval, "health-check-interval", 10*time.Second, "How often the database connectivity is checked for the health service")
+	fs.DurationVar(&o.drainTimeout, "drain-timeout", 30*time.Second, "How long the in-flight calls are waited for on shutdown")
+	fs.StringVar(&o.namespace, "namespace", "vega", "The namespace where the calculations and calculation bulks exist.")
+	fs.BoolVar(&o.collectResults, "collect-results", false, "Organise the output files of the completed calculations in the shared storage and register them")
+	fs.StringVar(&o.nfsPath, "nfs-path", "/var/tmp/nfs", "Path of the mounted nfs storage, where the output files are collected")
 	o.databaseOptions.Bind(fs)
+	o.serverOptions.Bind(fs)
+	o.retentionOptions.Bind(fs)
+	o.tracingOptions.Bind(fs)
 
-	if err := fs.Parse(os.Args[1:]); err != nil {
+	if err := fs.Parse(args); err != nil {
 		logrus.WithError(err).Fatal("couldn't parse arguments.")
 	}
 	return o
 }
 
 func validateOptions(o options) error {
-	return o.databaseOptions.Validate()
+	errs := []error{o.databaseOptions.Validate(), o.serverOptions.Validate(), o.retentionOptions.Validate(), o.tracingOptions.Validate()}
+	if o.healthCheckInterval <= 0 {
+		errs = append(errs, fmt.Errorf("--health-check-interval must be positive"))
+	}
+	if o.drainTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("--drain-timeout must be positive"))
+	}
+	if o.collectResults && o.nfsPath == "" {
+		errs = append(errs, fmt.Errorf("--nfs-path is required to collect the results"))
+	}
+	return utilerrors.NewAggregate(errs)
 }
 
 func main() {
-	o := gatherOptions()
-	err := validateOptions(o)
-	if err != nil {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				logrus.WithError(err).Fatalf("%s failed", os.Args[1])
+			}
+			return
+		}
+	}
+
+	o := gatherOptions(os.Args[1:])
+	if err := validateOptions(o); err != nil {
 		logrus.WithError(err).Fatal("invalid options")
 	}
 
+	if err := run(o); err != nil {
+		logrus.WithError(err).Fatal("results-handler failed")
+	}
+}
+
+// run serves the results until a termination signal is received, then drains the in-flight calls.
+func run(o options) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	shutdownTracing, err := o.tracingOptions.Setup(ctx, "vega-results-handler")
+	if err != nil {
+		return fmt.Errorf("couldn't set up tracing: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	resultstore, err := o.databaseOptions.NewCalculationResultsStore()
 	if err != nil {
-		logrus.WithError(err).Fatal("couldn't initialize database")
+		return fmt.Errorf("couldn't initialize database: %w", err)
+	}
+
+	if o.retentionOptions.Enabled() {
+		archiver, err := newRetentionArchiver(o, resultstore)
+		if err != nil {
+			return fmt.Errorf("couldn't initialize the results archiver: %w", err)
+		}
+		go archiver.Start(ctx, o.retentionOptions.Interval())
+	}
+
+	managerErr := make(chan error, 1)
+	if o.collectResults {
+		mgr, err := newResultsCollector(o, resultstore)
+		if err != nil {
+			return fmt.Errorf("couldn't initialize the results collector: %w", err)
+		}
+		go func() {
+			managerErr <- mgr.Start(ctx)
+		}()
 	}
 
+	var client ctrlruntimeclient.Client
+	if o.serverOptions.ServiceAccountTokenAuth() {
+		if client, err = newKubeClient(); err != nil {
+			return fmt.Errorf("couldn't create the client for reviewing service account tokens: %w", err)
+		}
+	}
+
+	serverOptions, err := o.serverOptions.GRPCServerOptions(client)
+	if err != nil {
+		return fmt.Errorf("couldn't configure the gRPC server: %w", err)
+	}
+	s := grpc.NewServer(serverOptions...)
+	proto.RegisterDbServiceServer(s, vega_grpc.NewServer(resultstore, o.serverOptions.ChunkSize(), o.serverOptions.MaxStreamSize()))
+	reflection.Register(s)
+
+	healthReporter := vega_grpc.NewHealthReporter(resultstore)
+	healthReporter.Register(s)
+	go healthReporter.Start(ctx, o.healthCheckInterval)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{Addr: fmt.Sprintf(":%d", o.metricsPort), Handler: mux}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).Error("couldn't start the metrics http server")
+		}
+	}()
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", o.port))
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(lis)
+	}()
+	logrus.Infof("Server listening on port %d", o.port)
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	case err := <-managerErr:
+		if err != nil {
+			return fmt.Errorf("the results collector failed: %w", err)
+		}
+	case <-ctx.Done():
 	}
-	s := grpc.NewServer()
-	proto.RegisterDbServiceServer(s, vega_grpc.NewServer(resultstore))
 
-	log.Printf("Server listening on port %d", o.port)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	logrus.Info("Shutdown signal received, draining the in-flight calls...")
+	healthReporter.Shutdown()
+	gracefulStop(s, o.drainTimeout)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	return metricsServer.Shutdown(shutdownCtx)
+}
+
+// gracefulStop waits for the in-flight calls to finish, and cancels them if they take longer than the timeout.
+func gracefulStop(s *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		logrus.Info("All in-flight calls finished")
+	case <-time.After(timeout):
+		logrus.Warnf("In-flight calls didn't finish within %v, stopping the server", timeout)
+		s.Stop()
+	}
+}
+
+func newKubeClient() (ctrlruntimeclient.Client, error) {
+	clusterConfig, err := util.LoadClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load cluster config: %w", err)
 	}
+	return ctrlruntimeclient.New(clusterConfig, ctrlruntimeclient.Options{})
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	"github.com/vega-project/ccb-operator/pkg/db"
+)
+
+// retentionArchiver archives the results that match the retention options.
+type retentionArchiver struct {
+	logger    *logrus.Entry
+	archiver  *db.Archiver
+	options   db.RetentionOptions
+	client    ctrlruntimeclient.Client
+	namespace string
+}
+
+func newRetentionArchiver(o options, resultstore db.CalculationResultsStore) (*retentionArchiver, error) {
+	archiver, err := db.NewArchiver(resultstore, o.retentionOptions.ArchiveDir())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &retentionArchiver{
+		logger:    logrus.WithField("component", "retention"),
+		archiver:  archiver,
+		options:   o.retentionOptions,
+		namespace: o.namespace,
+	}
+
+	if o.retentionOptions.ArchiveDeletedBulks() {
+		if r.client, err = newKubeClient(); err != nil {
+			return nil, fmt.Errorf("failed to create client: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Start archives the results periodically until the context is cancelled.
+func (r *retentionArchiver) Start(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.run(ctx); err != nil {
+			r.logger.WithError(err).Error("Failed to archive the results")
+		}
+	}, interval)
+}
+
+func (r *retentionArchiver) run(ctx context.Context) error {
+	var live sets.Set[string]
+	if r.client != nil {
+		var err error
+		live, err = liveInputHashes(ctx, r.client, r.namespace)
+		if err != nil {
+			return err
+		}
+	}
+
+	path, archived, err := r.archiver.Archive(ctx, r.options.Filter(time.Now(), live))
+	if err != nil {
+		return err
+	}
+	if archived > 0 {
+		r.logger.WithFields(logrus.Fields{"archive": path, "results": archived}).Info("Archived results")
+	}
+	return nil
+}
+
+// liveInputHashes returns the input hashes of the calculations of the existing calculation bulks.
+func liveInputHashes(ctx context.Context, client ctrlruntimeclient.Client, namespace string) (sets.Set[string], error) {
+	var bulks bulkv1.CalculationBulkList
+	if err := client.List(ctx, &bulks, ctrlruntimeclient.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("couldn't list calculation bulks: %w", err)
+	}
+
+	hashes := sets.New[string]()
+	for _, bulk := range bulks.Items {
+		for _, inputHash := range bulk.Status.InputHashes {
+			hashes.Insert(inputHash)
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+)
+
+func TestLiveInputHashes(t *testing.T) {
+	bulks := []ctrlruntimeclient.Object{
+		&bulkv1.CalculationBulk{
+			ObjectMeta: metav1.ObjectMeta{Name: "bulk-1", Namespace: "vega"},
+			Status:     bulkv1.CalculationBulkStatus{InputHashes: map[string]string{"calc1": "hash1", "calc2": "hash2"}},
+		},
+		&bulkv1.CalculationBulk{
+			ObjectMeta: metav1.ObjectMeta{Name: "bulk-2", Namespace: "vega"},
+			Status:     bulkv1.CalculationBulkStatus{InputHashes: map[string]string{"calc1": "hash1", "calc3": "hash3"}},
+		},
+		&bulkv1.CalculationBulk{
+			ObjectMeta: metav1.ObjectMeta{Name: "bulk-3", Namespace: "other"},
+			Status:     bulkv1.CalculationBulkStatus{InputHashes: map[string]string{"calc1": "hash4"}},
+		},
+	}
+	client := fakectrlruntimeclient.NewClientBuilder().WithObjects(bulks...).Build()
+
+	hashes, err := liveInputHashes(context.Background(), client, "vega")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(sets.List(hashes), []string{"hash1", "hash2", "hash3"}); diff != "" {
+		t.Fatal(diff)
+	}
+}
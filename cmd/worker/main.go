@@ -1,20 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
 	"github.com/vega-project/ccb-operator/pkg/grpc"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
 	"github.com/vega-project/ccb-operator/pkg/util"
 	"github.com/vega-project/ccb-operator/pkg/worker"
+	"github.com/vega-project/ccb-operator/pkg/worker/cgroups"
+	"github.com/vega-project/ccb-operator/pkg/worker/sandbox"
 )
 
 type options struct {
@@ -22,22 +28,49 @@ type options struct {
 	namespace         string
 	workerPool        string
 	nodename          string
+	metricsPort       int
 	grpcClientOptions grpc.Options
+	artifactOptions   artifacts.Options
+	tracingOptions    tracing.Options
+
+	spoolDir            string
+	spoolReplayInterval time.Duration
+
+	inputCacheDir  string
+	inputCacheSize string
+
+	cgroups    bool
+	cgroupRoot string
+
+	maxDrainTime time.Duration
 }
 
 func gatherOptions() options {
 	o := options{}
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
-	fs.StringVar(&o.nfsPath, "nfs-path", "/var/tmp/nfs", "Path of the mounted nfs storage.")
+	fs.StringVar(&o.nfsPath, "nfs-path", "/var/tmp/nfs", "Path of the mounted nfs storage, used by the filesystem artifact store.")
 	fs.StringVar(&o.namespace, "namespace", "vega", "Namespace where the calculations exists")
 	fs.StringVar(&o.nodename, "nodename", "", "The name of the node in which the worker is running")
 	fs.StringVar(&o.workerPool, "worker-pool", "vega-workers", "The pool where the worker will post the status updates")
+	fs.IntVar(&o.metricsPort, "metrics-port", 9090, "Port number where the prometheus metrics are served")
+	fs.StringVar(&o.spoolDir, "spool-dir", "", "Directory where the results that couldn't be delivered are kept until they are delivered. Defaults to spool/<nodename> in the nfs storage")
+	fs.DurationVar(&o.spoolReplayInterval, "spool-replay-interval", time.Minute, "How often the delivery of the spooled results is retried")
+	fs.StringVar(&o.inputCacheDir, "input-cache-dir", "", "Directory on the local disk of the node where the input files are cached. The cache is disabled if not specified")
+	fs.StringVar(&o.inputCacheSize, "input-cache-size", "20Gi", "Maximum size of the input cache, the least recently used files are evicted beyond it")
+	fs.BoolVar(&o.cgroups, "cgroups", true, "Run each calculation in its own cgroup v2, which enforces its resource limits and measures the resources of its steps")
+	fs.StringVar(&o.cgroupRoot, "cgroup-root", "", "Cgroup v2 folder where the cgroups of the calculations are created. Defaults to the cgroup of the worker")
+	fs.DurationVar(&o.maxDrainTime, "max-drain-time", 30*time.Minute, "How long the worker waits for its calculation to finish when it's drained. The calculation is then interrupted and rescheduled")
 	o.grpcClientOptions.Bind(fs)
+	o.artifactOptions.Bind(fs)
+	o.tracingOptions.Bind(fs)
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		logrus.WithError(err).Fatal("couldn't parse options")
 	}
+	if o.spoolDir == "" && o.nodename != "" {
+		o.spoolDir = filepath.Join(o.nfsPath, "spool", o.nodename)
+	}
 	return o
 }
 
@@ -50,10 +83,39 @@ func validateOptions(o options) error {
 		return fmt.Errorf("--nodename was not provided")
 	}
 
-	return nil
+	if o.metricsPort <= 0 {
+		return fmt.Errorf("--metrics-port must be positive")
+	}
+
+	if o.spoolReplayInterval <= 0 {
+		return fmt.Errorf("--spool-replay-interval must be positive")
+	}
+
+	if o.maxDrainTime <= 0 {
+		return fmt.Errorf("--max-drain-time must be positive")
+	}
+
+	if o.inputCacheDir != "" {
+		if size, err := resource.ParseQuantity(o.inputCacheSize); err != nil || size.Sign() <= 0 {
+			return fmt.Errorf("--input-cache-size must be a positive quantity, e.g. 20Gi")
+		}
+	}
+
+	if err := o.artifactOptions.Validate(); err != nil {
+		return err
+	}
+
+	if err := o.tracingOptions.Validate(); err != nil {
+		return err
+	}
+
+	return o.grpcClientOptions.Validate()
 }
 
 func main() {
+	// The worker binary sets up the sandbox of the commands of the calculations.
+	sandbox.Init()
+
 	controllerruntime.SetLogger(zap.New(zap.UseDevMode(true)))
 	logrus.SetFormatter(&logrus.TextFormatter{
 		DisableQuote: true,
@@ -73,9 +135,6 @@ func main() {
 		logger.WithError(err).Fatal("could not load cluster clusterConfig")
 	}
 
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-
 	// Hostname is the same with the pod's name.
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -83,24 +142,50 @@ func main() {
 	}
 
 	ctx := controllerruntime.SetupSignalHandler()
+	// The controllers and the executor keep running while the worker drains after the shutdown signal.
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdownTracing, err := o.tracingOptions.Setup(ctx, "vega-worker")
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't set up tracing")
+	}
+
+	artifactStore, err := o.artifactOptions.NewArtifactStore(o.nfsPath)
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't initialize the artifact store")
+	}
+
+	var inputCacheSize int64
+	if o.inputCacheDir != "" {
+		size := resource.MustParse(o.inputCacheSize)
+		inputCacheSize = size.Value()
+	}
+
+	var cgroupRoot string
+	if o.cgroups {
+		cgroupRoot = o.cgroupRoot
+		if cgroupRoot == "" {
+			if cgroupRoot, err = cgroups.Detect(); err != nil {
+				logger.WithError(err).Warn("Couldn't find the cgroup of the worker, running the calculations without cgroups")
+			}
+		}
+	}
 
-	op := worker.NewMainOperator(ctx, hostname, o.nodename, o.namespace, o.workerPool, o.nfsPath, clusterConfig, o.grpcClientOptions.Address())
+	// The sandboxed commands can't access the shared storage and the caches of the worker.
+	sandboxHiddenPaths := []string{o.nfsPath, o.spoolDir, o.inputCacheDir}
+
+	op := worker.NewMainOperator(runCtx, hostname, o.nodename, o.namespace, o.workerPool, o.spoolDir, o.inputCacheDir, inputCacheSize, sandboxHiddenPaths, cgroupRoot, artifactStore, o.spoolReplayInterval, o.metricsPort, o.maxDrainTime, clusterConfig, o.grpcClientOptions)
 	if err := op.Initialize(); err != nil {
 		logger.WithError(err).Fatal("couldn't initialize operator")
 	}
 
-	if err := op.Run(stopCh); err != nil {
+	if err := op.Run(ctx.Done()); err != nil {
 		logger.WithError(err).Fatal("Error starting operator")
 	}
-	sigTerm := make(chan os.Signal, 1)
-	signal.Notify(sigTerm, syscall.SIGTERM)
-	signal.Notify(sigTerm, syscall.SIGINT)
-	for {
-		select {
-		case <-sigTerm:
-			logger.Infof("Shutdown signal received, exiting...")
-			close(stopCh)
-			os.Exit(0)
-		}
+	logger.Info("The worker was drained, exiting...")
+	cancel()
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.WithError(err).Warn("Couldn't flush the traces")
 	}
 }
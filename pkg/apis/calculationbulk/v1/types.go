@@ -2,6 +2,7 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 )
@@ -18,6 +19,8 @@ type CalculationBulk struct {
 	WorkerPool       string                 `json:"worker_pool,omitempty"`
 	InputFiles       *v1.InputFiles         `json:"input_files,omitempty"`
 	OutputFilesRegex string                 `json:"output_files_regex,omitempty"`
+	OutputRules      []v1.OutputRule        `json:"output_rules,omitempty"`
+	Resources        *v1.Resources          `json:"resources,omitempty"`
 	Calculations     map[string]Calculation `json:"calculations,omitempty"`
 	PostCalculation  *Calculation           `json:"postCalculation,omitempty"`
 	Status           CalculationBulkStatus  `json:"status,omitempty"`
@@ -44,6 +47,24 @@ type CalculationBulkStatus struct {
 	CreatedTime    metav1.Time          `json:"startTime,omitempty"`
 	CompletionTime *metav1.Time         `json:"completionTime,omitempty"`
 	State          CalculationBulkState `json:"state,omitempty"`
+	// Conditions represent the latest available observations of the bulk's state.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// InputHashes maps each calculation to the content hash of its inputs,
+	// which is used to look up cached results.
+	InputHashes map[string]string `json:"inputHashes,omitempty"`
+	// Calculations maps each calculation to the calculation object that was created for it.
+	Calculations map[string]CalculationReference `json:"calculations,omitempty"`
+	// PostCalculation is the calculation object that was created for the post-calculation.
+	PostCalculation *CalculationReference `json:"postCalculation,omitempty"`
+}
+
+// CalculationReference links a calculation of the bulk to the calculation object of its attempt.
+// The attempt grows every time the calculation is rescheduled, and the name is derived from it, so a
+// calculation of a previous attempt is never mistaken for the current one.
+type CalculationReference struct {
+	Name    string    `json:"name,omitempty"`
+	UID     types.UID `json:"uid,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
 }
 
 type CalculationBulkState string
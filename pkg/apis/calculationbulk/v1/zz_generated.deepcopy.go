@@ -6,6 +6,7 @@ package v1
 
 import (
 	calculationsv1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -47,6 +48,18 @@ func (in *CalculationBulk) DeepCopyInto(out *CalculationBulk) {
 		*out = new(calculationsv1.InputFiles)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.OutputRules != nil {
+		in, out := &in.OutputRules, &out.OutputRules
+		*out = make([]calculationsv1.OutputRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(calculationsv1.Resources)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Calculations != nil {
 		in, out := &in.Calculations, &out.Calculations
 		*out = make(map[string]Calculation, len(*in))
@@ -120,6 +133,32 @@ func (in *CalculationBulkStatus) DeepCopyInto(out *CalculationBulkStatus) {
 		in, out := &in.CompletionTime, &out.CompletionTime
 		*out = (*in).DeepCopy()
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InputHashes != nil {
+		in, out := &in.InputHashes, &out.InputHashes
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Calculations != nil {
+		in, out := &in.Calculations, &out.Calculations
+		*out = make(map[string]CalculationReference, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PostCalculation != nil {
+		in, out := &in.PostCalculation, &out.PostCalculation
+		*out = new(CalculationReference)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CalculationBulkStatus.
@@ -131,3 +170,18 @@ func (in *CalculationBulkStatus) DeepCopy() *CalculationBulkStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CalculationReference) DeepCopyInto(out *CalculationReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CalculationReference.
+func (in *CalculationReference) DeepCopy() *CalculationReference {
+	if in == nil {
+		return nil
+	}
+	out := new(CalculationReference)
+	in.DeepCopyInto(out)
+	return out
+}
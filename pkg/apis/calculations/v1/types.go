@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -23,14 +24,21 @@ type Calculation struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec             CalculationSpec   `json:"spec"`
-	Pipeline         Pipeline          `json:"pipeline,omitempty"`
-	Assign           string            `json:"assign"`
-	WorkerPool       string            `json:"worker_pool"`
-	InputFiles       *InputFiles       `json:"input_files,omitempty"`
-	OutputFilesRegex string            `json:"output_files_regex,omitempty"`
-	Status           CalculationStatus `json:"status,omitempty"`
-	Phase            CalculationPhase  `json:"phase,omitempty"`
+	Spec             CalculationSpec `json:"spec"`
+	Pipeline         Pipeline        `json:"pipeline,omitempty"`
+	Assign           string          `json:"assign"`
+	WorkerPool       string          `json:"worker_pool"`
+	InputFiles       *InputFiles     `json:"input_files,omitempty"`
+	OutputFilesRegex string          `json:"output_files_regex,omitempty"`
+	// OutputRules select the output files of the calculation that are kept, in addition to the
+	// files matching OutputFilesRegex.
+	OutputRules []OutputRule `json:"output_rules,omitempty"`
+	// Resources limits the resources of the processes of the calculation.
+	Resources *Resources `json:"resources,omitempty"`
+	// InputHash is the content hash of the inputs, the results are stored with it.
+	InputHash string            `json:"input_hash,omitempty"`
+	Status    CalculationStatus `json:"status,omitempty"`
+	Phase     CalculationPhase  `json:"phase,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -63,16 +71,78 @@ type InputFiles struct {
 	Symlink bool     `json:"symlink,omitempty"`
 }
 
+// OutputRule selects output files in the folder of the calculation. Exactly one of Glob and Regex is set.
+type OutputRule struct {
+	// Name identifies the rule in the output manifest.
+	Name string `json:"name"`
+	// Glob matches the paths of the files relative to the calculation folder. A glob without
+	// a slash matches the file names in every subfolder, e.g. "*.out".
+	Glob string `json:"glob,omitempty"`
+	// Regex matches the paths of the files relative to the calculation folder.
+	Regex string `json:"regex,omitempty"`
+	// Required fails the calculation if no file matches the rule.
+	Required bool `json:"required,omitempty"`
+	// Rename is the path where the matching file is kept, relative to the output folder.
+	// A rule with a rename must match a single file.
+	Rename string `json:"rename,omitempty"`
+	// MaxSize skips the matching files larger than it.
+	MaxSize *resource.Quantity `json:"max_size,omitempty"`
+}
+
+// Resources limits the resources that all the processes of a calculation use together.
+type Resources struct {
+	// Memory limits the memory of the processes, which are killed beyond it.
+	Memory *resource.Quantity `json:"memory,omitempty"`
+	// CPU limits the CPU time of the processes, in cores.
+	CPU *resource.Quantity `json:"cpu,omitempty"`
+}
+
+// The types of the conditions of the calculations, the calculation bulks and the worker pools.
+const (
+	// ReadyCondition is true once the object reached its goal, e.g. a calculation or a bulk finished.
+	ReadyCondition = "Ready"
+	// ProgressingCondition is true while calculations are running.
+	ProgressingCondition = "Progressing"
+	// DegradedCondition is true when calculations failed or workers were lost.
+	DegradedCondition = "Degraded"
+)
+
 type Pipeline string
 
 const VegaPipeline Pipeline = "vega"
 
 type CalculationStatus struct {
-	//Conditions represent the latest available observations of an object's current state:
+	// Conditions represent the latest available observations of the calculation's state.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 	// StartTime is equal to the creation time of the Calculation
 	StartTime metav1.Time `json:"startTime,omitempty"`
 	// PendingTime is the timestamp for when the job moved from triggered to pending
 	PendingTime *metav1.Time `json:"pendingTime,omitempty"`
 	// CompletionTime is the timestamp for when the job goes to a final state
 	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Outputs is the manifest of the output files that the worker kept, set once the steps succeeded.
+	Outputs *OutputManifest `json:"outputs,omitempty"`
+	// StepUsage is the resources that the finished steps used.
+	StepUsage []StepUsage `json:"stepUsage,omitempty"`
+}
+
+// StepUsage is the resources that the processes of a step used.
+type StepUsage struct {
+	Step            int             `json:"step"`
+	WallTime        metav1.Duration `json:"wallTime"`
+	CPUTime         metav1.Duration `json:"cpuTime"`
+	PeakMemoryBytes int64           `json:"peakMemoryBytes"`
+}
+
+// OutputManifest describes the output files of a calculation.
+type OutputManifest struct {
+	Files []OutputFile `json:"files"`
+}
+
+// OutputFile is an output file of a calculation, with its path relative to the output folder.
+type OutputFile struct {
+	Rule   string `json:"rule"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
 }
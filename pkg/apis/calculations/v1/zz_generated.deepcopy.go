@@ -5,6 +5,7 @@
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -19,6 +20,18 @@ func (in *Calculation) DeepCopyInto(out *Calculation) {
 		*out = new(InputFiles)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.OutputRules != nil {
+		in, out := &in.OutputRules, &out.OutputRules
+		*out = make([]OutputRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(Resources)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -98,6 +111,13 @@ func (in *CalculationSpec) DeepCopy() *CalculationSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CalculationStatus) DeepCopyInto(out *CalculationStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	in.StartTime.DeepCopyInto(&out.StartTime)
 	if in.PendingTime != nil {
 		in, out := &in.PendingTime, &out.PendingTime
@@ -107,6 +127,16 @@ func (in *CalculationStatus) DeepCopyInto(out *CalculationStatus) {
 		in, out := &in.CompletionTime, &out.CompletionTime
 		*out = (*in).DeepCopy()
 	}
+	if in.Outputs != nil {
+		in, out := &in.Outputs, &out.Outputs
+		*out = new(OutputManifest)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StepUsage != nil {
+		in, out := &in.StepUsage, &out.StepUsage
+		*out = make([]StepUsage, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CalculationStatus.
@@ -139,6 +169,61 @@ func (in *InputFiles) DeepCopy() *InputFiles {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutputFile) DeepCopyInto(out *OutputFile) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutputFile.
+func (in *OutputFile) DeepCopy() *OutputFile {
+	if in == nil {
+		return nil
+	}
+	out := new(OutputFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutputManifest) DeepCopyInto(out *OutputManifest) {
+	*out = *in
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make([]OutputFile, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutputManifest.
+func (in *OutputManifest) DeepCopy() *OutputManifest {
+	if in == nil {
+		return nil
+	}
+	out := new(OutputManifest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutputRule) DeepCopyInto(out *OutputRule) {
+	*out = *in
+	if in.MaxSize != nil {
+		in, out := &in.MaxSize, &out.MaxSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutputRule.
+func (in *OutputRule) DeepCopy() *OutputRule {
+	if in == nil {
+		return nil
+	}
+	out := new(OutputRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Params) DeepCopyInto(out *Params) {
 	*out = *in
@@ -154,6 +239,31 @@ func (in *Params) DeepCopy() *Params {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Resources) DeepCopyInto(out *Resources) {
+	*out = *in
+	if in.Memory != nil {
+		in, out := &in.Memory, &out.Memory
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.CPU != nil {
+		in, out := &in.CPU, &out.CPU
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Resources.
+func (in *Resources) DeepCopy() *Resources {
+	if in == nil {
+		return nil
+	}
+	out := new(Resources)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Step) DeepCopyInto(out *Step) {
 	*out = *in
@@ -173,3 +283,20 @@ func (in *Step) DeepCopy() *Step {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StepUsage) DeepCopyInto(out *StepUsage) {
+	*out = *in
+	out.WallTime = in.WallTime
+	out.CPUTime = in.CPUTime
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StepUsage.
+func (in *StepUsage) DeepCopy() *StepUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(StepUsage)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -28,6 +28,21 @@ type WorkerPoolList struct {
 
 type WorkerPoolSpec struct {
 	Workers map[string]Worker `json:"workers,omitempty"`
+	// Sandbox runs the commands of the calculations in a sandbox. They run as the worker if unset.
+	Sandbox *Sandbox `json:"sandbox,omitempty"`
+}
+
+// Sandbox isolates the commands of the calculations in Linux user, mount, pid and network
+// namespaces. The commands only see the input files of their calculation read-only and can only
+// write to its folder, without access to the shared storage or the service account token.
+type Sandbox struct {
+	// Network keeps the network of the node, which is disabled by default.
+	Network bool `json:"network,omitempty"`
+	// RunAsUser is the user the commands run as when the worker runs as root, 65534 by default.
+	// Unprivileged workers run the commands as their own user.
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+	// RunAsGroup is the group the commands run as when the worker runs as root, 65534 by default.
+	RunAsGroup *int64 `json:"runAsGroup,omitempty"`
 }
 
 type CalculationBulk struct {
@@ -52,10 +67,15 @@ const (
 	WorkerReservedState   WorkerState = "Reserved"
 	WorkerProcessingState WorkerState = "Processing"
 	WorkerUnknownState    WorkerState = "Unknown"
+	// WorkerDrainingState is set on a worker that finishes its calculation and then leaves the pool.
+	// The dispatcher doesn't assign calculations to draining workers.
+	WorkerDrainingState WorkerState = "Draining"
 )
 
 type WorkerPoolStatus struct {
 	CreationTime   *metav1.Time `json:"creationTime,omitempty"`
 	PendingTime    *metav1.Time `json:"pendingTime,omitempty"`
 	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Conditions represent the latest available observations of the pool's state.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
@@ -0,0 +1,262 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/utils/lru"
+)
+
+// localStore keeps the artifacts in a local folder, which can be a mounted shared storage like NFS.
+type localStore struct {
+	root string
+
+	// digests caches the sha256 sums of the files by path, until their size or modification time changes.
+	digests *lru.Cache
+}
+
+// fileDigest is the cached sha256 sum of a file.
+type fileDigest struct {
+	size    int64
+	modTime time.Time
+	digest  string
+}
+
+// NewLocalStore returns an ArtifactStore that keeps the artifacts in the given folder.
+func NewLocalStore(root string) ArtifactStore {
+	return &localStore{root: root, digests: lru.New(maxCachedDigests)}
+}
+
+func (s *localStore) path(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *localStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return f, err
+}
+
+func (s *localStore) Download(ctx context.Context, key, dest string) error {
+	in, err := s.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dest, in)
+}
+
+// Link creates a symbolic link to the artifact, so large inputs are not copied.
+func (s *localStore) Link(ctx context.Context, key, dest string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return err
+	}
+	return os.Symlink(path, dest)
+}
+
+func (s *localStore) Upload(ctx context.Context, src, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(path, in)
+}
+
+func (s *localStore) List(ctx context.Context, folder string) ([]string, error) {
+	dir, err := s.path(folder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var keys []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list the artifacts in %s: %w", folder, err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *localStore) Remove(ctx context.Context, key string) error {
+	cleaned, err := folderKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(s.root, filepath.FromSlash(cleaned))); err != nil {
+		return fmt.Errorf("couldn't remove %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *localStore) Size(ctx context.Context, key string) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("couldn't compute the size of %s: %w", key, err)
+	}
+	return size, nil
+}
+
+// Digests reads only the files that changed since their sums were computed, so large input
+// files are read once.
+func (s *localStore) Digests(ctx context.Context, root string, paths []string) (map[string]string, error) {
+	dir, err := s.path(root)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string)
+	for _, p := range paths {
+		err := filepath.Walk(filepath.Join(dir, filepath.FromSlash(p)), func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			digest, err := s.digest(ctx, file)
+			if err != nil {
+				return err
+			}
+			digests[filepath.ToSlash(rel)] = digest
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't compute the digests of %s: %w", p, err)
+		}
+	}
+	return digests, nil
+}
+
+func (s *localStore) digest(ctx context.Context, file string) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if cached, ok := s.digests.Get(file); ok {
+		if cached := cached.(fileDigest); cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+			return cached.digest, nil
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, &contextReader{ctx: ctx, r: f}); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	s.digests.Add(file, fileDigest{size: info.Size(), modTime: info.ModTime(), digest: digest})
+	return digest, nil
+}
+
+// contextReader stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// writeFile writes the content through a temporary file in the same folder, so the file is
+// either complete or missing.
+func writeFile(path string, content io.Reader) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, content); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
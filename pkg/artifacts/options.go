@@ -0,0 +1,114 @@
+package artifacts
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	FilesystemBackend = "filesystem"
+	S3Backend         = "s3"
+)
+
+type Options struct {
+	backend string
+
+	s3Endpoint      string
+	s3Bucket        string
+	s3Prefix        string
+	s3Region        string
+	s3AccessKeyFile string
+	s3SecretKeyFile string
+	s3Insecure      bool
+}
+
+func (o *Options) Bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.backend, "artifact-store", FilesystemBackend, fmt.Sprintf("Storage of the input and output files of the calculations, one of: %s (the folder at --nfs-path, shared or local), %s", FilesystemBackend, S3Backend))
+	fs.StringVar(&o.s3Endpoint, "s3-endpoint", "", "Endpoint of the S3 compatible storage, e.g. minio:9000")
+	fs.StringVar(&o.s3Bucket, "s3-bucket", "", "Bucket where the artifacts are kept")
+	fs.StringVar(&o.s3Prefix, "s3-prefix", "", "Prefix of the keys of the artifacts in the bucket")
+	fs.StringVar(&o.s3Region, "s3-region", "", "Region of the bucket")
+	fs.StringVar(&o.s3AccessKeyFile, "s3-access-key-file", "", "File with the access key of the S3 compatible storage. If not specified, the credentials are taken from the environment or the instance metadata")
+	fs.StringVar(&o.s3SecretKeyFile, "s3-secret-key-file", "", "File with the secret key of the S3 compatible storage")
+	fs.BoolVar(&o.s3Insecure, "s3-insecure", false, "Connect to the S3 compatible storage without TLS")
+}
+
+func (o *Options) Validate() error {
+	var errs []error
+	switch o.backend {
+	case FilesystemBackend:
+	case S3Backend:
+		if o.s3Endpoint == "" {
+			errs = append(errs, fmt.Errorf("--s3-endpoint is not specified"))
+		}
+		if o.s3Bucket == "" {
+			errs = append(errs, fmt.Errorf("--s3-bucket is not specified"))
+		}
+		if (o.s3AccessKeyFile == "") != (o.s3SecretKeyFile == "") {
+			errs = append(errs, fmt.Errorf("--s3-access-key-file and --s3-secret-key-file must be specified together"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("--artifact-store %q is not supported", o.backend))
+	}
+	return errors.NewAggregate(errs)
+}
+
+// NewArtifactStore returns the configured store. The filesystem store keeps the artifacts in the given folder.
+func (o *Options) NewArtifactStore(nfsPath string) (ArtifactStore, error) {
+	if o.backend != S3Backend {
+		return NewLocalStore(nfsPath), nil
+	}
+
+	creds, err := o.s3Credentials()
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(o.s3Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !o.s3Insecure,
+		Region: o.s3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the S3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, o.s3Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't check the bucket %s: %w", o.s3Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s doesn't exist", o.s3Bucket)
+	}
+	return newS3Store(client, o.s3Bucket, o.s3Prefix), nil
+}
+
+func (o *Options) s3Credentials() (*credentials.Credentials, error) {
+	if o.s3AccessKeyFile == "" {
+		return credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{},
+		}), nil
+	}
+
+	accessKey, err := os.ReadFile(o.s3AccessKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the S3 access key: %w", err)
+	}
+	secretKey, err := os.ReadFile(o.s3SecretKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the S3 secret key: %w", err)
+	}
+	return credentials.NewStaticV4(strings.TrimSpace(string(accessKey)), strings.TrimSpace(string(secretKey)), ""), nil
+}
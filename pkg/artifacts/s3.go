@@ -0,0 +1,321 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+
+	"k8s.io/utils/lru"
+)
+
+// sha256Metadata is the user metadata where the sha256 sum of the uploaded artifacts is kept, so
+// the digests of the input files don't require downloading them.
+const sha256Metadata = "Sha256"
+
+// s3Store keeps the artifacts in a bucket of an S3 compatible object storage, like MinIO.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+
+	// digests caches the sha256 sums of the objects by object name and ETag.
+	digests *lru.Cache
+}
+
+func newS3Store(client *minio.Client, bucket, prefix string) *s3Store {
+	return &s3Store{
+		client:  client,
+		bucket:  bucket,
+		prefix:  strings.Trim(prefix, "/"),
+		digests: lru.New(maxCachedDigests),
+	}
+}
+
+func (s *s3Store) objectName(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return Key(s.prefix, cleaned), nil
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	object, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.wrapError(err, key)
+	}
+	// The object is fetched lazily, stat it to report missing artifacts right away.
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, s.wrapError(err, key)
+	}
+	return object, nil
+}
+
+func (s *s3Store) Download(ctx context.Context, key, dest string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	if err := s.client.FGetObject(ctx, s.bucket, name, dest, minio.GetObjectOptions{}); err != nil {
+		return s.wrapError(err, key)
+	}
+	return nil
+}
+
+// Link downloads the artifact, since objects can't be linked to.
+func (s *s3Store) Link(ctx context.Context, key, dest string) error {
+	return s.Download(ctx, key, dest)
+}
+
+func (s *s3Store) Upload(ctx context.Context, src, key string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+
+	sum, err := fileChecksum(src)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.FPutObject(ctx, s.bucket, name, src, minio.PutObjectOptions{
+		UserMetadata: map[string]string{sha256Metadata: sum},
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't upload %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *s3Store) List(ctx context.Context, folder string) ([]string, error) {
+	objects, err := s.listObjects(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, s.key(object.Key))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Remove deletes the object of the artifact and the objects in the folder of the same name.
+func (s *s3Store) Remove(ctx context.Context, key string) error {
+	if _, err := folderKey(key); err != nil {
+		return err
+	}
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	objects, err := s.listObjects(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	names := []string{name}
+	for _, object := range objects {
+		names = append(names, object.Key)
+	}
+	for _, name := range names {
+		if err := s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("couldn't remove %s: %w", s.key(name), err)
+		}
+	}
+	return nil
+}
+
+// Size adds the size of the object of the artifact to the sizes of the objects in the folder of the same name.
+func (s *s3Store) Size(ctx context.Context, key string) (int64, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return 0, err
+	}
+	objects, err := s.listObjects(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	if name != "" {
+		info, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{})
+		if err != nil && !isNotFound(err) {
+			return 0, fmt.Errorf("couldn't compute the size of %s: %w", key, err)
+		}
+		if err == nil {
+			size += info.Size
+		}
+	}
+	for _, object := range objects {
+		size += object.Size
+	}
+	return size, nil
+}
+
+func (s *s3Store) listObjects(ctx context.Context, folder string) ([]minio.ObjectInfo, error) {
+	name, err := s.objectName(folder)
+	if err != nil {
+		return nil, err
+	}
+	prefix := name
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var objects []minio.ObjectInfo
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("couldn't list the artifacts in %s: %w", folder, object.Err)
+		}
+		// Folder markers created by some clients are not artifacts.
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// key returns the key of the artifact stored in the object with the given name.
+func (s *s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, s.prefix+"/")
+}
+
+// Digests uses the sums recorded when the artifacts were uploaded, and downloads only the
+// artifacts that were uploaded by other clients.
+func (s *s3Store) Digests(ctx context.Context, root string, paths []string) (map[string]string, error) {
+	rootKey, err := cleanKey(root)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string)
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		key := Key(rootKey, p)
+		name, err := s.objectName(key)
+		if err != nil {
+			return nil, err
+		}
+
+		objects := []minio.ObjectInfo{}
+		info, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{})
+		switch {
+		case err == nil:
+			objects = append(objects, info)
+		case isNotFound(err):
+			if objects, err = s.listObjects(ctx, key); err != nil {
+				return nil, err
+			}
+			if len(objects) == 0 {
+				return nil, fmt.Errorf("couldn't compute the digests of %s: %w", p, ErrNotFound)
+			}
+		default:
+			return nil, fmt.Errorf("couldn't compute the digests of %s: %w", p, err)
+		}
+
+		for _, object := range objects {
+			digest, err := s.digest(ctx, object)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't compute the digests of %s: %w", p, err)
+			}
+			rel := strings.TrimPrefix(s.key(object.Key), rootKey+"/")
+			digests[rel] = digest
+		}
+	}
+	return digests, nil
+}
+
+func (s *s3Store) digest(ctx context.Context, object minio.ObjectInfo) (string, error) {
+	cacheKey := object.Key + "@" + object.ETag
+	if cached, ok := s.digests.Get(cacheKey); ok {
+		return cached.(string), nil
+	}
+
+	// Listed objects come without their metadata.
+	if object.UserMetadata == nil {
+		info, err := s.client.StatObject(ctx, s.bucket, object.Key, minio.StatObjectOptions{})
+		if err != nil {
+			return "", err
+		}
+		object = info
+	}
+
+	digest := recordedDigest(object)
+	if digest == "" {
+		var err error
+		if digest, err = s.computeDigest(ctx, object.Key); err != nil {
+			return "", err
+		}
+	}
+
+	s.digests.Add(cacheKey, digest)
+	return digest, nil
+}
+
+// recordedDigest returns the sum that was recorded when the object was uploaded, if any.
+func recordedDigest(object minio.ObjectInfo) string {
+	for name, value := range object.UserMetadata {
+		if strings.EqualFold(name, sha256Metadata) {
+			return value
+		}
+	}
+	return ""
+}
+
+func (s *s3Store) computeDigest(ctx context.Context, name string) (string, error) {
+	reader, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *s3Store) wrapError(err error, key string) error {
+	if isNotFound(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return fmt.Errorf("couldn't get %s: %w", key, err)
+}
+
+func isNotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == minio.NoSuchKey
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,72 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNotFound is returned when an artifact doesn't exist in the store.
+var ErrNotFound = errors.New("artifact not found")
+
+// maxCachedDigests bounds the number of sums the stores keep in memory, the least recently used
+// are evicted first.
+const maxCachedDigests = 100000
+
+// ArtifactStore keeps the input and output files of the calculations. Artifacts are addressed by
+// slash separated keys relative to the root of the store, e.g. <root folder>/atlas-data-files/molecules.dat.
+type ArtifactStore interface {
+	// Open returns the content of the artifact.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Download writes the artifact to the given local file.
+	Download(ctx context.Context, key, dest string) error
+	// Link makes the artifact available at the given local path as cheaply as the store allows.
+	// The local file must not be modified, since it can be the artifact itself.
+	Link(ctx context.Context, key, dest string) error
+	// Upload stores the given local file as an artifact.
+	Upload(ctx context.Context, src, key string) error
+	// List returns the keys of the artifacts in the given folder and its subfolders, sorted.
+	List(ctx context.Context, folder string) ([]string, error)
+	// Remove deletes the artifact, or the folder with all the artifacts in it. Removing a missing
+	// artifact is not an error, and the root of the store can't be removed.
+	Remove(ctx context.Context, key string) error
+	// Size returns the size in bytes of the artifact, or of all the artifacts in the folder.
+	// Missing artifacts have no size.
+	Size(ctx context.Context, key string) (int64, error)
+	// Digests returns the sha256 sums of the artifacts at the given paths relative to the root
+	// folder, keyed by their path. Folders are walked and every artifact in them is included.
+	Digests(ctx context.Context, root string, paths []string) (map[string]string, error)
+}
+
+// Key joins the given elements into an artifact key.
+func Key(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+// folderKey cleans the key of an artifact or folder that is removed, which can't be the root of the store.
+func folderKey(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if cleaned == "" {
+		return "", fmt.Errorf("the root of the store can't be removed")
+	}
+	return cleaned, nil
+}
+
+// cleanKey normalizes the key and makes sure it doesn't point outside of the store.
+// The empty key is the root of the store.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(key, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid artifact key %q", key)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
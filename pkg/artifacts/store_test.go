@@ -0,0 +1,290 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3EndpointEnv enables the conformance tests against a live S3 compatible storage, like MinIO.
+// The bucket and the credentials are taken from VEGA_TEST_S3_BUCKET, VEGA_TEST_S3_ACCESS_KEY and VEGA_TEST_S3_SECRET_KEY.
+const s3EndpointEnv = "VEGA_TEST_S3_ENDPOINT"
+
+func TestLocalStore(t *testing.T) {
+	testArtifactStore(t, func(t *testing.T) ArtifactStore {
+		return NewLocalStore(t.TempDir())
+	})
+}
+
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv(s3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", s3EndpointEnv)
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4(os.Getenv("VEGA_TEST_S3_ACCESS_KEY"), os.Getenv("VEGA_TEST_S3_SECRET_KEY"), ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := os.Getenv("VEGA_TEST_S3_BUCKET")
+
+	testArtifactStore(t, func(t *testing.T) ArtifactStore {
+		// Every test gets its own prefix, so they don't see each other's artifacts.
+		return newS3Store(client, bucket, fmt.Sprintf("test-%d", time.Now().UnixNano()))
+	})
+}
+
+// testArtifactStore is the conformance suite that every ArtifactStore backend must pass.
+func testArtifactStore(t *testing.T, newStore func(t *testing.T) ArtifactStore) {
+	ctx := context.Background()
+
+	upload := func(t *testing.T, store ArtifactStore, files map[string]string) {
+		dir := t.TempDir()
+		for key, content := range files {
+			src := filepath.Join(dir, "upload")
+			if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Upload(ctx, src, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	readFile := func(t *testing.T, path string) string {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	t.Run("uploaded artifacts can be opened, downloaded and linked", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{"root/data/molecules.dat": "molecules"})
+
+		f, err := store.Open(ctx, "root/data/molecules.dat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "molecules" {
+			t.Fatalf("expected the uploaded content, got %q", content)
+		}
+
+		dir := t.TempDir()
+		if err := store.Download(ctx, "root/data/molecules.dat", filepath.Join(dir, "downloaded")); err != nil {
+			t.Fatal(err)
+		}
+		if content := readFile(t, filepath.Join(dir, "downloaded")); content != "molecules" {
+			t.Fatalf("expected the uploaded content, got %q", content)
+		}
+		if err := store.Link(ctx, "root/data/molecules.dat", filepath.Join(dir, "linked")); err != nil {
+			t.Fatal(err)
+		}
+		if content := readFile(t, filepath.Join(dir, "linked")); content != "molecules" {
+			t.Fatalf("expected the uploaded content, got %q", content)
+		}
+	})
+
+	t.Run("uploads replace the previous artifact", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{"root/bulk.yaml": "first"})
+		upload(t, store, map[string]string{"root/bulk.yaml": "second"})
+
+		dest := filepath.Join(t.TempDir(), "bulk.yaml")
+		if err := store.Download(ctx, "root/bulk.yaml", dest); err != nil {
+			t.Fatal(err)
+		}
+		if content := readFile(t, dest); content != "second" {
+			t.Fatalf("expected the last uploaded content, got %q", content)
+		}
+	})
+
+	t.Run("missing artifacts return not found", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.Open(ctx, "root/missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound from Open, got %v", err)
+		}
+		dir := t.TempDir()
+		if err := store.Download(ctx, "root/missing", filepath.Join(dir, "missing")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound from Download, got %v", err)
+		}
+		if err := store.Link(ctx, "root/missing", filepath.Join(dir, "missing")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound from Link, got %v", err)
+		}
+	})
+
+	t.Run("keys outside of the store are rejected", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.Open(ctx, "../outside"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected an invalid key error, got %v", err)
+		}
+	})
+
+	t.Run("folders are listed recursively", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{
+			"root/data/b.dat":        "b",
+			"root/data/a.dat":        "a",
+			"root/data/nested/c.dat": "c",
+			"root/data-other/d.dat":  "d",
+			"root/control/template":  "template",
+		})
+
+		keys, err := store.List(ctx, "root/data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"root/data/a.dat", "root/data/b.dat", "root/data/nested/c.dat"}, keys); diff != "" {
+			t.Fatal(diff)
+		}
+
+		keys, err = store.List(ctx, "root/missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 0 {
+			t.Fatalf("expected no artifacts in a missing folder, got %v", keys)
+		}
+	})
+
+	t.Run("folders are removed with their artifacts", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{
+			"root/bulk/calc/fort.7":  "results",
+			"root/bulk/calc/nested":  "nested",
+			"root/bulk-other/fort.7": "other",
+			"root/bulk.yaml":         "bulk",
+		})
+
+		if err := store.Remove(ctx, "root/bulk"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Remove(ctx, "root/bulk.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Remove(ctx, "root/missing"); err != nil {
+			t.Fatalf("expected no error for a missing folder, got %v", err)
+		}
+		keys, err := store.List(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"root/bulk-other/fort.7"}, keys); diff != "" {
+			t.Fatal(diff)
+		}
+
+		for _, key := range []string{"", "/", "..", "root/.."} {
+			if err := store.Remove(ctx, key); err == nil {
+				t.Fatalf("expected the removal of %q to be rejected", key)
+			}
+		}
+	})
+
+	t.Run("sizes of artifacts and folders", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{
+			"root/bulk/calc/fort.7":  "results",
+			"root/bulk/calc/nested":  "nested",
+			"root/bulk-other/fort.7": "other",
+		})
+
+		for key, expected := range map[string]int64{
+			"root/bulk":             13,
+			"root/bulk/calc/fort.7": 7,
+			"root":                  18,
+			"root/missing":          0,
+		} {
+			size, err := store.Size(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != expected {
+				t.Fatalf("expected the size of %s to be %d, got %d", key, expected, size)
+			}
+		}
+	})
+
+	t.Run("digests of files and folders are keyed by their path in the root folder", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{
+			"root/data/a.dat":       "a",
+			"root/data/nested/b":    "b",
+			"root/control/template": "template",
+		})
+
+		digests, err := store.Digests(ctx, "root", []string{"data", "control/template"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{}
+		for _, path := range []string{"data/a.dat", "data/nested/b", "control/template"} {
+			expected[path] = checksum(t, store, "root/"+path)
+		}
+		if diff := cmp.Diff(expected, digests); diff != "" {
+			t.Fatal(diff)
+		}
+
+		if _, err := store.Digests(ctx, "root", []string{"missing"}); err == nil {
+			t.Fatal("expected an error for missing input files")
+		}
+	})
+
+	t.Run("digests of changed artifacts are recomputed", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{"root/data": "old"})
+		old, err := store.Digests(ctx, "root", []string{"data"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		upload(t, store, map[string]string{"root/data": "new content"})
+		digests, err := store.Digests(ctx, "root", []string{"data"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digests["data"] == old["data"] || digests["data"] != checksum(t, store, "root/data") {
+			t.Fatalf("expected the digest of the new content, got %s", digests["data"])
+		}
+	})
+
+	t.Run("digests are not computed once the context is done", func(t *testing.T) {
+		store := newStore(t)
+		upload(t, store, map[string]string{"root/data": "data"})
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		if _, err := store.Digests(cancelled, "root", []string{"data"}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the digests to be cancelled, got %v", err)
+		}
+	})
+}
+
+// checksum computes the sha256 sum of the artifact from its content.
+func checksum(t *testing.T, store ArtifactStore, key string) string {
+	dest := filepath.Join(t.TempDir(), "artifact")
+	if err := store.Download(context.Background(), key, dest); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileChecksum(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
@@ -0,0 +1,191 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/db"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+const (
+	controllerName = "results-collector"
+)
+
+// AddToManager adds the results collector, which organises the output files of the completed
+// calculations in the shared storage and registers them in the results store.
+func AddToManager(mgr manager.Manager, ns, nfsPath string, registry db.OutputRegistry) error {
+	c, err := controller.New(controllerName, mgr, controller.Options{
+		MaxConcurrentReconciles: 1,
+		Reconciler: &reconciler{
+			logger:   logrus.WithField("controller", controllerName),
+			client:   mgr.GetClient(),
+			nfsPath:  nfsPath,
+			registry: registry,
+			now:      time.Now,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to construct controller: %w", err)
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1.Calculation{}, &calculationHandler{namespace: ns})); err != nil {
+		return fmt.Errorf("failed to create watch for calculations: %w", err)
+	}
+
+	return nil
+}
+
+type calculationHandler struct {
+	namespace string
+}
+
+func (h *calculationHandler) Create(ctx context.Context, e event.TypedCreateEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(e.Object, q)
+}
+
+func (h *calculationHandler) Update(ctx context.Context, e event.TypedUpdateEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(e.ObjectNew, q)
+}
+
+func (h *calculationHandler) Delete(ctx context.Context, e event.TypedDeleteEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
+func (h *calculationHandler) Generic(ctx context.Context, e event.TypedGenericEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
+func (h *calculationHandler) enqueue(calc *v1.Calculation, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if h.namespace != calc.Namespace || !needsCollecting(calc) {
+		return
+	}
+	q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: calc.Namespace, Name: calc.Name}})
+}
+
+// needsCollecting reports whether the calculation is completed and its results are not collected yet.
+func needsCollecting(calc *v1.Calculation) bool {
+	_, collected := calc.Labels[util.ResultsCollected]
+	return calc.Phase == v1.CompletedPhase && !collected
+}
+
+type reconciler struct {
+	logger   *logrus.Entry
+	client   ctrlruntimeclient.Client
+	nfsPath  string
+	registry db.OutputRegistry
+	now      func() time.Time
+}
+
+func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := r.logger.WithField("request", req.String())
+	err := r.reconcile(ctx, req, logger)
+	if err != nil {
+		logger.WithError(err).Error("Reconciliation failed")
+	} else {
+		logger.Info("Finished reconciliation")
+	}
+	return reconcile.Result{}, err
+}
+
+func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logger *logrus.Entry) error {
+	logger.Info("Starting reconciliation")
+
+	calc := &v1.Calculation{}
+	if err := r.client.Get(ctx, req.NamespacedName, calc); err != nil {
+		return ctrlruntimeclient.IgnoreNotFound(err)
+	}
+	if !needsCollecting(calc) {
+		return nil
+	}
+
+	manifest, err := r.collect(calc)
+	if err != nil {
+		return fmt.Errorf("couldn't collect the output files: %w", err)
+	}
+
+	// The paths are registered relative to the shared storage, which is mounted at different paths.
+	dir, err := filepath.Rel(r.nfsPath, util.CollectedOutputPath(r.nfsPath, calc))
+	if err != nil {
+		return err
+	}
+	outputs := make([]*db.CalculationOutput, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		outputs = append(outputs, &db.CalculationOutput{
+			Bulk:      manifest.Bulk,
+			InputHash: manifest.InputHash,
+			Path:      filepath.ToSlash(filepath.Join(dir, file.Path)),
+			Size:      file.Size,
+			SHA256:    file.SHA256,
+		})
+	}
+	if err := r.registry.RegisterOutputs(ctx, calc.Name, outputs); err != nil {
+		return fmt.Errorf("couldn't register the output files: %w", err)
+	}
+
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.client.Get(ctx, req.NamespacedName, calc); err != nil {
+			return err
+		}
+		if calc.Labels == nil {
+			calc.Labels = make(map[string]string)
+		}
+		calc.Labels[util.ResultsCollected] = "true"
+		return r.client.Update(ctx, calc)
+	}); err != nil {
+		return fmt.Errorf("couldn't label the calculation as collected: %w", err)
+	}
+
+	logger.WithFields(logrus.Fields{"files": len(manifest.Files), "path": dir}).Info("Results collected")
+	return nil
+}
+
+// collect moves the output files of the calculation from the staging folder to its collected
+// output folder and writes their manifest. Every step can be repeated, so a collection that was
+// interrupted is completed by the next reconciliation.
+func (r *reconciler) collect(calc *v1.Calculation) (*Manifest, error) {
+	stagingDir := util.OutputStagingPath(r.nfsPath, calc)
+	destDir := util.CollectedOutputPath(r.nfsPath, calc)
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := moveFiles(stagingDir, destDir); err != nil {
+		return nil, err
+	}
+
+	files, err := listOutputFiles(destDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		Calculation: calc.Name,
+		Bulk:        calc.Labels[util.BulkLabel],
+		InputHash:   calc.InputHash,
+		Parameters:  util.ResultParameters(calc.Spec.Params),
+		CollectedAt: r.now().UTC(),
+		Files:       files,
+	}
+	if err := writeManifest(destDir, manifest); err != nil {
+		return nil, fmt.Errorf("couldn't write the manifest: %w", err)
+	}
+
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return nil, fmt.Errorf("couldn't remove the staging folder: %w", err)
+	}
+	return manifest, nil
+}
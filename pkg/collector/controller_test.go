@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/db"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+func newTestCalculation(phase v1.CalculationPhase, labels map[string]string) *v1.Calculation {
+	calcLabels := map[string]string{
+		util.BulkLabel:            "bulk-1",
+		util.CalculationNameLabel: "calc-1",
+		util.CalcRootFolder:       "root",
+	}
+	for key, value := range labels {
+		calcLabels[key] = value
+	}
+	return &v1.Calculation{
+		ObjectMeta: metav1.ObjectMeta{Name: "calc-abcdef", Namespace: "vega", Labels: calcLabels},
+		Spec:       v1.CalculationSpec{Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+		InputHash:  "hash",
+		Phase:      phase,
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		name              string
+		calculation       *v1.Calculation
+		staged            map[string]string
+		alreadyCollected  map[string]string
+		expectedManifest  *Manifest
+		expectedOutputs   []string
+		expectedCollected bool
+	}{
+		{
+			name:        "output files are organised, registered and the calculation is labeled",
+			calculation: newTestCalculation(v1.CompletedPhase, nil),
+			staged: map[string]string{
+				"spectrum.out":     "spectrum",
+				"models/model.out": "model",
+			},
+			expectedManifest: &Manifest{
+				Calculation: "calc-abcdef",
+				Bulk:        "bulk-1",
+				InputHash:   "hash",
+				Parameters:  map[string]string{"teff": "10000.000000", "log_g": "4.000000"},
+				CollectedAt: now,
+				Files: []OutputFile{
+					{Path: "models/model.out", Size: 5},
+					{Path: "spectrum.out", Size: 8},
+				},
+			},
+			expectedOutputs:   []string{"root/bulk-1/calc-1/models/model.out", "root/bulk-1/calc-1/spectrum.out"},
+			expectedCollected: true,
+		},
+		{
+			name:        "an interrupted collection is completed",
+			calculation: newTestCalculation(v1.CompletedPhase, nil),
+			staged:      map[string]string{"spectrum.out": "spectrum"},
+			alreadyCollected: map[string]string{
+				"models/model.out": "model",
+			},
+			expectedManifest: &Manifest{
+				Calculation: "calc-abcdef",
+				Bulk:        "bulk-1",
+				InputHash:   "hash",
+				Parameters:  map[string]string{"teff": "10000.000000", "log_g": "4.000000"},
+				CollectedAt: now,
+				Files: []OutputFile{
+					{Path: "models/model.out", Size: 5},
+					{Path: "spectrum.out", Size: 8},
+				},
+			},
+			expectedOutputs:   []string{"root/bulk-1/calc-1/models/model.out", "root/bulk-1/calc-1/spectrum.out"},
+			expectedCollected: true,
+		},
+		{
+			name:        "calculations without output files get an empty manifest",
+			calculation: newTestCalculation(v1.CompletedPhase, nil),
+			expectedManifest: &Manifest{
+				Calculation: "calc-abcdef",
+				Bulk:        "bulk-1",
+				InputHash:   "hash",
+				Parameters:  map[string]string{"teff": "10000.000000", "log_g": "4.000000"},
+				CollectedAt: now,
+				Files:       []OutputFile{},
+			},
+			expectedOutputs:   []string{},
+			expectedCollected: true,
+		},
+		{
+			name:        "calculations that are not completed are not collected",
+			calculation: newTestCalculation(v1.ProcessingPhase, nil),
+			staged:      map[string]string{"spectrum.out": "spectrum"},
+		},
+		{
+			name:              "calculations that were already collected are skipped",
+			calculation:       newTestCalculation(v1.CompletedPhase, map[string]string{util.ResultsCollected: "true"}),
+			staged:            map[string]string{"spectrum.out": "spectrum"},
+			expectedCollected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nfsPath := t.TempDir()
+			stagingDir := util.OutputStagingPath(nfsPath, tc.calculation)
+			destDir := util.CollectedOutputPath(nfsPath, tc.calculation)
+			for dir, files := range map[string]map[string]string{stagingDir: tc.staged, destDir: tc.alreadyCollected} {
+				for name, content := range files {
+					path := filepath.Join(dir, name)
+					if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			registry := db.NewMemoryResultsStore().(db.OutputRegistry)
+			r := &reconciler{
+				logger:   logrus.WithField("test-name", tc.name),
+				client:   fakectrlruntimeclient.NewClientBuilder().WithObjects(tc.calculation).Build(),
+				nfsPath:  nfsPath,
+				registry: registry,
+				now:      func() time.Time { return now },
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: tc.calculation.Name}}
+			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+				t.Fatal(err)
+			}
+
+			calc := &v1.Calculation{}
+			if err := r.client.Get(context.Background(), req.NamespacedName, calc); err != nil {
+				t.Fatal(err)
+			}
+			if _, collected := calc.Labels[util.ResultsCollected]; collected != tc.expectedCollected {
+				t.Fatalf("expected the collected label to be %t, got labels %v", tc.expectedCollected, calc.Labels)
+			}
+
+			if tc.expectedManifest == nil {
+				if _, err := os.Stat(filepath.Join(destDir, ManifestFile)); !os.IsNotExist(err) {
+					t.Fatalf("expected no manifest, got %v", err)
+				}
+				return
+			}
+
+			manifest, err := ReadManifest(destDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedManifest, manifest, cmpopts.IgnoreFields(OutputFile{}, "SHA256")); diff != "" {
+				t.Fatal(diff)
+			}
+			for _, file := range manifest.Files {
+				sum, err := fileChecksum(filepath.Join(destDir, file.Path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sum != file.SHA256 {
+					t.Errorf("expected checksum %s for %s, got %s", sum, file.Path, file.SHA256)
+				}
+			}
+
+			if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+				t.Fatalf("expected the staging folder to be removed, got %v", err)
+			}
+
+			outputs, err := registry.GetOutputs(context.Background(), tc.calculation.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := []string{}
+			for _, output := range outputs {
+				if output.InputHash != "hash" || output.Bulk != "bulk-1" {
+					t.Errorf("unexpected registered output: %+v", output)
+				}
+				paths = append(paths, output.Path)
+			}
+			if diff := cmp.Diff(tc.expectedOutputs, paths); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// ManifestFile is the name of the manifest in the folder of the collected output files.
+const ManifestFile = "manifest.json"
+
+// Manifest describes the output files of a calculation that were collected.
+type Manifest struct {
+	Calculation string            `json:"calculation"`
+	Bulk        string            `json:"bulk,omitempty"`
+	InputHash   string            `json:"input_hash,omitempty"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+	CollectedAt time.Time         `json:"collected_at"`
+	Files       []OutputFile      `json:"files"`
+}
+
+// OutputFile is a collected output file, with its path relative to the folder of the manifest.
+type OutputFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// moveFiles moves the files of the source folder to the destination folder, keeping their
+// relative paths. A missing source folder has no files to move.
+func moveFiles(srcDir, destDir string) error {
+	if _, err := os.Stat(srcDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		return moveFile(path, dest)
+	})
+}
+
+// moveFile renames the file, or copies and removes it if the destination is on another filesystem.
+func moveFile(src, dest string) error {
+	err := os.Rename(src, dest)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// listOutputFiles returns the files of the folder with their sizes and checksums, sorted by path.
+// The manifest itself is left out.
+func listOutputFiles(dir string) ([]OutputFile, error) {
+	files := []OutputFile{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == ManifestFile {
+			return nil
+		}
+
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, OutputFile{Path: filepath.ToSlash(rel), Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeManifest writes the manifest through a temporary file, so a manifest is always complete.
+func writeManifest(dir string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, "."+ManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ManifestFile))
+}
+
+// ReadManifest reads the manifest of the collected output files in the given folder.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("couldn't parse the manifest: %w", err)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	archiveBatchSize = 100
+	archiveTimeFmt   = "20060102T150405Z"
+)
+
+// archivedResult is the format of the results in the archive files, one JSON document per line.
+type archivedResult struct {
+	ID         uint              `json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	Parameters map[string]string `json:"parameters"`
+	InputHash  string            `json:"input_hash,omitempty"`
+	Results    string            `json:"results"`
+}
+
+// Archiver moves results from the store to gzip compressed archive files and back.
+type Archiver struct {
+	store  ArchivableResultsStore
+	dir    string
+	logger *logrus.Entry
+	now    func() time.Time
+}
+
+// NewArchiver returns an Archiver that keeps the archive files in the given directory.
+func NewArchiver(store CalculationResultsStore, dir string) (*Archiver, error) {
+	archivable, ok := store.(ArchivableResultsStore)
+	if !ok {
+		return nil, fmt.Errorf("the results store doesn't support archiving")
+	}
+	return &Archiver{
+		store:  archivable,
+		dir:    dir,
+		logger: logrus.WithField("component", "archiver"),
+		now:    time.Now,
+	}, nil
+}
+
+// Archive writes the results that match the filter to a new archive file and deletes them
+// from the store. The results are deleted only after the archive file is complete.
+// It returns the path of the archive file, empty if no result matched.
+func (a *Archiver) Archive(ctx context.Context, filter RetentionFilter) (string, int, error) {
+	ids, err := a.store.ListArchivable(ctx, filter)
+	if err != nil {
+		return "", 0, fmt.Errorf("couldn't list the results to archive: %w", err)
+	}
+	if len(ids) == 0 {
+		return "", 0, nil
+	}
+
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("couldn't create the archive directory: %w", err)
+	}
+
+	path := filepath.Join(a.dir, fmt.Sprintf("results-%s.jsonl.gz", a.now().UTC().Format(archiveTimeFmt)))
+	if err := a.writeArchive(ctx, path, ids); err != nil {
+		return "", 0, err
+	}
+	a.logger.WithFields(logrus.Fields{"archive": path, "results": len(ids)}).Info("Results archived")
+
+	for start := 0; start < len(ids); start += archiveBatchSize {
+		end := min(start+archiveBatchSize, len(ids))
+		if err := a.store.DeleteResults(ctx, ids[start:end]); err != nil {
+			return path, start, fmt.Errorf("couldn't delete the archived results: %w", err)
+		}
+	}
+	return path, len(ids), nil
+}
+
+func (a *Archiver) writeArchive(ctx context.Context, path string, ids []uint) (err error) {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("couldn't create the archive file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	encoder := json.NewEncoder(gz)
+	for start := 0; start < len(ids); start += archiveBatchSize {
+		end := min(start+archiveBatchSize, len(ids))
+		results, err := a.store.GetResultsByID(ctx, ids[start:end])
+		if err != nil {
+			return fmt.Errorf("couldn't get the results to archive: %w", err)
+		}
+		for _, r := range results {
+			if err := encoder.Encode(archivedResult{
+				ID:         r.ID,
+				CreatedAt:  r.CreatedAt,
+				UpdatedAt:  r.UpdatedAt,
+				Parameters: r.Parameters,
+				InputHash:  r.InputHash,
+				Results:    r.Results,
+			}); err != nil {
+				return fmt.Errorf("couldn't write the archive file: %w", err)
+			}
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("couldn't write the archive file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("couldn't sync the archive file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("couldn't close the archive file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Restore stores the results of the given archive file back to the store.
+// Results that already exist in the store are skipped.
+func (a *Archiver) Restore(ctx context.Context, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't open the archive file: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read the archive file: %w", err)
+	}
+	defer gz.Close()
+
+	var restored int
+	var batch []*CalculationResults
+	flush := func() error {
+		n, err := a.store.RestoreResults(ctx, batch)
+		restored += n
+		batch = nil
+		return err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(gz))
+	for {
+		var r archivedResult
+		if err := decoder.Decode(&r); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return restored, fmt.Errorf("couldn't decode the archive file: %w", err)
+		}
+
+		result := &CalculationResults{Parameters: r.Parameters, InputHash: r.InputHash, Results: r.Results}
+		result.CreatedAt, result.UpdatedAt = r.CreatedAt, r.UpdatedAt
+		batch = append(batch, result)
+		if len(batch) == archiveBatchSize {
+			if err := flush(); err != nil {
+				return restored, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return restored, err
+	}
+
+	a.logger.WithFields(logrus.Fields{"archive": path, "results": restored}).Info("Results restored")
+	return restored, nil
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestArchiver(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) CalculationResultsStore{
+		"memory": func(t *testing.T) CalculationResultsStore { return NewMemoryResultsStore() },
+		"sqlite": func(t *testing.T) CalculationResultsStore {
+			store, err := newSQLiteResultsStore(filepath.Join(t.TempDir(), "results.db"), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testArchiver(t, newStore(t))
+		})
+	}
+}
+
+func testArchiver(t *testing.T, store CalculationResultsStore) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	liveHash := strings.Repeat("a", 64)
+	deletedHash := strings.Repeat("b", 64)
+
+	old := &CalculationResults{Parameters: map[string]string{"teff": "10000.000000", "log_g": "4.000000"}, Results: "old"}
+	old.CreatedAt = now.AddDate(0, 0, -40)
+	live := &CalculationResults{Parameters: map[string]string{"teff": "11000.000000", "log_g": "4.000000"}, InputHash: liveHash, Results: "live"}
+	live.CreatedAt = now.AddDate(0, 0, -1)
+	deleted := &CalculationResults{Parameters: map[string]string{"teff": "12000.000000", "log_g": "4.000000"}, InputHash: deletedHash, Results: "deleted"}
+	deleted.CreatedAt = now.AddDate(0, 0, -1)
+
+	archiver, err := NewArchiver(store, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver.now = func() time.Time { return now }
+
+	if restored, err := archiver.store.RestoreResults(ctx, []*CalculationResults{old, live, deleted}); err != nil || restored != 3 {
+		t.Fatalf("expected 3 restored results, got %d: %v", restored, err)
+	}
+
+	path, archived, err := archiver.Archive(ctx, RetentionFilter{CreatedBefore: now.AddDate(0, 0, -30), LiveInputHashes: sets.New(liveHash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 2 {
+		t.Fatalf("expected 2 archived results, got %d", archived)
+	}
+	if filepath.Base(path) != "results-20240601T000000Z.jsonl.gz" {
+		t.Fatalf("unexpected archive file: %s", path)
+	}
+
+	if _, err := store.GetDataByHash(ctx, liveHash); err != nil {
+		t.Fatalf("expected the results of the live bulk to be kept: %v", err)
+	}
+	if _, err := store.GetDataByHash(ctx, deletedHash); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected the results of the deleted bulk to be archived, got %v", err)
+	}
+	if _, err := store.GetData(ctx, old.Parameters); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected the old results to be archived, got %v", err)
+	}
+
+	if _, archived, err := archiver.Archive(ctx, RetentionFilter{CreatedBefore: now.AddDate(0, 0, -30)}); err != nil || archived != 0 {
+		t.Fatalf("expected nothing to archive, got %d: %v", archived, err)
+	}
+
+	restored, err := archiver.Restore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 2 {
+		t.Fatalf("expected 2 restored results, got %d", restored)
+	}
+
+	result, err := store.GetDataByHash(ctx, deletedHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Results != "deleted" || !result.CreatedAt.Equal(deleted.CreatedAt) {
+		t.Fatalf("expected results %q created at %v, got %q created at %v", "deleted", deleted.CreatedAt, result.Results, result.CreatedAt)
+	}
+	if _, err := store.GetData(ctx, old.Parameters); err != nil {
+		t.Fatalf("expected the old results to be restored: %v", err)
+	}
+
+	// Restoring twice doesn't duplicate the results.
+	if restored, err := archiver.Restore(ctx, path); err != nil || restored != 0 {
+		t.Fatalf("expected no restored results, got %d: %v", restored, err)
+	}
+}
+
+func TestListArchivable(t *testing.T) {
+	for name, newStore := range map[string]func(t *testing.T) CalculationResultsStore{
+		"memory": func(t *testing.T) CalculationResultsStore { return NewMemoryResultsStore() },
+		"sqlite": func(t *testing.T) CalculationResultsStore {
+			store, err := newSQLiteResultsStore(filepath.Join(t.TempDir(), "results.db"), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testListArchivable(t, newStore(t))
+		})
+	}
+}
+
+func testListArchivable(t *testing.T, store CalculationResultsStore) {
+	pageSize := archivablePageSize
+	archivablePageSize = 2
+	t.Cleanup(func() { archivablePageSize = pageSize })
+
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	hash := func(c string) string { return strings.Repeat(c, 64) }
+
+	var results []*CalculationResults
+	for i, r := range []struct {
+		inputHash string
+		age       int
+	}{
+		{age: 40},
+		{inputHash: hash("a"), age: 1},
+		{inputHash: hash("b"), age: 1},
+		{age: 1},
+		{inputHash: hash("c"), age: 40},
+		{inputHash: hash("d"), age: 1},
+	} {
+		result := &CalculationResults{Parameters: map[string]string{"teff": fmt.Sprintf("%d.000000", 10000+i*1000), "log_g": "4.000000"}, InputHash: r.inputHash, Results: "results"}
+		result.CreatedAt = now.AddDate(0, 0, -r.age)
+		results = append(results, result)
+	}
+	if restored, err := store.(ArchivableResultsStore).RestoreResults(ctx, results); err != nil || restored != len(results) {
+		t.Fatalf("expected %d restored results, got %d: %v", len(results), restored, err)
+	}
+
+	testCases := []struct {
+		name     string
+		filter   RetentionFilter
+		expected []int
+	}{
+		{
+			name: "nothing matches an empty filter",
+		},
+		{
+			name:     "old results",
+			filter:   RetentionFilter{CreatedBefore: now.AddDate(0, 0, -30)},
+			expected: []int{0, 4},
+		},
+		{
+			name:     "results of deleted bulks",
+			filter:   RetentionFilter{LiveInputHashes: sets.New(hash("a"), hash("c"))},
+			expected: []int{2, 5},
+		},
+		{
+			name:     "results of deleted bulks when no bulk exists",
+			filter:   RetentionFilter{LiveInputHashes: sets.New[string]()},
+			expected: []int{1, 2, 4, 5},
+		},
+		{
+			name:     "old results or results of deleted bulks",
+			filter:   RetentionFilter{CreatedBefore: now.AddDate(0, 0, -30), LiveInputHashes: sets.New(hash("a"), hash("c"))},
+			expected: []int{0, 2, 4, 5},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ids, err := store.(ArchivableResultsStore).ListArchivable(ctx, tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archivable, err := store.(ArchivableResultsStore).GetResultsByID(ctx, ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, result := range archivable {
+				actual = append(actual, result.Parameters["teff"])
+			}
+			var expected []string
+			for _, i := range tc.expected {
+				expected = append(expected, results[i].Parameters["teff"])
+			}
+			sort.Strings(actual)
+			sort.Strings(expected)
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
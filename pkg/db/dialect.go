@@ -0,0 +1,20 @@
+package db
+
+// dialect holds the SQL that differs between the database backends.
+type dialect interface {
+	// jsonField returns an expression that extracts a key of the parameters as text,
+	// along with the argument that binds the key to the expression's placeholder.
+	jsonField(key string) (string, interface{})
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) jsonField(key string) (string, interface{}) {
+	return "parameters_json ->> ?", key
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) jsonField(key string) (string, interface{}) {
+	return "json_extract(parameters_json, ?)", "$." + key
+}
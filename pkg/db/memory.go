@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/vega-project/ccb-operator/pkg/results"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+// memoryResultsStore keeps the results in memory. It is meant for local development and tests,
+// everything is lost when the process exits.
+type memoryResultsStore struct {
+	mu      sync.RWMutex
+	results []*CalculationResults
+	outputs map[string][]*CalculationOutput
+	nextID  uint
+}
+
+// NewMemoryResultsStore returns a CalculationResultsStore that keeps the results in memory.
+func NewMemoryResultsStore() CalculationResultsStore {
+	return &memoryResultsStore{nextID: 1}
+}
+
+func (s *memoryResultsStore) StoreOrUpdateData(ctx context.Context, in *proto.StoreRequest) (*proto.StoreResponse, error) {
+	if err := validateStoreRequest(in); err != nil {
+		return nil, err
+	}
+
+	parametersJson, err := json.Marshal(in.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Results with an input hash are keyed on it, the rest on their parameters.
+	var existing *CalculationResults
+	if in.InputHash != "" {
+		existing = s.findByHash(in.InputHash)
+	} else {
+		existing = s.find(in.Parameters)
+	}
+	if existing != nil {
+		existing.Results = in.Results
+		existing.UpdatedAt = now
+		return &proto.StoreResponse{Message: "Data updated successfully"}, nil
+	}
+
+	parameters := make(map[string]string, len(in.Parameters))
+	for k, v := range in.Parameters {
+		parameters[k] = v
+	}
+
+	s.results = append(s.results, &CalculationResults{
+		Model:          gorm.Model{ID: s.nextID, CreatedAt: now, UpdatedAt: now},
+		Parameters:     parameters,
+		ParametersJSON: string(parametersJson),
+		InputHash:      in.InputHash,
+		Results:        in.Results,
+	})
+	s.nextID++
+
+	return &proto.StoreResponse{Message: "Data stored successfully"}, nil
+}
+
+func (s *memoryResultsStore) GetData(ctx context.Context, parameters map[string]string) (*CalculationResults, error) {
+	if err := results.ValidateParameters(parameters); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := s.find(parameters)
+	if result == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	ret := *result
+	return &ret, nil
+}
+
+func (s *memoryResultsStore) GetDataByHash(ctx context.Context, inputHash string) (*CalculationResults, error) {
+	if err := results.ValidateInputHash(inputHash); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := s.findByHash(inputHash)
+	if result == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	ret := *result
+	return &ret, nil
+}
+
+func (s *memoryResultsStore) GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*CalculationResults, error) {
+	for _, query := range queries {
+		if err := validateDataQuery(query); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]*CalculationResults, len(queries))
+	for i, query := range queries {
+		var result *CalculationResults
+		if query.InputHash != "" {
+			result = s.findByHash(query.InputHash)
+		} else {
+			result = s.find(query.Parameters)
+		}
+		if result == nil {
+			continue
+		}
+
+		ret := *result
+		if metadataOnly {
+			ret.Results = ""
+		}
+		results[i] = &ret
+	}
+	return results, nil
+}
+
+func (s *memoryResultsStore) StoreOrUpdateDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error) {
+	// Everything is validated first, so none of the results are stored if any request is invalid.
+	for _, in := range requests {
+		if err := validateStoreRequest(in); err != nil {
+			return nil, err
+		}
+	}
+
+	responses := make([]*proto.StoreResponse, 0, len(requests))
+	for _, in := range requests {
+		response, err := s.StoreOrUpdateData(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+func (s *memoryResultsStore) findByHash(inputHash string) *CalculationResults {
+	for _, result := range s.results {
+		if result.InputHash == inputHash {
+			return result
+		}
+	}
+	return nil
+}
+
+// find returns the newest stored result whose parameters contain all the given ones.
+func (s *memoryResultsStore) find(parameters map[string]string) *CalculationResults {
+	for i := len(s.results) - 1; i >= 0; i-- {
+		if matchesParameters(s.results[i].Parameters, parameters) {
+			return s.results[i]
+		}
+	}
+	return nil
+}
+
+func matchesParameters(stored, parameters map[string]string) bool {
+	for key, value := range parameters {
+		if v, ok := stored[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memoryResultsStore) ListArchivable(ctx context.Context, filter RetentionFilter) ([]uint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []uint
+	for _, result := range s.results {
+		if filter.matches(result) {
+			ids = append(ids, result.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memoryResultsStore) GetResultsByID(ctx context.Context, ids []uint) ([]*CalculationResults, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := sets.New(ids...)
+	var results []*CalculationResults
+	for _, result := range s.results {
+		if wanted.Has(result.ID) {
+			ret := *result
+			results = append(results, &ret)
+		}
+	}
+	return results, nil
+}
+
+func (s *memoryResultsStore) DeleteResults(ctx context.Context, ids []uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := sets.New(ids...)
+	var kept []*CalculationResults
+	for _, result := range s.results {
+		if !deleted.Has(result.ID) {
+			kept = append(kept, result)
+		}
+	}
+	s.results = kept
+	return nil
+}
+
+func (s *memoryResultsStore) RestoreResults(ctx context.Context, results []*CalculationResults) (int, error) {
+	var restored int
+	for _, result := range results {
+		exists, err := restoreExists(ctx, s, result)
+		if err != nil {
+			return restored, err
+		}
+		if exists {
+			continue
+		}
+
+		if _, err := s.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: result.Parameters, InputHash: result.InputHash, Results: result.Results}); err != nil {
+			return restored, err
+		}
+
+		// Keep the timestamps of the archived results.
+		s.mu.Lock()
+		stored := s.results[len(s.results)-1]
+		stored.CreatedAt, stored.UpdatedAt = result.CreatedAt, result.UpdatedAt
+		s.mu.Unlock()
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// migration is a versioned and reversible change of the database schema.
+// Migrations must never be modified once released, a new one must be added instead.
+type migration struct {
+	version uint
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error
+}
+
+// schemaMigration records an applied migration.
+type schemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// The models below are snapshots of CalculationResults as of each migration,
+// so changing the model doesn't change the migrations that were already applied.
+
+type calculationResultsV1 struct {
+	gorm.Model
+
+	ParametersJSON string `gorm:"column:parameters_json;type:jsonb"`
+	Results        string
+}
+
+func (calculationResultsV1) TableName() string {
+	return "calculation_results"
+}
+
+type calculationResultsV2 struct {
+	gorm.Model
+
+	ParametersJSON string `gorm:"column:parameters_json;type:jsonb"`
+	InputHash      string `gorm:"column:input_hash;index"`
+	Results        string
+}
+
+func (calculationResultsV2) TableName() string {
+	return "calculation_results"
+}
+
+type calculationOutputsV3 struct {
+	gorm.Model
+
+	Calculation string `gorm:"column:calculation;index"`
+	Bulk        string `gorm:"column:bulk"`
+	InputHash   string `gorm:"column:input_hash"`
+	Path        string `gorm:"column:path"`
+	Size        int64  `gorm:"column:size"`
+	SHA256      string `gorm:"column:sha256"`
+}
+
+func (calculationOutputsV3) TableName() string {
+	return "calculation_outputs"
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create calculation results",
+		up: func(tx *gorm.DB) error {
+			// Databases that were created by AutoMigrate already have the table.
+			if tx.Migrator().HasTable(&calculationResultsV1{}) {
+				return nil
+			}
+			return tx.Migrator().CreateTable(&calculationResultsV1{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&calculationResultsV1{})
+		},
+	},
+	{
+		version: 2,
+		name:    "add input hash",
+		up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&calculationResultsV2{}, "InputHash") {
+				if err := tx.Migrator().AddColumn(&calculationResultsV2{}, "InputHash"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&calculationResultsV2{}, "InputHash") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&calculationResultsV2{}, "InputHash")
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&calculationResultsV2{}, "InputHash"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&calculationResultsV2{}, "InputHash")
+		},
+	},
+	{
+		version: 3,
+		name:    "create calculation outputs",
+		up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&calculationOutputsV3{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&calculationOutputsV3{})
+		},
+	},
+	{
+		version: 4,
+		name:    "make the input hash unique",
+		up: func(tx *gorm.DB) error {
+			// Concurrent stores of the same inputs could create several rows, only the newest is kept.
+			if err := tx.Exec(`DELETE FROM calculation_results WHERE input_hash <> '' AND id NOT IN
+				(SELECT MAX(id) FROM calculation_results WHERE input_hash <> '' GROUP BY input_hash)`).Error; err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex(&calculationResultsV2{}, "InputHash"); err != nil {
+				return err
+			}
+			return tx.Exec(`CREATE UNIQUE INDEX idx_calculation_results_unique_input_hash ON calculation_results (input_hash) WHERE input_hash <> ''`).Error
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Exec(`DROP INDEX idx_calculation_results_unique_input_hash`).Error; err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&calculationResultsV2{}, "InputHash")
+		},
+	},
+}
+
+// LatestSchemaVersion is the schema version the results store expects.
+func LatestSchemaVersion() uint {
+	return migrations[len(migrations)-1].version
+}
+
+// Migrator applies and reverts the schema migrations of the results database.
+type Migrator struct {
+	db     *gorm.DB
+	logger *logrus.Entry
+}
+
+func newMigrator(db *gorm.DB) (*Migrator, error) {
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, fmt.Errorf("couldn't create the schema migrations table: %w", err)
+	}
+	return &Migrator{db: db, logger: logrus.WithField("component", "migrator")}, nil
+}
+
+// Version returns the version of the latest applied migration, 0 if none is applied.
+func (m *Migrator) Version() (uint, error) {
+	var applied schemaMigration
+	err := m.db.Order("version desc").Limit(1).Find(&applied).Error
+	return applied.Version, err
+}
+
+// Migrate applies or reverts migrations until the schema is at the given version.
+func (m *Migrator) Migrate(target uint) error {
+	if target > LatestSchemaVersion() {
+		return fmt.Errorf("unknown schema version %d, the latest one is %d", target, LatestSchemaVersion())
+	}
+
+	current, err := m.Version()
+	if err != nil {
+		return fmt.Errorf("couldn't get the schema version: %w", err)
+	}
+
+	if target >= current {
+		for _, mig := range migrations {
+			if mig.version <= current || mig.version > target {
+				continue
+			}
+			if err := m.apply(mig); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if mig.version > current || mig.version <= target {
+			continue
+		}
+		if err := m.revert(mig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(mig migration) error {
+	m.logger.WithFields(logrus.Fields{"version": mig.version, "name": mig.name}).Info("Applying migration")
+	if err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := mig.up(tx); err != nil {
+			return err
+		}
+		return tx.Create(&schemaMigration{Version: mig.version, Name: mig.name, AppliedAt: time.Now()}).Error
+	}); err != nil {
+		return fmt.Errorf("couldn't apply migration %d (%s): %w", mig.version, mig.name, err)
+	}
+	return nil
+}
+
+func (m *Migrator) revert(mig migration) error {
+	m.logger.WithFields(logrus.Fields{"version": mig.version, "name": mig.name}).Info("Reverting migration")
+	if err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := mig.down(tx); err != nil {
+			return err
+		}
+		return tx.Delete(&schemaMigration{Version: mig.version}).Error
+	}); err != nil {
+		return fmt.Errorf("couldn't revert migration %d (%s): %w", mig.version, mig.name, err)
+	}
+	return nil
+}
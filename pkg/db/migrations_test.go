@@ -0,0 +1,146 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+)
+
+func newTestSQLiteDB(t *testing.T) *Migrator {
+	db, err := connect(sqlite.Open(filepath.Join(t.TempDir(), "results.db")), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := newMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+func expectVersion(t *testing.T, m *Migrator, expected uint) {
+	t.Helper()
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected {
+		t.Fatalf("expected schema version %d, got %d", expected, version)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	m := newTestSQLiteDB(t)
+	expectVersion(t, m, 0)
+
+	if err := m.Migrate(LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, LatestSchemaVersion())
+	if !m.db.Migrator().HasColumn(&calculationResultsV2{}, "InputHash") {
+		t.Fatal("expected the input_hash column after migrating to the latest version")
+	}
+
+	// Migrating again is a no-op.
+	if err := m.Migrate(LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Migrate(1); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 1)
+	if m.db.Migrator().HasColumn(&calculationResultsV2{}, "InputHash") {
+		t.Fatal("expected the input_hash column to be dropped after reverting to version 1")
+	}
+
+	if err := m.Migrate(0); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 0)
+	if m.db.Migrator().HasTable(&calculationResultsV1{}) {
+		t.Fatal("expected the calculation_results table to be dropped after reverting to version 0")
+	}
+
+	if err := m.Migrate(LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, LatestSchemaVersion())
+
+	if err := m.Migrate(LatestSchemaVersion() + 1); err == nil {
+		t.Fatal("expected an error for an unknown schema version")
+	}
+}
+
+func TestMigratorKeepsResultsOfAutoMigratedDatabases(t *testing.T) {
+	m := newTestSQLiteDB(t)
+	// Databases created before the migrations were introduced only have the table of the first version.
+	if err := m.db.AutoMigrate(&calculationResultsV1{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.db.Create(&calculationResultsV1{ParametersJSON: `{"log_g":"4.000000","teff":"10000.000000"}`, Results: "results"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Migrate(LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	if err := m.db.Model(&CalculationResults{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 result after migrating, got %d", count)
+	}
+}
+
+func TestNewCalculationResultsStoreRequiresMigratedSchema(t *testing.T) {
+	m := newTestSQLiteDB(t)
+	if _, err := newCalculationResultsStore(m.db, sqliteDialect{}, false); err == nil {
+		t.Fatal("expected an error for a database that isn't migrated")
+	}
+
+	if err := m.Migrate(LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCalculationResultsStore(m.db, sqliteDialect{}, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigratorRemovesDuplicateInputHashes(t *testing.T) {
+	m := newTestSQLiteDB(t)
+	if err := m.Migrate(3); err != nil {
+		t.Fatal(err)
+	}
+	hash := strings.Repeat("a", 64)
+	for _, results := range []string{"old", "new"} {
+		if err := m.db.Create(&calculationResultsV2{ParametersJSON: `{"log_g":"4.000000","teff":"10000.000000"}`, InputHash: hash, Results: results}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Migrate(4); err != nil {
+		t.Fatal(err)
+	}
+	var remaining []calculationResultsV2
+	if err := m.db.Where("input_hash = ?", hash).Find(&remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].Results != "new" {
+		t.Fatalf("expected only the newest results of the input hash, got %v", remaining)
+	}
+	if err := m.db.Create(&calculationResultsV2{InputHash: hash, Results: "duplicate"}).Error; err == nil {
+		t.Fatal("expected the input hash to be unique")
+	}
+
+	if err := m.Migrate(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.db.Create(&calculationResultsV2{InputHash: hash, Results: "duplicate"}).Error; err != nil {
+		t.Fatalf("expected the input hash not to be unique after reverting: %v", err)
+	}
+}
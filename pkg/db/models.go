@@ -1,6 +1,8 @@
 package db
 
 import (
+	"encoding/json"
+
 	"gorm.io/gorm"
 )
 
@@ -9,5 +11,27 @@ type CalculationResults struct {
 
 	Parameters     map[string]string `gorm:"-"`
 	ParametersJSON string            `gorm:"column:parameters_json;type:jsonb"`
+	InputHash      string            `gorm:"column:input_hash;uniqueIndex:idx_calculation_results_unique_input_hash,where:input_hash <> ''"`
 	Results        string
 }
+
+// decodeParameters fills the Parameters from the stored JSON.
+func (r *CalculationResults) decodeParameters() error {
+	if r.ParametersJSON == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(r.ParametersJSON), &r.Parameters)
+}
+
+// CalculationOutput is an output file of a calculation that was organised by the results collector.
+type CalculationOutput struct {
+	gorm.Model
+
+	Calculation string `gorm:"column:calculation;index"`
+	Bulk        string `gorm:"column:bulk"`
+	InputHash   string `gorm:"column:input_hash"`
+	// Path is relative to the root of the shared storage.
+	Path   string `gorm:"column:path"`
+	Size   int64  `gorm:"column:size"`
+	SHA256 string `gorm:"column:sha256"`
+}
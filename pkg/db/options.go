@@ -8,69 +8,170 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
+	"github.com/glebarez/sqlite"
+
 	"k8s.io/apimachinery/pkg/util/errors"
 )
 
+const (
+	PostgresDriver = "postgres"
+	SQLiteDriver   = "sqlite"
+	MemoryDriver   = "memory"
+)
+
 type Options struct {
+	dbDriver   string
+	dbPath     string
 	dbPort     int
 	dbUsername string
 	dbPassword string
 	dbHost     string
 	dbName     string
 	dbLogLevel int
+
+	autoMigrate bool
 }
 
 func (o *Options) Bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.dbDriver, "db-driver", PostgresDriver, fmt.Sprintf("Database backend for the results, one of: %s, %s, %s", PostgresDriver, SQLiteDriver, MemoryDriver))
+	fs.StringVar(&o.dbPath, "db-path", "results.db", "Path of the database file, used only by the sqlite backend")
 	fs.IntVar(&o.dbPort, "db-port", 5432, "Database port number")
 	fs.StringVar(&o.dbUsername, "db-username", "", "Database username")
 	fs.StringVar(&o.dbPassword, "db-password", "", "Database password")
 	fs.StringVar(&o.dbHost, "db-host", "", "Database host")
 	fs.StringVar(&o.dbName, "db-name", "", "Database name")
 	fs.IntVar(&o.dbLogLevel, "db-log-level", 1, "Database log level")
+	fs.BoolVar(&o.autoMigrate, "db-auto-migrate", true, "Apply the pending schema migrations on startup. If disabled, the schema must be migrated with the migrate subcommand")
 }
 
 func (o *Options) Validate() error {
 	var errs []error
-	if o.dbUsername == "" {
-		errs = append(errs, fmt.Errorf("--db-username is not specified"))
-	}
-	if o.dbPassword == "" {
-		errs = append(errs, fmt.Errorf("--db-password is not specified"))
-	}
-	if o.dbHost == "" {
-		errs = append(errs, fmt.Errorf("--db-host is not specified"))
-	}
-	if o.dbName == "" {
-		errs = append(errs, fmt.Errorf("--db-name is not specified"))
+	switch o.dbDriver {
+	case PostgresDriver:
+		if o.dbUsername == "" {
+			errs = append(errs, fmt.Errorf("--db-username is not specified"))
+		}
+		if o.dbPassword == "" {
+			errs = append(errs, fmt.Errorf("--db-password is not specified"))
+		}
+		if o.dbHost == "" {
+			errs = append(errs, fmt.Errorf("--db-host is not specified"))
+		}
+		if o.dbName == "" {
+			errs = append(errs, fmt.Errorf("--db-name is not specified"))
+		}
+	case SQLiteDriver:
+		if o.dbPath == "" {
+			errs = append(errs, fmt.Errorf("--db-path is not specified"))
+		}
+	case MemoryDriver:
+	default:
+		errs = append(errs, fmt.Errorf("--db-driver %q is not supported", o.dbDriver))
 	}
 	return errors.NewAggregate(errs)
 }
 
-func (o *Options) connect() (*gorm.DB, error) {
-	url := fmt.Sprintf("host=%s port=%v user=%s dbname=%s password=%s sslmode=disable",
+func (o *Options) postgresDSN() string {
+	return fmt.Sprintf("host=%s port=%v user=%s dbname=%s password=%s sslmode=disable",
 		o.dbHost,
 		o.dbPort,
 		o.dbUsername,
 		o.dbName,
 		o.dbPassword)
+}
 
-	db, err := gorm.Open(postgres.Open(url), &gorm.Config{
+func connect(dialector gorm.Dialector, logLevel int) (*gorm.DB, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{
 		DisableForeignKeyConstraintWhenMigrating: true,
-		Logger:                                   logger.Default.LogMode(logger.LogLevel(o.dbLogLevel)),
+		Logger:                                   logger.Default.LogMode(logger.LogLevel(logLevel)),
 	})
+	if err != nil {
+		return nil, err
+	}
 	return db.Session(&gorm.Session{
 		FullSaveAssociations: true,
 		QueryFields:          true,
-	}), err
+	}), nil
 }
 
 func (o *Options) NewCalculationResultsStore() (CalculationResultsStore, error) {
-	db, err := o.connect()
+	if o.dbDriver == MemoryDriver {
+		return NewMemoryResultsStore(), nil
+	}
+
+	db, dialect, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	return newCalculationResultsStore(db, dialect, o.autoMigrate)
+}
+
+// NewMigrator returns a Migrator for the schema of the configured database.
+func (o *Options) NewMigrator() (*Migrator, error) {
+	if o.dbDriver == MemoryDriver {
+		return nil, fmt.Errorf("the %s backend has no schema to migrate", MemoryDriver)
+	}
+
+	db, _, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db)
+}
+
+func (o *Options) connect() (*gorm.DB, dialect, error) {
+	var dialector gorm.Dialector
+	var d dialect
+	switch o.dbDriver {
+	case SQLiteDriver:
+		dialector, d = sqlite.Open(o.dbPath), sqliteDialect{}
+	default:
+		dialector, d = postgres.Open(o.postgresDSN()), postgresDialect{}
+	}
+
+	db, err := connect(dialector, o.dbLogLevel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't initialize database: %w", err)
+	}
+	return db, d, nil
+}
+
+func newPostgresResultsStore(dsn string, logLevel int) (CalculationResultsStore, error) {
+	db, err := connect(postgres.Open(dsn), logLevel)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't initialize database: %w", err)
 	}
-	if err = db.AutoMigrate(&CalculationResults{}); err != nil {
+	return newCalculationResultsStore(db, postgresDialect{}, true)
+}
+
+func newSQLiteResultsStore(path string, logLevel int) (CalculationResultsStore, error) {
+	db, err := connect(sqlite.Open(path), logLevel)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't initialize database: %w", err)
+	}
+	return newCalculationResultsStore(db, sqliteDialect{}, true)
+}
+
+// newCalculationResultsStore returns a store for the given database, after making sure
+// that its schema is at the latest version.
+func newCalculationResultsStore(db *gorm.DB, dialect dialect, autoMigrate bool) (CalculationResultsStore, error) {
+	migrator, err := newMigrator(db)
+	if err != nil {
 		return nil, err
 	}
-	return &calculationResultsStore{db: db}, nil
+
+	if autoMigrate {
+		if err := migrator.Migrate(LatestSchemaVersion()); err != nil {
+			return nil, err
+		}
+	} else {
+		version, err := migrator.Version()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get the schema version: %w", err)
+		}
+		if version != LatestSchemaVersion() {
+			return nil, fmt.Errorf("database schema is at version %d instead of %d, run the migrate subcommand", version, LatestSchemaVersion())
+		}
+	}
+	return &calculationResultsStore{db: db, dialect: dialect}, nil
 }
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutputRegistry is implemented by the stores that keep track of the output files organised by the results collector.
+type OutputRegistry interface {
+	// RegisterOutputs replaces the registered output files of the given calculation.
+	RegisterOutputs(ctx context.Context, calculation string, outputs []*CalculationOutput) error
+	// GetOutputs returns the registered output files of the given calculation, sorted by path.
+	GetOutputs(ctx context.Context, calculation string) ([]*CalculationOutput, error)
+}
+
+func (s *calculationResultsStore) RegisterOutputs(ctx context.Context, calculation string, outputs []*CalculationOutput) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("calculation = ?", calculation).Delete(&CalculationOutput{}).Error; err != nil {
+			return err
+		}
+		if len(outputs) == 0 {
+			return nil
+		}
+
+		records := make([]*CalculationOutput, 0, len(outputs))
+		for _, output := range outputs {
+			record := *output
+			record.Model = gorm.Model{}
+			record.Calculation = calculation
+			records = append(records, &record)
+		}
+		return tx.Create(records).Error
+	})
+}
+
+func (s *calculationResultsStore) GetOutputs(ctx context.Context, calculation string) ([]*CalculationOutput, error) {
+	var outputs []*CalculationOutput
+	if err := s.db.WithContext(ctx).Where("calculation = ?", calculation).Order("path").Find(&outputs).Error; err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+func (s *memoryResultsStore) RegisterOutputs(ctx context.Context, calculation string, outputs []*CalculationOutput) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.outputs == nil {
+		s.outputs = make(map[string][]*CalculationOutput)
+	}
+
+	now := time.Now()
+	records := make([]*CalculationOutput, 0, len(outputs))
+	for _, output := range outputs {
+		record := *output
+		record.Model = gorm.Model{ID: s.nextID, CreatedAt: now, UpdatedAt: now}
+		record.Calculation = calculation
+		records = append(records, &record)
+		s.nextID++
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+	s.outputs[calculation] = records
+	return nil
+}
+
+func (s *memoryResultsStore) GetOutputs(ctx context.Context, calculation string) ([]*CalculationOutput, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	outputs := make([]*CalculationOutput, 0, len(s.outputs[calculation]))
+	for _, output := range s.outputs[calculation] {
+		ret := *output
+		outputs = append(outputs, &ret)
+	}
+	return outputs, nil
+}
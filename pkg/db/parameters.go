@@ -0,0 +1,23 @@
+package db
+
+import (
+	"github.com/vega-project/ccb-operator/pkg/results"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+func validateStoreRequest(in *proto.StoreRequest) error {
+	if err := results.ValidateParameters(in.Parameters); err != nil {
+		return err
+	}
+	if in.InputHash != "" {
+		return results.ValidateInputHash(in.InputHash)
+	}
+	return nil
+}
+
+func validateDataQuery(query *proto.DataQuery) error {
+	if query.InputHash != "" {
+		return results.ValidateInputHash(query.InputHash)
+	}
+	return results.ValidateParameters(query.Parameters)
+}
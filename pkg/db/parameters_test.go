@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/vega-project/ccb-operator/pkg/results"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+// FuzzGetDataHostileKeys makes sure that arbitrary keys and values can neither match
+// results they shouldn't nor modify the stored results.
+func FuzzGetDataHostileKeys(f *testing.F) {
+	for _, seed := range []struct{ key, value string }{
+		{"teff", "10000.000000"},
+		{"teff' = '1' OR '1' = '1' --", "1"},
+		{"teff') = '1' OR ('1", "1"},
+		{"log_g'; DROP TABLE calculation_results; --", "4.0"},
+		{"$.teff", "10000.000000"},
+		{"teff", "1 OR 1=1"},
+		{"", ""},
+	} {
+		f.Add(seed.key, seed.value)
+	}
+
+	store, err := newSQLiteResultsStore(filepath.Join(f.TempDir(), "results.db"), 1)
+	if err != nil {
+		f.Fatal(err)
+	}
+	memoryStore := NewMemoryResultsStore()
+
+	ctx := context.Background()
+	stored := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+	for _, s := range []CalculationResultsStore{store, memoryStore} {
+		if _, err := s.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: stored, Results: "results"}); err != nil {
+			f.Fatal(err)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, key, value string) {
+		parameters := map[string]string{key: value}
+		validationErr := results.ValidateParameters(parameters)
+
+		for _, s := range []CalculationResultsStore{store, memoryStore} {
+			result, err := s.GetData(ctx, parameters)
+			switch {
+			case validationErr != nil:
+				if !errors.Is(err, results.ErrInvalidParameters) {
+					t.Fatalf("expected results.ErrInvalidParameters for %q=%q, got %v", key, value, err)
+				}
+			case err == nil:
+				if stored[key] != value {
+					t.Fatalf("%q=%q matched results stored with %v", key, value, stored)
+				}
+				if result.Results != "results" {
+					t.Fatalf("unexpected results: %q", result.Results)
+				}
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				t.Fatalf("unexpected error for %q=%q: %v", key, value, err)
+			}
+
+			if _, err := s.GetData(ctx, stored); err != nil {
+				t.Fatalf("stored results are not retrievable after querying %q=%q: %v", key, value, err)
+			}
+		}
+	})
+}
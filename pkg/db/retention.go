@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ArchivableResultsStore is implemented by the stores whose results can be archived and restored.
+type ArchivableResultsStore interface {
+	// ListArchivable returns the IDs of the results that match the given filter.
+	ListArchivable(ctx context.Context, filter RetentionFilter) ([]uint, error)
+	// GetResultsByID returns the results with the given IDs.
+	GetResultsByID(ctx context.Context, ids []uint) ([]*CalculationResults, error)
+	// DeleteResults permanently deletes the results with the given IDs.
+	DeleteResults(ctx context.Context, ids []uint) error
+	// RestoreResults stores the given results, keeping their timestamps. Results that
+	// already exist are skipped. It returns the number of restored results.
+	RestoreResults(ctx context.Context, results []*CalculationResults) (int, error)
+}
+
+// RetentionFilter selects the results that should be archived.
+// A result matches if it matches any of the criteria that are set.
+type RetentionFilter struct {
+	// CreatedBefore matches the results that were created before the given time.
+	CreatedBefore time.Time
+	// LiveInputHashes matches the results with an input hash that isn't in the set,
+	// i.e. the results of calculation bulks that were deleted. Ignored if nil.
+	LiveInputHashes sets.Set[string]
+}
+
+func (f RetentionFilter) matches(r *CalculationResults) bool {
+	if !f.CreatedBefore.IsZero() && r.CreatedAt.Before(f.CreatedBefore) {
+		return true
+	}
+	if f.LiveInputHashes != nil && r.InputHash != "" && !f.LiveInputHashes.Has(r.InputHash) {
+		return true
+	}
+	return false
+}
+
+// restoreExists reports whether the given results are already in the store, using the
+// same key as StoreOrUpdateData.
+func restoreExists(ctx context.Context, store CalculationResultsStore, r *CalculationResults) (bool, error) {
+	var err error
+	if r.InputHash != "" {
+		_, err = store.GetDataByHash(ctx, r.InputHash)
+	} else {
+		_, err = store.GetData(ctx, r.Parameters)
+	}
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// RetentionOptions configures the archiving of old results.
+type RetentionOptions struct {
+	archiveDir          string
+	retentionDays       int
+	archiveDeletedBulks bool
+	interval            time.Duration
+}
+
+func (o *RetentionOptions) Bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.archiveDir, "archive-dir", "/var/tmp/nfs/results-archive", "Directory where the archive files of the results are written")
+	fs.IntVar(&o.retentionDays, "retention-days", 0, "Archive the results older than the given number of days. 0 disables the archiving by age")
+	fs.BoolVar(&o.archiveDeletedBulks, "archive-deleted-bulks", false, "Archive the results that aren't used by any existing calculation bulk")
+	fs.DurationVar(&o.interval, "retention-interval", 24*time.Hour, "How often the results are checked for archiving")
+}
+
+func (o *RetentionOptions) Validate() error {
+	var errs []error
+	if o.retentionDays < 0 {
+		errs = append(errs, fmt.Errorf("--retention-days must not be negative"))
+	}
+	if o.Enabled() && o.archiveDir == "" {
+		errs = append(errs, fmt.Errorf("--archive-dir is not specified"))
+	}
+	if o.interval <= 0 {
+		errs = append(errs, fmt.Errorf("--retention-interval must be positive"))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Enabled reports whether any retention criterion is configured.
+func (o *RetentionOptions) Enabled() bool {
+	return o.retentionDays > 0 || o.archiveDeletedBulks
+}
+
+func (o *RetentionOptions) ArchiveDir() string {
+	return o.archiveDir
+}
+
+func (o *RetentionOptions) Interval() time.Duration {
+	return o.interval
+}
+
+// ArchiveDeletedBulks reports whether the results of deleted calculation bulks are archived.
+func (o *RetentionOptions) ArchiveDeletedBulks() bool {
+	return o.archiveDeletedBulks
+}
+
+// Filter returns the RetentionFilter of the options at the given time. The live input hashes
+// are only used if the results of deleted calculation bulks are archived.
+func (o *RetentionOptions) Filter(now time.Time, liveInputHashes sets.Set[string]) RetentionFilter {
+	var filter RetentionFilter
+	if o.retentionDays > 0 {
+		filter.CreatedBefore = now.AddDate(0, 0, -o.retentionDays)
+	}
+	if o.archiveDeletedBulks {
+		filter.LiveInputHashes = liveInputHashes
+		if filter.LiveInputHashes == nil {
+			filter.LiveInputHashes = sets.New[string]()
+		}
+	}
+	return filter
+}
@@ -5,27 +5,63 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/vega-project/ccb-operator/pkg/results"
 	proto "github.com/vega-project/ccb-operator/proto"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 type CalculationResultsStore interface {
 	StoreOrUpdateData(ctx context.Context, in *proto.StoreRequest) (*proto.StoreResponse, error)
 	GetData(ctx context.Context, parameters map[string]string) (*CalculationResults, error)
+	// GetDataByHash returns the results that were produced by the inputs with the given content hash.
+	GetDataByHash(ctx context.Context, inputHash string) (*CalculationResults, error)
+	// GetDataBatch returns the results that match each of the given queries, in the same order.
+	// The results of the queries that didn't match are nil. If metadataOnly is set, the results
+	// themselves are left empty.
+	GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*CalculationResults, error)
+	// StoreOrUpdateDataBatch stores the results of all the given requests, or none of them.
+	StoreOrUpdateDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error)
+}
+
+// hashQueryBatchSize is the maximum number of input hashes in a single query, which keeps
+// the queries below the limit of bound variables of the databases.
+const hashQueryBatchSize = 500
+
+// archivablePageSize is the number of results that are checked at once for archiving.
+var archivablePageSize = 1000
+
+// Pinger is implemented by the stores that can check the connectivity to their database.
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
 
 type calculationResultsStore struct {
-	db *gorm.DB
+	db      *gorm.DB
+	dialect dialect
 }
 
 func (s *calculationResultsStore) StoreOrUpdateData(ctx context.Context, in *proto.StoreRequest) (*proto.StoreResponse, error) {
+	if err := validateStoreRequest(in); err != nil {
+		return nil, err
+	}
+
 	parametersJson, err := json.Marshal(in.Parameters)
 	if err != nil {
 		return nil, err
 	}
 
+	// Results with an input hash are keyed on it, the rest on their parameters.
+	if in.InputHash != "" {
+		return s.upsertByHash(ctx, string(parametersJson), in)
+	}
+
 	existingData, err := s.GetData(ctx, in.Parameters)
 	if err != nil {
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -33,31 +69,269 @@ func (s *calculationResultsStore) StoreOrUpdateData(ctx context.Context, in *pro
 		}
 	} else {
 		existingData.Results = in.Results
-		if err := s.db.Save(existingData).Error; err != nil {
+		if err := s.db.WithContext(ctx).Save(existingData).Error; err != nil {
 			return nil, err
 		}
 		return &proto.StoreResponse{Message: "Data updated successfully"}, nil
 	}
 
-	if err := s.db.Create(&CalculationResults{ParametersJSON: string(parametersJson), Results: in.Results}).Error; err != nil {
+	if err := s.db.WithContext(ctx).Create(&CalculationResults{ParametersJSON: string(parametersJson), Results: in.Results}).Error; err != nil {
 		return nil, err
 	}
 
 	return &proto.StoreResponse{Message: "Data stored successfully"}, nil
 }
 
+// upsertByHash stores the results with an input hash in a single statement, so the results of the
+// same inputs that are stored concurrently end up in a single row.
+func (s *calculationResultsStore) upsertByHash(ctx context.Context, parametersJson string, in *proto.StoreRequest) (*proto.StoreResponse, error) {
+	now := time.Now()
+	result := &CalculationResults{
+		Model:          gorm.Model{CreatedAt: now, UpdatedAt: now},
+		ParametersJSON: parametersJson,
+		InputHash:      in.InputHash,
+		Results:        in.Results,
+	}
+	if err := s.db.WithContext(ctx).Clauses(
+		clause.OnConflict{
+			Columns:     []clause.Column{{Name: "input_hash"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "input_hash <> ''"}}},
+			DoUpdates:   clause.AssignmentColumns([]string{"results", "updated_at"}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "created_at"}}},
+	).Create(result).Error; err != nil {
+		return nil, err
+	}
+
+	// The existing row keeps its creation time when it's updated.
+	if !result.CreatedAt.Equal(now) {
+		return &proto.StoreResponse{Message: "Data updated successfully"}, nil
+	}
+	return &proto.StoreResponse{Message: "Data stored successfully"}, nil
+}
+
 func (s *calculationResultsStore) GetData(ctx context.Context, parameters map[string]string) (*CalculationResults, error) {
-	query := s.db.Model(&CalculationResults{})
+	if err := results.ValidateParameters(parameters); err != nil {
+		return nil, err
+	}
+
+	query := s.db.WithContext(ctx).Model(&CalculationResults{})
 
 	for key, value := range parameters {
-		jsonQuery := fmt.Sprintf("parameters_json ->> '%s' = ?", key)
-		query = query.Where(jsonQuery, value)
+		field, arg := s.dialect.jsonField(key)
+		query = query.Where(fmt.Sprintf("%s = ?", field), arg, value)
 	}
 
+	// Results stored before the input hashes were introduced may share their parameters with
+	// newer ones, the newest results are returned.
 	var result CalculationResults
-	if err := query.First(&result).Error; err != nil {
+	if err := query.Order("id DESC").Take(&result).Error; err != nil {
 		return nil, err
 	}
 
+	if err := result.decodeParameters(); err != nil {
+		return nil, err
+	}
 	return &result, nil
 }
+
+func (s *calculationResultsStore) GetDataByHash(ctx context.Context, inputHash string) (*CalculationResults, error) {
+	if err := results.ValidateInputHash(inputHash); err != nil {
+		return nil, err
+	}
+
+	var result CalculationResults
+	if err := s.db.WithContext(ctx).Model(&CalculationResults{}).Where("input_hash = ?", inputHash).First(&result).Error; err != nil {
+		return nil, err
+	}
+
+	if err := result.decodeParameters(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *calculationResultsStore) GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*CalculationResults, error) {
+	for _, query := range queries {
+		if err := validateDataQuery(query); err != nil {
+			return nil, err
+		}
+	}
+
+	var hashes []string
+	for _, query := range queries {
+		if query.InputHash != "" {
+			hashes = append(hashes, query.InputHash)
+		}
+	}
+
+	byHash := make(map[string]*CalculationResults, len(hashes))
+	for start := 0; start < len(hashes); start += hashQueryBatchSize {
+		query := s.db.WithContext(ctx).Model(&CalculationResults{})
+		if metadataOnly {
+			query = query.Select("id", "created_at", "updated_at", "parameters_json", "input_hash")
+		}
+
+		var found []*CalculationResults
+		if err := query.Where("input_hash IN ?", hashes[start:min(start+hashQueryBatchSize, len(hashes))]).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, result := range found {
+			if err := result.decodeParameters(); err != nil {
+				return nil, err
+			}
+			byHash[result.InputHash] = result
+		}
+	}
+
+	results := make([]*CalculationResults, len(queries))
+	for i, query := range queries {
+		if query.InputHash != "" {
+			results[i] = byHash[query.InputHash]
+			continue
+		}
+
+		// The parameters can't be matched in a single query, since each key is a separate condition.
+		result, err := s.GetData(ctx, query.Parameters)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if metadataOnly {
+			result.Results = ""
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+func (s *calculationResultsStore) StoreOrUpdateDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error) {
+	for _, in := range requests {
+		if err := validateStoreRequest(in); err != nil {
+			return nil, err
+		}
+	}
+
+	responses := make([]*proto.StoreResponse, 0, len(requests))
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		txStore := &calculationResultsStore{db: tx, dialect: s.dialect}
+		for _, in := range requests {
+			response, err := txStore.StoreOrUpdateData(ctx, in)
+			if err != nil {
+				return err
+			}
+			responses = append(responses, response)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+func (s *calculationResultsStore) ListArchivable(ctx context.Context, filter RetentionFilter) ([]uint, error) {
+	var conditions []string
+	var args []interface{}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.CreatedBefore)
+	}
+	if filter.LiveInputHashes != nil {
+		// Large sets of live input hashes don't fit in a single query, their results are
+		// matched against the set page by page instead.
+		if live := filter.LiveInputHashes.Len(); live > 0 && live <= hashQueryBatchSize {
+			conditions = append(conditions, "(input_hash <> '' AND input_hash NOT IN ?)")
+			args = append(args, sets.List(filter.LiveInputHashes))
+		} else {
+			conditions = append(conditions, "input_hash <> ''")
+		}
+	}
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+	where := "(" + strings.Join(conditions, " OR ") + ")"
+
+	var ids []uint
+	var lastID uint
+	for {
+		var page []*CalculationResults
+		if err := s.db.WithContext(ctx).Model(&CalculationResults{}).Select("id", "created_at", "input_hash").
+			Where("id > ?", lastID).Where(where, args...).Order("id").Limit(archivablePageSize).Find(&page).Error; err != nil {
+			return nil, err
+		}
+		for _, candidate := range page {
+			if filter.matches(candidate) {
+				ids = append(ids, candidate.ID)
+			}
+		}
+		if len(page) < archivablePageSize {
+			return ids, nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+func (s *calculationResultsStore) GetResultsByID(ctx context.Context, ids []uint) ([]*CalculationResults, error) {
+	var results []*CalculationResults
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Order("id").Find(&results).Error; err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if err := result.decodeParameters(); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (s *calculationResultsStore) DeleteResults(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Unscoped().Delete(&CalculationResults{}, ids).Error
+}
+
+func (s *calculationResultsStore) RestoreResults(ctx context.Context, results []*CalculationResults) (int, error) {
+	var restored int
+	for _, result := range results {
+		if err := validateStoreRequest(&proto.StoreRequest{Parameters: result.Parameters, InputHash: result.InputHash}); err != nil {
+			return restored, err
+		}
+
+		exists, err := restoreExists(ctx, s, result)
+		if err != nil {
+			return restored, err
+		}
+		if exists {
+			continue
+		}
+
+		parametersJson, err := json.Marshal(result.Parameters)
+		if err != nil {
+			return restored, err
+		}
+
+		if err := s.db.WithContext(ctx).Create(&CalculationResults{
+			Model:          gorm.Model{CreatedAt: result.CreatedAt, UpdatedAt: result.UpdatedAt},
+			ParametersJSON: string(parametersJson),
+			InputHash:      result.InputHash,
+			Results:        result.Results,
+		}).Error; err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+func (s *calculationResultsStore) Ping(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,371 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/vega-project/ccb-operator/pkg/results"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+// postgresDSNEnv enables the conformance tests against a live Postgres database.
+const postgresDSNEnv = "VEGA_TEST_POSTGRES_DSN"
+
+func TestMemoryResultsStore(t *testing.T) {
+	testCalculationResultsStore(t, func(t *testing.T) CalculationResultsStore {
+		return NewMemoryResultsStore()
+	})
+}
+
+func TestSQLiteResultsStore(t *testing.T) {
+	testCalculationResultsStore(t, func(t *testing.T) CalculationResultsStore {
+		store, err := newSQLiteResultsStore(filepath.Join(t.TempDir(), "results.db"), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestPostgresResultsStore(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	testCalculationResultsStore(t, func(t *testing.T) CalculationResultsStore {
+		store, err := newPostgresResultsStore(dsn, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := store.(*calculationResultsStore)
+		if err := s.db.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(&CalculationResults{}).Error; err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+// testCalculationResultsStore is the conformance suite that every CalculationResultsStore backend must pass.
+func testCalculationResultsStore(t *testing.T, newStore func(t *testing.T) CalculationResultsStore) {
+	ctx := context.Background()
+	params := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+
+	t.Run("missing results return record not found", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.GetData(ctx, params); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("expected gorm.ErrRecordNotFound, got %v", err)
+		}
+	})
+
+	t.Run("stored results can be retrieved", func(t *testing.T) {
+		store := newStore(t)
+		resp, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, Results: "results"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Message != "Data stored successfully" {
+			t.Fatalf("unexpected message: %s", resp.Message)
+		}
+
+		result, err := store.GetData(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Results != "results" {
+			t.Fatalf("expected results %q, got %q", "results", result.Results)
+		}
+		if result.CreatedAt.IsZero() {
+			t.Fatal("expected created_at to be set")
+		}
+	})
+
+	t.Run("storing the same parameters updates the results", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, Results: "old"}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, Results: "new"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Message != "Data updated successfully" {
+			t.Fatalf("unexpected message: %s", resp.Message)
+		}
+
+		result, err := store.GetData(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Results != "new" {
+			t.Fatalf("expected results %q, got %q", "new", result.Results)
+		}
+	})
+
+	t.Run("results are filtered by every parameter", func(t *testing.T) {
+		store := newStore(t)
+		other := map[string]string{"teff": "11000.000000", "log_g": "4.000000"}
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, Results: "first"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: other, Results: "second"}); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := store.GetData(ctx, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Results != "second" {
+			t.Fatalf("expected results %q, got %q", "second", result.Results)
+		}
+
+		if _, err := store.GetData(ctx, map[string]string{"teff": "12000.000000", "log_g": "4.000000"}); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("expected gorm.ErrRecordNotFound, got %v", err)
+		}
+	})
+
+	t.Run("unknown parameters are rejected", func(t *testing.T) {
+		store := newStore(t)
+		hostile := map[string]string{"teff') = '1' OR ('1": "1"}
+		if _, err := store.GetData(ctx, hostile); !errors.Is(err, results.ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters, got %v", err)
+		}
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: hostile, Results: "results"}); !errors.Is(err, results.ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters, got %v", err)
+		}
+	})
+	t.Run("results are keyed on the input hash", func(t *testing.T) {
+		store := newStore(t)
+		hash1 := strings.Repeat("a", 64)
+		hash2 := strings.Repeat("b", 64)
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, InputHash: hash1, Results: "first"}); err != nil {
+			t.Fatal(err)
+		}
+		// Same parameters with different inputs must not override the previous results.
+		resp, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, InputHash: hash2, Results: "second"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Message != "Data stored successfully" {
+			t.Fatalf("unexpected message: %s", resp.Message)
+		}
+
+		result, err := store.GetDataByHash(ctx, hash1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Results != "first" || result.InputHash != hash1 {
+			t.Fatalf("expected results %q with hash %s, got %q with hash %s", "first", hash1, result.Results, result.InputHash)
+		}
+		if !reflect.DeepEqual(result.Parameters, params) {
+			t.Fatalf("expected parameters %v, got %v", params, result.Parameters)
+		}
+
+		if _, err := store.GetDataByHash(ctx, strings.Repeat("c", 64)); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("expected gorm.ErrRecordNotFound, got %v", err)
+		}
+		if _, err := store.GetDataByHash(ctx, "not-a-hash"); !errors.Is(err, results.ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters, got %v", err)
+		}
+	})
+
+	t.Run("storing the same input hash updates the results", func(t *testing.T) {
+		store := newStore(t)
+		hash := strings.Repeat("a", 64)
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, InputHash: hash, Results: "old"}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, InputHash: hash, Results: "new"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Message != "Data updated successfully" {
+			t.Fatalf("unexpected message: %s", resp.Message)
+		}
+
+		result, err := store.GetDataByHash(ctx, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Results != "new" {
+			t.Fatalf("expected results %q, got %q", "new", result.Results)
+		}
+	})
+
+	t.Run("the newest results of the parameters are returned", func(t *testing.T) {
+		store := newStore(t)
+		// Results stored before the input hashes were introduced.
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, Results: "stale"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, InputHash: strings.Repeat("a", 64), Results: "fresh"}); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := store.GetData(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Results != "fresh" {
+			t.Fatalf("expected results %q, got %q", "fresh", result.Results)
+		}
+	})
+
+	t.Run("batches of results are stored and retrieved", func(t *testing.T) {
+		store := newStore(t)
+		other := map[string]string{"teff": "11000.000000", "log_g": "4.000000"}
+		hash1 := strings.Repeat("a", 64)
+
+		responses, err := store.StoreOrUpdateDataBatch(ctx, []*proto.StoreRequest{
+			{Parameters: params, InputHash: hash1, Results: "first"},
+			{Parameters: other, Results: "second"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(responses) != 2 {
+			t.Fatalf("expected 2 responses, got %d", len(responses))
+		}
+
+		queries := []*proto.DataQuery{
+			{InputHash: strings.Repeat("c", 64)},
+			{Parameters: other},
+			{InputHash: hash1},
+		}
+		results, err := store.GetDataBatch(ctx, queries, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(queries) || results[0] != nil {
+			t.Fatalf("expected no results for the unknown hash, got %v", results)
+		}
+		if results[1] == nil || results[1].Results != "second" || !reflect.DeepEqual(results[1].Parameters, other) {
+			t.Fatalf("expected the results of the parameters query, got %v", results[1])
+		}
+		if results[2] == nil || results[2].Results != "first" || results[2].InputHash != hash1 {
+			t.Fatalf("expected the results of the input hash query, got %v", results[2])
+		}
+
+		metadata, err := store.GetDataBatch(ctx, queries, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata[2] == nil || metadata[2].Results != "" || metadata[2].InputHash != hash1 || metadata[2].CreatedAt.IsZero() {
+			t.Fatalf("expected only the metadata of the results, got %v", metadata[2])
+		}
+	})
+	t.Run("batches with invalid results are not stored", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.StoreOrUpdateDataBatch(ctx, []*proto.StoreRequest{
+			{Parameters: params, Results: "results"},
+			{Parameters: map[string]string{"unknown": "1"}, Results: "results"},
+		}); !errors.Is(err, results.ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters, got %v", err)
+		}
+		if _, err := store.GetData(ctx, params); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("expected no results to be stored, got %v", err)
+		}
+		if _, err := store.GetDataBatch(ctx, []*proto.DataQuery{{InputHash: "not-a-hash"}}, false); !errors.Is(err, results.ErrInvalidParameters) {
+			t.Fatalf("expected ErrInvalidParameters, got %v", err)
+		}
+	})
+	t.Run("registered outputs replace the previous ones", func(t *testing.T) {
+		registry, ok := newStore(t).(OutputRegistry)
+		if !ok {
+			t.Fatal("the store doesn't implement OutputRegistry")
+		}
+		if err := registry.RegisterOutputs(ctx, "calc", []*CalculationOutput{{Path: "root/b", Size: 1}, {Path: "root/a", Size: 2}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := registry.RegisterOutputs(ctx, "other", []*CalculationOutput{{Path: "root/other"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		outputs, err := registry.GetOutputs(ctx, "calc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(outputs) != 2 || outputs[0].Path != "root/a" || outputs[1].Path != "root/b" || outputs[0].Calculation != "calc" {
+			t.Fatalf("expected the outputs sorted by path, got %v", outputs)
+		}
+
+		if err := registry.RegisterOutputs(ctx, "calc", []*CalculationOutput{{Path: "root/c"}}); err != nil {
+			t.Fatal(err)
+		}
+		outputs, err = registry.GetOutputs(ctx, "calc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(outputs) != 1 || outputs[0].Path != "root/c" {
+			t.Fatalf("expected only the latest outputs, got %v", outputs)
+		}
+		if outputs, err := registry.GetOutputs(ctx, "other"); err != nil || len(outputs) != 1 {
+			t.Fatalf("expected the outputs of other calculations to be kept, got %v: %v", outputs, err)
+		}
+	})
+}
+
+func TestSQLiteResultsStoreUsesTheContext(t *testing.T) {
+	store, err := newSQLiteResultsStore(filepath.Join(t.TempDir(), "results.db"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	params := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+	if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, Results: "results"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected storing to be cancelled, got %v", err)
+	}
+	if _, err := store.GetData(ctx, params); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the lookup by parameters to be cancelled, got %v", err)
+	}
+	if _, err := store.GetDataByHash(ctx, strings.Repeat("a", 64)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the lookup by input hash to be cancelled, got %v", err)
+	}
+}
+
+func TestSQLiteResultsStoreKeepsASingleRowPerInputHash(t *testing.T) {
+	store, err := newSQLiteResultsStore(filepath.Join(t.TempDir(), "results.db"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	hash := strings.Repeat("a", 64)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			params := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+			if _, err := store.StoreOrUpdateData(ctx, &proto.StoreRequest{Parameters: params, InputHash: hash, Results: fmt.Sprintf("results-%d", i)}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	var count int64
+	if err := store.(*calculationResultsStore).db.Model(&CalculationResults{}).Where("input_hash = ?", hash).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected a single row for the input hash, got %d", count)
+	}
+}
@@ -4,18 +4,24 @@ import (
 	"context"
 	"fmt"
 	"sort"
-	"time"
 
 	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -24,22 +30,34 @@ import (
 	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
 	"github.com/vega-project/ccb-operator/pkg/grpc"
+	"github.com/vega-project/ccb-operator/pkg/pipelines"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
 	"github.com/vega-project/ccb-operator/pkg/util"
+	proto "github.com/vega-project/ccb-operator/proto"
 )
 
 const (
 	controllerName = "bulks"
+	bulkKind       = "CalculationBulk"
+
+	// The reasons of the events of the bulks and their calculations.
+	AssignedReason  = "Assigned"
+	CachedReason    = "Cached"
+	CompletedReason = "Completed"
 )
 
-func AddToManager(ctx context.Context, mgr manager.Manager, ns string, calculationCh chan v1.Calculation, gRPCClient grpc.Client) error {
+func AddToManager(ctx context.Context, mgr manager.Manager, ns string, gRPCClient grpc.Client, artifactStore artifacts.ArtifactStore) error {
 	c, err := controller.New(controllerName, mgr, controller.Options{
 		MaxConcurrentReconciles: 1,
 		Reconciler: &reconciler{
-			logger:        logrus.WithField("controller", controllerName),
-			client:        mgr.GetClient(),
-			calculationCh: calculationCh,
-			gRPCClient:    gRPCClient,
+			logger:     logrus.WithField("controller", controllerName),
+			client:     mgr.GetClient(),
+			gRPCClient: gRPCClient,
+			digester:   artifactStore,
+			artifacts:  artifactStore,
+			recorder:   mgr.GetEventRecorderFor(util.DispatcherEventSource),
 		},
 	})
 	if err != nil {
@@ -50,6 +68,10 @@ func AddToManager(ctx context.Context, mgr manager.Manager, ns string, calculati
 		return fmt.Errorf("failed to create watch for clusterpools: %w", err)
 	}
 
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1.Calculation{}, &calculationHandler{namespace: ns})); err != nil {
+		return fmt.Errorf("failed to create watch for calculations: %w", err)
+	}
+
 	return nil
 }
 
@@ -77,11 +99,37 @@ func (h *calculationBulkHandler) Delete(ctx context.Context, e event.TypedDelete
 func (h *calculationBulkHandler) Generic(ctx context.Context, e event.TypedGenericEvent[*bulkv1.CalculationBulk], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
 }
 
+// calculationHandler requeues the bulk whose calculation was removed, so a bulk that is deleted
+// is finalized once its calculations are gone.
+type calculationHandler struct {
+	namespace string
+}
+
+func (h *calculationHandler) Create(ctx context.Context, e event.TypedCreateEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
+func (h *calculationHandler) Update(ctx context.Context, e event.TypedUpdateEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
+func (h *calculationHandler) Delete(ctx context.Context, e event.TypedDeleteEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if h.namespace != e.Object.Namespace {
+		return
+	}
+	if owner := metav1.GetControllerOf(e.Object); owner != nil && owner.Kind == bulkKind {
+		q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: e.Object.Namespace, Name: owner.Name}})
+	}
+}
+
+func (h *calculationHandler) Generic(ctx context.Context, e event.TypedGenericEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
 type reconciler struct {
-	logger        *logrus.Entry
-	client        ctrlruntimeclient.Client
-	calculationCh chan v1.Calculation
-	gRPCClient    grpc.Client
+	logger     *logrus.Entry
+	client     ctrlruntimeclient.Client
+	gRPCClient grpc.Client
+	digester   pipelines.Digester
+	artifacts  artifacts.ArtifactStore
+	recorder   record.EventRecorder
 }
 
 func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -102,31 +150,53 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 	if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: req.Namespace, Name: req.Name}, bulk); err != nil {
 		return fmt.Errorf("failed to get calculation bulk: %s in namespace %s: %w", req.Name, req.Namespace, err)
 	}
+	if bulk.DeletionTimestamp != nil {
+		return r.finalize(ctx, bulk, logger)
+	}
 
 	workerpool := &workersv1.WorkerPool{}
 	if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: bulk.Namespace, Name: bulk.WorkerPool}, workerpool); err != nil {
 		return fmt.Errorf("failed to get workerpool: %s in namespace %s: %w", bulk.WorkerPool, bulk.Namespace, err)
 	}
 
-	if err := r.reconcileCalculations(bulk.Calculations, bulk.CreationTimestamp.Time); err != nil {
+	reconciled := bulk.DeepCopy()
+	// The cache of the results is looked up in the trace of the bulk.
+	if err := r.reconcileCalculations(tracing.Extract(ctx, bulk), reconciled); err != nil {
 		logrus.WithError(err).Error("error while reconciling calculations")
 	}
 
+	var completed bool
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: req.Namespace, Name: req.Name}, bulk); err != nil {
 			return err
 		}
+		mergeReconciledCalculations(bulk, reconciled)
+		controllerutil.AddFinalizer(bulk, util.CleanupFinalizer)
+		wasReady := meta.IsStatusConditionTrue(bulk.Status.Conditions, v1.ReadyCondition)
+		util.SetBulkConditions(bulk)
+		completed = !wasReady && meta.IsStatusConditionTrue(bulk.Status.Conditions, v1.ReadyCondition)
 		return r.client.Update(ctx, bulk)
 	}); err != nil {
 		return fmt.Errorf("failed to update calculation bulk: %w", err)
 	}
+	if completed {
+		r.recorder.Event(bulk, corev1.EventTypeNormal, CompletedReason, meta.FindStatusCondition(bulk.Status.Conditions, v1.ReadyCondition).Message)
+	}
 
 	// If the bulk is finished and the post-calculation is not yet created, create it
 	if util.IsAllFinishedCalculations(bulk.Calculations) && bulk.PostCalculation != nil && bulk.PostCalculation.Phase == "" {
+		var ref bulkv1.CalculationReference
+		if bulk.Status.PostCalculation != nil {
+			ref = *bulk.Status.PostCalculation
+		}
+		if ref.Name != "" {
+			logger.WithField("calc-name", ref.Name).Info("The post calculation was already created.")
+			return nil
+		}
 		for _, worker := range workerpool.Spec.Workers {
 			if worker.State == workersv1.WorkerAvailableState {
 
-				calc := *newCalculationForBulk(*bulk, *bulk.PostCalculation, req.Namespace, worker.Name, bulk.WorkerPool, map[string]string{
+				calc := *newCalculationForBulk(*bulk, *bulk.PostCalculation, util.GetPostCalculationName(bulk.UID, ref.Attempt), req.Namespace, worker.Name, bulk.WorkerPool, map[string]string{
 					util.BulkLabel:            bulk.Name,
 					util.PostCalculationLabel: "",
 					util.CalcRootFolder:       bulk.RootFolder,
@@ -134,64 +204,274 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 				})
 
 				r.logger.WithField("calc-name", calc.Name).Info("Creating post calculation.")
-				if err := r.client.Create(ctx, &calc); err != nil {
+				uid, err := r.createCalculation(ctx, bulk, &calc)
+				if err != nil {
 					r.logger.WithError(err).Error("couldn't create post calculation")
 					return err
 				}
-				return nil
+				ref.Name, ref.UID = calc.Name, uid
+				return r.recordCalculations(ctx, bulk, nil, &ref)
 			}
 		}
 	}
 
-	calculations := assignCalculationsToWorkers(bulk, workerpool, req.Namespace)
-	for _, calc := range calculations {
+	var errs []error
+	created := make(map[string]bulkv1.CalculationReference)
+	for _, calc := range assignCalculationsToWorkers(bulk, workerpool, req.Namespace) {
+		key := calc.Labels[util.CalculationNameLabel]
 		r.logger.WithField("calc-name", calc.Name).WithField("worker", calc.Assign).Info("Creating calculation.")
-		if err := r.client.Create(ctx, &calc); err != nil {
+		uid, err := r.createCalculation(ctx, bulk, &calc)
+		if err != nil {
 			r.logger.WithError(err).Error("couldn't create calculation")
+			errs = append(errs, err)
+			continue
+		}
+		created[key] = bulkv1.CalculationReference{Name: calc.Name, UID: uid, Attempt: bulk.Status.Calculations[key].Attempt}
+	}
+	if len(created) > 0 {
+		if err := r.recordCalculations(ctx, bulk, created, nil); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
+	return utilerrors.NewAggregate(errs)
+}
+
+// createCalculation creates the calculation in a span of the trace of its bulk, and records the
+// span in the calculation so the worker continues the trace. The name of the calculation is
+// derived from its bulk, its key and its attempt, so a calculation that a previous leader created
+// before it stopped, and that wasn't recorded in the bulk yet, already exists and is adopted
+// instead of created twice. A calculation with the same name that isn't controlled by the bulk is
+// a collision and is reported as an error. It returns the UID of the calculation.
+func (r *reconciler) createCalculation(ctx context.Context, bulk *bulkv1.CalculationBulk, calc *v1.Calculation) (types.UID, error) {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, bulk), "DispatchCalculation", trace.WithAttributes(
+		attribute.String("vega.bulk", bulk.Name),
+		attribute.String("vega.calculation", calc.Name),
+		attribute.String("vega.worker", calc.Assign),
+	))
+	tracing.Inject(ctx, calc)
+	util.SetCalculationConditions(calc)
+	err := r.client.Create(ctx, calc)
+	if kerrors.IsAlreadyExists(err) {
+		existing := &v1.Calculation{}
+		if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKeyFromObject(calc), existing); err != nil {
+			err = fmt.Errorf("couldn't get the existing calculation %s: %w", calc.Name, err)
+			tracing.End(span, err)
+			return "", err
+		}
+		if !metav1.IsControlledBy(existing, bulk) {
+			err := fmt.Errorf("calculation %s already exists and doesn't belong to the bulk %s", calc.Name, bulk.Name)
+			tracing.End(span, err)
+			return "", err
+		}
+		r.logger.WithField("calc-name", calc.Name).Info("The calculation already exists.")
+		span.SetAttributes(attribute.Bool("vega.already_exists", true))
+		tracing.End(span, nil)
+		return existing.UID, nil
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return "", err
+	}
+	r.recorder.Eventf(calc, corev1.EventTypeNormal, AssignedReason, "Assigned to worker %s of the pool %s", calc.Assign, calc.WorkerPool)
+	return calc.UID, nil
+}
+
+// recordCalculations records in the bulk the calculation objects that were created for its
+// calculations and its post-calculation, so the bulk knows which object belongs to which of its
+// calculations even after the dispatcher restarts. A reference is only recorded if the calculation
+// wasn't rescheduled meanwhile.
+func (r *reconciler) recordCalculations(ctx context.Context, bulk *bulkv1.CalculationBulk, refs map[string]bulkv1.CalculationReference, post *bulkv1.CalculationReference) error {
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKeyFromObject(bulk), bulk); err != nil {
+			return err
+		}
+		for key, ref := range refs {
+			if _, ok := bulk.Calculations[key]; !ok || bulk.Status.Calculations[key].Attempt != ref.Attempt {
+				continue
+			}
+			if bulk.Status.Calculations == nil {
+				bulk.Status.Calculations = make(map[string]bulkv1.CalculationReference)
+			}
+			bulk.Status.Calculations[key] = ref
+		}
+		if post != nil {
+			if current := bulk.Status.PostCalculation; current == nil || current.Attempt == post.Attempt {
+				bulk.Status.PostCalculation = post
+			}
+		}
+		return r.client.Update(ctx, bulk)
+	}); err != nil {
+		return fmt.Errorf("failed to record the calculations of the bulk %s: %w", bulk.Name, err)
+	}
 	return nil
 }
 
-func (r *reconciler) reconcileCalculations(calcs map[string]bulkv1.Calculation, bulkCreationTime time.Time) error {
-	var errs []error
-	for key, calc := range calcs {
-		params := map[string]string{
-			"log_g": fmt.Sprintf("%.6f", calc.Params.LogG),
-			"teff":  fmt.Sprintf("%.6f", calc.Params.Teff),
+// finalize cleans up after the deleted bulk. Its calculations are deleted, which stops the ones
+// that are running, and once they are gone the output files of the bulk are removed along with
+// its finalizer. A bulk that is deleted with the orphan propagation leaves its calculations and
+// their output files alone.
+func (r *reconciler) finalize(ctx context.Context, bulk *bulkv1.CalculationBulk, logger *logrus.Entry) error {
+	if !controllerutil.ContainsFinalizer(bulk, util.CleanupFinalizer) {
+		return nil
+	}
+
+	if !controllerutil.ContainsFinalizer(bulk, metav1.FinalizerOrphanDependents) {
+		calcs := &v1.CalculationList{}
+		if err := r.client.List(ctx, calcs, ctrlruntimeclient.InNamespace(bulk.Namespace), ctrlruntimeclient.MatchingLabels{util.BulkLabel: bulk.Name}); err != nil {
+			return fmt.Errorf("couldn't list the calculations of the bulk: %w", err)
 		}
 
-		resp, err := r.gRPCClient.GetData(params)
-		if err != nil {
-			if status.Code(err) == codes.NotFound {
+		var remaining int
+		for _, calc := range calcs.Items {
+			// The calculations that were created before the bulks controlled them only have the label.
+			if owner := metav1.GetControllerOf(&calc); owner != nil && owner.UID != bulk.UID {
 				continue
 			}
-			errs = append(errs, fmt.Errorf("couldn't get results for calculation %s: %w", key, err))
-			continue
+			remaining++
+			if calc.DeletionTimestamp != nil {
+				continue
+			}
+			if err := r.client.Delete(ctx, &calc); err != nil && !kerrors.IsNotFound(err) {
+				return fmt.Errorf("couldn't delete the calculation %s: %w", calc.Name, err)
+			}
+		}
+		if remaining > 0 {
+			logger.WithField("calculations", remaining).Info("Waiting for the calculations of the deleted bulk to be removed")
+			return nil
 		}
 
-		if resp == nil {
+		if err := r.artifacts.Remove(ctx, util.BulkOutputKey(bulk)); err != nil {
+			return fmt.Errorf("couldn't remove the output files of the bulk: %w", err)
+		}
+	}
+
+	logger.Info("Removing the finalizer of the deleted bulk")
+	return util.RemoveFinalizer(ctx, r.client, bulk, &bulkv1.CalculationBulk{})
+}
+
+// reconcileCalculations computes the input hash of every calculation that hasn't started yet
+// and marks the ones whose results were already produced by the exact same inputs as cached.
+// The cache is checked with a single batched call, only for the calculations whose input hash
+// is not yet in the status, i.e. calculations that are new or whose inputs changed. Bulks have
+// no status subresource, so their generation changes with every phase update and can't be used
+// to tell whether the cache was already checked.
+func (r *reconciler) reconcileCalculations(ctx context.Context, bulk *bulkv1.CalculationBulk) error {
+	if bulk.Status.InputHashes == nil {
+		bulk.Status.InputHashes = make(map[string]string)
+	}
+
+	var pending []string
+	files := sets.New[string]()
+	for key, calc := range bulk.Calculations {
+		if calc.Phase != "" {
 			continue
 		}
+		pending = append(pending, key)
+		files.Insert(pipelines.InputFiles(calc.Pipeline, calc.InputFiles)...)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// The input files of all the pending calculations are digested at once. If any of them can't be,
+	// the calculations are hashed one by one, so only those with the missing files fail.
+	digests, err := r.digester.Digests(ctx, bulk.RootFolder, sets.List(files))
+	if err != nil {
+		r.logger.WithError(err).WithField("bulk", bulk.Name).Warn("Couldn't compute the digests of the input files, hashing the calculations one by one")
+	}
 
-		createdAt, err := time.Parse(time.RFC3339, resp.CreatedAt)
+	var errs []error
+	var keys []string
+	inputHashes := make(map[string]string)
+	for _, key := range pending {
+		calc := bulk.Calculations[key]
+		var inputHash string
+		var err error
+		if digests != nil {
+			inputHash, err = pipelines.InputHashFromDigests(digests, calc.Pipeline, calc.Steps, calc.Params, calc.InputFiles)
+		} else {
+			inputHash, err = pipelines.InputHash(ctx, r.digester, bulk.RootFolder, calc.Pipeline, calc.Steps, calc.Params, calc.InputFiles)
+		}
 		if err != nil {
-			errs = append(errs, fmt.Errorf("couldn't parse created_at timestamp for calculation %s: %w", key, err))
+			errs = append(errs, fmt.Errorf("couldn't compute the input hash for calculation %s: %w", key, err))
+			continue
+		}
+		if bulk.Status.InputHashes[key] == inputHash {
 			continue
 		}
+		keys = append(keys, key)
+		inputHashes[key] = inputHash
+	}
+
+	if len(keys) == 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	sort.Strings(keys)
+
+	queries := make([]*proto.DataQuery, 0, len(keys))
+	for _, key := range keys {
+		queries = append(queries, &proto.DataQuery{InputHash: inputHashes[key]})
+	}
+
+	results, err := r.gRPCClient.GetDataBatch(ctx, queries, true)
+	if err != nil {
+		// The input hashes are not recorded, so the cache is checked again on the next reconciliation.
+		errs = append(errs, fmt.Errorf("couldn't look up the results of %d calculations: %w", len(keys), err))
+		return utilerrors.NewAggregate(errs)
+	}
+
+	var cached int
+	for i, key := range keys {
+		bulk.Status.InputHashes[key] = inputHashes[key]
 
-		if createdAt.After(bulkCreationTime) {
+		resp := results[i]
+		if resp == nil {
 			continue
 		}
 
+		r.logger.WithFields(logrus.Fields{
+			"calculation": key,
+			"input-hash":  inputHashes[key],
+			"created-at":  resp.CreatedAt,
+			"parameters":  resp.Parameters,
+		}).Info("Found results produced by the same inputs, marking calculation as cached")
+		calc := bulk.Calculations[key]
 		calc.Phase = v1.CachedPhase
-		calcs[key] = calc
+		bulk.Calculations[key] = calc
+		cached++
+	}
+	r.logger.WithFields(logrus.Fields{"bulk": bulk.Name, "checked": len(keys), "cached": cached}).Info("Checked the cache for calculations")
+	if cached > 0 {
+		r.recorder.Eventf(bulk, corev1.EventTypeNormal, CachedReason, "Found the results of %d of %d calculations in the cache", cached, len(keys))
 	}
 
 	return utilerrors.NewAggregate(errs)
 }
 
+// mergeReconciledCalculations copies the input hashes and the cached phases from the reconciled
+// bulk to the latest version of the bulk, without overriding calculations that started meanwhile.
+func mergeReconciledCalculations(bulk, reconciled *bulkv1.CalculationBulk) {
+	for key, inputHash := range reconciled.Status.InputHashes {
+		if bulk.Status.InputHashes == nil {
+			bulk.Status.InputHashes = make(map[string]string)
+		}
+		bulk.Status.InputHashes[key] = inputHash
+	}
+
+	for key, calc := range reconciled.Calculations {
+		current, ok := bulk.Calculations[key]
+		if !ok || current.Phase != "" || calc.Phase != v1.CachedPhase {
+			continue
+		}
+		current.Phase = v1.CachedPhase
+		bulk.Calculations[key] = current
+	}
+}
+
+// assignCalculationsToWorkers assigns the calculations of the bulk that haven't started yet to the
+// available workers, and returns the calculation objects to create. The calculations that were
+// already created for their current attempt are skipped.
 func assignCalculationsToWorkers(bulk *bulkv1.CalculationBulk, workerpool *workersv1.WorkerPool, namespace string) []v1.Calculation {
 	var calculations []v1.Calculation
 	calculationItems := util.GetSortedCreatedCalculations(bulk.Calculations).Items
@@ -205,16 +485,18 @@ func assignCalculationsToWorkers(bulk *bulkv1.CalculationBulk, workerpool *worke
 	sort.Strings(availableWorkers)
 	workerIndex := 0
 	for _, item := range calculationItems {
-		if item.Calculation.Phase == "" && workerIndex < len(availableWorkers) {
+		ref := bulk.Status.Calculations[item.Name]
+		if item.Calculation.Phase == "" && ref.Name == "" && workerIndex < len(availableWorkers) {
 			workerName := availableWorkers[workerIndex]
 			worker := workerpool.Spec.Workers[workerName]
 
-			calculation := newCalculationForBulk(*bulk, item.Calculation, namespace, worker.Name, bulk.WorkerPool, map[string]string{
+			calculation := newCalculationForBulk(*bulk, item.Calculation, util.GetCalculationName(bulk.UID, item.Name, ref.Attempt), namespace, worker.Name, bulk.WorkerPool, map[string]string{
 				util.BulkLabel:            bulk.Name,
 				util.CalculationNameLabel: item.Name,
 				util.CalcRootFolder:       bulk.RootFolder,
 				util.AssignWorkerLabel:    worker.Name,
 			})
+			calculation.InputHash = bulk.Status.InputHashes[item.Name]
 			calculations = append(calculations, *calculation)
 			workerIndex++
 		}
@@ -222,9 +504,8 @@ func assignCalculationsToWorkers(bulk *bulkv1.CalculationBulk, workerpool *worke
 	return calculations
 }
 
-func newCalculationForBulk(bulk bulkv1.CalculationBulk, calcBulkCalculation bulkv1.Calculation, namespace, assignWorker, workerPool string, labels map[string]string) *v1.Calculation {
+func newCalculationForBulk(bulk bulkv1.CalculationBulk, calcBulkCalculation bulkv1.Calculation, name, namespace, assignWorker, workerPool string, labels map[string]string) *v1.Calculation {
 	calc := util.NewCalculation(&calcBulkCalculation)
-
 	if calc.InputFiles == nil {
 		calc.InputFiles = calcBulkCalculation.InputFiles
 	}
@@ -233,11 +514,24 @@ func newCalculationForBulk(bulk bulkv1.CalculationBulk, calcBulkCalculation bulk
 		calc.OutputFilesRegex = bulk.OutputFilesRegex
 	}
 
+	if len(calc.OutputRules) == 0 {
+		calc.OutputRules = bulk.OutputRules
+	}
+
+	if calc.Resources == nil {
+		calc.Resources = bulk.Resources
+	}
+
 	if calc.Pipeline == "" {
 		calc.Pipeline = calcBulkCalculation.Pipeline
 	}
 
+	calc.Name = name
 	calc.Namespace = namespace
+	// The bulk controls its calculations, which tells the calculations of the bulk apart from the
+	// objects that happen to have the same name.
+	calc.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(&bulk, bulkv1.SchemeGroupVersion.WithKind(bulkKind))}
+	calc.Finalizers = []string{util.CleanupFinalizer}
 	calc.WorkerPool = workerPool
 	calc.Labels = labels
 	calc.Assign = assignWorker
@@ -1,6 +1,9 @@
 package bulks
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -8,16 +11,30 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/pipelines"
+	"github.com/vega-project/ccb-operator/pkg/util"
 	proto "github.com/vega-project/ccb-operator/proto"
 )
 
 func Test_assignCalculationsToWorkers(t *testing.T) {
+	controlledByBulk := []metav1.OwnerReference{{APIVersion: "vegaproject.io/v1", Kind: "CalculationBulk", Controller: ptr.To(true), BlockOwnerDeletion: ptr.To(true)}}
 	tests := []struct {
 		name       string
 		bulk       *bulkv1.CalculationBulk
@@ -51,7 +68,9 @@ func Test_assignCalculationsToWorkers(t *testing.T) {
 			want: []v1.Calculation{
 				{
 					ObjectMeta: metav1.ObjectMeta{
-						Name: "calc-1wij91455czrwswi",
+						Name:            "calc-m5qtx45t8v1sy4xi",
+						OwnerReferences: controlledByBulk,
+						Finalizers:      []string{"vegaproject.io/cleanup"},
 						Labels: map[string]string{
 							"vegaproject.io/assign":          "worker1",
 							"vegaproject.io/bulk":            "",
@@ -74,7 +93,9 @@ func Test_assignCalculationsToWorkers(t *testing.T) {
 				},
 				{
 					ObjectMeta: metav1.ObjectMeta{
-						Name: "calc-xmlv9xmmc2mcgjq0",
+						Name:            "calc-42db65r56j7k0syy",
+						OwnerReferences: controlledByBulk,
+						Finalizers:      []string{"vegaproject.io/cleanup"},
 						Labels: map[string]string{
 							"vegaproject.io/assign":          "worker2",
 							"vegaproject.io/bulk":            "",
@@ -125,7 +146,9 @@ func Test_assignCalculationsToWorkers(t *testing.T) {
 			want: []v1.Calculation{
 				{
 					ObjectMeta: metav1.ObjectMeta{
-						Name: "calc-1wij91455czrwswi",
+						Name:            "calc-m5qtx45t8v1sy4xi",
+						OwnerReferences: controlledByBulk,
+						Finalizers:      []string{"vegaproject.io/cleanup"},
 						Labels: map[string]string{
 							"vegaproject.io/assign":          "worker1",
 							"vegaproject.io/bulk":            "",
@@ -148,7 +171,9 @@ func Test_assignCalculationsToWorkers(t *testing.T) {
 				},
 				{
 					ObjectMeta: metav1.ObjectMeta{
-						Name: "calc-xmlv9xmmc2mcgjq0",
+						Name:            "calc-42db65r56j7k0syy",
+						OwnerReferences: controlledByBulk,
+						Finalizers:      []string{"vegaproject.io/cleanup"},
 						Labels: map[string]string{
 							"vegaproject.io/assign":          "worker2",
 							"vegaproject.io/bulk":            "",
@@ -196,7 +221,9 @@ func Test_assignCalculationsToWorkers(t *testing.T) {
 			want: []v1.Calculation{
 				{
 					ObjectMeta: metav1.ObjectMeta{
-						Name: "calc-1wij91455czrwswi",
+						Name:            "calc-m5qtx45t8v1sy4xi",
+						OwnerReferences: controlledByBulk,
+						Finalizers:      []string{"vegaproject.io/cleanup"},
 						Labels: map[string]string{
 							"vegaproject.io/assign":          "worker1",
 							"vegaproject.io/bulk":            "",
@@ -219,6 +246,64 @@ func Test_assignCalculationsToWorkers(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "calculations created for their attempt are skipped, rescheduled ones get the name of their next attempt",
+			bulk: &bulkv1.CalculationBulk{
+				ObjectMeta: metav1.ObjectMeta{Name: "bulk", UID: "bulk-uid"},
+				Calculations: map[string]bulkv1.Calculation{
+					"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+					"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
+				},
+				Status: bulkv1.CalculationBulkStatus{
+					Calculations: map[string]bulkv1.CalculationReference{
+						"calc1": {Name: "calc-created", UID: "calc-uid"},
+						"calc2": {Attempt: 1},
+					},
+				},
+			},
+			workerpool: &workersv1.WorkerPool{
+				Spec: workersv1.WorkerPoolSpec{
+					Workers: map[string]workersv1.Worker{
+						"worker1-node": {
+							Name:  "worker1",
+							State: workersv1.WorkerAvailableState,
+						},
+						"worker2-node": {
+							Name:  "worker2",
+							State: workersv1.WorkerAvailableState,
+						},
+					},
+				},
+			},
+			want: []v1.Calculation{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "calc-hmtfvzmsgr0g04qp",
+						OwnerReferences: []metav1.OwnerReference{
+							{APIVersion: "vegaproject.io/v1", Kind: "CalculationBulk", Name: "bulk", UID: "bulk-uid", Controller: ptr.To(true), BlockOwnerDeletion: ptr.To(true)},
+						},
+						Finalizers: []string{"vegaproject.io/cleanup"},
+						Labels: map[string]string{
+							"vegaproject.io/assign":          "worker1",
+							"vegaproject.io/bulk":            "bulk",
+							"vegaproject.io/calculationName": "calc2",
+							"vegaproject.io/rootFolder":      "",
+						},
+					},
+					Spec: v1.CalculationSpec{
+						Steps: []v1.Step{
+							{Command: "atlas12_ada", Args: []string{"s"}},
+							{Command: "atlas12_ada", Args: []string{"r"}},
+							{Command: "/bin/bash", Args: []string{"-c", "synspec49 < input_tlusty_fortfive"}},
+						},
+						Params: v1.Params{LogG: 4.0, Teff: 11000.0},
+					},
+					Pipeline: "vega",
+					Assign:   "worker1",
+					Phase:    "Created",
+				},
+			},
+		},
 		{
 			name: "3 calculation, no workers available - expect no calculations assigned to workers",
 			bulk: &bulkv1.CalculationBulk{
@@ -255,27 +340,71 @@ func Test_assignCalculationsToWorkers(t *testing.T) {
 
 type fakeResults struct {
 	parameters map[string]string
+	inputHash  string
 	createdAt  time.Time
 	results    string
 }
 
 type fakeGRPCClient struct {
 	results []fakeResults
+	// batchErr is returned by the batch calls, if set
+	batchErr error
+	// batches are the queries of every GetDataBatch call
+	batches [][]*proto.DataQuery
 }
 
-func (f *fakeGRPCClient) StoreData(parameters map[string]string, results string) (*proto.StoreResponse, error) {
+func (f *fakeGRPCClient) StoreData(ctx context.Context, parameters map[string]string, inputHash, results string) (*proto.StoreResponse, error) {
 	return nil, nil
 }
 
-func (f *fakeGRPCClient) GetData(parameters map[string]string) (*proto.GetDataResponse, error) {
+func (f *fakeGRPCClient) GetData(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error) {
 	for _, result := range f.results {
 		if reflect.DeepEqual(result.parameters, parameters) {
-			return &proto.GetDataResponse{
-				Results:   result.results,
-				CreatedAt: result.createdAt.Format(time.RFC3339),
-			}, nil
+			return newFakeGetDataResponse(result), nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "results not found")
+}
+
+func (f *fakeGRPCClient) LookupByHash(ctx context.Context, inputHash string) (*proto.GetDataResponse, error) {
+	for _, result := range f.results {
+		if result.inputHash == inputHash {
+			return newFakeGetDataResponse(result), nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "results not found")
+}
+
+func (f *fakeGRPCClient) StoreDataStream(ctx context.Context, parameters map[string]string, inputHash string, results []byte) (*proto.StoreResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeGRPCClient) GetDataStream(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error) {
+	return f.GetData(ctx, parameters)
+}
+
+func (f *fakeGRPCClient) GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*proto.GetDataResponse, error) {
+	f.batches = append(f.batches, queries)
+	if f.batchErr != nil {
+		return nil, f.batchErr
+	}
+
+	responses := make([]*proto.GetDataResponse, len(queries))
+	for i, query := range queries {
+		for _, result := range f.results {
+			if (query.InputHash != "" && result.inputHash == query.InputHash) || (query.InputHash == "" && reflect.DeepEqual(result.parameters, query.Parameters)) {
+				responses[i] = newFakeGetDataResponse(result)
+				if metadataOnly {
+					responses[i].Results = ""
+				}
+				break
+			}
 		}
 	}
+	return responses, nil
+}
+
+func (f *fakeGRPCClient) StoreDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error) {
 	return nil, nil
 }
 
@@ -283,94 +412,586 @@ func (f *fakeGRPCClient) Close() error {
 	return nil
 }
 
+func newFakeGetDataResponse(result fakeResults) *proto.GetDataResponse {
+	return &proto.GetDataResponse{
+		Results:    result.results,
+		CreatedAt:  result.createdAt.Format(time.RFC3339),
+		InputHash:  result.inputHash,
+		Parameters: result.parameters,
+	}
+}
+
 func Test_reconciler_reconcileCalculations(t *testing.T) {
 	tests := []struct {
-		name             string
-		results          []fakeResults
-		calcs            map[string]bulkv1.Calculation
-		bulkCreationTime time.Time
-		wantErr          bool
-		expectedCalcs    map[string]bulkv1.Calculation
+		name string
+		// cached are the calculations whose results were stored before the inputs were modified
+		cached         []string
+		modifyInputs   bool
+		calcs          map[string]bulkv1.Calculation
+		expectedCalcs  map[string]bulkv1.Calculation
+		expectedHashes []string
+		expectedEvents []string
 	}{
 		{
 			name: "no results",
 			calcs: map[string]bulkv1.Calculation{
 				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
 				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
-				"calc3": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 12000.0}},
 			},
-			results:          []fakeResults{},
-			bulkCreationTime: time.Now(),
 			expectedCalcs: map[string]bulkv1.Calculation{
 				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
 				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
-				"calc3": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 12000.0}},
 			},
+			expectedHashes: []string{"calc1", "calc2"},
 		},
-
 		{
-			name: "results for some calculations",
+			name:   "results with the same inputs for some calculations",
+			cached: []string{"calc1"},
 			calcs: map[string]bulkv1.Calculation{
-				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 10000.000000}},
-				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 11000.000000}},
-				"calc3": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 12000.000000}},
-			},
-			results: []fakeResults{
-				{
-					parameters: map[string]string{"log_g": "4.000000", "teff": "10000.000000"},
-					results:    "results1",
-					createdAt:  time.Now().Add(-24 * time.Hour),
-				},
+				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
 			},
-			bulkCreationTime: time.Now(),
 			expectedCalcs: map[string]bulkv1.Calculation{
-				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 10000.000000}, Phase: v1.CachedPhase},
-				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 11000.000000}},
-				"calc3": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 12000.000000}},
+				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}, Phase: v1.CachedPhase},
+				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
 			},
+			expectedHashes: []string{"calc1", "calc2"},
+			expectedEvents: []string{"Normal Cached Found the results of 1 of 2 calculations in the cache"},
 		},
-
 		{
-			name: "results for some calculations, some results are newer than the bulk creation time",
+			name:         "results with the same parameters but modified input files are not a cache hit",
+			cached:       []string{"calc1"},
+			modifyInputs: true,
 			calcs: map[string]bulkv1.Calculation{
-				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 10000.000000}},
-				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 11000.000000}},
-				"calc3": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 12000.000000}},
+				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
 			},
-			results: []fakeResults{
-				{
-					parameters: map[string]string{"log_g": "4.000000", "teff": "10000.000000"},
-					results:    "results1",
-					createdAt:  time.Now().Add(-24 * time.Hour),
-				},
-				{
-					parameters: map[string]string{"log_g": "4.000000", "teff": "11000.000000"},
-					results:    "results1",
-					createdAt:  time.Now().Add(+1 * time.Hour),
-				},
+			expectedCalcs: map[string]bulkv1.Calculation{
+				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
+			},
+			expectedHashes: []string{"calc1", "calc2"},
+		},
+		{
+			name:   "calculations that already started are skipped",
+			cached: []string{"calc1"},
+			calcs: map[string]bulkv1.Calculation{
+				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}, Phase: v1.ProcessingPhase},
+				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
 			},
-			bulkCreationTime: time.Now(),
 			expectedCalcs: map[string]bulkv1.Calculation{
-				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 10000.000000}, Phase: v1.CachedPhase},
-				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 11000.000000}},
-				"calc3": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.000000, Teff: 12000.000000}},
+				"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}, Phase: v1.ProcessingPhase},
+				"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
 			},
+			expectedHashes: []string{"calc2"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			nfsPath := t.TempDir()
+			rootFolder := "bulk"
+			controlFile := writeTestInputFiles(t, filepath.Join(nfsPath, rootFolder))
+
+			var results []fakeResults
+			for _, name := range tt.cached {
+				calc := tt.calcs[name]
+				inputHash, err := pipelines.InputHash(context.Background(), artifacts.NewLocalStore(nfsPath), rootFolder, calc.Pipeline, calc.Steps, calc.Params, calc.InputFiles)
+				if err != nil {
+					t.Fatal(err)
+				}
+				results = append(results, fakeResults{
+					parameters: util.ResultParameters(calc.Params),
+					inputHash:  inputHash,
+					createdAt:  time.Now().Add(-24 * time.Hour),
+					results:    "results",
+				})
+			}
+
+			if tt.modifyInputs {
+				if err := os.WriteFile(controlFile, []byte("modified template"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gRPCClient := &fakeGRPCClient{results: results}
+			recorder := record.NewFakeRecorder(10)
+			digester := &countingDigester{Digester: artifacts.NewLocalStore(nfsPath)}
 			r := &reconciler{
 				logger:     logrus.WithField("name", tt.name),
-				gRPCClient: &fakeGRPCClient{results: tt.results},
+				gRPCClient: gRPCClient,
+				digester:   digester,
+				recorder:   recorder,
+			}
+			bulk := &bulkv1.CalculationBulk{RootFolder: rootFolder, Calculations: tt.calcs}
+			if err := r.reconcileCalculations(context.Background(), bulk); err != nil {
+				t.Fatalf("reconciler.reconcileCalculations() error = %v", err)
 			}
-			if err := r.reconcileCalculations(tt.calcs, tt.bulkCreationTime); (err != nil) != tt.wantErr {
-				t.Errorf("reconciler.reconcileCalculations() error = %v, wantErr %v", err, tt.wantErr)
+
+			if len(gRPCClient.batches) != 1 || len(gRPCClient.batches[0]) != len(tt.expectedHashes) {
+				t.Fatalf("expected a single batch call with %d queries, got %v", len(tt.expectedHashes), gRPCClient.batches)
+			}
+			if digester.calls != 1 {
+				t.Fatalf("expected the input files to be digested once, got %d calls", digester.calls)
+			}
+
+			if diff := cmp.Diff(bulk.Calculations, tt.expectedCalcs); diff != "" {
+				t.Fatal(diff)
 			}
 
-			if diff := cmp.Diff(tt.calcs, tt.expectedCalcs); diff != "" {
+			var hashed []string
+			for name, inputHash := range bulk.Status.InputHashes {
+				if inputHash == "" {
+					t.Errorf("empty input hash for calculation %s", name)
+				}
+				hashed = append(hashed, name)
+			}
+			if diff := cmp.Diff(hashed, tt.expectedHashes, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(tt.expectedEvents, recordedEvents(recorder)); diff != "" {
 				t.Fatal(diff)
 			}
 		})
 	}
 }
+
+// countingDigester counts the calls that digest the input files.
+type countingDigester struct {
+	pipelines.Digester
+	calls int
+}
+
+func (d *countingDigester) Digests(ctx context.Context, root string, paths []string) (map[string]string, error) {
+	d.calls++
+	return d.Digester.Digests(ctx, root, paths)
+}
+
+func Test_reconciler_reconcileCalculationsMissingInputFiles(t *testing.T) {
+	nfsPath := t.TempDir()
+	writeTestInputFiles(t, nfsPath)
+	gRPCClient := &fakeGRPCClient{}
+	r := &reconciler{
+		logger:     logrus.WithField("name", "missing"),
+		gRPCClient: gRPCClient,
+		digester:   artifacts.NewLocalStore(nfsPath),
+		recorder:   record.NewFakeRecorder(10),
+	}
+	bulk := &bulkv1.CalculationBulk{
+		Calculations: map[string]bulkv1.Calculation{
+			"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+			"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}, InputFiles: &v1.InputFiles{Files: []string{"missing"}}},
+		},
+	}
+
+	if err := r.reconcileCalculations(context.Background(), bulk); err == nil {
+		t.Fatal("expected an error for the calculation with a missing input file")
+	}
+	// The calculations whose input files exist are still checked.
+	if diff := cmp.Diff([]string{"calc1"}, sets.List(sets.KeySet(bulk.Status.InputHashes))); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// writeTestInputFiles writes the input files of the vega pipeline in the given root folder
+// and returns the path of the control file.
+func writeTestInputFiles(t *testing.T, rootFolder string) string {
+	dataFile := filepath.Join(rootFolder, "atlas-data-files", "molecules.dat")
+	controlFile := filepath.Join(rootFolder, "atlas-control-files", "kuruz-model-template-file")
+	for file, content := range map[string]string{dataFile: "data", controlFile: "template"} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return controlFile
+}
+
+func Test_reconciler_reconcileCalculationsRemembersCheckedCalculations(t *testing.T) {
+	nfsPath := t.TempDir()
+	writeTestInputFiles(t, nfsPath)
+	gRPCClient := &fakeGRPCClient{batchErr: status.Error(codes.Unavailable, "connection refused")}
+	r := &reconciler{
+		logger:     logrus.WithField("name", "remember"),
+		gRPCClient: gRPCClient,
+		digester:   artifacts.NewLocalStore(nfsPath),
+		recorder:   record.NewFakeRecorder(10),
+	}
+	bulk := &bulkv1.CalculationBulk{
+		Calculations: map[string]bulkv1.Calculation{
+			"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+			"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
+		},
+	}
+
+	if err := r.reconcileCalculations(context.Background(), bulk); err == nil {
+		t.Fatal("expected an error while the results-handler is unavailable")
+	}
+	if len(bulk.Status.InputHashes) != 0 {
+		t.Fatalf("expected no input hashes to be recorded after a failed lookup, got %v", bulk.Status.InputHashes)
+	}
+
+	gRPCClient.batchErr = nil
+	for i := 0; i < 2; i++ {
+		if err := r.reconcileCalculations(context.Background(), bulk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(gRPCClient.batches) != 2 {
+		t.Fatalf("expected the checked calculations not to be looked up again, got %d batch calls", len(gRPCClient.batches))
+	}
+
+	bulk.Calculations["calc3"] = bulkv1.Calculation{Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 12000.0}}
+	if err := r.reconcileCalculations(context.Background(), bulk); err != nil {
+		t.Fatal(err)
+	}
+	if len(gRPCClient.batches) != 3 || len(gRPCClient.batches[2]) != 1 {
+		t.Fatalf("expected only the new calculation to be looked up, got %v", gRPCClient.batches)
+	}
+}
+
+func Test_mergeReconciledCalculations(t *testing.T) {
+	bulk := &bulkv1.CalculationBulk{
+		Calculations: map[string]bulkv1.Calculation{
+			"calc1": {Pipeline: v1.VegaPipeline},
+			"calc2": {Pipeline: v1.VegaPipeline, Phase: v1.ProcessingPhase},
+			"calc3": {Pipeline: v1.VegaPipeline},
+		},
+	}
+	reconciled := &bulkv1.CalculationBulk{
+		Calculations: map[string]bulkv1.Calculation{
+			"calc1": {Pipeline: v1.VegaPipeline, Phase: v1.CachedPhase},
+			"calc2": {Pipeline: v1.VegaPipeline, Phase: v1.CachedPhase},
+			"calc3": {Pipeline: v1.VegaPipeline},
+		},
+		Status: bulkv1.CalculationBulkStatus{InputHashes: map[string]string{"calc1": "hash1", "calc3": "hash3"}},
+	}
+
+	mergeReconciledCalculations(bulk, reconciled)
+
+	expected := &bulkv1.CalculationBulk{
+		Calculations: map[string]bulkv1.Calculation{
+			"calc1": {Pipeline: v1.VegaPipeline, Phase: v1.CachedPhase},
+			"calc2": {Pipeline: v1.VegaPipeline, Phase: v1.ProcessingPhase},
+			"calc3": {Pipeline: v1.VegaPipeline},
+		},
+		Status: bulkv1.CalculationBulkStatus{InputHashes: map[string]string{"calc1": "hash1", "calc3": "hash3"}},
+	}
+	if diff := cmp.Diff(bulk, expected); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// recordedEvents returns the events that the fake recorder received so far.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestReconcileConditionsAndEvents(t *testing.T) {
+	nfsPath := t.TempDir()
+	writeTestInputFiles(t, filepath.Join(nfsPath, "bulk"))
+
+	bulk := &bulkv1.CalculationBulk{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-bulk", Namespace: "vega"},
+		RootFolder: "bulk",
+		WorkerPool: "test-pool",
+		Calculations: map[string]bulkv1.Calculation{
+			"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}, Phase: v1.CompletedPhase},
+			"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
+		},
+	}
+	pool := &workersv1.WorkerPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Namespace: "vega"},
+		Spec: workersv1.WorkerPoolSpec{
+			Workers: map[string]workersv1.Worker{"node-1": {Name: "worker-1", State: workersv1.WorkerAvailableState}},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &reconciler{
+		logger:     logrus.WithField("name", t.Name()),
+		client:     fakectrlruntimeclient.NewClientBuilder().WithObjects(bulk, pool).Build(),
+		gRPCClient: &fakeGRPCClient{},
+		digester:   artifacts.NewLocalStore(nfsPath),
+		recorder:   recorder,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-bulk"}}
+	ignoreTransitionTime := cmpopts.IgnoreFields(metav1.Condition{}, "LastTransitionTime")
+
+	if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+		t.Fatal(err)
+	}
+	actual := &bulkv1.CalculationBulk{}
+	if err := r.client.Get(context.Background(), req.NamespacedName, actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := []metav1.Condition{
+		{Type: v1.ReadyCondition, Status: metav1.ConditionFalse, Reason: "Processing", Message: "1 of 2 calculations finished"},
+		{Type: v1.ProgressingCondition, Status: metav1.ConditionTrue, Reason: "Processing", Message: "1 of 2 calculations finished"},
+		{Type: v1.DegradedCondition, Status: metav1.ConditionFalse, Reason: "AsExpected"},
+	}
+	if diff := cmp.Diff(expected, actual.Status.Conditions, ignoreTransitionTime); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var calcs v1.CalculationList
+	if err := r.client.List(context.Background(), &calcs); err != nil {
+		t.Fatal(err)
+	}
+	if len(calcs.Items) != 1 {
+		t.Fatalf("expected a calculation to be created, got %d", len(calcs.Items))
+	}
+	if condition := meta.FindStatusCondition(calcs.Items[0].Status.Conditions, v1.ProgressingCondition); condition == nil || condition.Reason != "Assigned" {
+		t.Fatalf("expected the created calculation to be assigned, got %v", calcs.Items[0].Status.Conditions)
+	}
+	if diff := cmp.Diff([]string{"Normal Assigned Assigned to worker worker-1 of the pool test-pool"}, recordedEvents(recorder)); diff != "" {
+		t.Fatal(diff)
+	}
+
+	actual.Calculations["calc2"] = bulkv1.Calculation{Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}, Phase: v1.FailedPhase}
+	if err := r.client.Update(context.Background(), actual); err != nil {
+		t.Fatal(err)
+	}
+	// The bulk is completed once, however many times it's reconciled.
+	for i := 0; i < 2; i++ {
+		if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.client.Get(context.Background(), req.NamespacedName, actual); err != nil {
+		t.Fatal(err)
+	}
+	expected = []metav1.Condition{
+		{Type: v1.ReadyCondition, Status: metav1.ConditionTrue, Reason: "Completed", Message: "All 2 calculations finished, 0 from the cache"},
+		{Type: v1.ProgressingCondition, Status: metav1.ConditionFalse, Reason: "Completed", Message: "All 2 calculations finished, 0 from the cache"},
+		{Type: v1.DegradedCondition, Status: metav1.ConditionTrue, Reason: "CalculationsFailed", Message: "1 of 2 calculations failed"},
+	}
+	if diff := cmp.Diff(expected, actual.Status.Conditions, ignoreTransitionTime); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff([]string{"Normal Completed All 2 calculations finished, 0 from the cache"}, recordedEvents(recorder)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestReconcileIdempotentCreation(t *testing.T) {
+	nfsPath := t.TempDir()
+	writeTestInputFiles(t, filepath.Join(nfsPath, "bulk"))
+
+	bulk := &bulkv1.CalculationBulk{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-bulk", Namespace: "vega", UID: "bulk-uid"},
+		RootFolder: "bulk",
+		WorkerPool: "test-pool",
+		Calculations: map[string]bulkv1.Calculation{
+			"calc1": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 10000.0}},
+			"calc2": {Pipeline: v1.VegaPipeline, Params: v1.Params{LogG: 4.0, Teff: 11000.0}},
+		},
+	}
+	pool := &workersv1.WorkerPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Namespace: "vega"},
+		Spec: workersv1.WorkerPoolSpec{
+			Workers: map[string]workersv1.Worker{
+				"node-1": {Name: "worker-1", State: workersv1.WorkerAvailableState},
+				"node-2": {Name: "worker-2", State: workersv1.WorkerAvailableState},
+			},
+		},
+	}
+	controllerRef := func(uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "vegaproject.io/v1", Kind: "CalculationBulk", Name: "test-bulk", UID: uid, Controller: ptr.To(true)}}
+	}
+	// A previous leader created the first calculation but stopped before it recorded it in the bulk,
+	// and the name of the second one is taken by a calculation of another bulk.
+	created := &v1.Calculation{ObjectMeta: metav1.ObjectMeta{
+		Name:            util.GetCalculationName("bulk-uid", "calc1", 0),
+		Namespace:       "vega",
+		UID:             "calc1-uid",
+		OwnerReferences: controllerRef("bulk-uid"),
+	}}
+	taken := &v1.Calculation{ObjectMeta: metav1.ObjectMeta{
+		Name:            util.GetCalculationName("bulk-uid", "calc2", 0),
+		Namespace:       "vega",
+		UID:             "other-uid",
+		OwnerReferences: controllerRef("other-bulk-uid"),
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &reconciler{
+		logger:     logrus.WithField("name", t.Name()),
+		client:     fakectrlruntimeclient.NewClientBuilder().WithObjects(bulk, pool, created, taken).Build(),
+		gRPCClient: &fakeGRPCClient{},
+		digester:   artifacts.NewLocalStore(nfsPath),
+		recorder:   recorder,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-bulk"}}
+
+	for i := 0; i < 2; i++ {
+		if err := r.reconcile(context.Background(), req, r.logger); err == nil {
+			t.Fatal("expected the collision of the second calculation to be reported")
+		}
+	}
+
+	actual := &bulkv1.CalculationBulk{}
+	if err := r.client.Get(context.Background(), req.NamespacedName, actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bulkv1.CalculationReference{
+		"calc1": {Name: created.Name, UID: "calc1-uid"},
+	}
+	if diff := cmp.Diff(expected, actual.Status.Calculations); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var calcs v1.CalculationList
+	if err := r.client.List(context.Background(), &calcs); err != nil {
+		t.Fatal(err)
+	}
+	if len(calcs.Items) != 2 {
+		t.Fatalf("expected no calculation to be created, got %d", len(calcs.Items))
+	}
+	if events := recordedEvents(recorder); len(events) != 0 {
+		t.Fatalf("expected no events for the adopted calculation, got %v", events)
+	}
+}
+
+func TestReconcileDeletion(t *testing.T) {
+	deleted := metav1.NewTime(time.Now())
+	controllerRef := func(uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "vegaproject.io/v1", Kind: "CalculationBulk", Name: "test-bulk", UID: uid, Controller: ptr.To(true)}}
+	}
+	calculation := func(name string, uid types.UID) *v1.Calculation {
+		return &v1.Calculation{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "vega",
+			Labels:          map[string]string{util.BulkLabel: "test-bulk"},
+			OwnerReferences: controllerRef(uid),
+			Finalizers:      []string{util.CleanupFinalizer},
+		}}
+	}
+
+	testCases := []struct {
+		name               string
+		finalizers         []string
+		expectedDeleted    []string
+		expectedFinalizers []string
+	}{
+		{
+			name:               "the calculations of the bulk are deleted before its output files",
+			finalizers:         []string{util.CleanupFinalizer},
+			expectedDeleted:    []string{"calc-1", "calc-2"},
+			expectedFinalizers: []string{util.CleanupFinalizer},
+		},
+		{
+			name:               "orphaned calculations and output files are kept",
+			finalizers:         []string{metav1.FinalizerOrphanDependents, util.CleanupFinalizer},
+			expectedFinalizers: []string{metav1.FinalizerOrphanDependents},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nfsPath := t.TempDir()
+			output := filepath.Join(nfsPath, "bulk", "test-bulk")
+			if err := os.MkdirAll(output, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(output, "fort.7"), []byte("results"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			bulk := &bulkv1.CalculationBulk{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "test-bulk",
+					Namespace:         "vega",
+					UID:               "bulk-uid",
+					DeletionTimestamp: &deleted,
+					Finalizers:        tc.finalizers,
+				},
+				RootFolder: "bulk",
+			}
+			r := &reconciler{
+				logger: logrus.WithField("name", t.Name()),
+				client: fakectrlruntimeclient.NewClientBuilder().WithObjects(
+					bulk,
+					calculation("calc-1", "bulk-uid"),
+					calculation("calc-2", "bulk-uid"),
+					calculation("calc-3", "other-bulk-uid"),
+				).Build(),
+				artifacts: artifacts.NewLocalStore(nfsPath),
+			}
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-bulk"}}
+			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+				t.Fatal(err)
+			}
+
+			var calcs v1.CalculationList
+			if err := r.client.List(context.Background(), &calcs); err != nil {
+				t.Fatal(err)
+			}
+			var actualDeleted []string
+			for _, calc := range calcs.Items {
+				if calc.DeletionTimestamp != nil {
+					actualDeleted = append(actualDeleted, calc.Name)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedDeleted, actualDeleted); diff != "" {
+				t.Fatal(diff)
+			}
+
+			actual := &bulkv1.CalculationBulk{}
+			if err := r.client.Get(context.Background(), req.NamespacedName, actual); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedFinalizers, actual.Finalizers); diff != "" {
+				t.Fatal(diff)
+			}
+			if _, err := os.Stat(output); err != nil {
+				t.Fatalf("expected the output files to be kept while the calculations are deleted: %v", err)
+			}
+		})
+	}
+}
+
+func TestReconcileDeletionRemovesOutput(t *testing.T) {
+	nfsPath := t.TempDir()
+	output := filepath.Join(nfsPath, "bulk", "test-bulk")
+	if err := os.MkdirAll(output, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := metav1.NewTime(time.Now())
+	bulk := &bulkv1.CalculationBulk{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-bulk",
+			Namespace:         "vega",
+			UID:               "bulk-uid",
+			DeletionTimestamp: &deleted,
+			Finalizers:        []string{util.CleanupFinalizer},
+		},
+		RootFolder: "bulk",
+	}
+	r := &reconciler{
+		logger:    logrus.WithField("name", t.Name()),
+		client:    fakectrlruntimeclient.NewClientBuilder().WithObjects(bulk).Build(),
+		artifacts: artifacts.NewLocalStore(nfsPath),
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-bulk"}}
+	if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatalf("expected the output files of the bulk to be removed, got %v", err)
+	}
+	if err := r.client.Get(context.Background(), req.NamespacedName, &bulkv1.CalculationBulk{}); !kerrors.IsNotFound(err) {
+		t.Fatalf("expected the bulk to be removed with its finalizer, got %v", err)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -15,6 +17,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -23,6 +26,8 @@ import (
 	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
 	factoryv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulkfactory/v1"
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
 	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
@@ -30,29 +35,13 @@ const (
 	controllerName = "calculations"
 )
 
-var calculationValues = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-	Namespace: "vega",
-	Name:      "calculations",
-	Help:      "Calculation ID, status and time of creation",
-},
-	[]string{
-		"calc_id",
-		"status",
-		"creation_time",
-	})
-
-func init() {
-	if err := prometheus.Register(calculationValues); err != nil {
-		logrus.Errorf("couldn't register calculation values in prometheus")
-	}
-}
-
-func AddToManager(ctx context.Context, mgr manager.Manager, ns string) error {
+func AddToManager(ctx context.Context, mgr manager.Manager, ns string, artifactStore artifacts.ArtifactStore, registerer prometheus.Registerer) error {
 	c, err := controller.New(controllerName, mgr, controller.Options{
 		MaxConcurrentReconciles: 1,
 		Reconciler: &reconciler{
-			logger: logrus.WithField("controller", controllerName),
-			client: mgr.GetClient(),
+			logger:    logrus.WithField("controller", controllerName),
+			client:    mgr.GetClient(),
+			artifacts: artifactStore,
 		},
 	})
 	if err != nil {
@@ -72,6 +61,10 @@ func AddToManager(ctx context.Context, mgr manager.Manager, ns string) error {
 		return fmt.Errorf("failed to create watch for clusterpools: %w", err)
 	}
 
+	if err := registerer.Register(newCalculationsCollector(mgr.GetClient(), ns, mgr.Elected())); err != nil {
+		return fmt.Errorf("failed to register the calculation metrics: %w", err)
+	}
+
 	return nil
 }
 
@@ -100,8 +93,9 @@ func (c *calculationHandler) Generic(ctx context.Context, e event.TypedGenericEv
 }
 
 type reconciler struct {
-	logger *logrus.Entry
-	client ctrlruntimeclient.Client
+	logger    *logrus.Entry
+	client    ctrlruntimeclient.Client
+	artifacts artifacts.ArtifactStore
 }
 
 func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -123,10 +117,14 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 	if err != nil {
 		return fmt.Errorf("failed to get calculation: %s in namespace %s: %w", req.Name, req.Namespace, err)
 	}
+	if calc.DeletionTimestamp != nil {
+		return r.finalize(ctx, calc, logger)
+	}
 
 	if calc.Phase == v1.ProcessingPhase {
-		if util.IsFinishedCalculation(calc.Spec.Steps) {
-			phase := util.GetCalculationFinalPhase(calc.Spec.Steps)
+		phase := util.GetCalculationFinalPhase(calc.Spec.Steps)
+		// A calculation whose steps succeeded is completed once the worker recorded its output files.
+		if util.IsFinishedCalculation(calc.Spec.Steps) && (phase != v1.CompletedPhase || calc.Status.Outputs != nil) {
 			if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 				calculation := &v1.Calculation{}
 				if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: calc.Namespace, Name: calc.Name}, calculation); err != nil {
@@ -135,6 +133,7 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 
 				calculation.Phase = phase
 				calculation.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+				util.SetCalculationConditions(calculation)
 
 				r.logger.WithField("calculation", calculation.Name).WithField("phase", phase).Info("Updating calculation phase...")
 				if err := r.client.Update(ctx, calculation); err != nil {
@@ -155,23 +154,13 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 					return fmt.Errorf("failed to get the calculation bulk factory: %w", err)
 				}
 
-				condition := metav1.ConditionFalse
-				reason := "Failed"
-				conditionType := "Unavailable"
+				condition := metav1.Condition{Type: v1.ReadyCondition, Status: metav1.ConditionFalse, Reason: "Failed", Message: "The factory calculation failed"}
 				if calc.Phase == v1.CompletedPhase {
-					condition = metav1.ConditionTrue
-					reason = "Completed"
-					conditionType = "Available"
+					condition = metav1.Condition{Type: v1.ReadyCondition, Status: metav1.ConditionTrue, Reason: "Completed", Message: "The factory calculation generated the bulk"}
 				}
 
-				now := metav1.Time{Time: time.Now()}
-				factory.Status.CompletionTime = &now
-				factory.Status.Conditions = append(factory.Status.Conditions, metav1.Condition{
-					Type:               conditionType,
-					Status:             condition,
-					Reason:             reason,
-					LastTransitionTime: now,
-				})
+				factory.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+				meta.SetStatusCondition(&factory.Status.Conditions, condition)
 
 				r.logger.WithField("bulk-factory", factory.Name).Info("Updating calculation bulk factory...")
 				if err := r.client.Update(ctx, factory); err != nil {
@@ -196,7 +185,7 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 
 		// If its a post calculation then update the corresponding bulk and return.
 		if _, exist := calc.Labels[util.PostCalculationLabel]; exist {
-			if err := r.updatePostCalculationBulk(ctx, req.Namespace, bulkName, calc.Phase); err != nil {
+			if err := r.updatePostCalculationBulk(ctx, req.Namespace, bulkName, calc); err != nil {
 				return err
 			}
 			return nil
@@ -210,22 +199,88 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 			return nil
 		}
 
-		if err := r.updateCalculationBulk(ctx, req.Namespace, bulkName, calcName, calc.Phase); err != nil {
+		if err := r.updateCalculationBulk(ctx, req.Namespace, bulkName, calcName, calc); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *reconciler) updateCalculationBulk(ctx context.Context, namespace, bulkName, calcName string, phase v1.CalculationPhase) error {
+// trackedCalculation returns the reference of the bulk to its calculation object, and whether the
+// calculation is the object of the current attempt. A calculation that was created but not yet
+// recorded in the bulk is matched by the name of the current attempt, and its reference is filled
+// in. Calculations that were created before the bulks controlled them have no owner and are
+// matched by their labels only.
+func trackedCalculation(bulk *bulkv1.CalculationBulk, calc *v1.Calculation, ref *bulkv1.CalculationReference, name func(attempt int) string) (bulkv1.CalculationReference, bool) {
+	var current bulkv1.CalculationReference
+	if ref != nil {
+		current = *ref
+	}
+	if metav1.GetControllerOf(calc) == nil {
+		return current, true
+	}
+	if !metav1.IsControlledBy(calc, bulk) {
+		return current, false
+	}
+	if current.Name != "" {
+		return current, current.Name == calc.Name
+	}
+	if calc.Name != name(current.Attempt) {
+		return current, false
+	}
+	current.Name, current.UID = calc.Name, calc.UID
+	return current, true
+}
+
+// finalize finalizes the deleted calculation if its worker is gone. The workers stop the deleted
+// calculations that they run and finalize them themselves.
+func (r *reconciler) finalize(ctx context.Context, calc *v1.Calculation, logger *logrus.Entry) error {
+	if !controllerutil.ContainsFinalizer(calc, util.CleanupFinalizer) {
+		return nil
+	}
+
+	pool := &workersv1.WorkerPool{}
+	if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: calc.Namespace, Name: calc.WorkerPool}, pool); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get the worker pool %s: %w", calc.WorkerPool, err)
+	}
+	for _, worker := range pool.Spec.Workers {
+		if worker.Name == calc.Assign && worker.State != workersv1.WorkerUnknownState {
+			logger.WithField("worker", worker.Name).Info("The worker of the deleted calculation finalizes it")
+			return nil
+		}
+	}
+
+	logger.Info("Finalizing the deleted calculation of a worker that is gone")
+	return util.FinalizeCalculation(ctx, r.client, r.artifacts, calc)
+}
+
+func (r *reconciler) updateCalculationBulk(ctx context.Context, namespace, bulkName, calcName string, calc *v1.Calculation) error {
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		bulk := &bulkv1.CalculationBulk{}
 		if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: namespace, Name: bulkName}, bulk); err != nil {
 			return fmt.Errorf("failed to get the calculation bulk: %w", err)
 		}
 
+		var tracked *bulkv1.CalculationReference
+		if ref, ok := bulk.Status.Calculations[calcName]; ok {
+			tracked = &ref
+		}
+		ref, current := trackedCalculation(bulk, calc, tracked, func(attempt int) string {
+			return util.GetCalculationName(bulk.UID, calcName, attempt)
+		})
+		if !current {
+			r.logger.WithField("bulk", bulkName).WithField("calculation", calc.Name).Info("The calculation isn't the current attempt of the bulk's calculation, ignoring...")
+			return nil
+		}
+		if ref.Name != "" {
+			if bulk.Status.Calculations == nil {
+				bulk.Status.Calculations = make(map[string]bulkv1.CalculationReference)
+			}
+			bulk.Status.Calculations[calcName] = ref
+		}
+
 		bulkCalc := bulk.Calculations[calcName]
-		bulkCalc.Phase = phase
+		bulkCalc.Phase = calc.Phase
 
 		bulk.Calculations[calcName] = bulkCalc
 
@@ -240,14 +295,29 @@ func (r *reconciler) updateCalculationBulk(ctx context.Context, namespace, bulkN
 	return nil
 }
 
-func (r *reconciler) updatePostCalculationBulk(ctx context.Context, namespace, bulkName string, phase v1.CalculationPhase) error {
+func (r *reconciler) updatePostCalculationBulk(ctx context.Context, namespace, bulkName string, calc *v1.Calculation) error {
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		bulk := &bulkv1.CalculationBulk{}
 		if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: namespace, Name: bulkName}, bulk); err != nil {
 			return fmt.Errorf("failed to get the calculation bulk: %w", err)
 		}
+		if bulk.PostCalculation == nil {
+			r.logger.WithField("bulk", bulkName).Info("The bulk has no post calculation, ignoring...")
+			return nil
+		}
+
+		ref, current := trackedCalculation(bulk, calc, bulk.Status.PostCalculation, func(attempt int) string {
+			return util.GetPostCalculationName(bulk.UID, attempt)
+		})
+		if !current {
+			r.logger.WithField("bulk", bulkName).WithField("calculation", calc.Name).Info("The calculation isn't the current attempt of the bulk's post calculation, ignoring...")
+			return nil
+		}
+		if ref.Name != "" {
+			bulk.Status.PostCalculation = &ref
+		}
 
-		bulk.PostCalculation.Phase = phase
+		bulk.PostCalculation.Phase = calc.Phase
 
 		r.logger.WithField("bulk", bulkName).Info("Updating post calculation in bulk...")
 		if err := r.client.Update(ctx, bulk); err != nil {
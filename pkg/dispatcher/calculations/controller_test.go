@@ -2,20 +2,28 @@ package calculations
 
 import (
 	"context"
+	"os"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/sirupsen/logrus"
 
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	factoryv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulkfactory/v1"
 	calcv1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
 func TestReconcile(t *testing.T) {
@@ -163,3 +171,248 @@ func TestReconcile(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileCompletion(t *testing.T) {
+	testCases := []struct {
+		name          string
+		steps         []calcv1.Step
+		outputs       *calcv1.OutputManifest
+		expectedPhase calcv1.CalculationPhase
+	}{
+		{
+			name:          "calculations with unfinished steps are still processing",
+			steps:         []calcv1.Step{{Status: calcv1.CompletedPhase}, {}},
+			outputs:       &calcv1.OutputManifest{Files: []calcv1.OutputFile{}},
+			expectedPhase: calcv1.ProcessingPhase,
+		},
+		{
+			name:          "calculations whose steps succeeded wait for the output manifest",
+			steps:         []calcv1.Step{{Status: calcv1.CompletedPhase}},
+			expectedPhase: calcv1.ProcessingPhase,
+		},
+		{
+			name:          "calculations whose steps succeeded are completed with the output manifest",
+			steps:         []calcv1.Step{{Status: calcv1.CompletedPhase}},
+			outputs:       &calcv1.OutputManifest{Files: []calcv1.OutputFile{{Rule: "spectra", Path: "spectrum.out", Size: 8, SHA256: "sum"}}},
+			expectedPhase: calcv1.CompletedPhase,
+		},
+		{
+			name:          "calculations with a failed step fail without the output manifest",
+			steps:         []calcv1.Step{{Status: calcv1.FailedPhase}, {Status: calcv1.CompletedPhase}},
+			expectedPhase: calcv1.FailedPhase,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calc := &calcv1.Calculation{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-calc",
+					Namespace: "vega",
+					Labels:    map[string]string{"vegaproject.io/bulk": "test-bulk", "vegaproject.io/calculationName": "test-calc"},
+				},
+				Spec:   calcv1.CalculationSpec{Steps: tc.steps},
+				Phase:  calcv1.ProcessingPhase,
+				Status: calcv1.CalculationStatus{Outputs: tc.outputs},
+			}
+			bulk := &bulkv1.CalculationBulk{
+				ObjectMeta:   metav1.ObjectMeta{Name: "test-bulk", Namespace: "vega"},
+				Calculations: map[string]bulkv1.Calculation{"test-calc": {}},
+			}
+			r := &reconciler{
+				logger: logrus.WithField("test-name", tc.name),
+				client: fakectrlruntimeclient.NewClientBuilder().WithObjects(bulk, calc).Build(),
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-calc"}}
+			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+				t.Fatal(err)
+			}
+
+			actual := &calcv1.Calculation{}
+			if err := r.client.Get(context.Background(), req.NamespacedName, actual); err != nil {
+				t.Fatal(err)
+			}
+			if actual.Phase != tc.expectedPhase {
+				t.Fatalf("expected phase %s, got %s", tc.expectedPhase, actual.Phase)
+			}
+			if completed := actual.Status.CompletionTime != nil; completed != (tc.expectedPhase != calcv1.ProcessingPhase) {
+				t.Fatalf("unexpected completion time %v for phase %s", actual.Status.CompletionTime, actual.Phase)
+			}
+			if ready := meta.IsStatusConditionTrue(actual.Status.Conditions, calcv1.ReadyCondition); ready != (tc.expectedPhase == calcv1.CompletedPhase) {
+				t.Fatalf("unexpected Ready condition for phase %s: %v", actual.Phase, actual.Status.Conditions)
+			}
+			if degraded := meta.IsStatusConditionTrue(actual.Status.Conditions, calcv1.DegradedCondition); degraded != (tc.expectedPhase == calcv1.FailedPhase) {
+				t.Fatalf("unexpected Degraded condition for phase %s: %v", actual.Phase, actual.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestReconcileFactoryConditions(t *testing.T) {
+	factory := &factoryv1.CalculationBulkFactory{ObjectMeta: metav1.ObjectMeta{Name: "test-factory", Namespace: "vega"}}
+	calc := &calcv1.Calculation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-calc",
+			Namespace: "vega",
+			Labels:    map[string]string{"vegaproject.io/factory": "test-factory"},
+		},
+		Phase: calcv1.CompletedPhase,
+	}
+	r := &reconciler{
+		logger: logrus.WithField("test-name", "factory conditions"),
+		client: fakectrlruntimeclient.NewClientBuilder().WithObjects(factory, calc).Build(),
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-calc"}}
+	for i := 0; i < 3; i++ {
+		if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	actual := &factoryv1.CalculationBulkFactory{}
+	if err := r.client.Get(context.Background(), types.NamespacedName{Namespace: "vega", Name: "test-factory"}, actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := []metav1.Condition{{Type: calcv1.ReadyCondition, Status: metav1.ConditionTrue, Reason: "Completed", Message: "The factory calculation generated the bulk"}}
+	if diff := cmp.Diff(expected, actual.Status.Conditions, cmpopts.IgnoreFields(metav1.Condition{}, "LastTransitionTime")); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestReconcileAttempts(t *testing.T) {
+	controlledBy := func(uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "vegaproject.io/v1", Kind: "CalculationBulk", Name: "test-bulk", UID: uid, Controller: ptr.To(true)}}
+	}
+	labels := map[string]string{"vegaproject.io/bulk": "test-bulk", "vegaproject.io/calculationName": "test-calc"}
+
+	testCases := []struct {
+		name         string
+		status       bulkv1.CalculationBulkStatus
+		calculation  *calcv1.Calculation
+		expectedCalc bulkv1.Calculation
+		expectedRefs map[string]bulkv1.CalculationReference
+	}{
+		{
+			name: "the calculation of the current attempt is recorded",
+			calculation: &calcv1.Calculation{
+				ObjectMeta: metav1.ObjectMeta{Name: util.GetCalculationName("bulk-uid", "test-calc", 0), Namespace: "vega", UID: "calc-uid", Labels: labels, OwnerReferences: controlledBy("bulk-uid")},
+				Phase:      calcv1.CreatedPhase,
+			},
+			expectedCalc: bulkv1.Calculation{Phase: calcv1.CreatedPhase},
+			expectedRefs: map[string]bulkv1.CalculationReference{"test-calc": {Name: util.GetCalculationName("bulk-uid", "test-calc", 0), UID: "calc-uid"}},
+		},
+		{
+			name:   "the calculation of a previous attempt is ignored",
+			status: bulkv1.CalculationBulkStatus{Calculations: map[string]bulkv1.CalculationReference{"test-calc": {Attempt: 1}}},
+			calculation: &calcv1.Calculation{
+				ObjectMeta: metav1.ObjectMeta{Name: util.GetCalculationName("bulk-uid", "test-calc", 0), Namespace: "vega", UID: "calc-uid", Labels: labels, OwnerReferences: controlledBy("bulk-uid")},
+				Phase:      calcv1.FailedPhase,
+			},
+			expectedRefs: map[string]bulkv1.CalculationReference{"test-calc": {Attempt: 1}},
+		},
+		{
+			name: "the calculation of another bulk is ignored",
+			calculation: &calcv1.Calculation{
+				ObjectMeta: metav1.ObjectMeta{Name: util.GetCalculationName("bulk-uid", "test-calc", 0), Namespace: "vega", UID: "calc-uid", Labels: labels, OwnerReferences: controlledBy("other-bulk-uid")},
+				Phase:      calcv1.CompletedPhase,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bulk := &bulkv1.CalculationBulk{
+				ObjectMeta:   metav1.ObjectMeta{Name: "test-bulk", Namespace: "vega", UID: "bulk-uid"},
+				Calculations: map[string]bulkv1.Calculation{"test-calc": {}},
+				Status:       tc.status,
+			}
+			r := &reconciler{
+				logger: logrus.WithField("test-name", tc.name),
+				client: fakectrlruntimeclient.NewClientBuilder().WithObjects(bulk, tc.calculation).Build(),
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: tc.calculation.Name}}
+			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+				t.Fatal(err)
+			}
+
+			actual := &bulkv1.CalculationBulk{}
+			if err := r.client.Get(context.Background(), ctrlruntimeclient.ObjectKeyFromObject(bulk), actual); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedCalc, actual.Calculations["test-calc"]); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(tc.expectedRefs, actual.Status.Calculations); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestReconcileDeletion(t *testing.T) {
+	testCases := []struct {
+		name            string
+		workers         map[string]workersv1.Worker
+		expectedRemains bool
+	}{
+		{
+			name:            "the worker of the calculation finalizes it",
+			workers:         map[string]workersv1.Worker{"node-1": {Name: "worker-1", State: workersv1.WorkerProcessingState}},
+			expectedRemains: true,
+		},
+		{
+			name:    "the calculation of an unknown worker is finalized",
+			workers: map[string]workersv1.Worker{"node-1": {Name: "worker-1", State: workersv1.WorkerUnknownState}},
+		},
+		{
+			name: "the calculation of a removed worker is finalized",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deleted := metav1.Now()
+			calc := &calcv1.Calculation{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "test-calc",
+					Namespace:         "vega",
+					Labels:            map[string]string{util.CalcRootFolder: "bulk"},
+					DeletionTimestamp: &deleted,
+					Finalizers:        []string{util.CleanupFinalizer},
+				},
+				Assign:     "worker-1",
+				WorkerPool: "test-pool",
+			}
+			pool := &workersv1.WorkerPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pool", Namespace: "vega"},
+				Spec:       workersv1.WorkerPoolSpec{Workers: tc.workers},
+			}
+			nfsPath := t.TempDir()
+			staging := util.OutputStagingPath(nfsPath, calc)
+			if err := os.MkdirAll(staging, 0755); err != nil {
+				t.Fatal(err)
+			}
+			r := &reconciler{
+				logger:    logrus.WithField("test-name", tc.name),
+				client:    fakectrlruntimeclient.NewClientBuilder().WithObjects(calc, pool).Build(),
+				artifacts: artifacts.NewLocalStore(nfsPath),
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-calc"}}
+			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(staging); os.IsNotExist(err) == tc.expectedRemains {
+				t.Fatalf("expected the output files to remain: %t, got %v", tc.expectedRemains, err)
+			}
+			err := r.client.Get(context.Background(), req.NamespacedName, &calcv1.Calculation{})
+			if remains := !kerrors.IsNotFound(err); remains != tc.expectedRemains {
+				t.Fatalf("expected the calculation to remain: %t, got %v", tc.expectedRemains, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package calculations
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+var (
+	calculationsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("vega", "", "calculations"),
+		"Number of calculations by phase and worker pool",
+		[]string{"phase", "pool"}, nil,
+	)
+	bulkCalculationsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("vega", "", "bulk_calculations"),
+		"Number of calculations of each calculation bulk by phase",
+		[]string{"bulk", "phase"}, nil,
+	)
+)
+
+// calculationsCollector counts the calculations in the cache on every scrape, so the series of the
+// deleted bulks disappear with them and the cardinality is bounded by the existing bulks. Only the
+// leader exports them, so they aren't counted once per replica.
+type calculationsCollector struct {
+	logger    *logrus.Entry
+	client    ctrlruntimeclient.Reader
+	namespace string
+	elected   <-chan struct{}
+}
+
+func newCalculationsCollector(client ctrlruntimeclient.Reader, namespace string, elected <-chan struct{}) *calculationsCollector {
+	return &calculationsCollector{
+		logger:    logrus.WithField("collector", "calculations"),
+		client:    client,
+		namespace: namespace,
+		elected:   elected,
+	}
+}
+
+func (c *calculationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- calculationsDesc
+	ch <- bulkCalculationsDesc
+}
+
+func (c *calculationsCollector) Collect(ch chan<- prometheus.Metric) {
+	select {
+	case <-c.elected:
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	calcs := &v1.CalculationList{}
+	if err := c.client.List(ctx, calcs, ctrlruntimeclient.InNamespace(c.namespace)); err != nil {
+		c.logger.WithError(err).Error("couldn't list the calculations")
+		ch <- prometheus.NewInvalidMetric(calculationsDesc, err)
+		return
+	}
+
+	type poolKey struct{ phase, pool string }
+	type bulkKey struct{ bulk, phase string }
+	byPool := make(map[poolKey]int)
+	byBulk := make(map[bulkKey]int)
+	for _, calc := range calcs.Items {
+		phase := string(calc.Phase)
+		byPool[poolKey{phase: phase, pool: calc.WorkerPool}]++
+		if bulk, ok := calc.Labels[util.BulkLabel]; ok {
+			byBulk[bulkKey{bulk: bulk, phase: phase}]++
+		}
+	}
+
+	for key, count := range byPool {
+		ch <- prometheus.MustNewConstMetric(calculationsDesc, prometheus.GaugeValue, float64(count), key.phase, key.pool)
+	}
+	for key, count := range byBulk {
+		ch <- prometheus.MustNewConstMetric(bulkCalculationsDesc, prometheus.GaugeValue, float64(count), key.bulk, key.phase)
+	}
+}
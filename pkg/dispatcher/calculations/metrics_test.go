@@ -0,0 +1,62 @@
+package calculations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	calcv1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+func TestCalculationsCollector(t *testing.T) {
+	newCalculation := func(name, namespace, bulk, pool string, phase calcv1.CalculationPhase) ctrlruntimeclient.Object {
+		calc := &calcv1.Calculation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			WorkerPool: pool,
+			Phase:      phase,
+		}
+		if bulk != "" {
+			calc.Labels = map[string]string{util.BulkLabel: bulk}
+		}
+		return calc
+	}
+
+	client := fakectrlruntimeclient.NewClientBuilder().WithObjects(
+		newCalculation("calc-1", "vega", "bulk-a", "pool-a", calcv1.CompletedPhase),
+		newCalculation("calc-2", "vega", "bulk-a", "pool-a", calcv1.CompletedPhase),
+		newCalculation("calc-3", "vega", "bulk-a", "pool-b", calcv1.ProcessingPhase),
+		newCalculation("calc-4", "vega", "bulk-b", "pool-a", calcv1.FailedPhase),
+		newCalculation("calc-5", "vega", "", "pool-a", calcv1.CreatedPhase),
+		newCalculation("calc-6", "other", "bulk-c", "pool-a", calcv1.CreatedPhase),
+	).Build()
+
+	expected := `
+# HELP vega_bulk_calculations Number of calculations of each calculation bulk by phase
+# TYPE vega_bulk_calculations gauge
+vega_bulk_calculations{bulk="bulk-a",phase="Completed"} 2
+vega_bulk_calculations{bulk="bulk-a",phase="Processing"} 1
+vega_bulk_calculations{bulk="bulk-b",phase="Failed"} 1
+# HELP vega_calculations Number of calculations by phase and worker pool
+# TYPE vega_calculations gauge
+vega_calculations{phase="Completed",pool="pool-a"} 2
+vega_calculations{phase="Created",pool="pool-a"} 1
+vega_calculations{phase="Failed",pool="pool-a"} 1
+vega_calculations{phase="Processing",pool="pool-b"} 1
+`
+	elected := make(chan struct{})
+	collector := newCalculationsCollector(client, "vega", elected)
+	if count := testutil.CollectAndCount(collector); count != 0 {
+		t.Fatalf("expected no metrics before the replica is elected, got %d", count)
+	}
+
+	close(elected)
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/dispatcher/bulks"
+	"github.com/vega-project/ccb-operator/pkg/dispatcher/calculations"
+	"github.com/vega-project/ccb-operator/pkg/dispatcher/factory"
+	"github.com/vega-project/ccb-operator/pkg/dispatcher/workers"
+	"github.com/vega-project/ccb-operator/pkg/grpc"
+)
+
+// AddToManager adds the controllers of the dispatcher to the manager. The controllers keep their
+// whole state in the cluster, so a replica that becomes the leader takes over where the previous
+// one stopped.
+func AddToManager(ctx context.Context, mgr manager.Manager, ns string, gRPCClient grpc.Client, artifactStore artifacts.ArtifactStore, registerer prometheus.Registerer) error {
+	if err := calculations.AddToManager(ctx, mgr, ns, artifactStore, registerer); err != nil {
+		return fmt.Errorf("failed to add the calculations controller: %w", err)
+	}
+	if err := workers.AddToManager(mgr, ns, artifactStore); err != nil {
+		return fmt.Errorf("failed to add the workers controller: %w", err)
+	}
+	if err := bulks.AddToManager(ctx, mgr, ns, gRPCClient, artifactStore); err != nil {
+		return fmt.Errorf("failed to add the bulks controller: %w", err)
+	}
+	if err := factory.AddToManager(ctx, mgr, ns, artifactStore); err != nil {
+		return fmt.Errorf("failed to add the factory controller: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/grpc"
+	"github.com/vega-project/ccb-operator/pkg/util"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+const namespace = "vega"
+
+// noResultsClient is a results-handler without cached results.
+type noResultsClient struct {
+	grpc.Client
+}
+
+func (noResultsClient) GetDataBatch(ctx context.Context, queries []*proto.DataQuery, withResults bool) ([]*proto.GetDataResponse, error) {
+	return make([]*proto.GetDataResponse, len(queries)), nil
+}
+
+// startReplica starts a replica of the dispatcher that competes for the lease with short timings.
+// The returned function kills it without releasing the lease, like a crashed leader.
+func startReplica(t *testing.T, cfg *rest.Config, name string) (controllerruntime.Manager, func()) {
+	mgr, err := controllerruntime.NewManager(cfg, controllerruntime.Options{
+		Cache:                   cache.Options{DefaultNamespaces: map[string]cache.Config{namespace: {}}},
+		Metrics:                 metricsserver.Options{BindAddress: "0"},
+		Controller:              config.Controller{SkipNameValidation: ptr.To(true)},
+		LeaderElection:          true,
+		LeaderElectionID:        "vega-dispatcher",
+		LeaderElectionNamespace: namespace,
+		LeaseDuration:           ptr.To(2 * time.Second),
+		RenewDeadline:           ptr.To(time.Second),
+		RetryPeriod:             ptr.To(250 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := AddToManager(ctx, mgr, namespace, noResultsClient{}, artifacts.NewLocalStore(t.TempDir()), prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := mgr.Start(ctx); err != nil {
+			logrus.WithError(err).WithField("replica", name).Error("Manager ended with error")
+		}
+	}()
+	kill := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(kill)
+	return mgr, kill
+}
+
+// runWorkers completes the calculations assigned to the workers, like the workers record the
+// results of the steps and the output files.
+func runWorkers(ctx context.Context, client ctrlruntimeclient.Client) {
+	_ = wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		calcs := &v1.CalculationList{}
+		if err := client.List(ctx, calcs, ctrlruntimeclient.InNamespace(namespace)); err != nil {
+			return false, nil
+		}
+		for _, calc := range calcs.Items {
+			if calc.Phase != v1.CreatedPhase {
+				continue
+			}
+			_ = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				current := &v1.Calculation{}
+				if err := client.Get(ctx, ctrlruntimeclient.ObjectKeyFromObject(&calc), current); err != nil {
+					return err
+				}
+				current.Phase = v1.ProcessingPhase
+				for i := range current.Spec.Steps {
+					current.Spec.Steps[i].Status = v1.CompletedPhase
+				}
+				current.Status.Outputs = &v1.OutputManifest{Files: []v1.OutputFile{}}
+				return client.Update(ctx, current)
+			})
+		}
+		return false, nil
+	})
+}
+
+func TestFailover(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, the test needs the envtest binaries")
+	}
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "apis", "calculations", "v1"),
+			filepath.Join("..", "apis", "calculationbulk", "v1"),
+			filepath.Join("..", "apis", "calculationbulkfactory", "v1"),
+			filepath.Join("..", "apis", "workers", "v1"),
+		},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	client, err := ctrlruntimeclient.New(cfg, ctrlruntimeclient.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calculations := make(map[string]bulkv1.Calculation)
+	for i := 0; i < 8; i++ {
+		calculations[fmt.Sprintf("calc%d", i)] = bulkv1.Calculation{
+			Params: v1.Params{LogG: 4.0, Teff: float64(10000 + 1000*i)},
+			Steps:  []v1.Step{{Command: "true", Args: []string{}}},
+		}
+	}
+	for _, obj := range []ctrlruntimeclient.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}},
+		&workersv1.WorkerPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: namespace},
+			Spec: workersv1.WorkerPoolSpec{Workers: map[string]workersv1.Worker{
+				"node-1": {Name: "worker-1", Node: "node-1", State: workersv1.WorkerAvailableState},
+				"node-2": {Name: "worker-2", Node: "node-2", State: workersv1.WorkerAvailableState},
+			}},
+		},
+	} {
+		if err := client.Create(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	leader, killLeader := startReplica(t, cfg, "leader")
+	select {
+	case <-leader.Elected():
+	case <-ctx.Done():
+		t.Fatal("the first replica wasn't elected")
+	}
+	standby, _ := startReplica(t, cfg, "standby")
+
+	bulk := &bulkv1.CalculationBulk{
+		ObjectMeta:   metav1.ObjectMeta{Name: "bulk", Namespace: namespace},
+		WorkerPool:   "pool",
+		Calculations: calculations,
+	}
+	if err := client.Create(ctx, bulk); err != nil {
+		t.Fatal(err)
+	}
+
+	// The leader is killed as soon as it dispatched the first calculations, before any of them finished.
+	if err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		calcs := &v1.CalculationList{}
+		if err := client.List(ctx, calcs, ctrlruntimeclient.InNamespace(namespace)); err != nil {
+			return false, err
+		}
+		return len(calcs.Items) > 0, nil
+	}); err != nil {
+		t.Fatalf("the leader didn't dispatch any calculation: %v", err)
+	}
+	killLeader()
+
+	select {
+	case <-standby.Elected():
+	case <-ctx.Done():
+		t.Fatal("the standby replica didn't take over")
+	}
+	go runWorkers(ctx, client)
+
+	if err := wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		if err := client.Get(ctx, ctrlruntimeclient.ObjectKeyFromObject(bulk), bulk); err != nil {
+			return false, err
+		}
+		return meta.IsStatusConditionTrue(bulk.Status.Conditions, v1.ReadyCondition), nil
+	}); err != nil {
+		t.Fatalf("the bulk didn't complete after the failover: %v", err)
+	}
+
+	calcs := &v1.CalculationList{}
+	if err := client.List(ctx, calcs, ctrlruntimeclient.InNamespace(namespace)); err != nil {
+		t.Fatal(err)
+	}
+	dispatched := make(map[string]int)
+	for _, calc := range calcs.Items {
+		dispatched[calc.Labels[util.CalculationNameLabel]]++
+	}
+	for name := range calculations {
+		if dispatched[name] != 1 {
+			t.Errorf("expected calculation %s to be dispatched once, got %d", name, dispatched[name])
+		}
+		if phase := bulk.Calculations[name].Phase; phase != v1.CompletedPhase {
+			t.Errorf("expected calculation %s to be completed, got %q", name, phase)
+		}
+	}
+	if len(calcs.Items) != len(calculations) {
+		t.Errorf("expected %d calculations, got %d", len(calculations), len(calcs.Items))
+	}
+}
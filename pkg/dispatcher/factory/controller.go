@@ -3,15 +3,17 @@ package factory
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/sirupsen/logrus"
 
+	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 
@@ -25,21 +27,27 @@ import (
 	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulkfactory/v1"
 	calcv1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
 	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
 const (
 	controllerName = "factory"
+	factoryKind    = "CalculationBulkFactory"
+
+	// BulkGeneratedReason is the reason of the events of the factories that created their bulk.
+	BulkGeneratedReason = "BulkGenerated"
 )
 
-func AddToManager(ctx context.Context, mgr manager.Manager, ns string, calculationCh chan calcv1.Calculation, nfsPath string) error {
+func AddToManager(ctx context.Context, mgr manager.Manager, ns string, artifactStore artifacts.ArtifactStore) error {
 	c, err := controller.New(controllerName, mgr, controller.Options{
 		MaxConcurrentReconciles: 1,
 		Reconciler: &reconciler{
-			logger:        logrus.WithField("controller", controllerName),
-			client:        mgr.GetClient(),
-			calculationCh: calculationCh,
-			nfsPath:       nfsPath,
+			logger:    logrus.WithField("controller", controllerName),
+			client:    mgr.GetClient(),
+			artifacts: artifactStore,
+			recorder:  mgr.GetEventRecorderFor(util.DispatcherEventSource),
 		},
 	})
 	if err != nil {
@@ -78,10 +86,10 @@ func (h *calculationBulkFactoryHandler) Generic(ctx context.Context, e event.Typ
 }
 
 type reconciler struct {
-	logger        *logrus.Entry
-	client        ctrlruntimeclient.Client
-	calculationCh chan calcv1.Calculation
-	nfsPath       string
+	logger    *logrus.Entry
+	client    ctrlruntimeclient.Client
+	artifacts artifacts.ArtifactStore
+	recorder  record.EventRecorder
 }
 
 func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -100,16 +108,19 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 
 	factory := &v1.CalculationBulkFactory{}
 	err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: req.Namespace, Name: req.Name}, factory)
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get calculationbulkfactory %s in namespace %s: %w", req.Name, req.Namespace, err)
 	}
-	if kerrors.IsNotFound(err) {
+
+	if factory.Status.BulkCreated {
 		return nil
 	}
 
-	if factory.Status.CompletionTime != nil && !factory.Status.BulkCreated {
-		bulkFile := filepath.Join(r.nfsPath, factory.RootFolder, factory.BulkOutput)
-		b, err := os.ReadFile(bulkFile)
+	if factory.Status.CompletionTime != nil {
+		b, err := r.readBulkOutput(ctx, factory)
 		if err != nil {
 			return err
 		}
@@ -119,9 +130,20 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 			return nil
 		}
 
+		// The factory controls the bulk it generated, so deleting the factory deletes the bulk with it.
+		if bulk.Namespace == "" {
+			bulk.Namespace = factory.Namespace
+		}
+		bulk.OwnerReferences = append(bulk.OwnerReferences, *metav1.NewControllerRef(factory, v1.SchemeGroupVersion.WithKind(factoryKind)))
+
+		// The bulk may have been created by a previous leader that stopped before recording it.
 		r.logger.WithField("bulk", bulk.Name).Info("Creating calculation bulk")
+		created := true
 		if err := r.client.Create(ctx, &bulk); err != nil {
-			return err
+			if !kerrors.IsAlreadyExists(err) {
+				return err
+			}
+			created = false
 		}
 
 		if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
@@ -139,17 +161,47 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 		}); err != nil {
 			return err
 		}
+		if created {
+			r.recorder.Eventf(factory, corev1.EventTypeNormal, BulkGeneratedReason, "Created the calculation bulk %s with %d calculations", bulk.Name, len(bulk.Calculations))
+		}
 
 		return nil
 	}
 
-	calc := calcv1.Calculation{
+	return r.createFactoryCalculation(ctx, factory)
+}
+
+// createFactoryCalculation assigns the calculation that generates the bulk of the factory to an
+// available worker of its pool. The calculation is named after the factory, so it's created once
+// even if the factory is reconciled again, e.g. by a new leader.
+func (r *reconciler) createFactoryCalculation(ctx context.Context, factory *v1.CalculationBulkFactory) error {
+	name := fmt.Sprintf("calc-factory-%s", factory.Name)
+	if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: factory.Namespace, Name: name}, &calcv1.Calculation{}); err == nil {
+		return nil
+	} else if !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get the calculation %s: %w", name, err)
+	}
+
+	workerpool := &workersv1.WorkerPool{}
+	if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: factory.Namespace, Name: factory.WorkerPool}, workerpool); err != nil {
+		return fmt.Errorf("failed to get workerpool: %s in namespace %s: %w", factory.WorkerPool, factory.Namespace, err)
+	}
+	// The factory is reconciled again with a backoff until a worker is available.
+	worker := util.GetFirstAvailableWorker(workerpool.Spec.Workers)
+	if worker == nil {
+		return fmt.Errorf("no available worker in the workerpool %s", factory.WorkerPool)
+	}
+
+	calc := &calcv1.Calculation{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: req.Namespace,
-			Name:      fmt.Sprintf("calc-factory-%s", req.Name),
+			Namespace:       factory.Namespace,
+			Name:            name,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(factory, v1.SchemeGroupVersion.WithKind(factoryKind))},
+			Finalizers:      []string{util.CleanupFinalizer},
 			Labels: map[string]string{
-				util.FactoryLabel:   req.Name,
-				util.CalcRootFolder: factory.RootFolder,
+				util.FactoryLabel:      factory.Name,
+				util.CalcRootFolder:    factory.RootFolder,
+				util.AssignWorkerLabel: worker.Name,
 			},
 		},
 		Phase:      calcv1.CreatedPhase,
@@ -162,10 +214,25 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 				},
 			},
 		},
+		Assign:     worker.Name,
 		WorkerPool: factory.WorkerPool,
+		Status:     calcv1.CalculationStatus{StartTime: metav1.Time{Time: time.Now()}},
 	}
+	util.SetCalculationConditions(calc)
 
-	r.calculationCh <- calc
-
+	r.logger.WithField("calc-name", calc.Name).WithField("worker", calc.Assign).Info("Creating factory calculation.")
+	if err := r.client.Create(ctx, calc); err != nil && !kerrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create the calculation %s: %w", calc.Name, err)
+	}
 	return nil
 }
+
+// readBulkOutput reads the bulk that the factory calculation generated in the root folder.
+func (r *reconciler) readBulkOutput(ctx context.Context, factory *v1.CalculationBulkFactory) ([]byte, error) {
+	f, err := r.artifacts.Open(ctx, artifacts.Key(factory.RootFolder, factory.BulkOutput))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the generated bulk: %w", err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
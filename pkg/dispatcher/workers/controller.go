@@ -7,6 +7,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
@@ -19,21 +20,27 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 
-	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
 const (
 	controllerName = "worker_pods"
+
+	// WorkerLostReason is the reason of the events of the workers whose pods were deleted.
+	WorkerLostReason = "WorkerLost"
 )
 
-func AddToManager(mgr manager.Manager, ns string) error {
+func AddToManager(mgr manager.Manager, ns string, artifactStore artifacts.ArtifactStore) error {
 	c, err := controller.New(controllerName, mgr, controller.Options{
 		MaxConcurrentReconciles: 1,
 		Reconciler: &reconciler{
-			logger: logrus.WithField("controller", controllerName),
-			client: mgr.GetClient(),
+			logger:    logrus.WithField("controller", controllerName),
+			client:    mgr.GetClient(),
+			recorder:  mgr.GetEventRecorderFor(util.DispatcherEventSource),
+			artifacts: artifactStore,
 		},
 	})
 	if err != nil {
@@ -96,8 +103,10 @@ func (h *podHandler) Generic(ctx context.Context, e event.TypedGenericEvent[*cor
 }
 
 type reconciler struct {
-	logger *logrus.Entry
-	client ctrlruntimeclient.Client
+	logger    *logrus.Entry
+	client    ctrlruntimeclient.Client
+	recorder  record.EventRecorder
+	artifacts artifacts.ArtifactStore
 }
 
 func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -155,6 +164,7 @@ func (r *reconciler) reconcileWorkerInPools(ctx context.Context, podName string)
 					workerToUpdate.State = workersv1.WorkerUnknownState
 
 					workerPool.Spec.Workers[name] = workerToUpdate
+					util.SetWorkerPoolConditions(workerPool)
 
 					r.logger.WithField("worker-name", worker.Name).WithField("worker", name).Info("Updating worker pool")
 					if err := r.client.Update(ctx, workerPool); err != nil {
@@ -164,6 +174,7 @@ func (r *reconciler) reconcileWorkerInPools(ctx context.Context, podName string)
 				}); err != nil {
 					return err
 				}
+				r.recorder.Eventf(&pool, corev1.EventTypeWarning, WorkerLostReason, "Worker %s on node %s is gone, its calculations are rescheduled", worker.Name, name)
 			}
 		}
 	}
@@ -173,59 +184,33 @@ func (r *reconciler) reconcileWorkerInPools(ctx context.Context, podName string)
 
 func (r *reconciler) deleteAssignedCalculations(ctx context.Context, assigned string) error {
 	calcList := &v1.CalculationList{}
-	if err := r.client.List(ctx, calcList, ctrlruntimeclient.MatchingLabels{"vegaproject.io/assign": assigned}); err != nil {
+	if err := r.client.List(ctx, calcList, ctrlruntimeclient.MatchingLabels{util.AssignWorkerLabel: assigned}); err != nil {
 		return fmt.Errorf("couldn't get a list of calculations: %v", err)
 	}
 
-	getAssignedCalculations := func(calculations []v1.Calculation) []v1.Calculation {
-		var ret []v1.Calculation
-		for _, c := range calculations {
-			if c.Phase == v1.CreatedPhase || c.Phase == v1.ProcessingPhase {
-				ret = append(ret, c)
+	var assignedCalculations []v1.Calculation
+	for _, calc := range calcList.Items {
+		// The calculations that were deleted while the worker was gone are finalized in its place.
+		if calc.DeletionTimestamp != nil {
+			if err := util.FinalizeCalculation(ctx, r.client, r.artifacts, &calc); err != nil {
+				return err
 			}
+			continue
+		}
+		if calc.Phase == v1.CreatedPhase || calc.Phase == v1.ProcessingPhase {
+			assignedCalculations = append(assignedCalculations, calc)
 		}
-		return ret
 	}
-	assignedCalculations := getAssignedCalculations(calcList.Items)
 	if len(assignedCalculations) == 0 {
 		r.logger.WithField("pod-name", assigned).Info("there were no calculations assigned to pod to delete...")
 		return nil
 	}
 
 	for _, calc := range assignedCalculations {
-		if err := r.client.Delete(ctx, &calc); err != nil {
-			return fmt.Errorf("couldn't delete the calculation: %v", err)
-		}
-
-		bulkName, exist := calc.Labels["vegaproject.io/bulk"]
-		if !exist {
-			continue
-		}
-
-		calcBulkName, exist := calc.Labels["vegaproject.io/calculationName"]
-		if !exist {
-			continue
-		}
-
-		if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-			bulk := &bulkv1.CalculationBulk{}
-			if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: calc.Namespace, Name: bulkName}, bulk); err != nil {
-				return fmt.Errorf("failed to get the calculation: %w", err)
-			}
-
-			calculation := bulk.Calculations[calcBulkName]
-			calculation.Phase = ""
-			bulk.Calculations[calcBulkName] = calculation
-
-			r.logger.WithField("bulk_calc_name", calcBulkName).WithField("bulk_name", bulkName).Info("Updating calculation bulk")
-			if err := r.client.Update(ctx, bulk); err != nil {
-				return fmt.Errorf("failed to update calculation bulk %s: %w", bulk.Name, err)
-			}
-			return nil
-		}); err != nil {
+		r.logger.WithField("calculation", calc.Name).WithField("pod-name", assigned).Info("Rescheduling calculation")
+		if err := util.RescheduleCalculation(ctx, r.client, r.artifacts, &calc); err != nil {
 			return err
 		}
-
 	}
 
 	return nil
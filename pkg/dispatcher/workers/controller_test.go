@@ -12,6 +12,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -30,6 +31,7 @@ func TestReconcile(t *testing.T) {
 		expectedCalculations     []v1.Calculation
 		expectedWorkerPools      []workersv1.WorkerPool
 		expectedCalculationBulks []bulkv1.CalculationBulk
+		expectedEvents           []string
 	}{
 		{
 			name: "basic case, nothing to delete",
@@ -139,6 +141,13 @@ func TestReconcile(t *testing.T) {
 							"node-2": {Name: "worker-2", State: workersv1.WorkerProcessingState},
 						},
 					},
+					Status: workersv1.WorkerPoolStatus{
+						Conditions: []metav1.Condition{
+							{Type: "Ready", Status: metav1.ConditionTrue, Reason: "CalculationsRunning", Message: "1 of 2 workers processing calculations"},
+							{Type: "Progressing", Status: metav1.ConditionTrue, Reason: "CalculationsRunning", Message: "1 of 2 workers processing calculations"},
+							{Type: "Degraded", Status: metav1.ConditionTrue, Reason: "WorkersLost", Message: "1 of 2 workers were lost"},
+						},
+					},
 				},
 			},
 			expectedCalculationBulks: []bulkv1.CalculationBulk{
@@ -147,20 +156,32 @@ func TestReconcile(t *testing.T) {
 					Calculations: map[string]bulkv1.Calculation{"calc-test": {}},
 				},
 			},
+			expectedEvents: []string{"Warning WorkerLost Worker worker-1 on node node-1 is gone, its calculations are rescheduled"},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
 			r := &reconciler{
-				logger: logrus.WithField("test-name", tc.name),
-				client: fakectrlruntimeclient.NewClientBuilder().WithObjects(tc.clusterObjects...).Build(),
+				logger:   logrus.WithField("test-name", tc.name),
+				client:   fakectrlruntimeclient.NewClientBuilder().WithObjects(tc.clusterObjects...).Build(),
+				recorder: recorder,
 			}
 			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "worker-1"}}
 			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
 				t.Fatal(err)
 			}
 
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if diff := cmp.Diff(tc.expectedEvents, events); diff != "" {
+				t.Fatal(diff)
+			}
+
 			var actualWorkerPools workersv1.WorkerPoolList
 			if err := r.client.List(context.Background(), &actualWorkerPools); err != nil {
 				t.Fatal(err)
@@ -168,6 +189,7 @@ func TestReconcile(t *testing.T) {
 
 			if diff := cmp.Diff(actualWorkerPools.Items, tc.expectedWorkerPools,
 				cmpopts.IgnoreFields(metav1.TypeMeta{}, "Kind", "APIVersion"),
+				cmpopts.IgnoreFields(metav1.Condition{}, "LastTransitionTime"),
 				cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion")); diff != "" {
 				t.Fatal(diff)
 			}
@@ -270,3 +292,52 @@ func TestDeleteAssignedCalculations(t *testing.T) {
 		}
 	}
 }
+
+func TestDeleteAssignedCalculationsReschedules(t *testing.T) {
+	bulk := &bulkv1.CalculationBulk{
+		ObjectMeta:      metav1.ObjectMeta{Name: "test-bulk", Namespace: "vega"},
+		Calculations:    map[string]bulkv1.Calculation{"test-calc": {Phase: v1.ProcessingPhase}},
+		PostCalculation: &bulkv1.Calculation{Phase: v1.CreatedPhase},
+		Status: bulkv1.CalculationBulkStatus{
+			Calculations:    map[string]bulkv1.CalculationReference{"test-calc": {Name: "calc-a", UID: "calc-a-uid", Attempt: 1}},
+			PostCalculation: &bulkv1.CalculationReference{Name: "calc-post", UID: "calc-post-uid"},
+		},
+	}
+	calculations := []ctrlruntimeclient.Object{
+		&v1.Calculation{
+			ObjectMeta: metav1.ObjectMeta{Name: "calc-a", Namespace: "vega", Labels: map[string]string{
+				"vegaproject.io/assign": "test-pod", "vegaproject.io/bulk": "test-bulk", "vegaproject.io/calculationName": "test-calc",
+			}},
+			Phase: v1.ProcessingPhase,
+		},
+		&v1.Calculation{
+			ObjectMeta: metav1.ObjectMeta{Name: "calc-post", Namespace: "vega", Labels: map[string]string{
+				"vegaproject.io/assign": "test-pod", "vegaproject.io/bulk": "test-bulk", "vegaproject.io/postCalculation": "",
+			}},
+			Phase: v1.CreatedPhase,
+		},
+	}
+	r := &reconciler{
+		logger: logrus.WithField("test-name", t.Name()),
+		client: fakectrlruntimeclient.NewClientBuilder().WithObjects(append(calculations, bulk)...).Build(),
+	}
+
+	if err := r.deleteAssignedCalculations(context.Background(), "test-pod"); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := &bulkv1.CalculationBulk{}
+	if err := r.client.Get(context.Background(), ctrlruntimeclient.ObjectKeyFromObject(bulk), actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := bulkv1.CalculationBulkStatus{
+		Calculations:    map[string]bulkv1.CalculationReference{"test-calc": {Attempt: 2}},
+		PostCalculation: &bulkv1.CalculationReference{Attempt: 1},
+	}
+	if diff := cmp.Diff(expected, actual.Status); diff != "" {
+		t.Fatal(diff)
+	}
+	if actual.Calculations["test-calc"].Phase != "" || actual.PostCalculation.Phase != "" {
+		t.Fatalf("expected the calculations to be rescheduled, got %v and %v", actual.Calculations, actual.PostCalculation)
+	}
+}
@@ -0,0 +1,328 @@
+package grpc
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+// permission is what an authenticated user is allowed to do.
+type permission int
+
+const (
+	noPermission permission = iota
+	readPermission
+	writePermission
+)
+
+// publicMethods can be called without a token, so the health can be probed by the kubelet.
+var publicMethods = sets.New(
+	healthpb.Health_Check_FullMethodName,
+	healthpb.Health_Watch_FullMethodName,
+)
+
+// methodPermissions are the permissions required by each RPC. Methods that are not listed are denied.
+var methodPermissions = map[string]permission{
+	reflectionv1.ServerReflection_ServerReflectionInfo_FullMethodName:      readPermission,
+	reflectionv1alpha.ServerReflection_ServerReflectionInfo_FullMethodName: readPermission,
+
+	proto.DbService_GetData_FullMethodName:         readPermission,
+	proto.DbService_GetDataStream_FullMethodName:   readPermission,
+	proto.DbService_LookupByHash_FullMethodName:    readPermission,
+	proto.DbService_GetDataBatch_FullMethodName:    readPermission,
+	proto.DbService_StoreData_FullMethodName:       writePermission,
+	proto.DbService_StoreDataStream_FullMethodName: writePermission,
+	proto.DbService_StoreDataBatch_FullMethodName:  writePermission,
+}
+
+var errUnauthenticated = errors.New("invalid token")
+
+// authenticator returns the name of the user that the given bearer token belongs to.
+type authenticator interface {
+	authenticate(ctx context.Context, token string) (string, error)
+}
+
+// staticTokenAuthenticator authenticates the tokens of a CSV file with "token,user" lines.
+// The file is reloaded when it changes.
+type staticTokenAuthenticator struct {
+	mu     sync.Mutex
+	files  reloadingFiles
+	tokens map[string]string
+}
+
+func newStaticTokenAuthenticator(file string) (*staticTokenAuthenticator, error) {
+	a := &staticTokenAuthenticator{files: reloadingFiles{files: []string{file}}}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *staticTokenAuthenticator) reload() error {
+	changed, err := a.files.changed()
+	if err != nil {
+		return fmt.Errorf("couldn't stat the token file: %w", err)
+	}
+	if !changed && a.tokens != nil {
+		return nil
+	}
+
+	tokens, err := readTokenFile(a.files.files[0])
+	if err != nil {
+		if a.tokens != nil {
+			a.files.modTimes = nil
+			logrus.WithError(err).Warn("Couldn't reload the token file, keeping the previous tokens")
+			return nil
+		}
+		return err
+	}
+	a.tokens = tokens
+	return nil
+}
+
+func (a *staticTokenAuthenticator) authenticate(_ context.Context, token string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.reload(); err != nil {
+		return "", err
+	}
+	user, ok := a.tokens[token]
+	if !ok {
+		return "", errUnauthenticated
+	}
+	return user, nil
+}
+
+func readTokenFile(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the token file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	tokens := make(map[string]string)
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return tokens, nil
+			}
+			return nil, fmt.Errorf("couldn't parse the token file: %w", err)
+		}
+		if record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("empty token or user in the token file")
+		}
+		tokens[record[0]] = record[1]
+	}
+}
+
+type cachedReview struct {
+	user    string
+	err     error
+	expires time.Time
+}
+
+// tokenReviewAuthenticator authenticates Kubernetes service account tokens with the TokenReview API.
+// The reviews are cached for a short time, so every RPC doesn't cost a request to the API server.
+type tokenReviewAuthenticator struct {
+	client    ctrlruntimeclient.Client
+	audiences []string
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedReview
+	now   func() time.Time
+}
+
+func newTokenReviewAuthenticator(client ctrlruntimeclient.Client, audiences []string) *tokenReviewAuthenticator {
+	return &tokenReviewAuthenticator{
+		client:    client,
+		audiences: audiences,
+		ttl:       time.Minute,
+		cache:     make(map[string]cachedReview),
+		now:       time.Now,
+	}
+}
+
+func (a *tokenReviewAuthenticator) authenticate(ctx context.Context, token string) (string, error) {
+	a.mu.Lock()
+	cached, ok := a.cache[token]
+	a.mu.Unlock()
+	if ok && a.now().Before(cached.expires) {
+		return cached.user, cached.err
+	}
+
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: a.audiences}}
+	if err := a.client.Create(ctx, review); err != nil {
+		// Errors of the API server are not cached, the token is reviewed again on the next call.
+		return "", fmt.Errorf("couldn't review the token: %w", err)
+	}
+
+	result := cachedReview{user: review.Status.User.Username, expires: a.now().Add(a.ttl)}
+	if !review.Status.Authenticated {
+		result.user, result.err = "", errUnauthenticated
+	}
+
+	a.mu.Lock()
+	for key, c := range a.cache {
+		if !a.now().Before(c.expires) {
+			delete(a.cache, key)
+		}
+	}
+	a.cache[token] = result
+	a.mu.Unlock()
+	return result.user, result.err
+}
+
+// unionAuthenticator tries each authenticator in order until one accepts the token.
+type unionAuthenticator []authenticator
+
+func (u unionAuthenticator) authenticate(ctx context.Context, token string) (string, error) {
+	var errs []error
+	for _, a := range u {
+		user, err := a.authenticate(ctx, token)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errUnauthenticated) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+	return "", errUnauthenticated
+}
+
+// authorizer grants the permissions of the users. Writers can also read.
+type authorizer struct {
+	writers sets.Set[string]
+	readers sets.Set[string]
+}
+
+func (a authorizer) permission(user string) permission {
+	switch {
+	case a.writers.Has(user):
+		return writePermission
+	case a.readers.Has(user):
+		return readPermission
+	default:
+		return noPermission
+	}
+}
+
+// authInterceptor authenticates the bearer token of every RPC and checks that its user has
+// the permission required by the called method.
+type authInterceptor struct {
+	authenticator authenticator
+	authorizer    authorizer
+	logger        *logrus.Entry
+}
+
+func (i *authInterceptor) authorize(ctx context.Context, method string) error {
+	if publicMethods.Has(method) {
+		return nil
+	}
+
+	required, ok := methodPermissions[method]
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "method %s is not allowed", method)
+	}
+
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	user, err := i.authenticator.authenticate(ctx, token)
+	if err != nil {
+		if errors.Is(err, errUnauthenticated) {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		i.logger.WithError(err).Error("Couldn't authenticate the token")
+		return status.Error(codes.Unavailable, "couldn't authenticate the token")
+	}
+
+	if i.authorizer.permission(user) < required {
+		i.logger.WithFields(logrus.Fields{"user": user, "method": method}).Warn("Permission denied")
+		return status.Errorf(codes.PermissionDenied, "user %s is not allowed to call %s", user, method)
+	}
+	return nil
+}
+
+func (i *authInterceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := i.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (i *authInterceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := i.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", errors.New("missing metadata")
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", errors.New("missing authorization header")
+	}
+
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || token == "" {
+		return "", errors.New("authorization header is not a bearer token")
+	}
+	return token, nil
+}
+
+// tokenFileCredentials sends the token of a file as a bearer token with every RPC.
+// The file is read on every call, so rotated tokens, like projected service account
+// tokens, are picked up.
+type tokenFileCredentials struct {
+	file string
+}
+
+func (c tokenFileCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the token file: %w", err)
+	}
+	return map[string]string{"authorization": "Bearer " + strings.TrimSpace(string(data))}, nil
+}
+
+func (c tokenFileCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,390 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/vega-project/ccb-operator/pkg/db"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, ca.path("ca.crt"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+// issue writes a certificate signed by the CA and its key to <name>.crt and <name>.key.
+func (ca *testCA) issue(t *testing.T, name string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := ca.path(name+".crt"), ca.path(name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTestServer starts a results server on a local port with the given flags.
+func startTestServer(t *testing.T, args ...string) string {
+	var o ServerOptions
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	o.Bind(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	serverOptions, err := o.GRPCServerOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(serverOptions...)
+	proto.RegisterDbServiceServer(s, NewServer(&fakeResultsStore{stored: make(map[string]*db.CalculationResults)}, o.ChunkSize(), o.MaxStreamSize()))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func newTestOptionsClient(t *testing.T, args ...string) Client {
+	var o Options
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	o.Bind(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestTLSAndTokenAuth(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", 2)
+	clientCert, clientKey := ca.issue(t, "client", 3)
+
+	tokenFile := ca.path("tokens.csv")
+	if err := os.WriteFile(tokenFile, []byte("# token,user\nwriter-token,worker\nreader-token,dispatcher\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"writer": "writer-token", "reader": "reader-token", "unknown": "unknown-token"} {
+		if err := os.WriteFile(ca.path(name), []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	address := startTestServer(t,
+		"--tls-cert-file="+serverCert,
+		"--tls-key-file="+serverKey,
+		"--tls-client-ca-file="+ca.path("ca.crt"),
+		"--auth-token-file="+tokenFile,
+		"--auth-writers=worker",
+		"--auth-readers=dispatcher",
+	)
+
+	params := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+	clientArgs := func(token string) []string {
+		return []string{
+			"--grpc-address=" + address,
+			"--grpc-tls-ca-file=" + ca.path("ca.crt"),
+			"--grpc-tls-cert-file=" + clientCert,
+			"--grpc-tls-key-file=" + clientKey,
+			"--grpc-tls-server-name=localhost",
+			"--grpc-token-file=" + ca.path(token),
+		}
+	}
+
+	t.Run("writers can store and read results", func(t *testing.T) {
+		c := newTestOptionsClient(t, clientArgs("writer")...)
+		if _, err := c.StoreData(context.Background(), params, "", "results"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetData(context.Background(), params); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("readers can't store results", func(t *testing.T) {
+		c := newTestOptionsClient(t, clientArgs("reader")...)
+		if _, err := c.GetData(context.Background(), params); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.StoreData(context.Background(), params, "", "results"); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected PermissionDenied, got %v", err)
+		}
+		if _, err := c.StoreDataStream(context.Background(), params, "", []byte("results")); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected PermissionDenied from the stream, got %v", err)
+		}
+	})
+
+	t.Run("unknown tokens are rejected", func(t *testing.T) {
+		c := newTestOptionsClient(t, clientArgs("unknown")...)
+		if _, err := c.GetData(context.Background(), params); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("expected Unauthenticated, got %v", err)
+		}
+	})
+
+	t.Run("clients without a certificate are rejected", func(t *testing.T) {
+		c := newTestOptionsClient(t,
+			"--grpc-address="+address,
+			"--grpc-tls-ca-file="+ca.path("ca.crt"),
+			"--grpc-tls-server-name=localhost",
+			"--grpc-token-file="+ca.path("writer"),
+			"--grpc-max-attempts=1",
+		)
+		if _, err := c.GetData(context.Background(), params); status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected Unavailable, got %v", err)
+		}
+	})
+
+	t.Run("servers with a certificate of another CA are rejected", func(t *testing.T) {
+		other := newTestCA(t)
+		c := newTestOptionsClient(t,
+			"--grpc-address="+address,
+			"--grpc-tls-ca-file="+other.path("ca.crt"),
+			"--grpc-tls-cert-file="+clientCert,
+			"--grpc-tls-key-file="+clientKey,
+			"--grpc-tls-server-name=localhost",
+			"--grpc-max-attempts=1",
+		)
+		if _, err := c.GetData(context.Background(), params); status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected Unavailable, got %v", err)
+		}
+	})
+}
+
+func TestFileCertificateReload(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", 2)
+
+	cert, err := newFileCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		c, err := cert.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.SerialNumber.Int64()
+	}
+
+	if s := serial(); s != 2 {
+		t.Fatalf("expected serial 2, got %d", s)
+	}
+
+	ca.issue(t, "server", 3)
+	future := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := serial(); s != 3 {
+		t.Fatalf("expected the rotated certificate with serial 3, got %d", s)
+	}
+
+	// A broken rotation keeps the previous certificate.
+	if err := os.WriteFile(certFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if s := serial(); s != 3 {
+		t.Fatalf("expected the previous certificate with serial 3, got %d", s)
+	}
+}
+
+func TestStaticTokenAuthenticatorReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(file, []byte("token1,user1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newStaticTokenAuthenticator(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := a.authenticate(context.Background(), "token1"); err != nil || user != "user1" {
+		t.Fatalf("expected user1, got %q: %v", user, err)
+	}
+
+	if err := os.WriteFile(file, []byte("token2,user2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.authenticate(context.Background(), "token1"); err != errUnauthenticated {
+		t.Fatalf("expected the removed token to be rejected, got %v", err)
+	}
+	if user, err := a.authenticate(context.Background(), "token2"); err != nil || user != "user2" {
+		t.Fatalf("expected user2, got %q: %v", user, err)
+	}
+}
+
+func TestServerOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		id      string
+		args    []string
+		wantErr bool
+	}{
+		{id: "defaults"},
+		{id: "tls", args: []string{"--tls-cert-file=cert", "--tls-key-file=key"}},
+		{id: "cert without key", args: []string{"--tls-cert-file=cert"}, wantErr: true},
+		{id: "client CA without cert", args: []string{"--tls-client-ca-file=ca"}, wantErr: true},
+		{id: "tokens without tls", args: []string{"--auth-token-file=tokens", "--auth-writers=worker"}, wantErr: true},
+		{id: "tokens without users", args: []string{"--tls-cert-file=cert", "--tls-key-file=key", "--auth-token-file=tokens"}, wantErr: true},
+		{id: "tokens", args: []string{"--tls-cert-file=cert", "--tls-key-file=key", "--auth-service-account-tokens", "--auth-readers=dispatcher"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			var o ServerOptions
+			fs := flag.NewFlagSet("server", flag.ContinueOnError)
+			o.Bind(fs)
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Validate(); (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %t, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	var reviews int
+	client := fakectrlruntimeclient.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, client ctrlruntimeclient.WithWatch, obj ctrlruntimeclient.Object, opts ...ctrlruntimeclient.CreateOption) error {
+			reviews++
+			review := obj.(*authenticationv1.TokenReview)
+			if review.Spec.Token == "sa-token" {
+				review.Status.Authenticated = true
+				review.Status.User.Username = "system:serviceaccount:vega:worker"
+			}
+			return nil
+		},
+	}).Build()
+
+	now := time.Now()
+	a := newTokenReviewAuthenticator(client, nil)
+	a.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if user, err := a.authenticate(context.Background(), "sa-token"); err != nil || user != "system:serviceaccount:vega:worker" {
+			t.Fatalf("expected the worker service account, got %q: %v", user, err)
+		}
+	}
+	if reviews != 1 {
+		t.Fatalf("expected the review to be cached, got %d reviews", reviews)
+	}
+
+	if _, err := a.authenticate(context.Background(), "other-token"); err != errUnauthenticated {
+		t.Fatalf("expected errUnauthenticated, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := a.authenticate(context.Background(), "sa-token"); err != nil {
+		t.Fatal(err)
+	}
+	if reviews != 3 {
+		t.Fatalf("expected the expired review to be repeated, got %d reviews", reviews)
+	}
+}
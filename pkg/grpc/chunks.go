@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// splitIntoChunks splits data into parts of at most size bytes.
+// An empty data results in a single empty chunk, so the receiver always gets a last chunk.
+func splitIntoChunks(data []byte, size int) [][]byte {
+	if len(data) == 0 {
+		return [][]byte{{}}
+	}
+
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := size
+		if len(data) < n {
+			n = len(data)
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// chunkAssembler collects the received chunks and verifies their checksums.
+type chunkAssembler struct {
+	buf      bytes.Buffer
+	received int
+}
+
+func (a *chunkAssembler) add(data []byte, sum string) error {
+	if got := checksum(data); got != sum {
+		return fmt.Errorf("checksum mismatch for chunk %d: expected %s, got %s", a.received, sum, got)
+	}
+	a.buf.Write(data)
+	a.received++
+	return nil
+}
+
+func (a *chunkAssembler) verify(totalSum string) error {
+	if got := checksum(a.buf.Bytes()); got != totalSum {
+		return fmt.Errorf("checksum mismatch for the assembled results: expected %s, got %s", totalSum, got)
+	}
+	return nil
+}
+
+// size returns the number of bytes that were received.
+func (a *chunkAssembler) size() int {
+	return a.buf.Len()
+}
+
+func (a *chunkAssembler) bytes() []byte {
+	return a.buf.Bytes()
+}
@@ -2,54 +2,236 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
 
 	proto "github.com/vega-project/ccb-operator/proto"
 )
 
 type Client interface {
-	StoreData(parameters map[string]string, results string) (*proto.StoreResponse, error)
-	GetData(parameters map[string]string) (*proto.GetDataResponse, error)
+	StoreData(ctx context.Context, parameters map[string]string, inputHash, results string) (*proto.StoreResponse, error)
+	GetData(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error)
+	// StoreDataStream stores the given results in chunks, for results that exceed the maximum message size.
+	StoreDataStream(ctx context.Context, parameters map[string]string, inputHash string, results []byte) (*proto.StoreResponse, error)
+	// GetDataStream retrieves the results in chunks, for results that exceed the maximum message size.
+	GetDataStream(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error)
+	// LookupByHash retrieves the results that were produced by the inputs with the given content hash.
+	LookupByHash(ctx context.Context, inputHash string) (*proto.GetDataResponse, error)
+	// GetDataBatch retrieves the results matching each of the given queries, in the same order.
+	// The results of the queries that didn't match are nil.
+	GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*proto.GetDataResponse, error)
+	// StoreDataBatch stores the results of the given requests in batches.
+	StoreDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error)
 	Close() error
 }
 
 type client struct {
-	client proto.DbServiceClient
-	conn   *grpc.ClientConn
+	client        proto.DbServiceClient
+	conn          *grpc.ClientConn
+	timeout       time.Duration
+	streamTimeout time.Duration
+	chunkSize     int
+	batchSize     int
 }
 
-// NewClient creates a new Client connected to the given address.
-func NewClient(address string) (Client, error) {
-	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewClient creates a new Client connected to the address of the given options.
+func NewClient(o Options) (Client, error) {
+	o.setDefaults()
+
+	callOptions := []grpc.CallOption{
+		grpc.MaxCallRecvMsgSize(o.maxMessageSize),
+		grpc.MaxCallSendMsgSize(o.maxMessageSize),
+	}
+	if o.compression {
+		callOptions = append(callOptions, grpc.UseCompressor(gzip.Name))
+	}
+
+	dialOptions, err := o.dialOptions()
 	if err != nil {
 		return nil, err
 	}
 
-	return &client{client: proto.NewDbServiceClient(conn), conn: conn}, nil
+	serviceConfig, err := o.serviceConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.NewClient(o.address, append(dialOptions,
+		grpc.WithDefaultCallOptions(callOptions...),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithStatsHandler(clientTracingHandler()),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client{
+		client:        proto.NewDbServiceClient(conn),
+		conn:          conn,
+		timeout:       o.timeout,
+		streamTimeout: o.streamTimeout,
+		chunkSize:     o.chunkSize,
+		batchSize:     o.batchSize,
+	}, nil
 }
 
 // StoreData stores the given data in the gRPC server.
-func (c *client) StoreData(parameters map[string]string, results string) (*proto.StoreResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (c *client) StoreData(ctx context.Context, parameters map[string]string, inputHash, results string) (*proto.StoreResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
 	return c.client.StoreData(ctx, &proto.StoreRequest{
 		Parameters: parameters,
+		InputHash:  inputHash,
 		Results:    results,
 	})
 }
 
 // GetData retrieves the data from the database filtered by the given parameters.
-func (c *client) GetData(parameters map[string]string) (*proto.GetDataResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (c *client) GetData(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
 	return c.client.GetData(ctx, &proto.GetDataRequest{Parameters: parameters})
 }
 
+// LookupByHash retrieves the data from the database produced by the inputs with the given content hash.
+func (c *client) LookupByHash(ctx context.Context, inputHash string) (*proto.GetDataResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	return c.client.LookupByHash(ctx, &proto.LookupByHashRequest{InputHash: inputHash})
+}
+
+// GetDataBatch retrieves the data of many calculations, with a call for every batch of queries.
+func (c *client) GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*proto.GetDataResponse, error) {
+	results := make([]*proto.GetDataResponse, len(queries))
+	for start := 0; start < len(queries); start += c.batchSize {
+		end := min(start+c.batchSize, len(queries))
+
+		callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		response, err := c.client.GetDataBatch(callCtx, &proto.GetDataBatchRequest{Queries: queries[start:end], MetadataOnly: metadataOnly})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		for index, result := range response.Results {
+			if int(index) >= end-start {
+				return nil, fmt.Errorf("the server returned results for unknown query %d", index)
+			}
+			results[start+int(index)] = result
+		}
+	}
+	return results, nil
+}
+
+// StoreDataBatch stores the data of many calculations, with a call for every batch of requests.
+// Each batch is stored atomically, but the batches that were stored before a failure are kept.
+func (c *client) StoreDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error) {
+	responses := make([]*proto.StoreResponse, 0, len(requests))
+	for start := 0; start < len(requests); start += c.batchSize {
+		end := min(start+c.batchSize, len(requests))
+
+		callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		response, err := c.client.StoreDataBatch(callCtx, &proto.StoreDataBatchRequest{Requests: requests[start:end]})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response.Responses...)
+	}
+	return responses, nil
+}
+
+// StoreDataStream sends the given data to the gRPC server in chunks.
+func (c *client) StoreDataStream(ctx context.Context, parameters map[string]string, inputHash string, results []byte) (*proto.StoreResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.streamTimeout)
+	defer cancel()
+
+	stream, err := c.client.StoreDataStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := splitIntoChunks(results, c.chunkSize)
+	for i, data := range chunks {
+		chunk := &proto.StoreDataChunk{Data: data, Sha256: checksum(data)}
+		if i == 0 {
+			chunk.Parameters = parameters
+			chunk.InputHash = inputHash
+		}
+		if i == len(chunks)-1 {
+			chunk.Last = true
+			chunk.TotalSha256 = checksum(results)
+		}
+
+		if err := stream.Send(chunk); err != nil {
+			// The actual error is returned by CloseAndRecv when the server closed the stream.
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("couldn't send chunk %d: %w", i, err)
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
+
+// GetDataStream retrieves the data from the gRPC server in chunks and assembles them.
+func (c *client) GetDataStream(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.streamTimeout)
+	defer cancel()
+
+	stream, err := c.client.GetDataStream(ctx, &proto.GetDataRequest{Parameters: parameters})
+	if err != nil {
+		return nil, err
+	}
+
+	var assembler chunkAssembler
+	response := &proto.GetDataResponse{}
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("stream ended before the last chunk was received")
+			}
+			return nil, err
+		}
+
+		if assembler.received == 0 {
+			response.CreatedAt = chunk.CreatedAt
+			response.InputHash = chunk.InputHash
+			response.Parameters = chunk.Parameters
+		}
+
+		if err := assembler.add(chunk.Data, chunk.Sha256); err != nil {
+			return nil, err
+		}
+
+		if chunk.Last {
+			if err := assembler.verify(chunk.TotalSha256); err != nil {
+				return nil, err
+			}
+			response.Results = string(assembler.bytes())
+			return response, nil
+		}
+	}
+}
+
+// StoreResults sends the results to the gRPC server, in chunks if they exceed the stream threshold.
+func StoreResults(ctx context.Context, c Client, streamThreshold int, parameters map[string]string, inputHash string, data []byte) (*proto.StoreResponse, error) {
+	if streamThreshold > 0 && len(data) > streamThreshold {
+		return c.StoreDataStream(ctx, parameters, inputHash, data)
+	}
+	return c.StoreData(ctx, parameters, inputHash, string(data))
+}
+
 // Close closes the connection to the gRPC server.
 func (c *client) Close() error {
 	return c.conn.Close()
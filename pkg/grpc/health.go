@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vega-project/ccb-operator/pkg/db"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// HealthReporter serves the standard gRPC health service, with a status that follows the
+// connectivity to the results database.
+type HealthReporter struct {
+	server *health.Server
+	pinger db.Pinger
+	logger *logrus.Entry
+}
+
+// NewHealthReporter returns a HealthReporter for the given store. Stores that can't be pinged
+// are always reported as serving.
+func NewHealthReporter(store db.CalculationResultsStore) *HealthReporter {
+	pinger, _ := store.(db.Pinger)
+	return &HealthReporter{
+		server: health.NewServer(),
+		pinger: pinger,
+		logger: logrus.WithField("component", "health"),
+	}
+}
+
+// Register registers the health service to the given server.
+func (h *HealthReporter) Register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, h.server)
+}
+
+// Start checks the database connectivity periodically until the context is cancelled.
+func (h *HealthReporter) Start(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, h.check, interval)
+}
+
+func (h *HealthReporter) check(ctx context.Context) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if h.pinger != nil {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		defer cancel()
+		if err := h.pinger.Ping(ctx); err != nil {
+			h.logger.WithError(err).Warn("Database is not reachable")
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+
+	// The empty service name is the health of the whole server.
+	h.server.SetServingStatus("", status)
+	h.server.SetServingStatus(proto.DbService_ServiceDesc.ServiceName, status)
+}
+
+// Shutdown reports every service as not serving, so no new calls are routed to the
+// server while it drains.
+func (h *HealthReporter) Shutdown() {
+	h.server.Shutdown()
+}
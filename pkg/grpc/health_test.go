@@ -0,0 +1,95 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/vega-project/ccb-operator/pkg/db"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+type fakePingableStore struct {
+	fakeResultsStore
+	err error
+}
+
+func (f *fakePingableStore) Ping(ctx context.Context) error {
+	return f.err
+}
+
+func TestHealthReporter(t *testing.T) {
+	store := &fakePingableStore{fakeResultsStore: fakeResultsStore{stored: make(map[string]*db.CalculationResults)}}
+	h := NewHealthReporter(store)
+
+	expectStatus := func(expected healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		for _, service := range []string{"", proto.DbService_ServiceDesc.ServiceName} {
+			resp, err := h.server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != expected {
+				t.Fatalf("expected %s for service %q, got %s", expected, service, resp.Status)
+			}
+		}
+	}
+
+	h.check(context.Background())
+	expectStatus(healthpb.HealthCheckResponse_SERVING)
+
+	store.err = errors.New("connection refused")
+	h.check(context.Background())
+	expectStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	store.err = nil
+	h.check(context.Background())
+	expectStatus(healthpb.HealthCheckResponse_SERVING)
+
+	h.Shutdown()
+	h.check(context.Background())
+	expectStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func TestHealthIsPublicAndCallsAreMeasured(t *testing.T) {
+	interceptor := &authInterceptor{
+		authenticator: unionAuthenticator{},
+		authorizer:    authorizer{writers: sets.New[string](), readers: sets.New[string]()},
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(metricsUnaryInterceptor, interceptor.unary),
+		grpc.ChainStreamInterceptor(metricsStreamInterceptor, interceptor.stream),
+	)
+	store := &fakeResultsStore{stored: make(map[string]*db.CalculationResults)}
+	proto.RegisterDbServiceServer(s, NewServer(store, 64, 0))
+	h := NewHealthReporter(store)
+	h.Register(s)
+
+	conn := newBufconnConn(t, s)
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("expected the health check to be allowed without a token: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING, got %s", resp.Status)
+	}
+
+	method := proto.DbService_GetData_FullMethodName
+	before := testutil.ToFloat64(rpcErrors.WithLabelValues(method, codes.Unauthenticated.String()))
+	_, err = proto.NewDbServiceClient(conn).GetData(context.Background(), &proto.GetDataRequest{Parameters: map[string]string{"teff": "10000.000000"}})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+	if after := testutil.ToFloat64(rpcErrors.WithLabelValues(method, codes.Unauthenticated.String())); after != before+1 {
+		t.Fatalf("expected the rejected call to be counted, got %v errors instead of %v", after, before+1)
+	}
+}
@@ -0,0 +1,58 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vega",
+		Subsystem: "results_handler",
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of the gRPC calls by method and status code",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"method", "code"})
+
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "results_handler",
+		Name:      "rpc_errors_total",
+		Help:      "Number of gRPC calls that failed, by method and status code",
+	}, []string{"method", "code"})
+)
+
+func init() {
+	for _, collector := range []prometheus.Collector{rpcDuration, rpcErrors} {
+		if err := prometheus.Register(collector); err != nil {
+			logrus.WithError(err).Error("couldn't register the gRPC metrics in prometheus")
+		}
+	}
+}
+
+func observeRPC(method string, start time.Time, err error) {
+	code := status.Code(err)
+	rpcDuration.WithLabelValues(method, code.String()).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(method, code.String()).Inc()
+	}
+}
+
+func metricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+func metricsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
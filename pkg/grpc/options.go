@@ -1,15 +1,345 @@
 package grpc
 
-import "flag"
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+const (
+	defaultMaxMessageSize  = 16 * 1024 * 1024
+	defaultChunkSize       = 1024 * 1024
+	defaultMaxStreamSize   = 512 * 1024 * 1024
+	defaultStreamThreshold = 2 * 1024 * 1024
+	defaultMaxAttempts     = 5
+	defaultMaxBackoff      = 5 * time.Second
+	defaultBatchSize       = 500
+	// maxBatchSize is the maximum number of queries or results in a single batch call.
+	maxBatchSize = 1000
+	// maxAttemptsLimit is the maximum number of attempts that gRPC allows in a retry policy.
+	maxAttemptsLimit = 5
+)
 
 type Options struct {
-	address string
+	address         string
+	timeout         time.Duration
+	streamTimeout   time.Duration
+	maxMessageSize  int
+	chunkSize       int
+	streamThreshold int
+	compression     bool
+	maxAttempts     int
+	initialBackoff  time.Duration
+	maxBackoff      time.Duration
+	batchSize       int
+
+	tlsCAFile     string
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsServerName string
+	tokenFile     string
 }
 
 func (o *Options) Bind(fs *flag.FlagSet) {
 	fs.StringVar(&o.address, "grpc-address", "results-handler:50051", "gRPC server address")
+	fs.DurationVar(&o.timeout, "grpc-timeout", 10*time.Second, "Timeout of the unary gRPC calls")
+	fs.DurationVar(&o.streamTimeout, "grpc-stream-timeout", 5*time.Minute, "Timeout of the streaming gRPC calls")
+	fs.IntVar(&o.maxMessageSize, "grpc-max-message-size", defaultMaxMessageSize, "Maximum size in bytes of a gRPC message that can be sent or received")
+	fs.IntVar(&o.chunkSize, "grpc-chunk-size", defaultChunkSize, "Size in bytes of each chunk when the results are streamed")
+	fs.IntVar(&o.streamThreshold, "grpc-stream-threshold", defaultStreamThreshold, "Results larger than this size in bytes are sent in chunks")
+	fs.BoolVar(&o.compression, "grpc-compression", true, "Compress the gRPC messages with gzip")
+	fs.IntVar(&o.maxAttempts, "grpc-max-attempts", defaultMaxAttempts, "Maximum number of attempts of a gRPC call that fails with a transient error, at most 5")
+	fs.DurationVar(&o.initialBackoff, "grpc-initial-backoff", time.Second, "Backoff before the first retry of a failed gRPC call")
+	fs.DurationVar(&o.maxBackoff, "grpc-max-backoff", defaultMaxBackoff, "Maximum backoff between the retries of a failed gRPC call, at most --grpc-timeout")
+	fs.IntVar(&o.batchSize, "grpc-batch-size", defaultBatchSize, fmt.Sprintf("Maximum number of queries or results sent in a single batch call, at most %d", maxBatchSize))
+	fs.StringVar(&o.tlsCAFile, "grpc-tls-ca-file", "", "CA certificate file used to verify the gRPC server. Enables TLS")
+	fs.StringVar(&o.tlsCertFile, "grpc-tls-cert-file", "", "Client certificate file, presented to gRPC servers that require mutual TLS")
+	fs.StringVar(&o.tlsKeyFile, "grpc-tls-key-file", "", "Key file of the client certificate")
+	fs.StringVar(&o.tlsServerName, "grpc-tls-server-name", "", "Server name used to verify the certificate of the gRPC server. Defaults to the host of --grpc-address")
+	fs.StringVar(&o.tokenFile, "grpc-token-file", "", "File with the bearer token sent with every gRPC call, e.g. a projected service account token. Requires TLS")
+}
+
+func (o *Options) Validate() error {
+	var errs []error
+	if o.address == "" {
+		errs = append(errs, fmt.Errorf("--grpc-address is not specified"))
+	}
+	if o.chunkSize <= 0 {
+		errs = append(errs, fmt.Errorf("--grpc-chunk-size must be positive"))
+	}
+	if o.maxMessageSize <= o.chunkSize {
+		errs = append(errs, fmt.Errorf("--grpc-max-message-size must be larger than --grpc-chunk-size"))
+	}
+	if o.maxAttempts < 1 || o.maxAttempts > maxAttemptsLimit {
+		errs = append(errs, fmt.Errorf("--grpc-max-attempts must be between 1 and %d", maxAttemptsLimit))
+	}
+	if o.initialBackoff <= 0 || o.maxBackoff < o.initialBackoff {
+		errs = append(errs, fmt.Errorf("--grpc-initial-backoff must be positive and not larger than --grpc-max-backoff"))
+	}
+	if o.maxBackoff > o.timeout {
+		errs = append(errs, fmt.Errorf("--grpc-max-backoff must not be larger than --grpc-timeout"))
+	}
+	if o.batchSize < 1 || o.batchSize > maxBatchSize {
+		errs = append(errs, fmt.Errorf("--grpc-batch-size must be between 1 and %d", maxBatchSize))
+	}
+	if (o.tlsCertFile == "") != (o.tlsKeyFile == "") {
+		errs = append(errs, fmt.Errorf("--grpc-tls-cert-file and --grpc-tls-key-file must be specified together"))
+	}
+	if o.tlsCAFile == "" {
+		if o.tlsCertFile != "" {
+			errs = append(errs, fmt.Errorf("--grpc-tls-cert-file requires --grpc-tls-ca-file"))
+		}
+		if o.tokenFile != "" {
+			errs = append(errs, fmt.Errorf("--grpc-token-file requires --grpc-tls-ca-file, tokens are not sent over plaintext connections"))
+		}
+	}
+	return errors.NewAggregate(errs)
+}
+
+// dialOptions returns the transport and per-RPC credentials of the options.
+func (o *Options) dialOptions() ([]grpc.DialOption, error) {
+	if o.tlsCAFile == "" {
+		return []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, nil
+	}
+
+	config, err := newClientTLSConfig(o.tlsCAFile, o.tlsCertFile, o.tlsKeyFile, o.tlsServerName)
+	if err != nil {
+		return nil, err
+	}
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(config))}
+	if o.tokenFile != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(tokenFileCredentials{file: o.tokenFile}))
+	}
+	return dialOptions, nil
 }
 
 func (o *Options) Address() string {
 	return o.address
 }
+
+// StreamThreshold returns the size in bytes above which the results should be streamed.
+func (o *Options) StreamThreshold() int {
+	return o.streamThreshold
+}
+
+// setDefaults fills the values that were not set through the flags.
+func (o *Options) setDefaults() {
+	if o.timeout == 0 {
+		o.timeout = 10 * time.Second
+	}
+	if o.streamTimeout == 0 {
+		o.streamTimeout = 5 * time.Minute
+	}
+	if o.maxMessageSize == 0 {
+		o.maxMessageSize = defaultMaxMessageSize
+	}
+	if o.chunkSize == 0 {
+		o.chunkSize = defaultChunkSize
+	}
+	if o.maxAttempts == 0 {
+		o.maxAttempts = defaultMaxAttempts
+	}
+	if o.initialBackoff == 0 {
+		o.initialBackoff = time.Second
+	}
+	if o.maxBackoff == 0 {
+		o.maxBackoff = defaultMaxBackoff
+	}
+	if o.batchSize == 0 {
+		o.batchSize = defaultBatchSize
+	}
+}
+
+// serviceConfig returns the gRPC service config that retries the calls to the results service
+// which fail with a transient error, with exponential backoff.
+func (o *Options) serviceConfig() (string, error) {
+	type retryPolicy struct {
+		MaxAttempts          int      `json:"maxAttempts"`
+		InitialBackoff       string   `json:"initialBackoff"`
+		MaxBackoff           string   `json:"maxBackoff"`
+		BackoffMultiplier    float64  `json:"backoffMultiplier"`
+		RetryableStatusCodes []string `json:"retryableStatusCodes"`
+	}
+	type methodConfig struct {
+		Name        []map[string]string `json:"name"`
+		RetryPolicy *retryPolicy        `json:"retryPolicy,omitempty"`
+	}
+
+	config := methodConfig{Name: []map[string]string{{"service": proto.DbService_ServiceDesc.ServiceName}}}
+	// A single attempt means no retries, which the retry policy can't express.
+	if o.maxAttempts > 1 {
+		config.RetryPolicy = &retryPolicy{
+			MaxAttempts:       o.maxAttempts,
+			InitialBackoff:    durationString(o.initialBackoff),
+			MaxBackoff:        durationString(o.maxBackoff),
+			BackoffMultiplier: 2,
+			// Other codes, like RESOURCE_EXHAUSTED for the results that are too large, fail the
+			// same way on every attempt.
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		}
+	}
+
+	data, err := json.Marshal(map[string][]methodConfig{"methodConfig": {config}})
+	return string(data), err
+}
+
+// durationString formats a duration the way the service config expects it, e.g. "1.5s".
+func durationString(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// ServerOptions are the options of the gRPC server that stores the results.
+type ServerOptions struct {
+	maxMessageSize int
+	chunkSize      int
+	maxStreamSize  int
+
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsClientCAFile string
+
+	authTokenFile            string
+	authServiceAccountTokens bool
+	authAudiences            string
+	authWriters              string
+	authReaders              string
+}
+
+func (o *ServerOptions) Bind(fs *flag.FlagSet) {
+	fs.IntVar(&o.maxMessageSize, "max-message-size", defaultMaxMessageSize, "Maximum size in bytes of a gRPC message that can be sent or received")
+	fs.IntVar(&o.chunkSize, "chunk-size", defaultChunkSize, "Size in bytes of each chunk when the results are streamed")
+	fs.IntVar(&o.maxStreamSize, "max-stream-size", defaultMaxStreamSize, "Maximum total size in bytes of the results that are streamed to the server")
+	fs.StringVar(&o.tlsCertFile, "tls-cert-file", "", "Server certificate file. Enables TLS, the file is reloaded when it changes")
+	fs.StringVar(&o.tlsKeyFile, "tls-key-file", "", "Key file of the server certificate")
+	fs.StringVar(&o.tlsClientCAFile, "tls-client-ca-file", "", "CA certificate file used to verify the clients. Enables mutual TLS")
+	fs.StringVar(&o.authTokenFile, "auth-token-file", "", "CSV file with token,user lines. Enables bearer token authentication, the file is reloaded when it changes")
+	fs.BoolVar(&o.authServiceAccountTokens, "auth-service-account-tokens", false, "Authenticate Kubernetes service account tokens with the TokenReview API")
+	fs.StringVar(&o.authAudiences, "auth-audiences", "", "Comma separated audiences that the service account tokens must be issued for")
+	fs.StringVar(&o.authWriters, "auth-writers", "", "Comma separated users that can store results, e.g. system:serviceaccount:vega:worker")
+	fs.StringVar(&o.authReaders, "auth-readers", "", "Comma separated users that can only read results, e.g. system:serviceaccount:vega:dispatcher")
+}
+
+func (o *ServerOptions) Validate() error {
+	var errs []error
+	if o.chunkSize <= 0 {
+		errs = append(errs, fmt.Errorf("--chunk-size must be positive"))
+	}
+	if o.maxMessageSize <= o.chunkSize {
+		errs = append(errs, fmt.Errorf("--max-message-size must be larger than --chunk-size"))
+	}
+	if o.maxStreamSize < o.maxMessageSize {
+		errs = append(errs, fmt.Errorf("--max-stream-size must not be smaller than --max-message-size"))
+	}
+	if (o.tlsCertFile == "") != (o.tlsKeyFile == "") {
+		errs = append(errs, fmt.Errorf("--tls-cert-file and --tls-key-file must be specified together"))
+	}
+	if o.tlsClientCAFile != "" && o.tlsCertFile == "" {
+		errs = append(errs, fmt.Errorf("--tls-client-ca-file requires --tls-cert-file"))
+	}
+	if o.authEnabled() {
+		if o.tlsCertFile == "" {
+			errs = append(errs, fmt.Errorf("token authentication requires --tls-cert-file, tokens are not accepted over plaintext connections"))
+		}
+		if o.authWriters == "" && o.authReaders == "" {
+			errs = append(errs, fmt.Errorf("token authentication requires --auth-writers or --auth-readers"))
+		}
+	}
+	return errors.NewAggregate(errs)
+}
+
+func (o *ServerOptions) authEnabled() bool {
+	return o.authTokenFile != "" || o.authServiceAccountTokens
+}
+
+// ServiceAccountTokenAuth reports whether a Kubernetes client is needed to review service account tokens.
+func (o *ServerOptions) ServiceAccountTokenAuth() bool {
+	return o.authServiceAccountTokens
+}
+
+// GRPCServerOptions returns the options for constructing a grpc.Server. The client is only used
+// to review service account tokens and can be nil otherwise.
+func (o *ServerOptions) GRPCServerOptions(client ctrlruntimeclient.Client) ([]grpc.ServerOption, error) {
+	serverOptions := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(o.maxMessageSize),
+		grpc.MaxSendMsgSize(o.maxMessageSize),
+		// The metrics interceptors come first, so calls that are rejected by the auth interceptors are counted.
+		grpc.ChainUnaryInterceptor(metricsUnaryInterceptor),
+		grpc.ChainStreamInterceptor(metricsStreamInterceptor),
+		grpc.StatsHandler(serverTracingHandler()),
+	}
+
+	if o.tlsCertFile != "" {
+		config, err := newServerTLSConfig(o.tlsCertFile, o.tlsKeyFile, o.tlsClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(config)))
+	}
+
+	if !o.authEnabled() {
+		logrus.Warn("Token authentication is disabled, every client can read and store results")
+		return serverOptions, nil
+	}
+
+	var authenticators unionAuthenticator
+	if o.authTokenFile != "" {
+		a, err := newStaticTokenAuthenticator(o.authTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if o.authServiceAccountTokens {
+		if client == nil {
+			return nil, fmt.Errorf("service account token authentication requires a Kubernetes client")
+		}
+		authenticators = append(authenticators, newTokenReviewAuthenticator(client, splitList(o.authAudiences)))
+	}
+
+	interceptor := &authInterceptor{
+		authenticator: authenticators,
+		authorizer: authorizer{
+			writers: sets.New(splitList(o.authWriters)...),
+			readers: sets.New(splitList(o.authReaders)...),
+		},
+		logger: logrus.WithField("component", "auth"),
+	}
+	return append(serverOptions,
+		grpc.ChainUnaryInterceptor(interceptor.unary),
+		grpc.ChainStreamInterceptor(interceptor.stream),
+	), nil
+}
+
+func (o *ServerOptions) ChunkSize() int {
+	return o.chunkSize
+}
+
+// MaxStreamSize returns the maximum total size of the results that are streamed to the server.
+func (o *ServerOptions) MaxStreamSize() int {
+	return o.maxStreamSize
+}
+
+// splitList splits a comma separated flag value, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,103 @@
+package grpc
+
+import (
+	"context"
+	"flag"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+// flakyServer fails the first calls with the given code, Unavailable by default.
+type flakyServer struct {
+	proto.UnimplementedDbServiceServer
+
+	mu       sync.Mutex
+	code     codes.Code
+	failures int
+	calls    int
+}
+
+func (f *flakyServer) StoreData(ctx context.Context, in *proto.StoreRequest) (*proto.StoreResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		if f.code != codes.OK {
+			return nil, status.Error(f.code, "the call failed")
+		}
+		return nil, status.Error(codes.Unavailable, "database is restarting")
+	}
+	return &proto.StoreResponse{Message: "Data stored successfully"}, nil
+}
+
+func TestClientRetries(t *testing.T) {
+	testCases := []struct {
+		id            string
+		maxAttempts   string
+		code          codes.Code
+		failures      int
+		expectedCalls int
+		expectedCode  codes.Code
+	}{
+		{id: "transient failures are retried", maxAttempts: "5", failures: 2, expectedCalls: 3, expectedCode: codes.OK},
+		{id: "retries give up after the maximum attempts", maxAttempts: "3", failures: 5, expectedCalls: 3, expectedCode: codes.Unavailable},
+		{id: "a single attempt is not retried", maxAttempts: "1", failures: 1, expectedCalls: 1, expectedCode: codes.Unavailable},
+		{id: "results that are too large are not retried", maxAttempts: "5", code: codes.ResourceExhausted, failures: 5, expectedCalls: 1, expectedCode: codes.ResourceExhausted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			server := &flakyServer{code: tc.code, failures: tc.failures}
+			s := grpc.NewServer()
+			proto.RegisterDbServiceServer(s, server)
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() { _ = s.Serve(lis) }()
+			t.Cleanup(s.Stop)
+
+			c := newTestOptionsClient(t,
+				"--grpc-address="+lis.Addr().String(),
+				"--grpc-max-attempts="+tc.maxAttempts,
+				"--grpc-initial-backoff=10ms",
+				"--grpc-max-backoff=50ms",
+			)
+
+			_, err = c.StoreData(context.Background(), map[string]string{"teff": "10000.000000"}, "", "results")
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("expected %s, got %v", tc.expectedCode, err)
+			}
+			if server.calls != tc.expectedCalls {
+				t.Fatalf("expected %d calls, got %d", tc.expectedCalls, server.calls)
+			}
+		})
+	}
+}
+
+func TestOptionsValidateRetries(t *testing.T) {
+	for _, args := range [][]string{
+		{"--grpc-max-attempts=0"},
+		{"--grpc-max-attempts=6"},
+		{"--grpc-initial-backoff=0"},
+		{"--grpc-initial-backoff=1m", "--grpc-max-backoff=1s"},
+		{"--grpc-timeout=10s", "--grpc-max-backoff=30s"},
+	} {
+		var o Options
+		fs := flag.NewFlagSet("client", flag.ContinueOnError)
+		o.Bind(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if err := o.Validate(); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
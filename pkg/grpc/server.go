@@ -3,10 +3,12 @@ package grpc
 import (
 	"context"
 	"errors"
+	"io"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vega-project/ccb-operator/pkg/db"
+	"github.com/vega-project/ccb-operator/pkg/results"
 	proto "github.com/vega-project/ccb-operator/proto"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -15,11 +17,20 @@ import (
 
 type Server struct {
 	resultstore db.CalculationResultsStore
+	chunkSize   int
+	// maxStreamSize limits the results that are streamed, which are assembled in memory.
+	maxStreamSize int
 	proto.UnimplementedDbServiceServer
 }
 
-func NewServer(resultstore db.CalculationResultsStore) *Server {
-	return &Server{resultstore: resultstore}
+func NewServer(resultstore db.CalculationResultsStore, chunkSize, maxStreamSize int) *Server {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	if maxStreamSize <= 0 {
+		maxStreamSize = defaultMaxStreamSize
+	}
+	return &Server{resultstore: resultstore, chunkSize: chunkSize, maxStreamSize: maxStreamSize}
 }
 
 func (s *Server) StoreData(ctx context.Context, in *proto.StoreRequest) (*proto.StoreResponse, error) {
@@ -27,7 +38,7 @@ func (s *Server) StoreData(ctx context.Context, in *proto.StoreRequest) (*proto.
 	reply, err := s.resultstore.StoreOrUpdateData(ctx, in)
 	if err != nil {
 		l.WithError(err).Error("error storing or updating data")
-		return nil, err
+		return nil, statusError(err)
 	}
 
 	l.Info(reply.GetMessage())
@@ -38,14 +49,155 @@ func (s *Server) GetData(ctx context.Context, in *proto.GetDataRequest) (*proto.
 	l := logrus.WithField("parametres", in.Parameters)
 	reply, err := s.resultstore.GetData(ctx, in.Parameters)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, status.Error(codes.NotFound, err.Error())
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			l.WithError(err).Error("error getting data")
+		}
+		return nil, statusError(err)
+	}
+	return getDataResponse(reply), nil
+}
+
+func (s *Server) LookupByHash(ctx context.Context, in *proto.LookupByHashRequest) (*proto.GetDataResponse, error) {
+	l := logrus.WithField("input-hash", in.InputHash)
+	reply, err := s.resultstore.GetDataByHash(ctx, in.InputHash)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			l.WithError(err).Error("error looking up data by hash")
+		}
+		return nil, statusError(err)
+	}
+	return getDataResponse(reply), nil
+}
+
+func (s *Server) GetDataBatch(ctx context.Context, in *proto.GetDataBatchRequest) (*proto.GetDataBatchResponse, error) {
+	if len(in.Queries) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d queries are allowed in a batch, got %d", maxBatchSize, len(in.Queries))
+	}
+
+	l := logrus.WithField("queries", len(in.Queries))
+	results, err := s.resultstore.GetDataBatch(ctx, in.Queries, in.MetadataOnly)
+	if err != nil {
+		l.WithError(err).Error("error getting batch of data")
+		return nil, statusError(err)
+	}
+
+	response := &proto.GetDataBatchResponse{Results: make(map[uint32]*proto.GetDataResponse)}
+	for i, result := range results {
+		if result != nil {
+			response.Results[uint32(i)] = getDataResponse(result)
 		}
-		l.WithError(err).Error("error getting data")
-		return nil, err
 	}
+	l.WithField("found", len(response.Results)).Debug("Batch of data retrieved")
+	return response, nil
+}
+
+func (s *Server) StoreDataBatch(ctx context.Context, in *proto.StoreDataBatchRequest) (*proto.StoreDataBatchResponse, error) {
+	if len(in.Requests) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d results are allowed in a batch, got %d", maxBatchSize, len(in.Requests))
+	}
+
+	l := logrus.WithField("results", len(in.Requests))
+	responses, err := s.resultstore.StoreOrUpdateDataBatch(ctx, in.Requests)
+	if err != nil {
+		l.WithError(err).Error("error storing or updating batch of data")
+		return nil, statusError(err)
+	}
+
+	l.Info("Batch of data stored successfully")
+	return &proto.StoreDataBatchResponse{Responses: responses}, nil
+}
+
+func getDataResponse(results *db.CalculationResults) *proto.GetDataResponse {
 	return &proto.GetDataResponse{
-		Results:   reply.Results,
-		CreatedAt: reply.CreatedAt.Format(time.RFC3339),
-	}, err
+		Results:    results.Results,
+		CreatedAt:  results.CreatedAt.Format(time.RFC3339),
+		InputHash:  results.InputHash,
+		Parameters: results.Parameters,
+	}
+}
+
+func (s *Server) StoreDataStream(stream proto.DbService_StoreDataStreamServer) error {
+	var parameters map[string]string
+	var inputHash string
+	var assembler chunkAssembler
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return status.Error(codes.InvalidArgument, "stream ended before the last chunk was received")
+			}
+			return err
+		}
+
+		if assembler.received == 0 {
+			parameters = chunk.Parameters
+			inputHash = chunk.InputHash
+		}
+
+		if size := assembler.size() + len(chunk.Data); size > s.maxStreamSize {
+			return status.Errorf(codes.ResourceExhausted, "the streamed results exceed the maximum size of %d bytes", s.maxStreamSize)
+		}
+		if err := assembler.add(chunk.Data, chunk.Sha256); err != nil {
+			return status.Error(codes.DataLoss, err.Error())
+		}
+
+		if chunk.Last {
+			if err := assembler.verify(chunk.TotalSha256); err != nil {
+				return status.Error(codes.DataLoss, err.Error())
+			}
+			break
+		}
+	}
+
+	l := logrus.WithField("parametres", parameters).WithField("size", len(assembler.bytes()))
+	reply, err := s.resultstore.StoreOrUpdateData(stream.Context(), &proto.StoreRequest{Parameters: parameters, InputHash: inputHash, Results: string(assembler.bytes())})
+	if err != nil {
+		l.WithError(err).Error("error storing or updating streamed data")
+		return statusError(err)
+	}
+
+	l.Info(reply.GetMessage())
+	return stream.SendAndClose(reply)
+}
+
+func (s *Server) GetDataStream(in *proto.GetDataRequest, stream proto.DbService_GetDataStreamServer) error {
+	l := logrus.WithField("parametres", in.Parameters)
+	reply, err := s.resultstore.GetData(stream.Context(), in.Parameters)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			l.WithError(err).Error("error getting data")
+		}
+		return statusError(err)
+	}
+
+	results := []byte(reply.Results)
+	chunks := splitIntoChunks(results, s.chunkSize)
+	for i, data := range chunks {
+		chunk := &proto.GetDataChunk{Data: data, Sha256: checksum(data)}
+		if i == 0 {
+			chunk.CreatedAt = reply.CreatedAt.Format(time.RFC3339)
+			chunk.InputHash = reply.InputHash
+			chunk.Parameters = reply.Parameters
+		}
+		if i == len(chunks)-1 {
+			chunk.Last = true
+			chunk.TotalSha256 = checksum(results)
+		}
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusError maps the errors of the results store to gRPC status errors.
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, results.ErrInvalidParameters):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return err
+	}
 }
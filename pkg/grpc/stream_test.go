@@ -0,0 +1,388 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/gorm"
+
+	"github.com/vega-project/ccb-operator/pkg/db"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+type fakeResultsStore struct {
+	stored map[string]*db.CalculationResults
+}
+
+func (f *fakeResultsStore) StoreOrUpdateData(ctx context.Context, in *proto.StoreRequest) (*proto.StoreResponse, error) {
+	f.stored[in.Parameters["teff"]] = &db.CalculationResults{Parameters: in.Parameters, InputHash: in.InputHash, Results: in.Results, Model: gorm.Model{CreatedAt: time.Now()}}
+	return &proto.StoreResponse{Message: "Data stored successfully"}, nil
+}
+
+func (f *fakeResultsStore) GetData(ctx context.Context, parameters map[string]string) (*db.CalculationResults, error) {
+	result, ok := f.stored[parameters["teff"]]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return result, nil
+}
+
+func (f *fakeResultsStore) GetDataByHash(ctx context.Context, inputHash string) (*db.CalculationResults, error) {
+	for _, result := range f.stored {
+		if result.InputHash == inputHash {
+			return result, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeResultsStore) GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*db.CalculationResults, error) {
+	results := make([]*db.CalculationResults, len(queries))
+	for i, query := range queries {
+		var result *db.CalculationResults
+		var err error
+		if query.InputHash != "" {
+			result, err = f.GetDataByHash(ctx, query.InputHash)
+		} else {
+			result, err = f.GetData(ctx, query.Parameters)
+		}
+		if err != nil {
+			continue
+		}
+		ret := *result
+		if metadataOnly {
+			ret.Results = ""
+		}
+		results[i] = &ret
+	}
+	return results, nil
+}
+
+func (f *fakeResultsStore) StoreOrUpdateDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error) {
+	var responses []*proto.StoreResponse
+	for _, in := range requests {
+		response, err := f.StoreOrUpdateData(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// newBufconnConn serves the given server in memory and returns a connection to it.
+func newBufconnConn(t *testing.T, s *grpc.Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1024 * 1024)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newTestClient(t *testing.T, store db.CalculationResultsStore, chunkSize int) *client {
+	return newTestClientWithServer(t, NewServer(store, chunkSize, 0), chunkSize)
+}
+
+func newTestClientWithServer(t *testing.T, server *Server, chunkSize int) *client {
+	s := grpc.NewServer()
+	proto.RegisterDbServiceServer(s, server)
+	conn := newBufconnConn(t, s)
+
+	return &client{
+		client:        proto.NewDbServiceClient(conn),
+		conn:          conn,
+		timeout:       10 * time.Second,
+		streamTimeout: 10 * time.Second,
+		chunkSize:     chunkSize,
+		batchSize:     defaultBatchSize,
+	}
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	inputHash := strings.Repeat("a", 64)
+	testCases := []struct {
+		id      string
+		results []byte
+	}{
+		{
+			id:      "empty results",
+			results: []byte{},
+		},
+		{
+			id:      "results smaller than a chunk",
+			results: []byte("fort.7 results"),
+		},
+		{
+			id:      "results that are split in several chunks",
+			results: bytes.Repeat([]byte("0123456789"), 1000),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			store := &fakeResultsStore{stored: make(map[string]*db.CalculationResults)}
+			c := newTestClient(t, store, 64)
+			params := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+
+			if _, err := c.StoreDataStream(context.Background(), params, inputHash, tc.results); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(store.stored["10000.000000"].Parameters, params) {
+				t.Fatalf("expected parameters %v, got %v", params, store.stored["10000.000000"].Parameters)
+			}
+
+			resp, err := c.GetDataStream(context.Background(), params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Results != string(tc.results) {
+				t.Fatalf("expected %d bytes, got %d bytes", len(tc.results), len(resp.Results))
+			}
+			if resp.CreatedAt == "" {
+				t.Fatal("expected created_at to be set")
+			}
+			// The streamed lookup returns the same metadata as the unary one.
+			if resp.InputHash != inputHash || !reflect.DeepEqual(resp.Parameters, params) {
+				t.Fatalf("expected the input hash and the parameters of the results, got %q and %v", resp.InputHash, resp.Parameters)
+			}
+		})
+	}
+}
+
+func TestGetDataStreamNotFound(t *testing.T) {
+	c := newTestClient(t, &fakeResultsStore{stored: make(map[string]*db.CalculationResults)}, 64)
+	_, err := c.GetDataStream(context.Background(), map[string]string{"teff": "10000.000000"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestStoreDataStreamChecksumMismatch(t *testing.T) {
+	store := &fakeResultsStore{stored: make(map[string]*db.CalculationResults)}
+	c := newTestClient(t, store, 64)
+
+	stream, err := c.client.StoreDataStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&proto.StoreDataChunk{
+		Parameters:  map[string]string{"teff": "10000.000000"},
+		Data:        []byte("corrupted"),
+		Sha256:      checksum([]byte("original")),
+		TotalSha256: checksum([]byte("original")),
+		Last:        true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.DataLoss {
+		t.Fatalf("expected DataLoss, got %v", err)
+	}
+	if len(store.stored) != 0 {
+		t.Fatal("expected nothing to be stored")
+	}
+}
+
+func TestStoreDataStreamMaxSize(t *testing.T) {
+	testCases := []struct {
+		id           string
+		results      []byte
+		expectedCode codes.Code
+	}{
+		{
+			id:           "results up to the maximum size are stored",
+			results:      bytes.Repeat([]byte("0"), 256),
+			expectedCode: codes.OK,
+		},
+		{
+			id:           "results over the maximum size are rejected",
+			results:      bytes.Repeat([]byte("0"), 257),
+			expectedCode: codes.ResourceExhausted,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			store := &fakeResultsStore{stored: make(map[string]*db.CalculationResults)}
+			c := newTestClientWithServer(t, NewServer(store, 64, 256), 64)
+
+			_, err := c.StoreDataStream(context.Background(), map[string]string{"teff": "10000.000000"}, "", tc.results)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("expected %s, got %v", tc.expectedCode, err)
+			}
+			if stored := len(store.stored) == 1; stored != (tc.expectedCode == codes.OK) {
+				t.Fatalf("expected the results to be stored: %t", tc.expectedCode == codes.OK)
+			}
+		})
+	}
+}
+
+func TestSplitIntoChunks(t *testing.T) {
+	testCases := []struct {
+		id       string
+		data     []byte
+		size     int
+		expected [][]byte
+	}{
+		{
+			id:       "empty data results in one empty chunk",
+			data:     []byte{},
+			size:     4,
+			expected: [][]byte{{}},
+		},
+		{
+			id:       "data fits exactly in chunks",
+			data:     []byte("abcdefgh"),
+			size:     4,
+			expected: [][]byte{[]byte("abcd"), []byte("efgh")},
+		},
+		{
+			id:       "last chunk is smaller",
+			data:     []byte("abcdefghij"),
+			size:     4,
+			expected: [][]byte{[]byte("abcd"), []byte("efgh"), []byte("ij")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			if got := splitIntoChunks(tc.data, tc.size); !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	c := newTestClient(t, db.NewMemoryResultsStore(), 64)
+	hostile := map[string]string{"teff' = '1' OR '1' = '1' --": "1"}
+
+	if _, err := c.GetData(context.Background(), hostile); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument from GetData, got %v", err)
+	}
+	if _, err := c.GetDataStream(context.Background(), hostile); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument from GetDataStream, got %v", err)
+	}
+	if _, err := c.StoreData(context.Background(), hostile, "", "results"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument from StoreData, got %v", err)
+	}
+	if _, err := c.StoreDataStream(context.Background(), hostile, "", []byte("results")); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument from StoreDataStream, got %v", err)
+	}
+}
+
+func TestLookupByHash(t *testing.T) {
+	store := &fakeResultsStore{stored: make(map[string]*db.CalculationResults)}
+	c := newTestClient(t, store, 64)
+	params := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+	inputHash := strings.Repeat("a", 64)
+
+	if _, err := c.StoreDataStream(context.Background(), params, inputHash, []byte("results")); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.LookupByHash(context.Background(), inputHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.InputHash != inputHash || resp.Results != "results" {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if !reflect.DeepEqual(resp.Parameters, params) {
+		t.Fatalf("expected parameters %v, got %v", params, resp.Parameters)
+	}
+
+	if _, err := c.LookupByHash(context.Background(), strings.Repeat("b", 64)); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestBatchCalls(t *testing.T) {
+	c := newTestClient(t, db.NewMemoryResultsStore(), 64)
+	// A small batch size splits the requests into several calls.
+	c.batchSize = 2
+
+	var requests []*proto.StoreRequest
+	var queries []*proto.DataQuery
+	for i, teff := range []string{"10000.000000", "11000.000000", "12000.000000", "13000.000000", "14000.000000"} {
+		inputHash := strings.Repeat(string(rune('a'+i)), 64)
+		requests = append(requests, &proto.StoreRequest{Parameters: map[string]string{"teff": teff}, InputHash: inputHash, Results: "results " + teff})
+		queries = append(queries, &proto.DataQuery{InputHash: inputHash})
+	}
+	// Only the results of the even queries exist.
+	for i := range requests {
+		if i%2 == 0 {
+			if _, err := c.StoreDataBatch(context.Background(), []*proto.StoreRequest{requests[i]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	results, err := c.GetDataBatch(context.Background(), queries, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(queries) {
+		t.Fatalf("expected %d results, got %d", len(queries), len(results))
+	}
+	for i, result := range results {
+		if i%2 != 0 {
+			if result != nil {
+				t.Errorf("expected no results for query %d, got %v", i, result)
+			}
+			continue
+		}
+		if result == nil || result.Results != requests[i].Results || result.InputHash != queries[i].InputHash {
+			t.Errorf("expected the results of query %d, got %v", i, result)
+		}
+	}
+
+	responses, err := c.StoreDataBatch(context.Background(), requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(requests) {
+		t.Fatalf("expected %d responses, got %d", len(requests), len(responses))
+	}
+	results, err = c.GetDataBatch(context.Background(), queries, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result == nil || result.Results != "" {
+			t.Errorf("expected only the metadata of query %d, got %v", i, result)
+		}
+	}
+}
+
+func TestBatchCallsAreLimited(t *testing.T) {
+	c := newTestClient(t, db.NewMemoryResultsStore(), 64)
+	c.batchSize = maxBatchSize + 1
+
+	queries := make([]*proto.DataQuery, maxBatchSize+1)
+	for i := range queries {
+		queries[i] = &proto.DataQuery{InputHash: strings.Repeat("a", 64)}
+	}
+	if _, err := c.GetDataBatch(context.Background(), queries, true); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
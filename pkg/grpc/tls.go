@@ -0,0 +1,208 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadingFiles tracks the modification times of a set of files, so their contents are
+// reloaded only when any of them changes. This lets rotated certificates be picked up
+// without restarting the process.
+type reloadingFiles struct {
+	files    []string
+	modTimes []time.Time
+}
+
+func (r *reloadingFiles) changed() (bool, error) {
+	modTimes := make([]time.Time, 0, len(r.files))
+	for _, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	changed := len(r.modTimes) != len(modTimes)
+	for i := range modTimes {
+		if changed || !modTimes[i].Equal(r.modTimes[i]) {
+			changed = true
+			break
+		}
+	}
+	r.modTimes = modTimes
+	return changed, nil
+}
+
+// fileCertificate is a key pair that is reloaded from its files when they change.
+type fileCertificate struct {
+	mu    sync.Mutex
+	files reloadingFiles
+	cert  *tls.Certificate
+}
+
+func newFileCertificate(certFile, keyFile string) (*fileCertificate, error) {
+	c := &fileCertificate{files: reloadingFiles{files: []string{certFile, keyFile}}}
+	if _, err := c.get(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *fileCertificate) get() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed, err := c.files.changed()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't stat the certificate files: %w", err)
+	}
+	if !changed && c.cert != nil {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.files.files[0], c.files.files[1])
+	if err != nil {
+		// Keep serving the previous certificate while the files are being rotated.
+		if c.cert != nil {
+			c.files.modTimes = nil
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("couldn't load the certificate: %w", err)
+	}
+	c.cert = &cert
+	return c.cert, nil
+}
+
+// fileCertPool is a pool of CA certificates that is reloaded from its file when it changes.
+type fileCertPool struct {
+	mu    sync.Mutex
+	files reloadingFiles
+	pool  *x509.CertPool
+}
+
+func newFileCertPool(caFile string) (*fileCertPool, error) {
+	p := &fileCertPool{files: reloadingFiles{files: []string{caFile}}}
+	if _, err := p.get(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *fileCertPool) get() (*x509.CertPool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed, err := p.files.changed()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't stat the CA file: %w", err)
+	}
+	if !changed && p.pool != nil {
+		return p.pool, nil
+	}
+
+	data, err := os.ReadFile(p.files.files[0])
+	if err == nil {
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(data) {
+			p.pool = pool
+			return p.pool, nil
+		}
+		err = fmt.Errorf("no certificates found in %s", p.files.files[0])
+	}
+
+	if p.pool != nil {
+		p.files.modTimes = nil
+		return p.pool, nil
+	}
+	return nil, fmt.Errorf("couldn't load the CA certificates: %w", err)
+}
+
+// newServerTLSConfig returns the TLS configuration of the server. If a client CA file is given,
+// clients must present a certificate signed by it.
+func newServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := newFileCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientCAs *fileCertPool
+	if clientCAFile != "" {
+		if clientCAs, err = newFileCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return cert.get()
+				},
+			}
+			if clientCAs != nil {
+				pool, err := clientCAs.get()
+				if err != nil {
+					return nil, err
+				}
+				config.ClientCAs = pool
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}, nil
+}
+
+// newClientTLSConfig returns the TLS configuration of the client. If a certificate is given,
+// it is presented to servers that require mutual TLS.
+func newClientTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	rootCAs, err := newFileCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// The chain is verified in VerifyConnection against the current CA pool instead,
+		// so a rotated CA is picked up without reconnecting.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			pool, err := rootCAs.get()
+			if err != nil {
+				return err
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("the server didn't present a certificate")
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         pool,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+
+	if certFile != "" {
+		cert, err := newFileCertificate(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.get()
+		}
+	}
+	return config, nil
+}
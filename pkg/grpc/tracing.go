@@ -0,0 +1,24 @@
+package grpc
+
+import (
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc/stats"
+)
+
+// traced leaves the health checks out of the traces, since they are not part of any calculation.
+func traced(info *stats.RPCTagInfo) bool {
+	return !strings.HasPrefix(info.FullMethodName, "/grpc.health.v1.Health/")
+}
+
+// clientTracingHandler records a span for every call and propagates the trace context of the
+// caller to the server.
+func clientTracingHandler() stats.Handler {
+	return otelgrpc.NewClientHandler(otelgrpc.WithFilter(traced))
+}
+
+// serverTracingHandler records a span for every call, in the trace of the client.
+func serverTracingHandler() stats.Handler {
+	return otelgrpc.NewServerHandler(otelgrpc.WithFilter(traced))
+}
@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vega-project/ccb-operator/pkg/tracing"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+func TestCallsAreTraced(t *testing.T) {
+	exporter, restore := tracing.SetupInMemory()
+	defer restore()
+	address := startTestServer(t)
+	c := newTestOptionsClient(t, "--grpc-address="+address)
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "calculation")
+	params := map[string]string{"teff": "10000.000000", "log_g": "4.000000"}
+	if _, err := c.StoreData(ctx, params, "", "results"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetData(ctx, params); err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+
+	spans := make(map[trace.SpanKind]map[string]trace.SpanContext)
+	parents := make(map[string]trace.SpanContext)
+	for _, s := range exporter.GetSpans() {
+		if s.SpanContext.TraceID() != span.SpanContext().TraceID() {
+			t.Errorf("expected span %s to be in the trace of the caller", s.Name)
+		}
+		if spans[s.SpanKind] == nil {
+			spans[s.SpanKind] = make(map[string]trace.SpanContext)
+		}
+		spans[s.SpanKind][s.Name] = s.SpanContext
+		parents[s.SpanContext.SpanID().String()] = s.Parent
+	}
+
+	for _, method := range []string{proto.DbService_StoreData_FullMethodName, proto.DbService_GetData_FullMethodName} {
+		name := method[1:]
+		client, ok := spans[trace.SpanKindClient][name]
+		if !ok {
+			t.Fatalf("expected a client span for %s, got %v", name, spans)
+		}
+		server, ok := spans[trace.SpanKindServer][name]
+		if !ok {
+			t.Fatalf("expected a server span for %s, got %v", name, spans)
+		}
+		if parent := parents[client.SpanID().String()]; parent.SpanID() != span.SpanContext().SpanID() {
+			t.Errorf("expected the client span of %s to be a child of the caller", name)
+		}
+		if parent := parents[server.SpanID().String()]; parent.SpanID() != client.SpanID() {
+			t.Errorf("expected the server span of %s to be a child of the client span", name)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package janitor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+)
+
+const (
+	// partitionLayout names the partitions of the archive after the day the objects were archived.
+	partitionLayout = "2006-01-02"
+	archiveSuffix   = ".jsonl.gz"
+	// archiveFile is the file that the objects of a day are appended to in an archive folder.
+	archiveFile = "objects" + archiveSuffix
+)
+
+// Record is an archived object, as it was before the janitor deleted it.
+type Record struct {
+	Kind       string          `json:"kind"`
+	Namespace  string          `json:"namespace"`
+	Name       string          `json:"name"`
+	ArchivedAt metav1.Time     `json:"archivedAt"`
+	Object     json.RawMessage `json:"object"`
+}
+
+func newRecord(k *kind, obj ctrlruntimeclient.Object, at time.Time) (Record, error) {
+	obj = obj.DeepCopyObject().(ctrlruntimeclient.Object)
+	obj.SetManagedFields(nil)
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return Record{}, fmt.Errorf("couldn't serialize %s %s: %w", k.name, obj.GetName(), err)
+	}
+	return Record{Kind: k.name, Namespace: obj.GetNamespace(), Name: obj.GetName(), ArchivedAt: metav1.NewTime(at.UTC()), Object: b}, nil
+}
+
+// decode returns the archived object.
+func (r *Record) decode() (ctrlruntimeclient.Object, error) {
+	k := kindByName(r.Kind)
+	if k == nil {
+		return nil, fmt.Errorf("unknown kind %q of the archived object %s", r.Kind, r.Name)
+	}
+	obj := k.newObject()
+	if err := json.Unmarshal(r.Object, obj); err != nil {
+		return nil, fmt.Errorf("couldn't decode the archived %s %s: %w", r.Kind, r.Name, err)
+	}
+	return obj, nil
+}
+
+// Archive keeps the objects that the janitor deletes as gzip compressed JSON lines, partitioned by
+// the day they were archived. Archived records are never rewritten.
+type Archive interface {
+	// Append archives the records in the partition of the day they were archived.
+	Append(ctx context.Context, records []Record) error
+	// Read calls fn with the records of the partitions from the day of from to the day of to, day by
+	// day and in the order they were appended. A zero from or to leaves the range open.
+	Read(ctx context.Context, from, to time.Time, fn func(Record) error) error
+}
+
+// NewFileArchive returns an archive in a local folder, which can be a mounted shared storage like
+// NFS. The records of a day are appended to a single file, a gzip member at a time.
+func NewFileArchive(dir string) Archive {
+	return &fileArchive{dir: dir}
+}
+
+type fileArchive struct {
+	dir string
+	// lock serializes the appends, so their gzip members don't interleave.
+	lock sync.Mutex
+}
+
+func (a *fileArchive) Append(ctx context.Context, records []Record) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	contents, err := encodePartitions(records)
+	if err != nil {
+		return err
+	}
+	for partition, b := range contents {
+		file := filepath.Join(a.dir, partition, archiveFile)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("couldn't create the partition %s of the archive: %w", partition, err)
+		}
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("couldn't open the archive: %w", err)
+		}
+		_, err = f.Write(b)
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't append to the archive %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (a *fileArchive) Read(ctx context.Context, from, to time.Time, fn func(Record) error) error {
+	return readArchive(ctx, artifacts.NewLocalStore(a.dir), "", from, to, fn)
+}
+
+// NewArchive returns an archive in a folder of the artifact store, which can't append to its
+// artifacts. Every append adds a segment to the partition of the day instead.
+func NewArchive(store artifacts.ArtifactStore, folder string) Archive {
+	return &storeArchive{store: store, folder: folder}
+}
+
+type storeArchive struct {
+	store  artifacts.ArtifactStore
+	folder string
+}
+
+func (a *storeArchive) Append(ctx context.Context, records []Record) error {
+	contents, err := encodePartitions(records)
+	if err != nil {
+		return err
+	}
+	for partition, b := range contents {
+		// The segments are named after the time they were appended, so they are read in that order.
+		segment := fmt.Sprintf("%s-%s%s", time.Now().UTC().Format("150405.000000000"), rand.String(5), archiveSuffix)
+		if err := a.upload(ctx, b, artifacts.Key(a.folder, partition, segment)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *storeArchive) upload(ctx context.Context, b []byte, key string) error {
+	f, err := os.CreateTemp("", "archive-*"+archiveSuffix)
+	if err != nil {
+		return fmt.Errorf("couldn't create the segment of the archive: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't write the segment of the archive: %w", err)
+	}
+
+	if err := a.store.Upload(ctx, f.Name(), key); err != nil {
+		return fmt.Errorf("couldn't upload the segment of the archive: %w", err)
+	}
+	return nil
+}
+
+func (a *storeArchive) Read(ctx context.Context, from, to time.Time, fn func(Record) error) error {
+	return readArchive(ctx, a.store, a.folder, from, to, fn)
+}
+
+// encodePartitions groups the records by the partition of the day they were archived, and
+// compresses the records of every partition.
+func encodePartitions(records []Record) (map[string][]byte, error) {
+	partitions := make(map[string][]Record)
+	for _, record := range records {
+		partition := record.ArchivedAt.UTC().Format(partitionLayout)
+		partitions[partition] = append(partitions[partition], record)
+	}
+
+	contents := make(map[string][]byte, len(partitions))
+	for partition, records := range partitions {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return nil, fmt.Errorf("couldn't encode the archived %s %s: %w", record.Kind, record.Name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("couldn't compress the archived objects: %w", err)
+		}
+		contents[partition] = buf.Bytes()
+	}
+	return contents, nil
+}
+
+// readArchive reads the records of the partitions in the range from the folder of the store.
+func readArchive(ctx context.Context, store artifacts.ArtifactStore, folder string, from, to time.Time, fn func(Record) error) error {
+	keys, err := store.List(ctx, folder)
+	if err != nil {
+		return fmt.Errorf("couldn't list the archive: %w", err)
+	}
+
+	for _, key := range keys {
+		partition, file, ok := strings.Cut(strings.TrimPrefix(key, folder+"/"), "/")
+		if !ok || strings.Contains(file, "/") || !strings.HasSuffix(file, archiveSuffix) {
+			continue
+		}
+		day, err := time.Parse(partitionLayout, partition)
+		if err != nil {
+			continue
+		}
+		if !from.IsZero() && day.Before(truncateDay(from)) || !to.IsZero() && day.After(truncateDay(to)) {
+			continue
+		}
+		if err := readArchiveFile(ctx, store, key, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readArchiveFile(ctx context.Context, store artifacts.ArtifactStore, key string, fn func(Record) error) error {
+	f, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The reader reads every gzip member that was appended to the file.
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("couldn't read the archive %s: %w", path.Base(key), err)
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("couldn't decode a record of the archive %s: %w", key, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("couldn't read the archive %s: %w", key, err)
+	}
+	return nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ArchiveOptions configure where the janitor archives the objects before it deletes them.
+type ArchiveOptions struct {
+	dir    string
+	folder string
+}
+
+func (o *ArchiveOptions) Bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.dir, "archive-dir", "", "Local folder, e.g. on the NFS storage, where the deleted objects are archived")
+	fs.StringVar(&o.folder, "archive-folder", "", "Folder of the artifact store where the deleted objects are archived")
+}
+
+func (o *ArchiveOptions) Validate() error {
+	if o.dir != "" && o.folder != "" {
+		return fmt.Errorf("--archive-dir and --archive-folder are mutually exclusive")
+	}
+	return nil
+}
+
+// Enabled reports whether an archive is configured.
+func (o *ArchiveOptions) Enabled() bool {
+	return o.dir != "" || o.folder != ""
+}
+
+// NewArchive returns the configured archive, or nil if none is. The artifact store is only used by
+// an archive in a folder of the store.
+func (o *ArchiveOptions) NewArchive(store artifacts.ArtifactStore) Archive {
+	switch {
+	case o.dir != "":
+		return NewFileArchive(o.dir)
+	case o.folder != "":
+		return NewArchive(store, o.folder)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+)
+
+func record(name string, at time.Time) Record {
+	return Record{Kind: calculationKind.name, Namespace: "vega", Name: name, ArchivedAt: metav1.NewTime(at), Object: json.RawMessage(`{"metadata":{"name":"` + name + `"}}`)}
+}
+
+func TestArchive(t *testing.T) {
+	first := time.Date(2000, 1, 1, 23, 0, 0, 0, time.UTC)
+	second := time.Date(2000, 1, 2, 1, 0, 0, 0, time.UTC)
+	third := time.Date(2000, 1, 3, 1, 0, 0, 0, time.UTC)
+
+	archives := map[string]func(t *testing.T) (Archive, artifacts.ArtifactStore){
+		"file": func(t *testing.T) (Archive, artifacts.ArtifactStore) {
+			dir := t.TempDir()
+			return NewFileArchive(dir), artifacts.NewLocalStore(dir)
+		},
+		"artifact store": func(t *testing.T) (Archive, artifacts.ArtifactStore) {
+			store := artifacts.NewLocalStore(t.TempDir())
+			return NewArchive(store, "archive"), store
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		from, to time.Time
+		expected []string
+	}{
+		{
+			name:     "the records are read by day in the order they were appended",
+			expected: []string{"calc-1", "calc-2", "calc-4", "calc-3"},
+		},
+		{
+			name:     "the records of the days in the range are read",
+			from:     second.Add(time.Hour),
+			to:       second.Add(2 * time.Hour),
+			expected: []string{"calc-2", "calc-4"},
+		},
+		{
+			name:     "the records from a day are read",
+			from:     third,
+			expected: []string{"calc-3"},
+		},
+	}
+
+	for archiveName, newArchive := range archives {
+		for _, tc := range testCases {
+			t.Run(archiveName+"/"+tc.name, func(t *testing.T) {
+				archive, store := newArchive(t)
+				if err := archive.Append(context.Background(), []Record{record("calc-1", first), record("calc-2", second)}); err != nil {
+					t.Fatal(err)
+				}
+				if err := archive.Append(context.Background(), []Record{record("calc-3", third)}); err != nil {
+					t.Fatal(err)
+				}
+				if err := archive.Append(context.Background(), []Record{record("calc-4", second)}); err != nil {
+					t.Fatal(err)
+				}
+
+				var actual []string
+				if err := archive.Read(context.Background(), tc.from, tc.to, func(r Record) error {
+					actual = append(actual, r.Name)
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(tc.expected, actual); diff != "" {
+					t.Fatal(diff)
+				}
+
+				keys, err := store.List(context.Background(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if archiveName == "file" {
+					expectedKeys := []string{"2000-01-01/objects.jsonl.gz", "2000-01-02/objects.jsonl.gz", "2000-01-03/objects.jsonl.gz"}
+					if diff := cmp.Diff(expectedKeys, keys); diff != "" {
+						t.Fatal(diff)
+					}
+				} else if len(keys) != 4 {
+					t.Fatalf("expected a segment per append and partition, got %v", keys)
+				}
+			})
+		}
+	}
+}
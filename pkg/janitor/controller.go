@@ -0,0 +1,179 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	factoryv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulkfactory/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+)
+
+const (
+	controllerName = "janitor"
+)
+
+// IndexFields indexes the calculations and the bulks by name, which the janitor looks them up with.
+func IndexFields(ctx context.Context, indexer ctrlruntimeclient.FieldIndexer) error {
+	name := func(obj ctrlruntimeclient.Object) []string { return []string{obj.GetName()} }
+	if err := indexer.IndexField(ctx, &v1.Calculation{}, nameField, name); err != nil {
+		return fmt.Errorf("failed to index the calculations by name: %w", err)
+	}
+	if err := indexer.IndexField(ctx, &bulkv1.CalculationBulk{}, nameField, name); err != nil {
+		return fmt.Errorf("failed to index the bulks by name: %w", err)
+	}
+	return nil
+}
+
+// AddToManager adds a controller for every kind of object that the policy of the janitor deletes,
+// which deletes the objects once they expire, and sweeps the artifact folders of the objects that
+// are gone at the given interval. The janitor must use the client of the manager.
+func AddToManager(ctx context.Context, mgr manager.Manager, j *Janitor, artifactInterval time.Duration) error {
+	if err := IndexFields(ctx, mgr.GetFieldIndexer()); err != nil {
+		return err
+	}
+
+	if len(j.policy.Calculations) > 0 {
+		if err := addController(mgr, j, calculationKind, func(c controller.Controller) error {
+			return c.Watch(source.Kind(mgr.GetCache(), &v1.Calculation{}, &objectHandler[*v1.Calculation]{kind: calculationKind}))
+		}); err != nil {
+			return err
+		}
+	}
+	if len(j.policy.Bulks) > 0 {
+		if err := addController(mgr, j, bulkKind, func(c controller.Controller) error {
+			return c.Watch(source.Kind(mgr.GetCache(), &bulkv1.CalculationBulk{}, &objectHandler[*bulkv1.CalculationBulk]{kind: bulkKind}))
+		}); err != nil {
+			return err
+		}
+	}
+	if len(j.policy.Factories) > 0 {
+		if err := addController(mgr, j, factoryKind, func(c controller.Controller) error {
+			return c.Watch(source.Kind(mgr.GetCache(), &factoryv1.CalculationBulkFactory{}, &objectHandler[*factoryv1.CalculationBulkFactory]{kind: factoryKind}))
+		}); err != nil {
+			return err
+		}
+	}
+
+	if j.policy.Artifacts != nil {
+		// Runnables that don't say otherwise only run on the leader.
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			wait.UntilWithContext(ctx, func(ctx context.Context) {
+				if err := j.sweepArtifacts(ctx); err != nil {
+					j.logger.WithError(err).Error("Errors occurred while removing the artifacts")
+				}
+			}, artifactInterval)
+			return nil
+		})); err != nil {
+			return fmt.Errorf("failed to add the sweep of the artifacts: %w", err)
+		}
+	}
+	return nil
+}
+
+func addController(mgr manager.Manager, j *Janitor, k *kind, watch func(controller.Controller) error) error {
+	name := fmt.Sprintf("%s-%s", controllerName, strings.ToLower(k.name))
+	c, err := controller.New(name, mgr, controller.Options{
+		MaxConcurrentReconciles: 1,
+		Reconciler: &reconciler{
+			logger:  logrus.WithField("controller", name),
+			janitor: j,
+			kind:    k,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to construct controller: %w", err)
+	}
+	if err := watch(c); err != nil {
+		return fmt.Errorf("failed to create watch for %s objects: %w", k.name, err)
+	}
+	return nil
+}
+
+// objectHandler enqueues the objects once they finished.
+type objectHandler[T ctrlruntimeclient.Object] struct {
+	kind *kind
+}
+
+func (h *objectHandler[T]) Create(ctx context.Context, e event.TypedCreateEvent[T], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(e.Object, q)
+}
+
+func (h *objectHandler[T]) Update(ctx context.Context, e event.TypedUpdateEvent[T], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(e.ObjectNew, q)
+}
+
+func (h *objectHandler[T]) Delete(ctx context.Context, e event.TypedDeleteEvent[T], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
+func (h *objectHandler[T]) Generic(ctx context.Context, e event.TypedGenericEvent[T], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+}
+
+func (h *objectHandler[T]) enqueue(obj T, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if _, _, finished := h.kind.finished(obj); !finished || obj.GetDeletionTimestamp() != nil {
+		return
+	}
+	q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}})
+}
+
+// reconciler deletes the finished objects of a kind once they expire, and requeues them for when
+// they will.
+type reconciler struct {
+	logger  *logrus.Entry
+	janitor *Janitor
+	kind    *kind
+}
+
+func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := r.logger.WithField("request", req.String())
+	requeueAfter, err := r.reconcile(ctx, req)
+	if err != nil {
+		logger.WithError(err).Error("Reconciliation failed")
+	} else if requeueAfter > 0 {
+		logger.WithField("after", requeueAfter).Debug("Requeued until the object expires")
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, err
+}
+
+func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request) (time.Duration, error) {
+	obj := r.kind.newObject()
+	if err := r.janitor.client.Get(ctx, req.NamespacedName, obj); err != nil {
+		return 0, ctrlruntimeclient.IgnoreNotFound(err)
+	}
+	return r.janitor.expire(ctx, r.kind, obj, nil)
+}
+
+// sweepArtifacts removes the artifact folders of the objects that are gone from the root folders of
+// the objects that exist and the root folders of the policy.
+func (j *Janitor) sweepArtifacts(ctx context.Context) error {
+	roots := sets.New(j.policy.Artifacts.RootFolders...)
+	for _, k := range kinds {
+		if err := j.list(ctx, k, func(obj ctrlruntimeclient.Object) {
+			if root := k.rootFolder(obj); root != "" {
+				roots.Insert(root)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	report := &Report{Objects: make(map[string]int)}
+	err := j.cleanArtifacts(ctx, sets.List(roots), report)
+	j.logger.WithFields(logrus.Fields{"dry-run": j.dryRun, "folders": report.Folders, "bytes": report.Bytes}).Info("Artifacts report")
+	return err
+}
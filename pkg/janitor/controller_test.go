@@ -0,0 +1,94 @@
+package janitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+func TestReconcile(t *testing.T) {
+	policy := &Policy{
+		Calculations: []Rule{{Phases: []v1.CalculationPhase{v1.CompletedPhase}, ResultsCollected: true, Retention: day}},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	collected := map[string]string{util.ResultsCollected: "true"}
+
+	testCases := []struct {
+		name                 string
+		calculation          *v1.Calculation
+		dryRun               bool
+		expectedRequeueAfter time.Duration
+		expectedObjects      []string
+	}{
+		{
+			name:            "expired calculation is deleted",
+			calculation:     calculation("calc", v1.CompletedPhase, old, collected),
+			expectedObjects: nil,
+		},
+		{
+			name:                 "calculation is requeued until it expires",
+			calculation:          calculation("calc", v1.CompletedPhase, recent, collected),
+			expectedRequeueAfter: 23 * time.Hour,
+			expectedObjects:      []string{"calculation/calc"},
+		},
+		{
+			name:            "calculation that no rule matches is not requeued",
+			calculation:     calculation("calc", v1.CompletedPhase, old, nil),
+			expectedObjects: []string{"calculation/calc"},
+		},
+		{
+			name:            "calculation that didn't finish is not requeued",
+			calculation:     calculation("calc", v1.ProcessingPhase, old, collected),
+			expectedObjects: []string{"calculation/calc"},
+		},
+		{
+			name:            "expired calculation is kept in a dry run",
+			calculation:     calculation("calc", v1.CompletedPhase, old, collected),
+			dryRun:          true,
+			expectedObjects: []string{"calculation/calc"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeClient(tc.calculation)
+			j := New(client, nil, nil, policy, "vega", tc.dryRun, 0, testLog)
+			j.now = func() time.Time { return now }
+			r := &reconciler{logger: testLog, janitor: j, kind: calculationKind}
+
+			result, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "calc"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(reconcile.Result{RequeueAfter: tc.expectedRequeueAfter}, result); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(tc.expectedObjects, names(t, client)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestReconcileMissingObject(t *testing.T) {
+	j := New(newFakeClient(), nil, nil, DefaultPolicy(time.Hour), "vega", false, 0, testLog)
+	r := &reconciler{logger: testLog, janitor: j, kind: calculationKind}
+
+	result, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "calc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(reconcile.Result{}, result); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,460 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	factoryv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulkfactory/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/collector"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+const (
+	stagingFolder = "staging"
+	bulkFolder    = "bulk"
+
+	// nameField indexes the calculations and the bulks by name, to look up the owners of the
+	// artifact folders in every namespace.
+	nameField = "metadata.name"
+)
+
+// kind describes how the janitor lists the objects of a kind and tells when they finished.
+type kind struct {
+	name      string
+	rules     func(*Policy) []Rule
+	newObject func() ctrlruntimeclient.Object
+	newList   func() ctrlruntimeclient.ObjectList
+	// finished returns the phase of the finished object and when it finished.
+	finished func(ctrlruntimeclient.Object) (v1.CalculationPhase, time.Time, bool)
+	// rootFolder returns the root folder of the artifacts of the object.
+	rootFolder func(ctrlruntimeclient.Object) string
+	// dependents selects the objects that may be controlled by the object, and are deleted with it.
+	dependents func(ctrlruntimeclient.Object) []dependent
+}
+
+// dependent selects the objects of a kind that may be controlled by another object.
+type dependent struct {
+	kind *kind
+	opts []ctrlruntimeclient.ListOption
+}
+
+var (
+	calculationKind = &kind{
+		name:      "Calculation",
+		rules:     func(p *Policy) []Rule { return p.Calculations },
+		newObject: func() ctrlruntimeclient.Object { return &v1.Calculation{} },
+		newList:   func() ctrlruntimeclient.ObjectList { return &v1.CalculationList{} },
+		finished: func(obj ctrlruntimeclient.Object) (v1.CalculationPhase, time.Time, bool) {
+			return calculationFinished(obj.(*v1.Calculation))
+		},
+		rootFolder: func(obj ctrlruntimeclient.Object) string { return obj.GetLabels()[util.CalcRootFolder] },
+		dependents: func(ctrlruntimeclient.Object) []dependent { return nil },
+	}
+	bulkKind = &kind{
+		name:      "CalculationBulk",
+		rules:     func(p *Policy) []Rule { return p.Bulks },
+		newObject: func() ctrlruntimeclient.Object { return &bulkv1.CalculationBulk{} },
+		newList:   func() ctrlruntimeclient.ObjectList { return &bulkv1.CalculationBulkList{} },
+		finished: func(obj ctrlruntimeclient.Object) (v1.CalculationPhase, time.Time, bool) {
+			return conditionsFinished(obj.(*bulkv1.CalculationBulk).Status.Conditions)
+		},
+		rootFolder: func(obj ctrlruntimeclient.Object) string { return obj.(*bulkv1.CalculationBulk).RootFolder },
+		dependents: func(obj ctrlruntimeclient.Object) []dependent {
+			return []dependent{{kind: calculationKind, opts: []ctrlruntimeclient.ListOption{ctrlruntimeclient.MatchingLabels{util.BulkLabel: obj.GetName()}}}}
+		},
+	}
+	factoryKind = &kind{
+		name:      "CalculationBulkFactory",
+		rules:     func(p *Policy) []Rule { return p.Factories },
+		newObject: func() ctrlruntimeclient.Object { return &factoryv1.CalculationBulkFactory{} },
+		newList:   func() ctrlruntimeclient.ObjectList { return &factoryv1.CalculationBulkFactoryList{} },
+		finished: func(obj ctrlruntimeclient.Object) (v1.CalculationPhase, time.Time, bool) {
+			return factoryFinished(obj.(*factoryv1.CalculationBulkFactory))
+		},
+		rootFolder: func(obj ctrlruntimeclient.Object) string { return obj.(*factoryv1.CalculationBulkFactory).RootFolder },
+		dependents: func(obj ctrlruntimeclient.Object) []dependent {
+			return []dependent{
+				{kind: calculationKind, opts: []ctrlruntimeclient.ListOption{ctrlruntimeclient.MatchingLabels{util.FactoryLabel: obj.GetName()}}},
+				{kind: bulkKind},
+			}
+		},
+	}
+
+	kinds = []*kind{calculationKind, bulkKind, factoryKind}
+)
+
+func kindByName(name string) *kind {
+	for _, k := range kinds {
+		if k.name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+// Janitor deletes the objects that expired by its policy and removes the artifacts that the objects
+// which are gone left behind.
+type Janitor struct {
+	client    ctrlruntimeclient.Client
+	artifacts artifacts.ArtifactStore
+	archive   Archive
+	policy    *Policy
+	namespace string
+	dryRun    bool
+	pageSize  int64
+	logger    *logrus.Entry
+	now       func() time.Time
+}
+
+// New returns a janitor for the objects in the namespace, or in every namespace if it's empty. The
+// artifact store is only used by the artifact policy. The objects are archived before they are
+// deleted, unless the archive is nil. In a dry run nothing is deleted, and what would
+// be deleted is only reported. The objects are listed in pages of the given size, or all at once if
+// it's zero, which is what the cached clients support. The client must be able to list the
+// calculations and the bulks by their name.
+func New(client ctrlruntimeclient.Client, artifactStore artifacts.ArtifactStore, archive Archive, policy *Policy, namespace string, dryRun bool, pageSize int64, logger *logrus.Entry) *Janitor {
+	return &Janitor{
+		client:    client,
+		artifacts: artifactStore,
+		archive:   archive,
+		policy:    policy,
+		namespace: namespace,
+		dryRun:    dryRun,
+		pageSize:  pageSize,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Report counts what the janitor deleted, or would delete in a dry run.
+type Report struct {
+	// Objects counts the deleted objects by kind.
+	Objects map[string]int
+	// Archived is the number of the archived objects, including the dependents of the deleted objects.
+	Archived int
+	// Folders is the number of the removed artifact folders.
+	Folders int
+	// Bytes is the size of the artifacts in the removed folders.
+	Bytes int64
+}
+
+func (r *Report) addObject(kind string) {
+	if r != nil {
+		r.Objects[kind]++
+	}
+}
+
+func (r *Report) addArchived(count int) {
+	if r != nil {
+		r.Archived += count
+	}
+}
+
+func (r *Report) addFolder(size int64) {
+	if r != nil {
+		r.Folders++
+		r.Bytes += size
+	}
+}
+
+// Clean deletes the expired objects and removes the artifact folders of the objects that are gone.
+func (j *Janitor) Clean(ctx context.Context) (*Report, error) {
+	report := &Report{Objects: make(map[string]int)}
+	roots := sets.New[string]()
+
+	var errs []error
+	for _, k := range kinds {
+		if len(k.rules(j.policy)) == 0 && j.policy.Artifacts == nil {
+			continue
+		}
+		if err := j.list(ctx, k, func(obj ctrlruntimeclient.Object) {
+			if root := k.rootFolder(obj); root != "" {
+				roots.Insert(root)
+			}
+			if _, err := j.expire(ctx, k, obj, report); err != nil {
+				errs = append(errs, err)
+			}
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if j.policy.Artifacts != nil {
+		errs = append(errs, j.cleanArtifacts(ctx, sets.List(roots.Insert(j.policy.Artifacts.RootFolders...)), report))
+	}
+
+	fields := logrus.Fields{"dry-run": j.dryRun, "archived": report.Archived, "folders": report.Folders, "bytes": report.Bytes}
+	for kind, count := range report.Objects {
+		fields[kind] = count
+	}
+	j.logger.WithFields(fields).Info("Cleanup report")
+	return report, utilerrors.NewAggregate(errs)
+}
+
+// list calls fn with every object of the kind. The objects are listed in pages, so that a large
+// number of them never has to be sent in a single response.
+func (j *Janitor) list(ctx context.Context, k *kind, fn func(ctrlruntimeclient.Object)) error {
+	opts := []ctrlruntimeclient.ListOption{ctrlruntimeclient.InNamespace(j.namespace)}
+	if j.pageSize > 0 {
+		opts = append(opts, ctrlruntimeclient.Limit(j.pageSize))
+	}
+
+	var continueToken string
+	for {
+		list := k.newList()
+		if err := j.client.List(ctx, list, append(opts, ctrlruntimeclient.Continue(continueToken))...); err != nil {
+			return fmt.Errorf("couldn't list %s objects: %w", k.name, err)
+		}
+		if err := meta.EachListItem(list, func(obj runtime.Object) error {
+			fn(obj.(ctrlruntimeclient.Object))
+			return nil
+		}); err != nil {
+			return err
+		}
+		if continueToken = list.GetContinue(); continueToken == "" || j.pageSize == 0 {
+			return nil
+		}
+	}
+}
+
+// calculationFinished returns the phase of the finished calculation and when it finished. The
+// calculations that finished before their completion time was recorded finished after they started.
+func calculationFinished(calc *v1.Calculation) (v1.CalculationPhase, time.Time, bool) {
+	switch calc.Phase {
+	case v1.CompletedPhase, v1.FailedPhase, v1.CachedPhase:
+	default:
+		return "", time.Time{}, false
+	}
+	if calc.Status.CompletionTime != nil {
+		return calc.Phase, calc.Status.CompletionTime.Time, true
+	}
+	return calc.Phase, calc.Status.StartTime.Time, true
+}
+
+// conditionsFinished returns the phase of the object that finished once it became ready, and when
+// it became ready.
+func conditionsFinished(conditions []metav1.Condition) (v1.CalculationPhase, time.Time, bool) {
+	ready := meta.FindStatusCondition(conditions, v1.ReadyCondition)
+	if ready == nil || ready.Status != metav1.ConditionTrue {
+		return "", time.Time{}, false
+	}
+	if meta.IsStatusConditionTrue(conditions, v1.DegradedCondition) {
+		return v1.FailedPhase, ready.LastTransitionTime.Time, true
+	}
+	return v1.CompletedPhase, ready.LastTransitionTime.Time, true
+}
+
+// factoryFinished returns the phase of the factory once its calculation finished, and when it did.
+func factoryFinished(factory *factoryv1.CalculationBulkFactory) (v1.CalculationPhase, time.Time, bool) {
+	if factory.Status.CompletionTime == nil {
+		return "", time.Time{}, false
+	}
+	if meta.IsStatusConditionTrue(factory.Status.Conditions, v1.DegradedCondition) {
+		return v1.FailedPhase, factory.Status.CompletionTime.Time, true
+	}
+	return v1.CompletedPhase, factory.Status.CompletionTime.Time, true
+}
+
+// expire deletes the object if it expired by the rules of its kind, and otherwise returns how long
+// it has until it expires. The objects that didn't finish, that no rule matches, that are already
+// deleted or that were restored from the archive never expire, and neither do the expired objects
+// of a dry run, which are only reported. The object is archived with its dependents before it's
+// deleted, and the dependents are deleted in the background.
+func (j *Janitor) expire(ctx context.Context, k *kind, obj ctrlruntimeclient.Object, report *Report) (time.Duration, error) {
+	if obj.GetDeletionTimestamp() != nil {
+		return 0, nil
+	}
+	if _, ok := obj.GetAnnotations()[RestoredAnnotation]; ok {
+		return 0, nil
+	}
+	phase, finished, ok := k.finished(obj)
+	if !ok {
+		return 0, nil
+	}
+	expires, ok := expiry(k.rules(j.policy), phase, obj.GetLabels(), finished)
+	if !ok {
+		return 0, nil
+	}
+	if remaining := expires.Sub(j.now()); remaining > 0 {
+		return remaining, nil
+	}
+
+	logger := j.logger.WithFields(logrus.Fields{"kind": k.name, "namespace": obj.GetNamespace(), "name": obj.GetName(), "phase": phase})
+	if j.dryRun {
+		report.addObject(k.name)
+		logger.Info("Would delete the expired object")
+		return 0, nil
+	}
+
+	if j.archive != nil {
+		records, err := j.records(ctx, k, obj, j.now())
+		if err != nil {
+			return 0, err
+		}
+		if err := j.archive.Append(ctx, records); err != nil {
+			return 0, fmt.Errorf("couldn't archive %s %s: %w", k.name, obj.GetName(), err)
+		}
+		report.addArchived(len(records))
+		for _, record := range records {
+			archivedObjects.WithLabelValues(record.Kind).Inc()
+		}
+	}
+
+	if err := j.client.Delete(ctx, obj, ctrlruntimeclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		if kerrors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("couldn't delete %s %s: %w", k.name, obj.GetName(), err)
+	}
+	report.addObject(k.name)
+	deletedObjects.WithLabelValues(k.name, string(phase)).Inc()
+	logger.Info("Deleted the expired object")
+	return 0, nil
+}
+
+// records returns the records of the object and of the objects that it controls, recursively. They
+// are garbage collected once the object is deleted, so they are archived with it.
+func (j *Janitor) records(ctx context.Context, k *kind, obj ctrlruntimeclient.Object, at time.Time) ([]Record, error) {
+	record, err := newRecord(k, obj, at)
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{record}
+
+	for _, d := range k.dependents(obj) {
+		list := d.kind.newList()
+		if err := j.client.List(ctx, list, append([]ctrlruntimeclient.ListOption{ctrlruntimeclient.InNamespace(obj.GetNamespace())}, d.opts...)...); err != nil {
+			return nil, fmt.Errorf("couldn't list the %s objects of %s %s: %w", d.kind.name, k.name, obj.GetName(), err)
+		}
+		if err := meta.EachListItem(list, func(item runtime.Object) error {
+			dependent := item.(ctrlruntimeclient.Object)
+			if !metav1.IsControlledBy(dependent, obj) {
+				return nil
+			}
+			dependentRecords, err := j.records(ctx, d.kind, dependent, at)
+			records = append(records, dependentRecords...)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// cleanArtifacts removes the staging folders of the calculations and the output folders of the bulks
+// that are gone from the root folders. The folders are listed before their objects are looked up, and
+// the workers leave output files only for the calculations that they already run, so the folders of
+// the objects that are created in the meantime are never taken for folders of objects that are gone.
+func (j *Janitor) cleanArtifacts(ctx context.Context, roots []string, report *Report) error {
+	staging := make(map[string]string)
+	outputs := make(map[string]string)
+	for _, root := range roots {
+		if j.policy.Artifacts.StagingOutputs {
+			folder := util.OutputStagingFolderKey(root)
+			keys, err := j.artifacts.List(ctx, folder)
+			if err != nil {
+				return fmt.Errorf("couldn't list the staging folder of %s: %w", root, err)
+			}
+			for _, key := range keys {
+				name, _, _ := strings.Cut(strings.TrimPrefix(key, folder+"/"), "/")
+				staging[path.Join(folder, name)] = name
+			}
+		}
+		if j.policy.Artifacts.BulkOutputs {
+			keys, err := j.artifacts.List(ctx, root)
+			if err != nil {
+				return fmt.Errorf("couldn't list the root folder %s: %w", root, err)
+			}
+			for _, key := range keys {
+				// The output files of the calculations of a bulk are collected in <root>/<bulk>/<calculation>.
+				parts := strings.Split(strings.TrimPrefix(key, root+"/"), "/")
+				if len(parts) != 3 || parts[2] != collector.ManifestFile || parts[0] == util.OutputStagingFolderKey("") {
+					continue
+				}
+				outputs[path.Join(root, parts[0])] = parts[0]
+			}
+		}
+	}
+
+	var errs []error
+	for _, key := range sortedKeys(staging) {
+		exists, err := j.exists(ctx, &v1.CalculationList{}, ctrlruntimeclient.MatchingFields{nameField: staging[key]})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !exists {
+			errs = append(errs, j.removeFolder(ctx, key, stagingFolder, report))
+		}
+	}
+	for _, key := range sortedKeys(outputs) {
+		// The results of the bulks that were deleted but whose calculations were orphaned are kept with the calculations.
+		exists, err := j.exists(ctx, &bulkv1.CalculationBulkList{}, ctrlruntimeclient.MatchingFields{nameField: outputs[key]})
+		if err == nil && !exists {
+			exists, err = j.exists(ctx, &v1.CalculationList{}, ctrlruntimeclient.MatchingLabels{util.BulkLabel: outputs[key]})
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !exists {
+			errs = append(errs, j.removeFolder(ctx, key, bulkFolder, report))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// exists reports whether any object in the namespace of the janitor matches the selector.
+func (j *Janitor) exists(ctx context.Context, list ctrlruntimeclient.ObjectList, selector ctrlruntimeclient.ListOption) (bool, error) {
+	if err := j.client.List(ctx, list, ctrlruntimeclient.InNamespace(j.namespace), selector); err != nil {
+		return false, fmt.Errorf("couldn't look up the objects of the artifact folders: %w", err)
+	}
+	return meta.LenList(list) > 0, nil
+}
+
+func (j *Janitor) removeFolder(ctx context.Context, key, folder string, report *Report) error {
+	size, err := j.artifacts.Size(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	logger := j.logger.WithFields(logrus.Fields{"folder": key, "bytes": size})
+	if j.dryRun {
+		report.addFolder(size)
+		logger.Info("Would remove the folder of an object that is gone")
+		return nil
+	}
+
+	if err := j.artifacts.Remove(ctx, key); err != nil {
+		return err
+	}
+	report.addFolder(size)
+	deletedBytes.WithLabelValues(folder).Add(float64(size))
+	logger.Info("Removed the folder of an object that is gone")
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
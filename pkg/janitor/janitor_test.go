@@ -0,0 +1,360 @@
+package janitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	factoryv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulkfactory/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+var (
+	now     = time.Date(2000, 1, 10, 0, 0, 0, 0, time.UTC)
+	old     = metav1.NewTime(now.Add(-48 * time.Hour))
+	recent  = metav1.NewTime(now.Add(-time.Hour))
+	day     = metav1.Duration{Duration: 24 * time.Hour}
+	testLog = logrus.WithField("test", "janitor")
+)
+
+func calculation(name string, phase v1.CalculationPhase, completed metav1.Time, labels map[string]string) *v1.Calculation {
+	return &v1.Calculation{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vega", Labels: labels},
+		Phase:      phase,
+		Status:     v1.CalculationStatus{CompletionTime: &completed},
+	}
+}
+
+func bulk(name string, ready metav1.ConditionStatus, degraded metav1.ConditionStatus, transition metav1.Time) *bulkv1.CalculationBulk {
+	return &bulkv1.CalculationBulk{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vega"},
+		RootFolder: "vega",
+		Status: bulkv1.CalculationBulkStatus{
+			Conditions: []metav1.Condition{
+				{Type: v1.ReadyCondition, Status: ready, LastTransitionTime: transition},
+				{Type: v1.DegradedCondition, Status: degraded, LastTransitionTime: transition},
+			},
+		},
+	}
+}
+
+func newFakeClient(objs ...ctrlruntimeclient.Object) ctrlruntimeclient.Client {
+	name := func(obj ctrlruntimeclient.Object) []string { return []string{obj.GetName()} }
+	return fakectrlruntimeclient.NewClientBuilder().
+		WithIndex(&v1.Calculation{}, nameField, name).
+		WithIndex(&bulkv1.CalculationBulk{}, nameField, name).
+		WithObjects(objs...).
+		Build()
+}
+
+func names(t *testing.T, client ctrlruntimeclient.Client) []string {
+	t.Helper()
+	var result []string
+	var calculations v1.CalculationList
+	if err := client.List(context.Background(), &calculations); err != nil {
+		t.Fatal(err)
+	}
+	for _, calc := range calculations.Items {
+		result = append(result, "calculation/"+calc.Name)
+	}
+	var bulks bulkv1.CalculationBulkList
+	if err := client.List(context.Background(), &bulks); err != nil {
+		t.Fatal(err)
+	}
+	for _, bulk := range bulks.Items {
+		result = append(result, "bulk/"+bulk.Name)
+	}
+	var factories factoryv1.CalculationBulkFactoryList
+	if err := client.List(context.Background(), &factories); err != nil {
+		t.Fatal(err)
+	}
+	for _, factory := range factories.Items {
+		result = append(result, "factory/"+factory.Name)
+	}
+	return result
+}
+
+func TestClean(t *testing.T) {
+	policy := &Policy{
+		Calculations: []Rule{
+			{Phases: []v1.CalculationPhase{v1.CompletedPhase}, ResultsCollected: true, Retention: day},
+			{
+				Phases:    []v1.CalculationPhase{v1.FailedPhase, v1.CachedPhase},
+				Selector:  &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: util.BulkLabel, Operator: metav1.LabelSelectorOpDoesNotExist}}},
+				Retention: day,
+			},
+		},
+		Bulks:     []Rule{{Phases: []v1.CalculationPhase{v1.FailedPhase}, Retention: day}},
+		Factories: []Rule{{Retention: day}},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	collected := map[string]string{util.ResultsCollected: "true"}
+
+	objects := []ctrlruntimeclient.Object{
+		calculation("completed-collected", v1.CompletedPhase, old, collected),
+		calculation("completed-recently", v1.CompletedPhase, recent, collected),
+		calculation("completed-uncollected", v1.CompletedPhase, old, nil),
+		calculation("failed", v1.FailedPhase, old, nil),
+		calculation("failed-of-bulk", v1.FailedPhase, old, map[string]string{util.BulkLabel: "bulk"}),
+		calculation("cached", v1.CachedPhase, old, nil),
+		calculation("processing", v1.ProcessingPhase, old, nil),
+		bulk("bulk-failed", metav1.ConditionTrue, metav1.ConditionTrue, old),
+		bulk("bulk-completed", metav1.ConditionTrue, metav1.ConditionFalse, old),
+		bulk("bulk-processing", metav1.ConditionFalse, metav1.ConditionTrue, old),
+		&factoryv1.CalculationBulkFactory{
+			ObjectMeta: metav1.ObjectMeta{Name: "factory-completed", Namespace: "vega"},
+			Status:     factoryv1.CalculationBulkFactoryStatus{CompletionTime: &old},
+		},
+		&factoryv1.CalculationBulkFactory{ObjectMeta: metav1.ObjectMeta{Name: "factory-running", Namespace: "vega"}},
+	}
+
+	testCases := []struct {
+		name            string
+		dryRun          bool
+		expectedObjects []string
+	}{
+		{
+			name: "expired objects are deleted",
+			expectedObjects: []string{
+				"calculation/completed-recently",
+				"calculation/completed-uncollected",
+				"calculation/failed-of-bulk",
+				"calculation/processing",
+				"bulk/bulk-completed",
+				"bulk/bulk-processing",
+				"factory/factory-running",
+			},
+		},
+		{
+			name:   "nothing is deleted in a dry run",
+			dryRun: true,
+			expectedObjects: []string{
+				"calculation/cached",
+				"calculation/completed-collected",
+				"calculation/completed-recently",
+				"calculation/completed-uncollected",
+				"calculation/failed",
+				"calculation/failed-of-bulk",
+				"calculation/processing",
+				"bulk/bulk-completed",
+				"bulk/bulk-failed",
+				"bulk/bulk-processing",
+				"factory/factory-completed",
+				"factory/factory-running",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var initial []ctrlruntimeclient.Object
+			for _, obj := range objects {
+				initial = append(initial, obj.DeepCopyObject().(ctrlruntimeclient.Object))
+			}
+			client := newFakeClient(initial...)
+			j := New(client, nil, nil, policy, "vega", tc.dryRun, 2, testLog)
+			j.now = func() time.Time { return now }
+
+			report, err := j.Clean(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedReport := &Report{Objects: map[string]int{calculationKind.name: 3, bulkKind.name: 1, factoryKind.name: 1}}
+			if diff := cmp.Diff(expectedReport, report); diff != "" {
+				t.Fatal(diff)
+			}
+			if diff := cmp.Diff(tc.expectedObjects, names(t, client)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCleanDefaultPolicy(t *testing.T) {
+	collected := map[string]string{util.ResultsCollected: "true"}
+	started := func(name string, start time.Time, labels map[string]string) ctrlruntimeclient.Object {
+		return &v1.Calculation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Phase:      v1.CompletedPhase,
+			Status:     v1.CalculationStatus{StartTime: metav1.NewTime(start)},
+		}
+	}
+	expired := now.Add(-time.Hour)
+
+	testCases := []struct {
+		name         string
+		calculations []ctrlruntimeclient.Object
+		expected     []string
+	}{
+		{
+			name: "no calculation expired, no delete expected",
+			calculations: []ctrlruntimeclient.Object{
+				started("calc-1", now, nil),
+				started("calc-2", now, collected),
+				started("calc-3", now.Add(-5*time.Minute), collected),
+			},
+			expected: []string{"calculation/calc-1", "calculation/calc-2", "calculation/calc-3"},
+		},
+		{
+			name: "a calculation expired, delete expected",
+			calculations: []ctrlruntimeclient.Object{
+				started("calc-1", now, nil),
+				started("calc-2", now, collected),
+				started("calc-3", expired, collected),
+			},
+			expected: []string{"calculation/calc-1", "calculation/calc-2"},
+		},
+		{
+			name: "all calculation expired, delete expected",
+			calculations: []ctrlruntimeclient.Object{
+				started("calc-1", expired, collected),
+				started("calc-2", expired, collected),
+				started("calc-3", expired, collected),
+			},
+		},
+		{
+			name: "calculations expired but there is one with no results collected, expected to skip the one",
+			calculations: []ctrlruntimeclient.Object{
+				started("calc-1", expired, collected),
+				started("calc-2", expired, collected),
+				started("calc-3", expired, nil),
+			},
+			expected: []string{"calculation/calc-3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := DefaultPolicy(10 * time.Minute)
+			if err := policy.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			client := newFakeClient(tc.calculations...)
+			j := New(client, nil, nil, policy, "", false, 0, testLog)
+			j.now = func() time.Time { return now }
+
+			if _, err := j.Clean(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, names(t, client)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCleanArtifacts(t *testing.T) {
+	files := map[string]string{
+		"vega/.outputs/calc-running/fort.7":     "running",
+		"vega/.outputs/calc-gone/fort.7":        "gone",
+		"vega/.outputs/calc-gone/fort.8":        "gone",
+		"vega/bulk/calc/manifest.json":          "{}",
+		"vega/bulk-gone/calc/manifest.json":     "{}",
+		"vega/bulk-gone/calc/fort.7":            "results",
+		"vega/bulk-orphaned/calc/fort.7":        "results",
+		"vega/bulk-orphaned/calc/manifest.json": "{}",
+		"vega/atlas-data-files/molecules.dat":   "molecules",
+		"extra/.outputs/calc-gone/fort.7":       "gone",
+	}
+
+	testCases := []struct {
+		name           string
+		dryRun         bool
+		expectedReport *Report
+		expectedFiles  []string
+	}{
+		{
+			name:           "the folders of the objects that are gone are removed",
+			expectedReport: &Report{Objects: map[string]int{}, Folders: 3, Bytes: 21},
+			expectedFiles: []string{
+				"extra/.outputs",
+				"vega/.outputs/calc-running/fort.7",
+				"vega/atlas-data-files/molecules.dat",
+				"vega/bulk/calc/manifest.json",
+				"vega/bulk-orphaned/calc/fort.7",
+				"vega/bulk-orphaned/calc/manifest.json",
+			},
+		},
+		{
+			name:           "nothing is removed in a dry run",
+			dryRun:         true,
+			expectedReport: &Report{Objects: map[string]int{}, Folders: 3, Bytes: 21},
+			expectedFiles: []string{
+				"extra/.outputs/calc-gone/fort.7",
+				"vega/.outputs/calc-gone/fort.7",
+				"vega/.outputs/calc-gone/fort.8",
+				"vega/.outputs/calc-running/fort.7",
+				"vega/atlas-data-files/molecules.dat",
+				"vega/bulk/calc/manifest.json",
+				"vega/bulk-gone/calc/fort.7",
+				"vega/bulk-gone/calc/manifest.json",
+				"vega/bulk-orphaned/calc/fort.7",
+				"vega/bulk-orphaned/calc/manifest.json",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range files {
+				path := filepath.Join(root, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			policy := &Policy{Artifacts: &ArtifactPolicy{StagingOutputs: true, BulkOutputs: true, RootFolders: []string{"extra"}}}
+			client := newFakeClient(
+				calculation("calc-running", v1.ProcessingPhase, recent, map[string]string{util.CalcRootFolder: "vega"}),
+				calculation("calc-orphaned", v1.CompletedPhase, recent, map[string]string{util.BulkLabel: "bulk-orphaned"}),
+				bulk("bulk", metav1.ConditionTrue, metav1.ConditionFalse, recent),
+			)
+			j := New(client, artifacts.NewLocalStore(root), nil, policy, "vega", tc.dryRun, 0, testLog)
+
+			report, err := j.Clean(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedReport, report); diff != "" {
+				t.Fatal(diff)
+			}
+
+			var actualFiles []string
+			if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				entries, _ := os.ReadDir(path)
+				if !info.IsDir() || len(entries) == 0 {
+					rel, _ := filepath.Rel(root, path)
+					actualFiles = append(actualFiles, filepath.ToSlash(rel))
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expectedFiles, actualFiles); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
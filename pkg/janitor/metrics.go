@@ -0,0 +1,42 @@
+package janitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// pushJob is the job that the metrics are grouped by in the Pushgateway.
+const pushJob = "vega-janitor"
+
+var (
+	deletedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "janitor",
+		Name:      "deleted_objects_total",
+		Help:      "Number of objects that the janitor deleted, by kind and phase",
+	}, []string{"kind", "phase"})
+	archivedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "janitor",
+		Name:      "archived_objects_total",
+		Help:      "Number of objects that the janitor archived before deleting them, by kind",
+	}, []string{"kind"})
+	deletedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "janitor",
+		Name:      "deleted_bytes_total",
+		Help:      "Size of the artifacts that the janitor removed, by the type of their folder",
+	}, []string{"folder"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(deletedObjects, archivedObjects, deletedBytes)
+}
+
+// PushMetrics pushes the metrics of the janitor to the Prometheus Pushgateway at the given URL,
+// for the runs that exit before their metrics can be scraped. The metrics of the previous run
+// are replaced.
+func PushMetrics(url string) error {
+	return push.New(url, pushJob).Collector(deletedObjects).Collector(archivedObjects).Collector(deletedBytes).Push()
+}
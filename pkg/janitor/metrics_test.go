@@ -0,0 +1,33 @@
+package janitor
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushMetrics(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	deletedObjects.WithLabelValues("Calculation", "Completed").Inc()
+	if err := PushMetrics(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/vega-janitor" {
+		t.Fatalf("expected the metrics to replace the ones of the janitor job, got %s %s", method, path)
+	}
+	if !strings.Contains(body, "vega_janitor_deleted_objects_total") {
+		t.Fatal("expected the deleted objects to be pushed")
+	}
+}
@@ -0,0 +1,156 @@
+package janitor
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+// Policy selects the objects that the janitor deletes and the artifacts that it removes. An object
+// is deleted by the first rule of its kind that matches it, once the retention of the rule passed
+// since the object finished. Objects that no rule matches are kept.
+type Policy struct {
+	Calculations []Rule `json:"calculations,omitempty"`
+	// Bulks are deleted with their calculations and the output files they collected.
+	Bulks []Rule `json:"bulks,omitempty"`
+	// Factories are deleted with the bulks they generated.
+	Factories []Rule          `json:"factories,omitempty"`
+	Artifacts *ArtifactPolicy `json:"artifacts,omitempty"`
+}
+
+// Rule matches finished objects by their phase and their labels. The bulks and the factories are
+// in the Completed phase once they finished, or in the Failed phase if they are degraded.
+type Rule struct {
+	// Phases are the phases that the rule matches, every phase of the finished objects if empty.
+	Phases []v1.CalculationPhase `json:"phases,omitempty"`
+	// Selector matches the labels of the objects, every object if not set.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// ResultsCollected matches only the calculations whose output files were collected.
+	ResultsCollected bool `json:"resultsCollected,omitempty"`
+	// Retention is how long the objects are kept after they finished.
+	Retention metav1.Duration `json:"retention"`
+
+	selector labels.Selector
+}
+
+// ArtifactPolicy selects the folders that the objects which are gone left in the artifact store.
+type ArtifactPolicy struct {
+	// StagingOutputs removes the folders in <root>/.outputs of the calculations that are gone,
+	// whose output files were never collected.
+	StagingOutputs bool `json:"stagingOutputs,omitempty"`
+	// BulkOutputs removes the <root>/<bulk> folders with the collected output files of the bulks
+	// that are gone, once none of their calculations is left.
+	BulkOutputs bool `json:"bulkOutputs,omitempty"`
+	// RootFolders are swept in addition to the root folders of the existing bulks, factories and
+	// calculations.
+	RootFolders []string `json:"rootFolders,omitempty"`
+}
+
+// DefaultPolicy deletes the completed calculations whose output files were collected, once the
+// retention passed. It is the policy of the janitor when no policy file is given.
+func DefaultPolicy(retention time.Duration) *Policy {
+	return &Policy{
+		Calculations: []Rule{{
+			Phases:           []v1.CalculationPhase{v1.CompletedPhase},
+			ResultsCollected: true,
+			Retention:        metav1.Duration{Duration: retention},
+		}},
+	}
+}
+
+// LoadPolicy reads and validates the policy in the given YAML file.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the policy file: %w", err)
+	}
+	policy := &Policy{}
+	if err := yaml.Unmarshal(b, policy); err != nil {
+		return nil, fmt.Errorf("couldn't parse the policy file: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return policy, nil
+}
+
+// Validate checks the rules of the policy and parses their selectors. Policies must be validated
+// before they are used.
+func (p *Policy) Validate() error {
+	calculationPhases := []v1.CalculationPhase{v1.CompletedPhase, v1.FailedPhase, v1.CachedPhase}
+	phases := []v1.CalculationPhase{v1.CompletedPhase, v1.FailedPhase}
+
+	var errs []error
+	errs = append(errs, validateRules("calculations", p.Calculations, calculationPhases, true)...)
+	errs = append(errs, validateRules("bulks", p.Bulks, phases, false)...)
+	errs = append(errs, validateRules("factories", p.Factories, phases, false)...)
+	return utilerrors.NewAggregate(errs)
+}
+
+func validateRules(kind string, rules []Rule, phases []v1.CalculationPhase, calculations bool) []error {
+	var errs []error
+	for i := range rules {
+		rule := &rules[i]
+		for _, phase := range rule.Phases {
+			if !containsPhase(phases, phase) {
+				errs = append(errs, fmt.Errorf("%s[%d]: phase %q is not one of %v", kind, i, phase, phases))
+			}
+		}
+		if rule.ResultsCollected && !calculations {
+			errs = append(errs, fmt.Errorf("%s[%d]: resultsCollected only applies to calculations", kind, i))
+		}
+		if rule.Retention.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s[%d]: retention must be positive", kind, i))
+		}
+		if rule.Selector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(rule.Selector)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s[%d]: invalid selector: %w", kind, i, err))
+				continue
+			}
+			rule.selector = selector
+		}
+	}
+	return errs
+}
+
+func containsPhase(phases []v1.CalculationPhase, phase v1.CalculationPhase) bool {
+	for _, p := range phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matches(phase v1.CalculationPhase, objectLabels map[string]string) bool {
+	if len(r.Phases) > 0 && !containsPhase(r.Phases, phase) {
+		return false
+	}
+	if r.ResultsCollected {
+		if _, ok := objectLabels[util.ResultsCollected]; !ok {
+			return false
+		}
+	}
+	return r.selector == nil || r.selector.Matches(labels.Set(objectLabels))
+}
+
+// expiry returns when the object that finished in the given phase expires by the first of the rules
+// that matches it, and false if none matches.
+func expiry(rules []Rule, phase v1.CalculationPhase, objectLabels map[string]string, finished time.Time) (time.Time, bool) {
+	for i := range rules {
+		if rules[i].matches(phase, objectLabels) {
+			return finished.Add(rules[i].Retention.Duration), true
+		}
+	}
+	return time.Time{}, false
+}
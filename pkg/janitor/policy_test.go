@@ -0,0 +1,155 @@
+package janitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	testCases := []struct {
+		name          string
+		policy        string
+		errorExpected bool
+	}{
+		{
+			name: "valid policy",
+			policy: `
+calculations:
+- phases: [Completed]
+  resultsCollected: true
+  retention: 24h
+- phases: [Failed, Cached]
+  selector:
+    matchExpressions:
+    - key: vegaproject.io/bulk
+      operator: DoesNotExist
+  retention: 168h
+bulks:
+- phases: [Completed]
+  retention: 72h
+factories:
+- retention: 720h
+artifacts:
+  stagingOutputs: true
+  bulkOutputs: true
+  rootFolders: [vega]
+`,
+		},
+		{
+			name: "unknown phase",
+			policy: `
+bulks:
+- phases: [Cached]
+  retention: 24h
+`,
+			errorExpected: true,
+		},
+		{
+			name: "missing retention",
+			policy: `
+calculations:
+- phases: [Failed]
+`,
+			errorExpected: true,
+		},
+		{
+			name: "results collected only applies to calculations",
+			policy: `
+factories:
+- resultsCollected: true
+  retention: 24h
+`,
+			errorExpected: true,
+		},
+		{
+			name: "invalid selector",
+			policy: `
+calculations:
+- selector:
+    matchExpressions:
+    - key: vegaproject.io/bulk
+      operator: Maybe
+  retention: 24h
+`,
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(path, []byte(tc.policy), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadPolicy(path); (err != nil) != tc.errorExpected {
+				t.Fatalf("expected error: %t, got %v", tc.errorExpected, err)
+			}
+		})
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(`
+calculations:
+- phases: [Completed]
+  resultsCollected: true
+  retention: 1h
+- selector:
+    matchLabels:
+      team: stars
+  retention: 2h
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		phase    v1.CalculationPhase
+		labels   map[string]string
+		expected time.Duration
+		matches  bool
+	}{
+		{
+			name:     "the first matching rule applies",
+			phase:    v1.CompletedPhase,
+			labels:   map[string]string{util.ResultsCollected: "true", "team": "stars"},
+			expected: time.Hour,
+			matches:  true,
+		},
+		{
+			name:     "calculations whose results weren't collected fall through",
+			phase:    v1.CompletedPhase,
+			labels:   map[string]string{"team": "stars"},
+			expected: 2 * time.Hour,
+			matches:  true,
+		},
+		{
+			name:   "no rule matches",
+			phase:  v1.FailedPhase,
+			labels: map[string]string{"team": "planets"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expires, ok := expiry(policy.Calculations, tc.phase, tc.labels, finished)
+			if ok != tc.matches {
+				t.Fatalf("expected a match: %t, got %t", tc.matches, ok)
+			}
+			if ok && expires.Sub(finished) != tc.expected {
+				t.Fatalf("expected the retention %v, got %v", tc.expected, expires.Sub(finished))
+			}
+		})
+	}
+}
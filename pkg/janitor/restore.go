@@ -0,0 +1,156 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+// RestoredAnnotation records when the object was restored from the archive. The janitor keeps the
+// restored objects until they are deleted by hand.
+const RestoredAnnotation = "vegaproject.io/restored"
+
+// Filter selects the records of the archive. The empty fields match every record.
+type Filter struct {
+	// From and To select the days the records were archived, both included.
+	From, To  time.Time
+	Kind      string
+	Namespace string
+	Name      string
+	// Bulk matches the bulk with the name and the calculations that belong to it.
+	Bulk string
+}
+
+func (f *Filter) matches(record Record, obj ctrlruntimeclient.Object) bool {
+	if f.Kind != "" && record.Kind != f.Kind || f.Namespace != "" && record.Namespace != f.Namespace || f.Name != "" && record.Name != f.Name {
+		return false
+	}
+	if f.Bulk == "" {
+		return true
+	}
+	switch record.Kind {
+	case bulkKind.name:
+		return record.Name == f.Bulk
+	case calculationKind.name:
+		return obj.GetLabels()[util.BulkLabel] == f.Bulk
+	}
+	return false
+}
+
+// Query calls fn with the records of the archive that match the filter, day by day in the order they were archived.
+func Query(ctx context.Context, archive Archive, filter Filter, fn func(Record) error) error {
+	return archive.Read(ctx, filter.From, filter.To, func(record Record) error {
+		obj, err := record.decode()
+		if err != nil {
+			return err
+		}
+		if !filter.matches(record, obj) {
+			return nil
+		}
+		return fn(record)
+	})
+}
+
+// Restore recreates the bulk in the namespace with its calculations, as they were archived the last
+// time. The restored objects are annotated with RestoredAnnotation, and the calculations are
+// controlled by the restored bulk. The bulk isn't controlled by its factory anymore. The objects
+// that already exist are kept, and in a dry run nothing is created.
+func Restore(ctx context.Context, client ctrlruntimeclient.Client, archive Archive, namespace, name string, from, to time.Time, dryRun bool, logger *logrus.Entry) error {
+	var bulk *bulkv1.CalculationBulk
+	calculations := make(map[types.UID]*v1.Calculation)
+	var order []types.UID
+	filter := Filter{From: from, To: to, Namespace: namespace, Bulk: name}
+	if err := archive.Read(ctx, from, to, func(record Record) error {
+		obj, err := record.decode()
+		if err != nil {
+			return err
+		}
+		if !filter.matches(record, obj) {
+			return nil
+		}
+		switch o := obj.(type) {
+		case *bulkv1.CalculationBulk:
+			bulk = o
+		case *v1.Calculation:
+			// The calculations archived more than once are restored as they were archived the last time.
+			if _, ok := calculations[o.UID]; !ok {
+				order = append(order, o.UID)
+			}
+			calculations[o.UID] = o
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if bulk == nil {
+		return fmt.Errorf("the bulk %s/%s isn't in the archive", namespace, name)
+	}
+
+	restored := time.Now().UTC().Format(time.RFC3339)
+	archivedUID := bulk.UID
+	prepareRestore(bulk, restored)
+	bulk.OwnerReferences = nil
+
+	logger = logger.WithFields(logrus.Fields{"namespace": namespace, "bulk": name, "dry-run": dryRun})
+	if !dryRun {
+		if err := client.Create(ctx, bulk); err != nil {
+			return fmt.Errorf("couldn't restore the bulk %s: %w", name, err)
+		}
+	}
+	logger.Info("Restored the bulk")
+
+	var count int
+	for _, uid := range order {
+		calc := calculations[uid]
+		// Only the calculations of the archived bulk are restored, not those of an earlier bulk with the same name.
+		if owner := metav1.GetControllerOf(calc); owner != nil && owner.UID != archivedUID {
+			continue
+		}
+		prepareRestore(calc, restored)
+		calc.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(bulk, bulkv1.SchemeGroupVersion.WithKind(bulkKind.name))}
+		if !dryRun {
+			if err := client.Create(ctx, calc); err != nil {
+				if !kerrors.IsAlreadyExists(err) {
+					return fmt.Errorf("couldn't restore the calculation %s: %w", calc.Name, err)
+				}
+				logger.WithField("calculation", calc.Name).Info("The calculation already exists")
+				continue
+			}
+		}
+		count++
+	}
+	logger.WithField("calculations", count).Info("Restored the calculations of the bulk")
+	return nil
+}
+
+// prepareRestore clears the metadata that the API server sets on the objects it creates, and
+// annotates the object as restored.
+func prepareRestore(obj ctrlruntimeclient.Object, restored string) {
+	obj.SetUID("")
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+	obj.SetDeletionGracePeriodSeconds(nil)
+	obj.SetManagedFields(nil)
+	// The finalizers are added again by the controllers of the restored objects.
+	obj.SetFinalizers(nil)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[RestoredAnnotation] = restored
+	obj.SetAnnotations(annotations)
+}
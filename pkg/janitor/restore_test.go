@@ -0,0 +1,171 @@
+package janitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+// archiveBulk archives a bulk that the janitor deletes, with the calculations that it controls.
+func archiveBulk(t *testing.T) Archive {
+	t.Helper()
+	archived := bulk("bulk", metav1.ConditionTrue, metav1.ConditionFalse, old)
+	archived.UID = "bulk-uid"
+	archived.Finalizers = []string{util.CleanupFinalizer}
+	owned := func(name string, owner *bulkv1.CalculationBulk) *v1.Calculation {
+		calc := calculation(name, v1.CompletedPhase, old, map[string]string{util.BulkLabel: "bulk"})
+		calc.UID = types.UID(name + "-uid")
+		calc.Finalizers = []string{util.CleanupFinalizer}
+		calc.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, bulkv1.SchemeGroupVersion.WithKind(bulkKind.name))}
+		return calc
+	}
+	previous := bulk("bulk", metav1.ConditionTrue, metav1.ConditionFalse, old)
+	previous.UID = "previous-uid"
+
+	client := newFakeClient(archived, owned("calc-1", archived), owned("calc-2", archived), owned("calc-previous", previous))
+	policy := &Policy{Bulks: []Rule{{Retention: day}}}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	archive := NewFileArchive(t.TempDir())
+	j := New(client, nil, archive, policy, "vega", false, 0, testLog)
+	j.now = func() time.Time { return now }
+
+	report, err := j.Clean(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&Report{Objects: map[string]int{bulkKind.name: 1}, Archived: 3}, report); diff != "" {
+		t.Fatal(diff)
+	}
+	return archive
+}
+
+func TestQuery(t *testing.T) {
+	archive := archiveBulk(t)
+
+	testCases := []struct {
+		name     string
+		filter   Filter
+		expected []string
+	}{
+		{
+			name:     "the bulk and its calculations",
+			filter:   Filter{Bulk: "bulk"},
+			expected: []string{"CalculationBulk/bulk", "Calculation/calc-1", "Calculation/calc-2"},
+		},
+		{
+			name:     "a calculation by name",
+			filter:   Filter{Kind: calculationKind.name, Name: "calc-2"},
+			expected: []string{"Calculation/calc-2"},
+		},
+		{
+			name:   "another namespace",
+			filter: Filter{Namespace: "other"},
+		},
+		{
+			name:   "before the objects were archived",
+			filter: Filter{To: now.Add(-24 * time.Hour)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []string
+			if err := Query(context.Background(), archive, tc.filter, func(r Record) error {
+				actual = append(actual, r.Kind+"/"+r.Name)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	testCases := []struct {
+		name            string
+		bulk            string
+		dryRun          bool
+		existing        []ctrlruntimeclient.Object
+		errorExpected   bool
+		expectedObjects []string
+	}{
+		{
+			name:            "the bulk is restored with its calculations",
+			bulk:            "bulk",
+			expectedObjects: []string{"calculation/calc-1", "calculation/calc-2", "bulk/bulk"},
+		},
+		{
+			name:            "existing calculations are kept",
+			bulk:            "bulk",
+			existing:        []ctrlruntimeclient.Object{calculation("calc-1", v1.ProcessingPhase, recent, nil)},
+			expectedObjects: []string{"calculation/calc-1", "calculation/calc-2", "bulk/bulk"},
+		},
+		{
+			name:   "nothing is restored in a dry run",
+			bulk:   "bulk",
+			dryRun: true,
+		},
+		{
+			name:          "the bulk isn't archived",
+			bulk:          "missing",
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			archive := archiveBulk(t)
+			client := newFakeClient(tc.existing...)
+
+			err := Restore(context.Background(), client, archive, "vega", tc.bulk, time.Time{}, time.Time{}, tc.dryRun, testLog)
+			if (err != nil) != tc.errorExpected {
+				t.Fatalf("expected error: %t, got %v", tc.errorExpected, err)
+			}
+			if diff := cmp.Diff(tc.expectedObjects, names(t, client)); diff != "" {
+				t.Fatal(diff)
+			}
+			if tc.dryRun || tc.errorExpected {
+				return
+			}
+
+			var restored bulkv1.CalculationBulk
+			if err := client.Get(context.Background(), types.NamespacedName{Namespace: "vega", Name: "bulk"}, &restored); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := restored.Annotations[RestoredAnnotation]; !ok || len(restored.Finalizers) > 0 {
+				t.Fatalf("expected the bulk to be annotated as restored without finalizers, got %v", restored.ObjectMeta)
+			}
+			var calc v1.Calculation
+			if err := client.Get(context.Background(), types.NamespacedName{Namespace: "vega", Name: "calc-2"}, &calc); err != nil {
+				t.Fatal(err)
+			}
+			if owner := metav1.GetControllerOf(&calc); owner == nil || owner.Name != "bulk" || owner.UID != restored.UID {
+				t.Fatalf("expected the calculation to be controlled by the restored bulk, got %v", calc.OwnerReferences)
+			}
+
+			// The janitor keeps the restored objects.
+			j := New(client, nil, nil, &Policy{Bulks: []Rule{{Retention: day}}}, "vega", false, 0, testLog)
+			if requeueAfter, err := j.expire(context.Background(), bulkKind, &restored, nil); err != nil || requeueAfter != 0 {
+				t.Fatalf("expected the restored bulk to be kept, got %v, %v", requeueAfter, err)
+			}
+			if diff := cmp.Diff(tc.expectedObjects, names(t, client)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
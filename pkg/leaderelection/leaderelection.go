@@ -0,0 +1,62 @@
+package leaderelection
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Options configures the lease-based leader election of the replicas of a component, so only one
+// of them runs its controllers at a time.
+type Options struct {
+	enabled       bool
+	namespace     string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+func (o *Options) Bind(fs *flag.FlagSet) {
+	fs.BoolVar(&o.enabled, "leader-elect", true, "Elect a leader among the replicas, which is the only one that runs the controllers")
+	fs.StringVar(&o.namespace, "leader-election-namespace", "", "Namespace of the lease of the leader. Defaults to the namespace of the component")
+	fs.DurationVar(&o.leaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration that the other replicas wait before taking over the lease of a leader that stopped renewing it")
+	fs.DurationVar(&o.renewDeadline, "leader-election-renew-deadline", 10*time.Second, "Duration that the leader retries renewing its lease before it gives up leadership")
+	fs.DurationVar(&o.retryPeriod, "leader-election-retry-period", 2*time.Second, "Duration that the replicas wait between attempts to acquire or renew the lease")
+}
+
+func (o *Options) Validate() error {
+	if !o.enabled {
+		return nil
+	}
+	if o.retryPeriod <= 0 {
+		return fmt.Errorf("--leader-election-retry-period must be positive")
+	}
+	if o.renewDeadline <= o.retryPeriod {
+		return fmt.Errorf("--leader-election-renew-deadline must be greater than --leader-election-retry-period")
+	}
+	if o.leaseDuration <= o.renewDeadline {
+		return fmt.Errorf("--leader-election-lease-duration must be greater than --leader-election-renew-deadline")
+	}
+	return nil
+}
+
+// Apply enables the leader election in the options of the manager, with the lease named after the
+// component in the given namespace unless another one was configured.
+func (o *Options) Apply(opts *manager.Options, id, namespace string) {
+	if !o.enabled {
+		return
+	}
+	if o.namespace != "" {
+		namespace = o.namespace
+	}
+	opts.LeaderElection = true
+	opts.LeaderElectionID = id
+	opts.LeaderElectionNamespace = namespace
+	opts.LeaseDuration = &o.leaseDuration
+	opts.RenewDeadline = &o.renewDeadline
+	opts.RetryPeriod = &o.retryPeriod
+	// The leader releases the lease when it shuts down, so the next replica doesn't wait for it to expire.
+	opts.LeaderElectionReleaseOnCancel = true
+}
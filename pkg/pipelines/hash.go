@@ -0,0 +1,75 @@
+package pipelines
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+// Digester computes the sha256 sums of the input files at the given paths relative to the root folder.
+type Digester interface {
+	Digests(ctx context.Context, root string, paths []string) (map[string]string, error)
+}
+
+// InputHash returns the content hash of everything that determines the results of a calculation:
+// the pipeline and its version, the steps, the parameters and the digests of the input files
+// found in the root folder.
+func InputHash(ctx context.Context, digester Digester, rootFolder string, pipeline v1.Pipeline, steps []v1.Step, params v1.Params, inputFiles *v1.InputFiles) (string, error) {
+	digests, err := digester.Digests(ctx, rootFolder, InputFiles(pipeline, inputFiles))
+	if err != nil {
+		return "", err
+	}
+	return InputHashFromDigests(digests, pipeline, steps, params, inputFiles)
+}
+
+// InputFiles returns the paths, relative to the root folder, of the input files and folders
+// whose digests are part of the input hash of a calculation.
+func InputFiles(pipeline v1.Pipeline, inputFiles *v1.InputFiles) []string {
+	var files []string
+	if inputFiles != nil {
+		files = append(files, inputFiles.Files...)
+	}
+	if pipeline == v1.VegaPipeline {
+		files = append(files, atlasControlFiles, atlasDataFiles)
+	}
+	return files
+}
+
+// InputHashFromDigests returns the input hash of a calculation like InputHash, from the digests of
+// the files in its root folder. The digests can include the files of other calculations, so the
+// digests of the calculations sharing a root folder are computed only once.
+func InputHashFromDigests(digests map[string]string, pipeline v1.Pipeline, steps []v1.Step, params v1.Params, inputFiles *v1.InputFiles) (string, error) {
+	files := InputFiles(pipeline, inputFiles)
+	own := make(map[string]string)
+	for file, digest := range digests {
+		for _, f := range files {
+			if contains(f, file) {
+				own[file] = digest
+				break
+			}
+		}
+	}
+
+	var version string
+	if pipeline == v1.VegaPipeline {
+		steps = VegaCalculationSteps
+		version = VegaPipelineVersion
+	}
+
+	return util.HashInputs{
+		Pipeline:        pipeline,
+		PipelineVersion: version,
+		Steps:           util.NewHashSteps(steps),
+		Parameters:      util.ResultParameters(params),
+		InputDigests:    own,
+	}.InputHash()
+}
+
+// contains returns whether the file is the input path or in the input folder.
+func contains(input, file string) bool {
+	input = path.Clean(strings.TrimPrefix(input, "/"))
+	return input == "." || file == input || strings.HasPrefix(file, input+"/")
+}
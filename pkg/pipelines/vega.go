@@ -28,6 +28,12 @@ const (
 	kuruzInputFilename   = "t10000_400_72.mod.7011870916"
 	synspecInputFilename = "input_tlusty_fortfive"
 	modFilePrefix        = "t10000_400_72_strat.mod"
+	atlasControlFiles    = "atlas-control-files"
+	atlasDataFiles       = "atlas-data-files"
+
+	// VegaPipelineVersion is part of the input hash of the results. It must be bumped
+	// whenever a change in the pipeline affects the results it produces.
+	VegaPipelineVersion = "1"
 )
 
 var (
@@ -47,6 +53,15 @@ var (
 	}
 )
 
+// CommandFunc creates the commands of the calculations, like exec.CommandContext.
+type CommandFunc func(ctx context.Context, name string, args ...string) *exec.Cmd
+
+// StepRunner runs the commands of the steps of a calculation in its folder.
+type StepRunner interface {
+	// RunStep returns the combined output of the command of the step and the resources it used.
+	RunStep(ctx context.Context, index int, step v1.Step) ([]byte, *v1.StepUsage, error)
+}
+
 type VegaPipeline struct {
 	CalcPath                 string
 	CalcName                 string
@@ -55,9 +70,11 @@ type VegaPipeline struct {
 	KuruzModelTemplateFile   string
 	SynspecInputTemplateFile string
 	Params                   v1.Params
+	// Runner runs the commands of the steps.
+	Runner StepRunner
 }
 
-func (v *VegaPipeline) Run(logger *logrus.Entry, stepUpdaterChan chan util.Result) error {
+func (v *VegaPipeline) Run(ctx context.Context, logger *logrus.Entry, stepUpdaterChan chan util.Result) error {
 	for index, step := range VegaCalculationSteps {
 		// If there is already a status we should continue to the next step.
 		// We assume that the calculation was interrupted by another process and continues now.
@@ -87,16 +104,17 @@ func (v *VegaPipeline) Run(logger *logrus.Entry, stepUpdaterChan chan util.Resul
 		status = v1.CompletedPhase
 
 		// Default to 45 minutes timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+		stepCtx, cancel := context.WithTimeout(ctx, 45*time.Minute)
 		defer cancel()
 
-		cmd := exec.CommandContext(ctx, step.Command, step.Args...)
-		cmd.Dir = v.CalcPath
-
-		logger = logrus.WithFields(logrus.Fields{"command": cmd.Args, "step": index})
+		logger = logrus.WithFields(logrus.Fields{"command": append([]string{step.Command}, step.Args...), "step": index})
 		logger.Info("Running command and waiting for it to finish...")
 
-		combinedOut, err := cmd.CombinedOutput()
+		combinedOut, usage, err := v.Runner.RunStep(stepCtx, index, step)
+		if err != nil && ctx.Err() != nil {
+			// The steps of the calculations that are stopped aren't reported.
+			return fmt.Errorf("the calculation was stopped: %w", context.Cause(ctx))
+		}
 		if err != nil {
 			logger.WithError(err).WithField("output", string(combinedOut)).Error("command failed...")
 			status = v1.FailedPhase
@@ -113,6 +131,7 @@ func (v *VegaPipeline) Run(logger *logrus.Entry, stepUpdaterChan chan util.Resul
 			StdoutStderr: string(combinedOut),
 			Status:       status,
 			CommandError: cmdErr,
+			Usage:        usage,
 		}
 
 		logger.WithField("status", status).Info("Command finished")
@@ -129,8 +148,8 @@ func (v *VegaPipeline) Run(logger *logrus.Entry, stepUpdaterChan chan util.Resul
 
 func NewVegaPipeline(calcName, calcPath string, params v1.Params) *VegaPipeline {
 	return &VegaPipeline{
-		AtlasControlFiles:        "atlas-control-files",
-		AtlasDataFiles:           "atlas-data-files",
+		AtlasControlFiles:        atlasControlFiles,
+		AtlasDataFiles:           atlasDataFiles,
 		KuruzModelTemplateFile:   "kuruz-model-template-file",
 		SynspecInputTemplateFile: "synspec-input-template-file",
 		Params:                   params,
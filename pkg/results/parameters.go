@@ -0,0 +1,62 @@
+// Package results declares the parameters that calculation results are stored and queried with.
+package results
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// ErrInvalidParameters is returned when the parameters of a request don't match the parameter schema.
+var ErrInvalidParameters = errors.New("invalid parameters")
+
+var inputHashRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// parameterValidator checks that the value of a parameter is well formed.
+type parameterValidator func(value string) error
+
+func floatParameter(value string) error {
+	_, err := strconv.ParseFloat(value, 64)
+	return err
+}
+
+// parameterSchema declares the parameters that results can be stored and queried with.
+// Keys that are not in the schema are rejected, so they never reach a query.
+var parameterSchema = map[string]parameterValidator{
+	"teff":  floatParameter,
+	"log_g": floatParameter,
+}
+
+// ValidateParameters checks the given parameters against the parameter schema.
+func ValidateParameters(parameters map[string]string) error {
+	if len(parameters) == 0 {
+		return fmt.Errorf("%w: no parameters given", ErrInvalidParameters)
+	}
+
+	keys := make([]string, 0, len(parameters))
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		validate, ok := parameterSchema[key]
+		if !ok {
+			return fmt.Errorf("%w: unknown parameter %q", ErrInvalidParameters, key)
+		}
+		if err := validate(parameters[key]); err != nil {
+			return fmt.Errorf("%w: parameter %q has invalid value %q", ErrInvalidParameters, key, parameters[key])
+		}
+	}
+	return nil
+}
+
+// ValidateInputHash checks that the given input hash is a hex encoded sha256 sum.
+func ValidateInputHash(hash string) error {
+	if !inputHashRegexp.MatchString(hash) {
+		return fmt.Errorf("%w: input hash %q is not a hex encoded sha256 sum", ErrInvalidParameters, hash)
+	}
+	return nil
+}
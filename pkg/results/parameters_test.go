@@ -0,0 +1,54 @@
+package results
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateParameters(t *testing.T) {
+	testCases := []struct {
+		id         string
+		parameters map[string]string
+		wantErr    bool
+	}{
+		{
+			id:         "known parameters",
+			parameters: map[string]string{"teff": "10000.000000", "log_g": "4.000000"},
+		},
+		{
+			id:         "subset of known parameters",
+			parameters: map[string]string{"teff": "10000.000000"},
+		},
+		{
+			id:      "no parameters",
+			wantErr: true,
+		},
+		{
+			id:         "unknown parameter",
+			parameters: map[string]string{"teff": "10000.000000", "metallicity": "0.0"},
+			wantErr:    true,
+		},
+		{
+			id:         "non numeric value",
+			parameters: map[string]string{"teff": "10000' OR '1'='1"},
+			wantErr:    true,
+		},
+		{
+			id:         "injection in the key",
+			parameters: map[string]string{"teff' = '1' OR '1' = '1' --": "1"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			err := ValidateParameters(tc.parameters)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %v, got %v", tc.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidParameters) {
+				t.Fatalf("expected ErrInvalidParameters, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	instrumentationName = "github.com/vega-project/ccb-operator"
+	// annotationPrefix is the prefix of the annotations that carry the trace context of an object.
+	annotationPrefix = "trace.vegaproject.io/"
+)
+
+type Options struct {
+	endpoint    string
+	insecure    bool
+	sampleRatio float64
+}
+
+func (o *Options) Bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.endpoint, "otlp-endpoint", "", "OTLP/gRPC endpoint where the traces are exported, e.g. otel-collector:4317. Tracing is disabled if not specified")
+	fs.BoolVar(&o.insecure, "otlp-insecure", false, "Export the traces without TLS")
+	fs.Float64Var(&o.sampleRatio, "trace-sample-ratio", 1, "Ratio of the traces that are sampled, unless they continue a trace sampled by another component")
+}
+
+func (o *Options) Validate() error {
+	if o.sampleRatio < 0 || o.sampleRatio > 1 {
+		return fmt.Errorf("--trace-sample-ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// Setup installs the tracer provider of the component, which exports the spans to the OTLP
+// endpoint. Without an endpoint, the spans are not recorded but the trace context is still
+// propagated. The returned function flushes the pending spans, and must be called on exit.
+func (o *Options) Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if o.endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporterOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(o.endpoint)}
+	if o.insecure {
+		exporterOptions = append(exporterOptions, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(o.sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// SetupInMemory installs a tracer provider that records every span in the returned exporter as
+// soon as it ends, for the tests. The returned function restores the previous provider.
+func SetupInMemory() (*tracetest.InMemoryExporter, func()) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter, func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	}
+}
+
+// Tracer returns the tracer of the components.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Inject records the trace context of ctx in the annotations of the object, so the components
+// that reconcile it continue the trace.
+func Inject(ctx context.Context, obj metav1.Object) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for key, value := range carrier {
+		annotations[annotationPrefix+key] = value
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// Extract returns ctx with the trace context recorded in the annotations of the object.
+func Extract(ctx context.Context, obj metav1.Object) context.Context {
+	carrier := propagation.MapCarrier{}
+	for key, value := range obj.GetAnnotations() {
+		if key, ok := strings.CutPrefix(key, annotationPrefix); ok {
+			carrier[key] = value
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// End records the error of the operation in the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
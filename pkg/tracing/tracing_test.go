@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInjectExtract(t *testing.T) {
+	_, restore := SetupInMemory()
+	defer restore()
+
+	ctx, span := Tracer().Start(context.Background(), "test")
+	defer span.End()
+
+	obj := &metav1.ObjectMeta{Annotations: map[string]string{"other": "value"}}
+	Inject(ctx, obj)
+	if _, ok := obj.Annotations[annotationPrefix+"traceparent"]; !ok {
+		t.Fatalf("expected the traceparent annotation, got %v", obj.Annotations)
+	}
+	if obj.Annotations["other"] != "value" {
+		t.Fatalf("expected the other annotations to be kept, got %v", obj.Annotations)
+	}
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), obj))
+	if extracted.TraceID() != span.SpanContext().TraceID() || extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("expected the span context %v, got %v", span.SpanContext(), extracted)
+	}
+	if !extracted.IsRemote() {
+		t.Fatal("expected the extracted span context to be remote")
+	}
+}
+
+func TestInjectWithoutSpan(t *testing.T) {
+	_, restore := SetupInMemory()
+	defer restore()
+
+	obj := &metav1.ObjectMeta{}
+	Inject(context.Background(), obj)
+	if obj.Annotations != nil {
+		t.Fatalf("expected no annotations, got %v", obj.Annotations)
+	}
+	if trace.SpanContextFromContext(Extract(context.Background(), obj)).IsValid() {
+		t.Fatal("expected no span context")
+	}
+}
@@ -3,9 +3,11 @@ package util
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
@@ -36,19 +38,40 @@ func NewCalculation(calc *bulkv1.Calculation) *v1.Calculation {
 		Steps:  calc.Steps,
 	}
 
-	calcName := GetCalculationName(*calc)
 	calculation := &v1.Calculation{
-		ObjectMeta: metav1.ObjectMeta{Name: calcName},
-		Phase:      v1.CreatedPhase,
-		Status:     v1.CalculationStatus{StartTime: metav1.Time{Time: time.Now()}},
-		Spec:       calcSpec,
+		Phase:  v1.CreatedPhase,
+		Status: v1.CalculationStatus{StartTime: metav1.Time{Time: time.Now()}},
+		Spec:   calcSpec,
 	}
 
 	return calculation
 }
 
-func GetCalculationName(calc bulkv1.Calculation) string {
-	return fmt.Sprintf("calc-%s", InputHash([]byte(fmt.Sprintf("%v", calc))))
+// CalculationNameVersion is the version of the naming scheme of the calculations of the bulks. It is
+// hashed with the rest of the name, so a new scheme never reuses the names of the previous one.
+const CalculationNameVersion = "v1"
+
+// GetCalculationName returns the name of the calculation that is created for the entry of the bulk
+// with the given UID at the given attempt. The name only depends on its identity, not on the
+// content or the phase of the entry, so every dispatcher computes the same name for the same
+// attempt and a creation that is retried finds the calculation it already created.
+func GetCalculationName(bulkUID types.UID, key string, attempt int) string {
+	return calculationName(bulkUID, "calculation", key, attempt)
+}
+
+// GetPostCalculationName returns the name of the post-calculation of the bulk with the given UID at
+// the given attempt. It is hashed apart from the entries, so it can't collide with any of their names.
+func GetPostCalculationName(bulkUID types.UID, attempt int) string {
+	return calculationName(bulkUID, "postCalculation", "", attempt)
+}
+
+func calculationName(bulkUID types.UID, kind, key string, attempt int) string {
+	// Every part is prefixed with its length, so different parts never hash the same whatever they contain.
+	var parts []byte
+	for _, part := range []string{CalculationNameVersion, string(bulkUID), kind, key, strconv.Itoa(attempt)} {
+		parts = append(parts, fmt.Sprintf("%d:%s", len(part), part)...)
+	}
+	return fmt.Sprintf("calc-%s", InputHash(parts))
 }
 
 func IsFinishedCalculation(steps []v1.Step) bool {
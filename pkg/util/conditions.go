@@ -0,0 +1,167 @@
+package util
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+)
+
+// conditionState is the state of an object that its conditions report. The Ready and Progressing
+// conditions share the reason and the message, the Degraded condition is true if it has a reason.
+type conditionState struct {
+	ready           bool
+	progressing     bool
+	reason          string
+	message         string
+	degradedReason  string
+	degradedMessage string
+}
+
+// setConditions sets the Ready, Progressing and Degraded conditions and returns whether they changed.
+// The objects have no status subresource, so their generation changes with every update and the
+// conditions don't record it; otherwise setting them would never converge.
+func setConditions(conditions *[]metav1.Condition, state conditionState) bool {
+	status := func(value bool) metav1.ConditionStatus {
+		if value {
+			return metav1.ConditionTrue
+		}
+		return metav1.ConditionFalse
+	}
+
+	degraded := metav1.Condition{Type: v1.DegradedCondition, Status: metav1.ConditionFalse, Reason: "AsExpected"}
+	if state.degradedReason != "" {
+		degraded = metav1.Condition{Type: v1.DegradedCondition, Status: metav1.ConditionTrue, Reason: state.degradedReason, Message: state.degradedMessage}
+	}
+
+	changed := meta.SetStatusCondition(conditions, metav1.Condition{Type: v1.ReadyCondition, Status: status(state.ready), Reason: state.reason, Message: state.message})
+	changed = meta.SetStatusCondition(conditions, metav1.Condition{Type: v1.ProgressingCondition, Status: status(state.progressing), Reason: state.reason, Message: state.message}) || changed
+	changed = meta.SetStatusCondition(conditions, degraded) || changed
+	return changed
+}
+
+// SetCalculationConditions sets the conditions of the calculation from its phase and the status of
+// its steps, and returns whether they changed.
+func SetCalculationConditions(calc *v1.Calculation) bool {
+	var finished int
+	failedStep := -1
+	for i, step := range calc.Spec.Steps {
+		if step.Status != "" {
+			finished++
+		}
+		if step.Status == v1.FailedPhase && failedStep < 0 {
+			failedStep = i
+		}
+	}
+
+	var state conditionState
+	switch calc.Phase {
+	case v1.CreatedPhase:
+		state = conditionState{progressing: true, reason: "Assigned", message: fmt.Sprintf("Assigned to worker %s", calc.Assign)}
+	case v1.ProcessingPhase:
+		state = conditionState{progressing: true, reason: "Running", message: fmt.Sprintf("%d of %d steps finished", finished, len(calc.Spec.Steps))}
+	case v1.CompletedPhase:
+		state = conditionState{ready: true, reason: "Completed", message: "All the steps succeeded"}
+	case v1.CachedPhase:
+		state = conditionState{ready: true, reason: "Cached", message: "The results were produced by the same inputs"}
+	case v1.FailedPhase:
+		state = conditionState{reason: "Failed", message: "The calculation failed", degradedReason: "Failed", degradedMessage: "The calculation failed"}
+		if failedStep >= 0 {
+			state.degradedReason = "StepFailed"
+			state.degradedMessage = fmt.Sprintf("Step %d (%s) failed", failedStep, calc.Spec.Steps[failedStep].Command)
+		}
+	default:
+		return false
+	}
+	return setConditions(&calc.Status.Conditions, state)
+}
+
+// SetBulkConditions sets the conditions of the bulk from the phases of its calculations, and
+// returns whether they changed. The bulk is ready once all its calculations, including the
+// post-calculation, finished.
+func SetBulkConditions(bulk *bulkv1.CalculationBulk) bool {
+	var finished, failed, cached int
+	for _, calc := range bulk.Calculations {
+		switch calc.Phase {
+		case v1.CompletedPhase:
+			finished++
+		case v1.CachedPhase:
+			finished++
+			cached++
+		case v1.FailedPhase:
+			finished++
+			failed++
+		}
+	}
+
+	state := conditionState{
+		ready:   finished == len(bulk.Calculations),
+		reason:  "Completed",
+		message: fmt.Sprintf("All %d calculations finished, %d from the cache", len(bulk.Calculations), cached),
+	}
+	if post := bulk.PostCalculation; post != nil && post.Phase != v1.CompletedPhase && post.Phase != v1.FailedPhase {
+		state.ready = false
+		state.message = "Waiting for the post-calculation"
+	}
+	if !state.ready {
+		state.progressing = true
+		state.reason = "Processing"
+		if finished < len(bulk.Calculations) {
+			state.message = fmt.Sprintf("%d of %d calculations finished", finished, len(bulk.Calculations))
+		}
+	}
+
+	if failed > 0 {
+		state.degradedReason = "CalculationsFailed"
+		state.degradedMessage = fmt.Sprintf("%d of %d calculations failed", failed, len(bulk.Calculations))
+	} else if bulk.PostCalculation != nil && bulk.PostCalculation.Phase == v1.FailedPhase {
+		state.degradedReason = "PostCalculationFailed"
+		state.degradedMessage = "The post-calculation failed"
+	}
+	return setConditions(&bulk.Status.Conditions, state)
+}
+
+// SetWorkerPoolConditions sets the conditions of the pool from the states of its workers, and
+// returns whether they changed. The pool is ready while it has workers that weren't lost and
+// aren't draining.
+func SetWorkerPoolConditions(pool *workersv1.WorkerPool) bool {
+	var available, processing, draining, lost int
+	for _, worker := range pool.Spec.Workers {
+		switch worker.State {
+		case workersv1.WorkerAvailableState:
+			available++
+		case workersv1.WorkerProcessingState, workersv1.WorkerReservedState:
+			processing++
+		case workersv1.WorkerDrainingState:
+			draining++
+		case workersv1.WorkerUnknownState:
+			lost++
+		}
+	}
+
+	state := conditionState{
+		ready:   available+processing > 0,
+		reason:  "Idle",
+		message: fmt.Sprintf("%d of %d workers available", available, len(pool.Spec.Workers)),
+	}
+	if processing > 0 {
+		state.progressing = true
+		state.reason = "CalculationsRunning"
+		state.message = fmt.Sprintf("%d of %d workers processing calculations", processing, len(pool.Spec.Workers))
+	} else if draining > 0 {
+		state.progressing = true
+		state.reason = "WorkersDraining"
+		state.message = fmt.Sprintf("%d of %d workers draining", draining, len(pool.Spec.Workers))
+	} else if !state.ready {
+		state.reason = "NoWorkers"
+	}
+	if lost > 0 {
+		state.degradedReason = "WorkersLost"
+		state.degradedMessage = fmt.Sprintf("%d of %d workers were lost", lost, len(pool.Spec.Workers))
+	}
+	return setConditions(&pool.Status.Conditions, state)
+}
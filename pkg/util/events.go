@@ -0,0 +1,7 @@
+package util
+
+// The components that record the events of the calculations, the bulks and the worker pools.
+const (
+	DispatcherEventSource = "vega-dispatcher"
+	WorkerEventSource     = "vega-worker"
+)
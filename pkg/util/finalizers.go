@@ -0,0 +1,113 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+)
+
+// CleanupFinalizer keeps the bulks and the calculations until the work they started is stopped
+// and the files they left in the shared storage are removed.
+const CleanupFinalizer = "vegaproject.io/cleanup"
+
+// ArtifactRemover removes artifacts and folders from the shared storage.
+type ArtifactRemover interface {
+	Remove(ctx context.Context, key string) error
+}
+
+// BulkOutputKey returns the artifact key of the folder where the results collector organises the
+// output files of the calculations of the bulk.
+func BulkOutputKey(bulk *bulkv1.CalculationBulk) string {
+	return path.Join(bulk.RootFolder, bulk.Name)
+}
+
+// FinalizeCalculation removes the output files that the calculation left in the staging folder and
+// weren't collected, and then its finalizer, so the deleted calculation is removed. The factory
+// calculations leave their output files in the root folder, which is never removed.
+func FinalizeCalculation(ctx context.Context, client ctrlruntimeclient.Client, remover ArtifactRemover, calc *v1.Calculation) error {
+	if !controllerutil.ContainsFinalizer(calc, CleanupFinalizer) {
+		return nil
+	}
+	if _, ok := calc.Labels[FactoryLabel]; !ok {
+		if err := remover.Remove(ctx, OutputStagingKey(calc)); err != nil {
+			return fmt.Errorf("couldn't remove the output files of the calculation %s: %w", calc.Name, err)
+		}
+	}
+	return RemoveFinalizer(ctx, client, calc, &v1.Calculation{})
+}
+
+// RemoveFinalizer removes the cleanup finalizer from the object, which is fetched again into
+// latest on conflicts. Objects that are already gone are ignored.
+func RemoveFinalizer(ctx context.Context, client ctrlruntimeclient.Client, obj, latest ctrlruntimeclient.Object) error {
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := client.Get(ctx, ctrlruntimeclient.ObjectKeyFromObject(obj), latest); err != nil {
+			return err
+		}
+		if !controllerutil.RemoveFinalizer(latest, CleanupFinalizer) {
+			return nil
+		}
+		return client.Update(ctx, latest)
+	}); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("couldn't remove the finalizer of %s: %w", obj.GetName(), err)
+	}
+	return nil
+}
+
+// RescheduleCalculation deletes the calculation and finalizes it, and resets it in its bulk so the
+// bulk creates it again in a new attempt, which gets a new name so the deleted calculation is never
+// mistaken for it. The calculations that don't belong to a bulk are only deleted.
+func RescheduleCalculation(ctx context.Context, client ctrlruntimeclient.Client, remover ArtifactRemover, calc *v1.Calculation) error {
+	if err := client.Delete(ctx, calc); err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("couldn't delete the calculation: %v", err)
+	}
+	if err := FinalizeCalculation(ctx, client, remover, calc); err != nil {
+		return err
+	}
+
+	bulkName, exist := calc.Labels[BulkLabel]
+	if !exist {
+		return nil
+	}
+	_, isPostCalculation := calc.Labels[PostCalculationLabel]
+	calcBulkName, exist := calc.Labels[CalculationNameLabel]
+	if !exist && !isPostCalculation {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		bulk := &bulkv1.CalculationBulk{}
+		if err := client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: calc.Namespace, Name: bulkName}, bulk); err != nil {
+			return fmt.Errorf("failed to get the calculation bulk: %w", err)
+		}
+
+		if isPostCalculation {
+			if bulk.PostCalculation == nil {
+				return nil
+			}
+			bulk.PostCalculation.Phase = ""
+			if ref := bulk.Status.PostCalculation; ref != nil {
+				bulk.Status.PostCalculation = &bulkv1.CalculationReference{Attempt: ref.Attempt + 1}
+			}
+		} else {
+			calculation := bulk.Calculations[calcBulkName]
+			calculation.Phase = ""
+			bulk.Calculations[calcBulkName] = calculation
+			if ref, ok := bulk.Status.Calculations[calcBulkName]; ok {
+				bulk.Status.Calculations[calcBulkName] = bulkv1.CalculationReference{Attempt: ref.Attempt + 1}
+			}
+		}
+
+		if err := client.Update(ctx, bulk); err != nil {
+			return fmt.Errorf("failed to update calculation bulk %s: %w", bulk.Name, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+)
+
+// InputHashVersion is part of every input hash. It must be bumped whenever the
+// hashed inputs change, so results produced under the old scheme are never matched.
+const InputHashVersion = "v1"
+
+// HashInputs are the inputs that fully determine the results of a calculation.
+type HashInputs struct {
+	Pipeline        v1.Pipeline       `json:"pipeline"`
+	PipelineVersion string            `json:"pipeline_version"`
+	Steps           []HashStep        `json:"steps"`
+	Parameters      map[string]string `json:"parameters"`
+	// InputDigests maps the path of every input file, relative to the root folder, to its sha256 sum.
+	InputDigests map[string]string `json:"input_digests"`
+}
+
+// HashStep is the part of a step that affects the results.
+type HashStep struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// InputHash returns the hex encoded sha256 sum of the canonical form of the inputs.
+func (h HashInputs) InputHash() (string, error) {
+	// Maps are marshalled with sorted keys, so the encoding is canonical.
+	data, err := json.Marshal(struct {
+		Version string `json:"version"`
+		HashInputs
+	}{InputHashVersion, h})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NewHashSteps keeps the parts of the steps that affect the results.
+func NewHashSteps(steps []v1.Step) []HashStep {
+	ret := make([]HashStep, 0, len(steps))
+	for _, step := range steps {
+		ret = append(ret, HashStep{Command: step.Command, Args: step.Args})
+	}
+	return ret
+}
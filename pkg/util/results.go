@@ -1,6 +1,12 @@
 package util
 
-import v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+)
 
 type Result struct {
 	CalcName     string
@@ -8,4 +14,47 @@ type Result struct {
 	Status       v1.CalculationPhase
 	StdoutStderr string
 	CommandError error
+	// Usage is the resources that the step used, if it ran.
+	Usage *v1.StepUsage
+}
+
+// ResultParameters returns the parameters that the results of a calculation are stored with.
+func ResultParameters(params v1.Params) map[string]string {
+	return map[string]string{
+		"teff":  fmt.Sprintf("%f", params.Teff),
+		"log_g": fmt.Sprintf("%f", params.LogG),
+	}
+}
+
+// outputStagingFolder is the folder in the root folder of the calculations where the workers leave
+// the output files until the results collector organises them.
+const outputStagingFolder = ".outputs"
+
+// OutputStagingFolderKey returns the artifact key of the folder in the root folder where the workers
+// leave the output files of the calculations, each in a folder named after the calculation.
+func OutputStagingFolderKey(rootFolder string) string {
+	return path.Join(rootFolder, outputStagingFolder)
+}
+
+// OutputStagingKey returns the artifact key of the folder where the worker leaves the output files
+// of the calculation.
+func OutputStagingKey(calc *v1.Calculation) string {
+	return path.Join(OutputStagingFolderKey(calc.Labels[CalcRootFolder]), calc.Name)
+}
+
+// OutputStagingPath returns the folder where the worker leaves the output files of the calculation
+// in the shared storage.
+func OutputStagingPath(nfsPath string, calc *v1.Calculation) string {
+	return filepath.Join(nfsPath, filepath.FromSlash(OutputStagingKey(calc)))
+}
+
+// CollectedOutputPath returns the folder where the results collector organises the output files of
+// the calculation, <root folder>/<bulk>/<calculation>. Calculations that are not part of a bulk,
+// like post calculations, are named after the calculation object.
+func CollectedOutputPath(nfsPath string, calc *v1.Calculation) string {
+	name := calc.Labels[CalculationNameLabel]
+	if name == "" {
+		name = calc.Name
+	}
+	return filepath.Join(nfsPath, calc.Labels[CalcRootFolder], calc.Labels[BulkLabel], name)
 }
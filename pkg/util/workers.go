@@ -61,6 +61,8 @@ func GetCalculationBulksByRegisteredTime(bulks map[string]workersv1.CalculationB
 	return ret
 }
 
+// UpdateWorkerStatusInPool sets the state of the worker in the pool. A draining worker stays
+// draining until it leaves the pool, so only its update time changes.
 func UpdateWorkerStatusInPool(ctx context.Context, client ctrlruntimeclient.Client, workerPool, nodename, namespace string, state workersv1.WorkerState) error {
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		pool := &workersv1.WorkerPool{}
@@ -71,16 +73,21 @@ func UpdateWorkerStatusInPool(ctx context.Context, client ctrlruntimeclient.Clie
 
 		now := time.Now()
 		worker, exists := pool.Spec.Workers[nodename]
-		if exists {
-			if worker.LastUpdateTime != nil {
-				worker.LastUpdateTime.Time = now
-			} else {
-				worker.LastUpdateTime = &metav1.Time{Time: now}
-			}
+		if !exists {
+			// The worker left the pool already.
+			return nil
+		}
+		if worker.LastUpdateTime != nil {
+			worker.LastUpdateTime.Time = now
+		} else {
+			worker.LastUpdateTime = &metav1.Time{Time: now}
+		}
+		if worker.State != workersv1.WorkerDrainingState {
 			worker.State = state
 		}
 
 		pool.Spec.Workers[nodename] = worker
+		SetWorkerPoolConditions(pool)
 		if err := client.Update(ctx, pool); err != nil {
 			return fmt.Errorf("failed to update WorkerPool %s: %w", pool.Name, err)
 		}
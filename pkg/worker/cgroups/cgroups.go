@@ -0,0 +1,292 @@
+package cgroups
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// mountPoint is where the cgroup v2 hierarchy is mounted.
+	mountPoint = "/sys/fs/cgroup"
+	// cpuPeriod is the period of the CPU limits, in microseconds.
+	cpuPeriod = 100000
+	// maxMovePasses bounds the passes over the processes of the worker that are moved to its child
+	// cgroup, since processes can be started while they are moved.
+	maxMovePasses = 10
+)
+
+// controllers are enabled for the cgroups of the calculations.
+var controllers = []string{"cpu", "memory"}
+
+// Limits are the resources that the processes of a calculation can use. Zero values are unlimited.
+type Limits struct {
+	// Memory is in bytes.
+	Memory int64
+	// MilliCPU is in thousandths of a core.
+	MilliCPU int64
+}
+
+// Usage is the resources that the processes of a cgroup used.
+type Usage struct {
+	CPUTime time.Duration
+	// PeakMemory is in bytes, or zero if the kernel doesn't record it.
+	PeakMemory int64
+}
+
+// Detect returns the cgroup of the worker in the cgroup v2 hierarchy.
+func Detect() (string, error) {
+	if _, err := os.Stat(filepath.Join(mountPoint, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not mounted at %s: %w", mountPoint, err)
+	}
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(mountPoint, path), nil
+		}
+	}
+	return "", fmt.Errorf("the worker is not in a cgroup v2")
+}
+
+// Manager creates a cgroup for each calculation in the cgroup of the worker.
+type Manager struct {
+	dir string
+}
+
+// NewManager prepares the cgroup of the worker for the cgroups of the calculations. Since cgroups
+// with processes can't delegate controllers, the processes of the worker are moved to a child
+// cgroup, and the calculations are created in another one. Calculations left by a previous run
+// are removed.
+func NewManager(root string) (*Manager, error) {
+	available, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+	for _, controller := range controllers {
+		if !slices.Contains(strings.Fields(string(available)), controller) {
+			return nil, fmt.Errorf("the %s controller is not available in %s", controller, root)
+		}
+	}
+
+	workerDir := filepath.Join(root, "worker")
+	if err := os.MkdirAll(workerDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := moveProcesses(root, workerDir); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{dir: filepath.Join(root, "calculations")}
+	if err := enableControllers(root); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := enableControllers(m.dir); err != nil {
+		return nil, err
+	}
+
+	leftovers, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, leftover := range leftovers {
+		if leftover.IsDir() {
+			if err := (&Calculation{dir: filepath.Join(m.dir, leftover.Name())}).Close(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// Calculation is the cgroup of a calculation, which enforces its limits. The processes of each
+// step run in a child cgroup, where their usage is measured.
+type Calculation struct {
+	dir string
+}
+
+// NewCalculation creates the cgroup of the calculation with the given limits.
+func (m *Manager) NewCalculation(name string, limits Limits) (*Calculation, error) {
+	c := &Calculation{dir: filepath.Join(m.dir, name)}
+	// The cgroup of a previous attempt of the calculation is removed.
+	if err := c.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(c.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := enableControllers(c.dir); err != nil {
+		return nil, err
+	}
+	if limits.Memory > 0 {
+		if err := writeFile(c.dir, "memory.max", strconv.FormatInt(limits.Memory, 10)); err != nil {
+			return nil, err
+		}
+	}
+	if limits.MilliCPU > 0 {
+		if err := writeFile(c.dir, "cpu.max", fmt.Sprintf("%d %d", limits.MilliCPU*cpuPeriod/1000, cpuPeriod)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Step is the cgroup of a step of a calculation.
+type Step struct {
+	dir string
+	fd  *os.File
+}
+
+// NewStep creates the cgroup of a step.
+func (c *Calculation) NewStep(index int) (*Step, error) {
+	dir := filepath.Join(c.dir, fmt.Sprintf("step-%d", index))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Step{dir: dir, fd: fd}, nil
+}
+
+// Attach makes the command start in the cgroup of the step, so all its processes are accounted.
+func (s *Step) Attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(s.fd.Fd())
+}
+
+// Usage returns the resources that the processes of the step used.
+func (s *Step) Usage() (Usage, error) {
+	var usage Usage
+	stat, err := os.ReadFile(filepath.Join(s.dir, "cpu.stat"))
+	if err != nil {
+		return usage, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(stat)))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "usage_usec "); ok {
+			usec, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return usage, fmt.Errorf("invalid cpu.stat: %w", err)
+			}
+			usage.CPUTime = time.Duration(usec) * time.Microsecond
+		}
+	}
+
+	// memory.peak is only available since Linux 5.19.
+	peak, err := os.ReadFile(filepath.Join(s.dir, "memory.peak"))
+	if err != nil && !os.IsNotExist(err) {
+		return usage, err
+	}
+	if err == nil {
+		if usage.PeakMemory, err = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64); err != nil {
+			return usage, fmt.Errorf("invalid memory.peak: %w", err)
+		}
+	}
+	return usage, nil
+}
+
+// Close kills the processes that are left in the cgroup of the step and removes it.
+func (s *Step) Close() error {
+	s.fd.Close()
+	return remove(s.dir)
+}
+
+// Close removes the cgroup of the calculation and of its steps.
+func (c *Calculation) Close() error {
+	steps, err := os.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, step := range steps {
+		if step.IsDir() {
+			if err := remove(filepath.Join(c.dir, step.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return remove(c.dir)
+}
+
+// remove kills the processes of the cgroup and removes it once they exited.
+func remove(dir string) error {
+	// cgroup.kill is only available since Linux 5.14.
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.kill")); err == nil {
+		if err := writeFile(dir, "cgroup.kill", "1"); err != nil {
+			return err
+		}
+	}
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = syscall.Rmdir(dir); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return fmt.Errorf("couldn't remove the cgroup %s: %w", dir, err)
+}
+
+// moveProcesses moves all the processes of a cgroup to another one. The processes that are started
+// during a pass are moved in the next one, until none is left.
+func moveProcesses(from, to string) error {
+	for pass := 0; pass < maxMovePasses; pass++ {
+		procs, err := os.ReadFile(filepath.Join(from, "cgroup.procs"))
+		if err != nil {
+			return err
+		}
+		pids := strings.Fields(string(procs))
+		if len(pids) == 0 {
+			return nil
+		}
+		for _, pid := range pids {
+			if err := moveProcess(to, pid); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return fmt.Errorf("couldn't move process %s to %s: %w", pid, to, err)
+			}
+		}
+	}
+	return fmt.Errorf("processes are still started in %s after moving them to %s %d times", from, to, maxMovePasses)
+}
+
+// moveProcess moves a process to the given cgroup.
+var moveProcess = func(dir, pid string) error {
+	return writeFile(dir, "cgroup.procs", pid)
+}
+
+func enableControllers(dir string) error {
+	var enable []string
+	for _, controller := range controllers {
+		enable = append(enable, "+"+controller)
+	}
+	if err := writeFile(dir, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return fmt.Errorf("couldn't enable the controllers in %s: %w", dir, err)
+	}
+	return nil
+}
+
+// writeFile writes a value to an interface file of a cgroup.
+func writeFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
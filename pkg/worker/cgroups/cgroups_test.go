@@ -0,0 +1,222 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newFakeCgroup creates a folder with the interface files of a cgroup, which are regular files.
+func newFakeCgroup(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// fakeMoves moves the processes between fake cgroups like the kernel does, and starts a new
+// process in the source cgroup for each of the first given number of moves.
+func fakeMoves(t *testing.T, from string, forks int) {
+	original := moveProcess
+	t.Cleanup(func() { moveProcess = original })
+
+	next := 100
+	moveProcess = func(dir, pid string) error {
+		var remaining []string
+		for _, p := range strings.Fields(readFile(t, filepath.Join(from, "cgroup.procs"))) {
+			if p != pid {
+				remaining = append(remaining, p)
+			}
+		}
+		if forks > 0 {
+			forks--
+			remaining = append(remaining, strconv.Itoa(next))
+			next++
+		}
+		if err := os.WriteFile(filepath.Join(from, "cgroup.procs"), []byte(strings.Join(remaining, "\n")), 0644); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, "cgroup.procs"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.WriteString(pid + "\n")
+		return err
+	}
+}
+
+func TestNewManager(t *testing.T) {
+	testCases := []struct {
+		name          string
+		forks         int
+		expectedProcs string
+		expectedError bool
+	}{
+		{
+			name:          "the processes of the worker are moved",
+			expectedProcs: "1\n42\n",
+		},
+		{
+			name:          "the processes started while moving are moved too",
+			forks:         3,
+			expectedProcs: "1\n42\n100\n101\n102\n",
+		},
+		{
+			name:          "processes that keep being started are an error",
+			forks:         1000,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := newFakeCgroup(t, map[string]string{
+				"cgroup.controllers":     "cpuset cpu io memory pids",
+				"cgroup.procs":           "1\n42\n",
+				"cgroup.subtree_control": "",
+			})
+			fakeMoves(t, root, tc.forks)
+
+			m, err := NewManager(root)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.dir != filepath.Join(root, "calculations") {
+				t.Errorf("expected the calculations in %s, got %s", filepath.Join(root, "calculations"), m.dir)
+			}
+			if procs := readFile(t, filepath.Join(root, "worker", "cgroup.procs")); procs != tc.expectedProcs {
+				t.Errorf("expected the processes to be moved to the worker cgroup, got %q", procs)
+			}
+			if procs := readFile(t, filepath.Join(root, "cgroup.procs")); procs != "" {
+				t.Errorf("expected no process left in the cgroup of the worker, got %q", procs)
+			}
+			for _, dir := range []string{root, m.dir} {
+				if controllers := readFile(t, filepath.Join(dir, "cgroup.subtree_control")); controllers != "+cpu +memory" {
+					t.Errorf("expected the controllers to be enabled in %s, got %q", dir, controllers)
+				}
+			}
+		})
+	}
+}
+
+func TestNewManagerRequiresControllers(t *testing.T) {
+	root := newFakeCgroup(t, map[string]string{"cgroup.controllers": "cpuset pids", "cgroup.procs": ""})
+	if _, err := NewManager(root); err == nil {
+		t.Fatal("expected an error for missing controllers")
+	}
+}
+
+func TestNewCalculation(t *testing.T) {
+	testCases := []struct {
+		name          string
+		limits        Limits
+		expectedFiles map[string]string
+	}{
+		{
+			name:          "calculations without limits are unlimited",
+			expectedFiles: map[string]string{"cgroup.subtree_control": "+cpu +memory"},
+		},
+		{
+			name:   "memory and CPU limits are enforced",
+			limits: Limits{Memory: 2 << 30, MilliCPU: 1500},
+			expectedFiles: map[string]string{
+				"cgroup.subtree_control": "+cpu +memory",
+				"memory.max":             "2147483648",
+				"cpu.max":                "150000 100000",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Manager{dir: t.TempDir()}
+			c, err := m.NewCalculation("calc-1", tc.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files := map[string]string{}
+			entries, err := os.ReadDir(c.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				files[entry.Name()] = readFile(t, filepath.Join(c.dir, entry.Name()))
+			}
+			if diff := cmp.Diff(tc.expectedFiles, files); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestStepUsage(t *testing.T) {
+	testCases := []struct {
+		name          string
+		files         map[string]string
+		expected      Usage
+		expectedError bool
+	}{
+		{
+			name: "usage is read from the interface files",
+			files: map[string]string{
+				"cpu.stat":    "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+				"memory.peak": "1048576\n",
+			},
+			expected: Usage{CPUTime: 2500 * time.Millisecond, PeakMemory: 1048576},
+		},
+		{
+			name:     "peak memory is zero on older kernels",
+			files:    map[string]string{"cpu.stat": "usage_usec 10\n"},
+			expected: Usage{CPUTime: 10 * time.Microsecond},
+		},
+		{
+			name:          "invalid usage is an error",
+			files:         map[string]string{"cpu.stat": "usage_usec many\n"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Step{dir: newFakeCgroup(t, tc.files)}
+			usage, err := s.Usage()
+			if tc.expectedError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, usage); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package worker
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -24,6 +25,7 @@ import (
 
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
 	"github.com/vega-project/ccb-operator/pkg/util"
 	"github.com/vega-project/ccb-operator/pkg/worker/workerpools"
 )
@@ -32,7 +34,17 @@ const (
 	controllerName = "calculations"
 )
 
-func AddToManager(ctx context.Context, mgr manager.Manager, ns, hostname, nodename string, executeChan chan *v1.Calculation, workerPool, namespace string) error {
+// executions cancels the calculations that are deleted while they run.
+type executions interface {
+	Cancel(name string) bool
+}
+
+// drainer reports whether the worker is draining, when it reschedules the calculations assigned to it.
+type drainer interface {
+	Draining() bool
+}
+
+func AddToManager(ctx context.Context, mgr manager.Manager, ns, hostname, nodename string, executeChan chan *v1.Calculation, executions executions, drainer drainer, artifactStore artifacts.ArtifactStore, workerPool, namespace string) error {
 	logger := logrus.WithField("controller", controllerName)
 	c, err := controller.New(controllerName, mgr, controller.Options{
 		MaxConcurrentReconciles: 1,
@@ -42,6 +54,9 @@ func AddToManager(ctx context.Context, mgr manager.Manager, ns, hostname, nodena
 			hostname:    hostname,
 			nodename:    nodename,
 			executeChan: executeChan,
+			executions:  executions,
+			drainer:     drainer,
+			artifacts:   artifactStore,
 			workerPool:  workerPool,
 			namespace:   namespace,
 		},
@@ -67,7 +82,12 @@ func (h *calculationHandler) Create(ctx context.Context, e event.TypedCreateEven
 	q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: e.Object.Namespace, Name: e.Object.Name}})
 }
 
+// Update requeues the calculations that are deleted, which are finalized by their worker.
 func (h *calculationHandler) Update(ctx context.Context, e event.TypedUpdateEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if h.namespace != e.ObjectNew.Namespace || e.ObjectNew.DeletionTimestamp == nil {
+		return
+	}
+	q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: e.ObjectNew.Namespace, Name: e.ObjectNew.Name}})
 }
 
 func (h *calculationHandler) Delete(ctx context.Context, e event.TypedDeleteEvent[*v1.Calculation], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
@@ -80,6 +100,9 @@ type reconciler struct {
 	logger      *logrus.Entry
 	client      ctrlruntimeclient.Client
 	executeChan chan *v1.Calculation
+	executions  executions
+	drainer     drainer
+	artifacts   artifacts.ArtifactStore
 
 	hostname   string
 	nodename   string
@@ -111,8 +134,23 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 		return nil
 	}
 
+	if calculation.Assign == r.hostname && calculation.DeletionTimestamp != nil {
+		// A running calculation is finalized by the executor once its commands stopped.
+		if r.executions.Cancel(calculation.Name) {
+			r.logger.WithField("calculation", calculation.Name).Info("Cancelled the deleted calculation")
+			return nil
+		}
+		return util.FinalizeCalculation(ctx, r.client, r.artifacts, calculation)
+	}
+
 	if calculation.Assign == r.hostname {
 		if calculation.Phase == v1.CreatedPhase {
+			// The calculations that were assigned before the dispatcher saw the worker draining are rescheduled.
+			if r.drainer.Draining() {
+				r.logger.WithField("calculation", calculation.Name).Info("The worker is draining, rescheduling the calculation")
+				return util.RescheduleCalculation(ctx, r.client, r.artifacts, calculation)
+			}
+
 			r.logger.WithField("calculation", calculation.Name).Info("Processing assigned calculation")
 
 			if err := util.UpdateWorkerStatusInPool(ctx, r.client, r.workerPool, r.nodename, r.namespace, workersv1.WorkerProcessingState); err != nil {
@@ -130,6 +168,7 @@ func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logge
 
 				calculation.Phase = v1.ProcessingPhase
 				calculation.Status.PendingTime = &metav1.Time{Time: time.Now()}
+				util.SetCalculationConditions(calculation)
 
 				r.logger.WithField("calculation", calculation.Name).Info("Updating calculation phase...")
 				if err := r.client.Update(ctx, calculation); err != nil {
@@ -162,6 +201,9 @@ func NewController(
 	ctx context.Context,
 	mgr manager.Manager,
 	executeChan chan *v1.Calculation,
+	executions executions,
+	drainer *workerpools.Drainer,
+	artifactStore artifacts.ArtifactStore,
 	calcErrorChan chan string,
 	stepUpdaterChan chan util.Result,
 	hostname, nodename, namespace, workerPool string) *Controller {
@@ -180,11 +222,11 @@ func NewController(
 		workerPool:      workerPool,
 	}
 
-	if err := AddToManager(ctx, mgr, namespace, hostname, nodename, executeChan, workerPool, namespace); err != nil {
+	if err := AddToManager(ctx, mgr, namespace, hostname, nodename, executeChan, executions, drainer, artifactStore, workerPool, namespace); err != nil {
 		logrus.WithError(err).Fatal("Failed to add calculations controller to manager")
 	}
 
-	if err := workerpools.AddToManager(ctx, mgr, namespace, hostname, nodename, workerPool, namespace); err != nil {
+	if err := workerpools.AddToManager(ctx, mgr, namespace, hostname, nodename, workerPool, namespace, drainer); err != nil {
 		logrus.WithError(err).Fatal("Failed to add workerpools controller to manager")
 	}
 
@@ -236,6 +278,7 @@ func (c *Controller) updateErrorCalculation(name string) error {
 
 		calculation.Phase = v1.FailedPhase
 		calculation.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+		util.SetCalculationConditions(calculation)
 
 		if err := c.client.Update(c.ctx, calculation); err != nil {
 			return fmt.Errorf("failed to update calculation %s: %w", calculation.Name, err)
@@ -252,6 +295,10 @@ func (c *Controller) updateCalculation(r util.Result) error {
 		}
 
 		calculation.Spec.Steps[r.Step].Status = r.Status // TODO add the rest of the results here
+		if r.Usage != nil {
+			calculation.Status.StepUsage = setStepUsage(calculation.Status.StepUsage, *r.Usage)
+		}
+		util.SetCalculationConditions(calculation)
 
 		if err := c.client.Update(c.ctx, calculation); err != nil {
 			return fmt.Errorf("failed to update calculation %s: %w", calculation.Name, err)
@@ -259,3 +306,16 @@ func (c *Controller) updateCalculation(r util.Result) error {
 		return nil
 	})
 }
+
+// setStepUsage records the usage of a step, replacing the usage of a previous attempt of the step.
+func setStepUsage(usages []v1.StepUsage, usage v1.StepUsage) []v1.StepUsage {
+	for i := range usages {
+		if usages[i].Step == usage.Step {
+			usages[i] = usage
+			return usages
+		}
+	}
+	usages = append(usages, usage)
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Step < usages[j].Step })
+	return usages
+}
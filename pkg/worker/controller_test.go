@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+func TestSetStepUsage(t *testing.T) {
+	usage := func(step int, wall time.Duration) v1.StepUsage {
+		return v1.StepUsage{Step: step, WallTime: metav1.Duration{Duration: wall}}
+	}
+	testCases := []struct {
+		name     string
+		usages   []v1.StepUsage
+		usage    v1.StepUsage
+		expected []v1.StepUsage
+	}{
+		{
+			name:     "the usage of the first step is recorded",
+			usage:    usage(0, time.Second),
+			expected: []v1.StepUsage{usage(0, time.Second)},
+		},
+		{
+			name:     "the usages are sorted by step",
+			usages:   []v1.StepUsage{usage(0, time.Second), usage(2, time.Second)},
+			usage:    usage(1, time.Minute),
+			expected: []v1.StepUsage{usage(0, time.Second), usage(1, time.Minute), usage(2, time.Second)},
+		},
+		{
+			name:     "the usage of a previous attempt of the step is replaced",
+			usages:   []v1.StepUsage{usage(0, time.Second), usage(1, time.Second)},
+			usage:    usage(1, time.Minute),
+			expected: []v1.StepUsage{usage(0, time.Second), usage(1, time.Minute)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.expected, setStepUsage(tc.usages, tc.usage)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+type fakeExecutions struct {
+	running   string
+	cancelled []string
+}
+
+func (f *fakeExecutions) Cancel(name string) bool {
+	if f.running != name {
+		return false
+	}
+	f.cancelled = append(f.cancelled, name)
+	return true
+}
+
+func TestReconcileDeletion(t *testing.T) {
+	testCases := []struct {
+		name              string
+		running           string
+		expectedCancelled []string
+		expectedRemains   bool
+	}{
+		{
+			name:              "the running calculation is cancelled and finalized by the executor",
+			running:           "test-calc",
+			expectedCancelled: []string{"test-calc"},
+			expectedRemains:   true,
+		},
+		{
+			name: "the calculation that isn't running is finalized",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deleted := metav1.Now()
+			calc := &v1.Calculation{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "test-calc",
+					Namespace:         "vega",
+					DeletionTimestamp: &deleted,
+					Finalizers:        []string{util.CleanupFinalizer},
+				},
+				Assign: "worker-1",
+			}
+			executions := &fakeExecutions{running: tc.running}
+			r := &reconciler{
+				logger:     logrus.WithField("test-name", tc.name),
+				client:     fakectrlruntimeclient.NewClientBuilder().WithObjects(calc).Build(),
+				executions: executions,
+				artifacts:  artifacts.NewLocalStore(t.TempDir()),
+				hostname:   "worker-1",
+			}
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-calc"}}
+			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.expectedCancelled, executions.cancelled); diff != "" {
+				t.Fatal(diff)
+			}
+			err := r.client.Get(context.Background(), req.NamespacedName, &v1.Calculation{})
+			if remains := !kerrors.IsNotFound(err); remains != tc.expectedRemains {
+				t.Fatalf("expected the calculation to remain: %t, got %v", tc.expectedRemains, err)
+			}
+		})
+	}
+}
+
+type fakeDrainer struct {
+	draining bool
+}
+
+func (f *fakeDrainer) Draining() bool {
+	return f.draining
+}
+
+func TestReconcileDraining(t *testing.T) {
+	calc := &v1.Calculation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-calc",
+			Namespace:  "vega",
+			Finalizers: []string{util.CleanupFinalizer},
+			Labels:     map[string]string{util.AssignWorkerLabel: "worker-1"},
+		},
+		Assign: "worker-1",
+		Phase:  v1.CreatedPhase,
+	}
+	r := &reconciler{
+		logger:     logrus.WithField("test-name", t.Name()),
+		client:     fakectrlruntimeclient.NewClientBuilder().WithObjects(calc).Build(),
+		executions: &fakeExecutions{},
+		drainer:    &fakeDrainer{draining: true},
+		artifacts:  artifacts.NewLocalStore(t.TempDir()),
+		hostname:   "worker-1",
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "test-calc"}}
+	if err := r.reconcile(context.Background(), req, r.logger); err != nil {
+		t.Fatal(err)
+	}
+
+	// The calculation is rescheduled instead of executed by the draining worker.
+	if err := r.client.Get(context.Background(), req.NamespacedName, &v1.Calculation{}); !kerrors.IsNotFound(err) {
+		t.Fatalf("expected the calculation to be rescheduled, got %v", err)
+	}
+}
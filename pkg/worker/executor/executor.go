@@ -2,24 +2,39 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
-	"regexp"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sys/unix"
 
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 
 	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
 	"github.com/vega-project/ccb-operator/pkg/grpc"
 	"github.com/vega-project/ccb-operator/pkg/pipelines"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
 	"github.com/vega-project/ccb-operator/pkg/util"
+	"github.com/vega-project/ccb-operator/pkg/worker/cgroups"
+	"github.com/vega-project/ccb-operator/pkg/worker/inputcache"
+	"github.com/vega-project/ccb-operator/pkg/worker/outputs"
+	"github.com/vega-project/ccb-operator/pkg/worker/sandbox"
+	"github.com/vega-project/ccb-operator/pkg/worker/spool"
+	proto "github.com/vega-project/ccb-operator/proto"
 )
 
 type Executor struct {
@@ -28,203 +43,343 @@ type Executor struct {
 	stepUpdaterChan chan util.Result
 	calcErrorChan   chan string
 	Status          string
-	nfsPath         string
+	artifacts       artifacts.ArtifactStore
+	inputCache      *inputcache.Cache
+	// sandboxHiddenPaths are hidden from the commands that run in a sandbox.
+	sandboxHiddenPaths []string
+	// cgroups creates the cgroups of the calculations, nil if they are disabled.
+	cgroups         *cgroups.Manager
 	client          ctrlruntimeclient.Client
+	recorder        record.EventRecorder
 	ctx             context.Context
 	nodename        string
 	namespace       string
 	workerPool      string
 	grpcClient      grpc.Client
+	streamThreshold int
+	spool           *spool.Spool
+
+	// mu guards the calculation that is running and the cancellation of its execution.
+	mu        sync.Mutex
+	running   string
+	cancelRun context.CancelCauseFunc
 }
 
+var (
+	// errCancelled stops the calculations that are deleted while they run.
+	errCancelled = errors.New("the calculation was deleted")
+	// errInterrupted stops the calculation of a worker that couldn't drain in time.
+	errInterrupted = errors.New("the worker is draining")
+)
+
 func NewExecutor(
 	ctx context.Context,
 	client ctrlruntimeclient.Client,
+	recorder record.EventRecorder,
 	executeChan chan *v1.Calculation,
 	calcErrorChan chan string,
 	stepUpdaterChan chan util.Result,
-	nfsPath,
+	artifactStore artifacts.ArtifactStore,
+	inputCache *inputcache.Cache,
+	sandboxHiddenPaths []string,
+	cgroupManager *cgroups.Manager,
 	nodename,
 	namespace,
 	workerPool string,
-	grpcClient grpc.Client) *Executor {
+	grpcClient grpc.Client,
+	streamThreshold int,
+	spool *spool.Spool) *Executor {
 	return &Executor{
-		ctx:             ctx,
-		client:          client,
-		executeChan:     executeChan,
-		stepUpdaterChan: stepUpdaterChan,
-		calcErrorChan:   calcErrorChan,
-		nfsPath:         nfsPath,
-		nodename:        nodename,
-		namespace:       namespace,
-		workerPool:      workerPool,
-		grpcClient:      grpcClient,
+		ctx:                ctx,
+		client:             client,
+		recorder:           recorder,
+		executeChan:        executeChan,
+		stepUpdaterChan:    stepUpdaterChan,
+		calcErrorChan:      calcErrorChan,
+		artifacts:          artifactStore,
+		inputCache:         inputCache,
+		sandboxHiddenPaths: sandboxHiddenPaths,
+		cgroups:            cgroupManager,
+		nodename:           nodename,
+		namespace:          namespace,
+		workerPool:         workerPool,
+		grpcClient:         grpcClient,
+		streamThreshold:    streamThreshold,
+		spool:              spool,
 	}
 }
 
 func (e *Executor) Run() {
-	for {
-		select {
-		case calc := <-e.executeChan:
-			e.logger = logrus.WithField("for-calculation", calc.Name)
-
-			// TODO: Can this run only once when the worker is starting????????????
-			// Setting stack limit
-			if err := setUnlimitStack(); err != nil {
-				e.logger.WithError(err).Error("couln't set stack limit")
-				e.calcErrorChan <- calc.Name
+	for calc := range e.executeChan {
+		e.execute(calc)
+	}
+}
+
+// Cancel stops the execution of the calculation if it's running, and returns whether it was. The
+// executor finalizes the cancelled calculation once its commands stopped.
+func (e *Executor) Cancel(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running != name {
+		return false
+	}
+	e.cancelRun(errCancelled)
+	return true
+}
+
+// Interrupt stops the execution of the running calculation, and returns its name, empty if none
+// runs. The steps that didn't finish aren't reported and the interrupted calculation is left as is,
+// to be rescheduled by the caller once Running returns empty.
+func (e *Executor) Interrupt() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running != "" {
+		e.cancelRun(errInterrupted)
+	}
+	return e.running
+}
+
+// Running returns the name of the calculation that is running, empty if none runs.
+func (e *Executor) Running() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.running
+}
+
+// track records the calculation as running until the returned function is called, and returns the
+// context of its execution, which Cancel and Interrupt cancel.
+func (e *Executor) track(ctx context.Context, name string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	e.mu.Lock()
+	e.running, e.cancelRun = name, cancel
+	e.mu.Unlock()
+	return ctx, func() {
+		e.mu.Lock()
+		e.running, e.cancelRun = "", nil
+		e.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// execute runs the calculation and reports its failures to the controller.
+func (e *Executor) execute(calc *v1.Calculation) {
+	e.logger = logrus.WithField("for-calculation", calc.Name)
+
+	start := time.Now()
+	pipeline := pipelineLabel(calc.Pipeline)
+	queueWaitSeconds.WithLabelValues(pipeline).Observe(start.Sub(calc.CreationTimestamp.Time).Seconds())
+	busy.Set(1)
+
+	failed := false
+	fail := func() {
+		failed = true
+		e.calcErrorChan <- calc.Name
+	}
+	// The calculation continues the trace of its dispatch, and its steps and results are traced in it.
+	ctx, span := tracing.Tracer().Start(tracing.Extract(e.ctx, calc), "ExecuteCalculation", trace.WithAttributes(
+		attribute.String("vega.calculation", calc.Name),
+		attribute.String("vega.pipeline", pipeline),
+		attribute.String("vega.worker", e.nodename),
+	))
+	defer func() {
+		if failed {
+			span.SetStatus(codes.Error, "the calculation failed")
+		}
+		span.End()
+		observeCalculation(pipeline, start, failed)
+	}()
+	ctx, untrack := e.track(ctx, calc.Name)
+	interrupted := func() bool { return errors.Is(context.Cause(ctx), errInterrupted) }
+	defer func() {
+		cancelled := ctx.Err() != nil && !interrupted()
+		untrack()
+		if !cancelled {
+			return
+		}
+		// The calculation was deleted while it ran, it is removed once its output files are.
+		e.logger.Info("The calculation was cancelled, finalizing it")
+		if err := util.FinalizeCalculation(e.ctx, e.client, e.artifacts, calc); err != nil {
+			e.logger.WithError(err).Error("couldn't finalize the cancelled calculation")
+		}
+	}()
+
+	// TODO: Can this run only once when the worker is starting????????????
+	// Setting stack limit
+	if err := setUnlimitStack(); err != nil {
+		e.logger.WithError(err).Error("couln't set stack limit")
+		fail()
+		return
+	}
+
+	rootFolder := calc.Labels[util.CalcRootFolder]
+	calcPath, err := os.MkdirTemp("", calc.Labels[util.CalculationNameLabel])
+	if err != nil {
+		e.logger.WithError(err).Error("error creating temp directory")
+		fail()
+		return
+	}
+
+	if _, err := os.Stat(calcPath); err != nil {
+		if err := os.MkdirAll(calcPath, 0777); err != nil {
+			e.logger.WithError(err).Error("couln't create directory. Aborting...")
+			fail()
+			return
+		}
+	}
+
+	inputs, err := e.stageInputFiles(calc, rootFolder, calcPath)
+	if err != nil {
+		e.logger.WithError(err).Error("couldn't stage the input files. Aborting...")
+		fail()
+		return
+	}
+
+	var runner *stepRunner
+	switch calc.Pipeline {
+	case v1.VegaPipeline:
+		vegaPipeline := pipelines.NewVegaPipeline(calc.Name, calcPath, calc.Spec.Params)
+
+		controlFiles := artifacts.Key(rootFolder, vegaPipeline.AtlasControlFiles)
+		dataFiles := artifacts.Key(rootFolder, vegaPipeline.AtlasDataFiles)
+
+		// Linking the data/control files for atlas12_ada
+		atlasFiles, err := e.linkArtifacts([]string{controlFiles, dataFiles}, calcPath)
+		if err != nil {
+			e.logger.WithError(err).Error("coulnd't link the atlas files for the vega pipeline")
+			fail()
+			break
+		}
+		inputs.Insert(atlasFiles...)
+
+		if runner, err = e.newStepRunner(calc, calcPath, inputs); err != nil {
+			e.logger.WithError(err).Error("couldn't set up the commands of the calculation")
+			fail()
+			break
+		}
+		vegaPipeline.Runner = runner
+
+		if err := vegaPipeline.Run(ctx, e.logger, e.stepUpdaterChan); err != nil {
+			if interrupted() {
+				e.logger.Info("The calculation was interrupted")
 				break
 			}
+			e.logger.WithError(err).Error("error while running the vega pipeline")
+			fail()
+			break
+		}
 
-			rootFolder := filepath.Join(e.nfsPath, calc.Labels[util.CalcRootFolder])
-			calcPath, err := os.MkdirTemp("", calc.Labels[util.CalculationNameLabel])
-			if err != nil {
-				e.logger.WithError(err).Error("error creating temp directory")
-				e.calcErrorChan <- calc.Name
+		data, err := os.ReadFile(filepath.Join(calcPath, "fort.7"))
+		if err != nil {
+			e.logger.WithError(err).Error("couldn't read the fort.7 file")
+			fail()
+			break
+		}
+
+		params := util.ResultParameters(calc.Spec.Params)
+		reply, err := e.storeResults(ctx, params, calc.InputHash, data)
+		if err != nil {
+			// The results are spooled instead of failing the calculation, since producing them again takes hours.
+			e.logger.WithError(err).Warn("couldn't deliver the results, spooling them to retry later")
+			if err := e.spool.Add(calc.Name, params, calc.InputHash, data); err != nil {
+				e.logger.WithError(err).Error("error while spooling the results")
+				fail()
 				break
 			}
+		} else {
+			e.logger.Infof("gRPC server response: %s", reply.GetMessage())
+		}
 
-			if _, err := os.Stat(calcPath); err != nil {
-				if err := os.MkdirAll(calcPath, 0777); err != nil {
-					e.logger.WithError(err).Error("couln't create directory. Aborting...")
-					e.calcErrorChan <- calc.Name
-					break
-				}
-			}
+		if err := e.collectOutputs(calc, calcPath, inputs); err != nil {
+			e.logger.WithError(err).Error("couldn't collect the output files")
+			fail()
+		}
+	default:
+		if runner, err = e.newStepRunner(calc, calcPath, inputs); err != nil {
+			e.logger.WithError(err).Error("couldn't set up the commands of the calculation")
+			fail()
+			break
+		}
 
-			if calc.InputFiles != nil {
-				for _, inputFile := range calc.InputFiles.Files {
-					if calc.InputFiles.Symlink {
-						if err := e.createSymbolicLinks([]string{filepath.Join(rootFolder, inputFile)}, calcPath); err != nil {
-							e.logger.WithError(err).Error("couln't creating symlink. Aborting...")
-							e.calcErrorChan <- calc.Name
-							break
-						}
-						continue
-					}
-
-					input, err := os.ReadFile(filepath.Join(rootFolder, inputFile))
-					if err != nil {
-						e.logger.WithError(err).Error("couln't read input file. Aborting...")
-						e.calcErrorChan <- calc.Name
-						return
-					}
-
-					_, inputFilename := filepath.Split(inputFile)
-					destinationFile := filepath.Join(calcPath, inputFilename)
-					err = os.WriteFile(destinationFile, input, 0644)
-					if err != nil {
-						e.logger.WithError(err).Errorf("couln't write input file %s. Aborting...", destinationFile)
-						e.calcErrorChan <- calc.Name
-						return
-					}
-				}
+		for index, step := range calc.Spec.Steps {
+			if len(step.Status) != 0 {
+				continue
 			}
 
-			switch calc.Pipeline {
-			case v1.VegaPipeline:
-				vegaPipeline := pipelines.NewVegaPipeline(calc.Name, calcPath, calc.Spec.Params)
+			var status v1.CalculationPhase
+			var cmdErr error
+			status = v1.CompletedPhase
 
-				controlFiles := filepath.Join(rootFolder, vegaPipeline.AtlasControlFiles)
-				dataFiles := filepath.Join(rootFolder, vegaPipeline.AtlasDataFiles)
+			stepCtx, cancel := context.WithTimeout(ctx, 4*time.Hour)
+			defer cancel()
 
-				// Creating symbolic links with the data/control files for atlas12_ada
-				if err := e.createSymbolicLinks([]string{controlFiles, dataFiles}, calcPath); err != nil {
-					e.logger.WithError(err).Error("coulnd't create symlinks for the vega pipeline")
-					e.calcErrorChan <- calc.Name
-					break
-				}
+			fields := logrus.Fields{"command": append([]string{step.Command}, step.Args...), "step": index}
+			e.logger.WithFields(fields).Info("Running command and waiting for it to finish...")
 
-				if err := vegaPipeline.Run(e.logger, e.stepUpdaterChan); err != nil {
-					e.logger.WithError(err).Error("error while running the vega pipeline")
-					e.calcErrorChan <- calc.Name
-					break
-				}
-
-				data, err := os.ReadFile(filepath.Join(calcPath, "fort.7"))
-				if err != nil {
-					e.logger.WithError(err).Error("couldn't read the fort.7 file")
-					e.calcErrorChan <- calc.Name
-					break
-				}
+			combinedOut, usage, err := runner.RunStep(stepCtx, index, step)
+			if interrupted() {
+				e.logger.WithFields(fields).Info("The calculation was interrupted")
+				break
+			}
+			if err != nil {
+				e.logger.WithError(err).WithField("output", string(combinedOut)).Error("command failed...")
+				status = v1.FailedPhase
+				cmdErr = err
+			}
 
-				params := map[string]string{
-					"teff":  fmt.Sprintf("%f", calc.Spec.Params.Teff),
-					"log_g": fmt.Sprintf("%f", calc.Spec.Params.LogG),
-				}
+			if err := e.dumpCommandOutput(calcPath, index, combinedOut); err != nil {
+				e.logger.WithError(err).Error("couldn't dump command output to file")
+			}
 
-				reply, err := e.grpcClient.StoreData(params, string(data))
-				if err != nil {
-					e.logger.WithError(err).Error("error while storing the data")
-					e.calcErrorChan <- calc.Name
-					break
-				}
+			result := util.Result{
+				CalcName:     calc.Name,
+				Step:         index,
+				StdoutStderr: string(combinedOut),
+				Status:       status,
+				CommandError: cmdErr,
+				Usage:        usage,
+			}
 
-				e.logger.Infof("gRPC server response: %s", reply.GetMessage())
-			default:
-				for index, step := range calc.Spec.Steps {
-					if len(step.Status) != 0 {
-						continue
-					}
-
-					var status v1.CalculationPhase
-					var cmdErr error
-					status = v1.CompletedPhase
-
-					ctx, cancel := context.WithTimeout(context.Background(), 4*time.Hour)
-					defer cancel()
-
-					cmd := exec.CommandContext(ctx, step.Command, step.Args...)
-					cmd.Dir = calcPath
-
-					fields := logrus.Fields{"command": cmd.Args, "step": index}
-					e.logger.WithFields(fields).Info("Running command and waiting for it to finish...")
-
-					combinedOut, err := cmd.CombinedOutput()
-					if err != nil {
-						e.logger.WithError(err).WithField("output", string(combinedOut)).Error("command failed...")
-						status = v1.FailedPhase
-						cmdErr = err
-					}
-
-					if err := e.dumpCommandOutput(calcPath, index, combinedOut); err != nil {
-						e.logger.WithError(err).Error("couldn't dump command output to file")
-					}
-
-					result := util.Result{
-						CalcName:     calc.Name,
-						Step:         index,
-						StdoutStderr: string(combinedOut),
-						Status:       status,
-						CommandError: cmdErr,
-					}
-
-					e.logger.WithFields(fields).WithField("status", status).Info("Command finished")
-					e.stepUpdaterChan <- result
-
-					if status == v1.FailedPhase {
-						e.calcErrorChan <- calc.Name
-					}
-				}
-				if err := copyMatchingFiles(calcPath, filepath.Join(rootFolder, calc.Labels[util.CalculationNameLabel]), calc.OutputFilesRegex); err != nil {
-					e.logger.WithError(err).Error("couldn't copy the output files")
-					e.calcErrorChan <- calc.Name
-				}
+			e.logger.WithFields(fields).WithField("status", status).Info("Command finished")
+			e.stepUpdaterChan <- result
 
+			if status == v1.FailedPhase {
+				fail()
 			}
+		}
+		if failed || interrupted() {
+			break
+		}
 
-			// All steps finished. Update worker in workerpool and cleanup
-			e.logger.WithField("calc-path", calcPath).Info("All steps finished. Cleaning up...")
-			if err := os.RemoveAll(calcPath); err != nil {
-				e.logger.WithField("path", calcPath).WithError(err).Error("couldn't remove the temp directory")
-				e.calcErrorChan <- calc.Name
-			}
-			if err := util.UpdateWorkerStatusInPool(e.ctx, e.client, e.workerPool, e.nodename, e.namespace, workersv1.WorkerAvailableState); err != nil {
-				// TODO: retry until the state is updated, otherwise the worker will deadlock
-				panic(fmt.Errorf("failed to update worker's state in worker pool: %w", err))
-			}
+		if err := e.collectOutputs(calc, calcPath, inputs); err != nil {
+			e.logger.WithError(err).Error("couldn't collect the output files")
+			fail()
 		}
 	}
+
+	// All steps finished. Update worker in workerpool and cleanup
+	e.logger.WithField("calc-path", calcPath).Info("All steps finished. Cleaning up...")
+	if runner != nil {
+		runner.Close()
+	}
+	if err := os.RemoveAll(calcPath); err != nil {
+		e.logger.WithField("path", calcPath).WithError(err).Error("couldn't remove the temp directory")
+		fail()
+	}
+	if err := util.UpdateWorkerStatusInPool(e.ctx, e.client, e.workerPool, e.nodename, e.namespace, workersv1.WorkerAvailableState); err != nil {
+		// TODO: retry until the state is updated, otherwise the worker will deadlock
+		panic(fmt.Errorf("failed to update worker's state in worker pool: %w", err))
+	}
+}
+
+// storeResults sends the results to the gRPC server, in chunks if they exceed the stream threshold.
+func (e *Executor) storeResults(ctx context.Context, params map[string]string, inputHash string, data []byte) (*proto.StoreResponse, error) {
+	if e.streamThreshold > 0 && len(data) > e.streamThreshold {
+		e.logger.WithField("size", len(data)).Info("Streaming the results in chunks")
+	}
+	start := time.Now()
+	reply, err := grpc.StoreResults(ctx, e.grpcClient, e.streamThreshold, params, inputHash, data)
+	storeDataSeconds.WithLabelValues(resultLabel(err)).Observe(time.Since(start).Seconds())
+	return reply, err
 }
 
 func (e *Executor) dumpCommandOutput(calcPath string, step int, data []byte) error {
@@ -236,82 +391,173 @@ func (e *Executor) dumpCommandOutput(calcPath string, step int, data []byte) err
 	return nil
 }
 
-func (e *Executor) createSymbolicLinks(paths []string, toPath string) error {
-	for _, path := range paths {
-		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+// stageInputFiles makes the input files of the calculation available in its folder, and returns their names.
+func (e *Executor) stageInputFiles(calc *v1.Calculation, rootFolder, calcPath string) (sets.Set[string], error) {
+	staged := sets.New[string]()
+	if calc.InputFiles == nil {
+		return staged, nil
+	}
+
+	for _, inputFile := range calc.InputFiles.Files {
+		key := artifacts.Key(rootFolder, inputFile)
+		if calc.InputFiles.Symlink {
+			names, err := e.linkArtifact(key, calcPath)
 			if err != nil {
-				e.logger.WithError(err).Errorf("prevent panic by handling failure accessing a path %q", path)
-				return err
+				return nil, fmt.Errorf("couldn't link the input file %s: %w", inputFile, err)
 			}
-			if !info.IsDir() {
-				if err := os.Symlink(path, filepath.Join(toPath, filepath.Base(path))); err != nil {
-					return err
+			staged.Insert(names...)
+			continue
+		}
+
+		name := path.Base(key)
+		if err := e.downloadArtifact(key, filepath.Join(calcPath, name)); err != nil {
+			return nil, fmt.Errorf("couldn't download the input file %s: %w", inputFile, err)
+		}
+		staged.Insert(name)
+	}
+	return staged, nil
+}
+
+// commandFunc returns the function that creates the commands of the calculation, which runs them
+// in a sandbox if the worker pool requires it. The staged input files are read-only in the sandbox.
+func (e *Executor) commandFunc(calcPath string, inputs sets.Set[string]) (pipelines.CommandFunc, error) {
+	pool := &workersv1.WorkerPool{}
+	if err := e.client.Get(e.ctx, ctrlruntimeclient.ObjectKey{Namespace: e.namespace, Name: e.workerPool}, pool); err != nil {
+		return nil, fmt.Errorf("failed to get the worker pool: %w", err)
+	}
+	if pool.Spec.Sandbox == nil {
+		return exec.CommandContext, nil
+	}
+
+	config := sandbox.Config{
+		Dir:     calcPath,
+		Hidden:  e.sandboxHiddenPaths,
+		Network: pool.Spec.Sandbox.Network,
+		UID:     os.Geteuid(),
+		GID:     os.Getegid(),
+	}
+	for _, name := range sets.List(inputs) {
+		config.ReadOnly = append(config.ReadOnly, filepath.Join(calcPath, name))
+	}
+
+	// Privileged workers run the commands as another user, which needs to write to the calculation
+	// folder and read the input files.
+	if config.UID == 0 {
+		config.UID, config.GID = sandboxUser, sandboxUser
+		if pool.Spec.Sandbox.RunAsUser != nil {
+			config.UID = int(*pool.Spec.Sandbox.RunAsUser)
+		}
+		if pool.Spec.Sandbox.RunAsGroup != nil {
+			config.GID = int(*pool.Spec.Sandbox.RunAsGroup)
+		}
+		if err := os.Chown(calcPath, config.UID, config.GID); err != nil {
+			return nil, err
+		}
+		for _, path := range config.ReadOnly {
+			info, err := os.Lstat(path)
+			if err != nil {
+				return nil, err
+			}
+			if info.Mode().IsRegular() {
+				if err := os.Chmod(path, info.Mode().Perm()|0444); err != nil {
+					return nil, err
 				}
 			}
-			return nil
-		})
-		if err != nil {
-			e.logger.WithField("path", path).WithError(err).Error("error while walking to path")
-			return err
 		}
 	}
-	return nil
-}
 
-func setUnlimitStack() error {
-	var rLimit unix.Rlimit
-	rLimit.Max = 18446744073709551615
-	rLimit.Cur = 18446744073709551615
+	e.logger.WithFields(logrus.Fields{"uid": config.UID, "network": config.Network}).Info("Running the commands in a sandbox")
+	return config.CommandContext, nil
+}
 
-	if err := unix.Setrlimit(unix.RLIMIT_STACK, &rLimit); err != nil {
-		return fmt.Errorf("error Setting Rlimit %v", err)
+// linkArtifacts links the artifacts with the given keys to the folder. The artifacts in folders
+// are linked without their subfolders.
+func (e *Executor) linkArtifacts(keys []string, toPath string) ([]string, error) {
+	var linked []string
+	for _, key := range keys {
+		names, err := e.linkArtifact(key, toPath)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't link %s: %w", key, err)
+		}
+		linked = append(linked, names...)
 	}
-	return nil
+	return linked, nil
 }
 
-func copyFile(src, dest string) error {
-	sourceFile, err := os.Open(src)
+func (e *Executor) linkArtifact(key, toPath string) ([]string, error) {
+	keys, err := e.artifacts.List(e.ctx, key)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(keys) == 0 {
+		keys = []string{key}
 	}
-	defer sourceFile.Close()
 
-	destFile, err := os.Create(dest)
-	if err != nil {
-		return err
+	var names []string
+	for _, k := range keys {
+		name := path.Base(k)
+		if err := e.linkArtifactFile(k, filepath.Join(toPath, name)); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
 	}
-	defer destFile.Close()
+	return names, nil
+}
 
-	_, err = io.Copy(destFile, sourceFile)
-	if err != nil {
-		return err
+// downloadArtifact makes a private copy of the artifact, from the input cache when it is enabled.
+func (e *Executor) downloadArtifact(key, dest string) error {
+	if e.inputCache != nil {
+		return e.inputCache.Copy(e.ctx, key, dest)
 	}
-	return nil
+	return e.artifacts.Download(e.ctx, key, dest)
 }
 
-func copyMatchingFiles(srcDir, destDir, pattern string) error {
-	regex, err := regexp.Compile(pattern)
-	if err != nil {
-		return err
+// linkArtifactFile links the artifact, from the input cache when it is enabled.
+func (e *Executor) linkArtifactFile(key, dest string) error {
+	if e.inputCache != nil {
+		return e.inputCache.Link(e.ctx, key, dest)
 	}
+	return e.artifacts.Link(e.ctx, key, dest)
+}
 
-	if err := os.MkdirAll(destDir, 0755); err != nil {
+// collectOutputs uploads the output files of the calculation and records their manifest in its
+// status, which the dispatcher waits for before completing the calculation. The staged input
+// files are not uploaded back.
+func (e *Executor) collectOutputs(calc *v1.Calculation, calcPath string, inputs sets.Set[string]) error {
+	manifest, err := outputs.Collect(e.ctx, e.artifacts, calc, calcPath, outputKey(calc), inputs, e.logger)
+	if err != nil {
 		return err
 	}
 
-	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		calculation := &v1.Calculation{}
+		if err := e.client.Get(e.ctx, ctrlruntimeclient.ObjectKey{Namespace: calc.Namespace, Name: calc.Name}, calculation); err != nil {
+			return fmt.Errorf("failed to get the calculation: %w", err)
 		}
+		calculation.Status.Outputs = manifest
+		return e.client.Update(e.ctx, calculation)
+	})
+}
 
-		if !info.IsDir() && regex.MatchString(info.Name()) {
-			destPath := filepath.Join(destDir, info.Name())
-			if err := copyFile(path, destPath); err != nil {
-				return err
-			}
-			logrus.Infof("Copied %s to %s", path, destPath)
-		}
+// outputKey returns the folder where the output files of the calculation are uploaded. The factory
+// calculations leave them in the root folder, where the factory reads the generated bulk from.
+func outputKey(calc *v1.Calculation) string {
+	if _, ok := calc.Labels[util.FactoryLabel]; ok {
+		return calc.Labels[util.CalcRootFolder]
+	}
+	return util.OutputStagingKey(calc)
+}
 
-		return nil
-	})
+// sandboxUser is the user and group that privileged workers run the sandboxed commands as by default, nobody.
+const sandboxUser = 65534
+
+func setUnlimitStack() error {
+	var rLimit unix.Rlimit
+	rLimit.Max = 18446744073709551615
+	rLimit.Cur = 18446744073709551615
+
+	if err := unix.Setrlimit(unix.RLIMIT_STACK, &rLimit); err != nil {
+		return fmt.Errorf("error Setting Rlimit %v", err)
+	}
+	return nil
 }
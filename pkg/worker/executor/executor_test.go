@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStopExecution(t *testing.T) {
+	testCases := []struct {
+		name          string
+		stop          func(e *Executor) bool
+		expectedCause error
+	}{
+		{
+			name:          "the deleted calculation is cancelled",
+			stop:          func(e *Executor) bool { return e.Cancel("calc") },
+			expectedCause: errCancelled,
+		},
+		{
+			name:          "the calculation of a draining worker is interrupted",
+			stop:          func(e *Executor) bool { return e.Interrupt() == "calc" },
+			expectedCause: errInterrupted,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Executor{}
+			if e.Cancel("calc") || e.Interrupt() != "" {
+				t.Fatal("expected nothing to stop before the calculation runs")
+			}
+
+			ctx, untrack := e.track(context.Background(), "calc")
+			if running := e.Running(); running != "calc" {
+				t.Fatalf("expected the calculation to run, got %q", running)
+			}
+			if e.Cancel("other") {
+				t.Fatal("expected another calculation not to be cancelled")
+			}
+			if !tc.stop(e) {
+				t.Fatal("expected the calculation to be stopped")
+			}
+			if cause := context.Cause(ctx); !errors.Is(cause, tc.expectedCause) {
+				t.Fatalf("expected the execution to be stopped by %v, got %v", tc.expectedCause, cause)
+			}
+
+			untrack()
+			if running := e.Running(); running != "" {
+				t.Fatalf("expected no calculation to run, got %q", running)
+			}
+		})
+	}
+}
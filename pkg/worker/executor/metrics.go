@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+)
+
+const (
+	resultSucceeded = "succeeded"
+	resultFailed    = "failed"
+)
+
+var (
+	stepWallSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "step_wall_seconds",
+		Help:      "Wall time of the steps of the calculations, by pipeline and step index",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+	}, []string{"pipeline", "step"})
+	stepCPUSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "step_cpu_seconds",
+		Help:      "CPU time of the processes of the steps of the calculations, by pipeline and step index",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+	}, []string{"pipeline", "step"})
+	stepPeakMemoryBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "step_peak_memory_bytes",
+		Help:      "Peak memory of the processes of the steps of the calculations, by pipeline and step index",
+		Buckets:   prometheus.ExponentialBuckets(1<<20, 2, 16),
+	}, []string{"pipeline", "step"})
+	stepsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "steps_total",
+		Help:      "Number of steps of the calculations that ran, by pipeline and result",
+	}, []string{"pipeline", "result"})
+	calculationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "calculations_total",
+		Help:      "Number of calculations that the worker executed, by pipeline and result",
+	}, []string{"pipeline", "result"})
+	queueWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "queue_wait_seconds",
+		Help:      "Time from the creation of the calculations until the worker started to execute them, by pipeline",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 12),
+	}, []string{"pipeline"})
+	busy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "busy",
+		Help:      "Whether the executor is running a calculation",
+	})
+	busySeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "busy_seconds_total",
+		Help:      "Time the executor spent running calculations, its rate is the busy ratio of the worker",
+	})
+	storeDataSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vega",
+		Subsystem: "worker",
+		Name:      "store_data_duration_seconds",
+		Help:      "Latency of the delivery of the results to the results-handler, by result",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		stepWallSeconds, stepCPUSeconds, stepPeakMemoryBytes, stepsTotal,
+		calculationsTotal, queueWaitSeconds, busy, busySeconds, storeDataSeconds,
+	)
+}
+
+// pipelineLabel is the value of the pipeline label of the metrics of a calculation.
+func pipelineLabel(pipeline v1.Pipeline) string {
+	if pipeline == "" {
+		return "default"
+	}
+	return string(pipeline)
+}
+
+// resultLabel is the value of the result label of the metrics of an operation.
+func resultLabel(err error) string {
+	if err != nil {
+		return resultFailed
+	}
+	return resultSucceeded
+}
+
+// observeCalculation records a calculation that the executor finished running since start.
+func observeCalculation(pipeline string, start time.Time, failed bool) {
+	result := resultSucceeded
+	if failed {
+		result = resultFailed
+	}
+	calculationsTotal.WithLabelValues(pipeline, result).Inc()
+	busySeconds.Add(time.Since(start).Seconds())
+	busy.Set(0)
+}
@@ -0,0 +1,165 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/pipelines"
+	"github.com/vega-project/ccb-operator/pkg/tracing"
+	"github.com/vega-project/ccb-operator/pkg/worker/cgroups"
+)
+
+// The reasons of the events of the steps of the calculations.
+const (
+	StepStartedReason  = "StepStarted"
+	StepFinishedReason = "StepFinished"
+	StepFailedReason   = "StepFailed"
+)
+
+// stepRunner runs the commands of the steps of a calculation in its folder and measures the
+// resources they use. With cgroups, the processes of the calculation are limited together and the
+// processes of each step are accounted in their own cgroup.
+type stepRunner struct {
+	logger     *logrus.Entry
+	recorder   record.EventRecorder
+	calc       *v1.Calculation
+	pipeline   string
+	dir        string
+	newCommand pipelines.CommandFunc
+	cgroup     *cgroups.Calculation
+}
+
+// newStepRunner returns the runner of the steps of the calculation, which enforces its resource
+// limits. Limits can't be enforced without cgroups, so such calculations fail.
+func (e *Executor) newStepRunner(calc *v1.Calculation, calcPath string, inputs sets.Set[string]) (*stepRunner, error) {
+	newCommand, err := e.commandFunc(calcPath, inputs)
+	if err != nil {
+		return nil, err
+	}
+	r := &stepRunner{
+		logger:     e.logger,
+		recorder:   e.recorder,
+		calc:       calc,
+		pipeline:   pipelineLabel(calc.Pipeline),
+		dir:        calcPath,
+		newCommand: newCommand,
+	}
+
+	var limits cgroups.Limits
+	if calc.Resources != nil {
+		if calc.Resources.Memory != nil {
+			limits.Memory = calc.Resources.Memory.Value()
+		}
+		if calc.Resources.CPU != nil {
+			limits.MilliCPU = calc.Resources.CPU.MilliValue()
+		}
+	}
+	if e.cgroups == nil {
+		if limits != (cgroups.Limits{}) {
+			return nil, fmt.Errorf("the resource limits of the calculation can't be enforced without cgroups")
+		}
+		return r, nil
+	}
+
+	if r.cgroup, err = e.cgroups.NewCalculation(calc.Name, limits); err != nil {
+		return nil, fmt.Errorf("couldn't create the cgroup of the calculation: %w", err)
+	}
+	return r, nil
+}
+
+// RunStep runs the step in a span of the trace of the calculation, and records its start and end
+// in the events of the calculation.
+func (r *stepRunner) RunStep(ctx context.Context, index int, step v1.Step) ([]byte, *v1.StepUsage, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "RunStep", trace.WithAttributes(
+		attribute.Int("vega.step", index),
+		attribute.String("vega.command", step.Command),
+	))
+	r.recorder.Eventf(r.calc, corev1.EventTypeNormal, StepStartedReason, "Started step %d (%s)", index, step.Command)
+	out, usage, err := r.runStep(ctx, index, step)
+	if err != nil {
+		r.recorder.Eventf(r.calc, corev1.EventTypeWarning, StepFailedReason, "Step %d (%s) failed: %v", index, step.Command, err)
+	} else {
+		r.recorder.Eventf(r.calc, corev1.EventTypeNormal, StepFinishedReason, "Step %d (%s) finished in %s", index, step.Command, usage.WallTime.Round(time.Millisecond))
+	}
+	if usage != nil {
+		span.SetAttributes(
+			attribute.Float64("vega.cpu_seconds", usage.CPUTime.Seconds()),
+			attribute.Int64("vega.peak_memory_bytes", usage.PeakMemoryBytes),
+		)
+	}
+	tracing.End(span, err)
+	return out, usage, err
+}
+
+func (r *stepRunner) runStep(ctx context.Context, index int, step v1.Step) ([]byte, *v1.StepUsage, error) {
+	cmd := r.newCommand(ctx, step.Command, step.Args...)
+	cmd.Dir = r.dir
+
+	var cgroup *cgroups.Step
+	if r.cgroup != nil {
+		var err error
+		if cgroup, err = r.cgroup.NewStep(index); err != nil {
+			return nil, nil, fmt.Errorf("couldn't create the cgroup of the step: %w", err)
+		}
+		defer func() {
+			if err := cgroup.Close(); err != nil {
+				r.logger.WithError(err).Warn("Couldn't remove the cgroup of the step")
+			}
+		}()
+		cgroup.Attach(cmd)
+	}
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	usage := &v1.StepUsage{Step: index, WallTime: metav1.Duration{Duration: time.Since(start)}}
+
+	// Without cgroups, the usage of the processes that were waited for is known.
+	if cmd.ProcessState != nil {
+		usage.CPUTime.Duration = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+		if rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+			usage.PeakMemoryBytes = rusage.Maxrss * 1024
+		}
+	}
+	if cgroup != nil {
+		cgroupUsage, usageErr := cgroup.Usage()
+		if usageErr != nil {
+			r.logger.WithError(usageErr).Warn("Couldn't read the usage of the cgroup of the step")
+		} else {
+			usage.CPUTime.Duration = cgroupUsage.CPUTime
+			if cgroupUsage.PeakMemory > 0 {
+				usage.PeakMemoryBytes = cgroupUsage.PeakMemory
+			}
+		}
+	}
+
+	labels := prometheus.Labels{"pipeline": r.pipeline, "step": strconv.Itoa(index)}
+	stepWallSeconds.With(labels).Observe(usage.WallTime.Seconds())
+	stepCPUSeconds.With(labels).Observe(usage.CPUTime.Seconds())
+	stepPeakMemoryBytes.With(labels).Observe(float64(usage.PeakMemoryBytes))
+	stepsTotal.WithLabelValues(r.pipeline, resultLabel(err)).Inc()
+	return out, usage, err
+}
+
+// Close removes the cgroup of the calculation.
+func (r *stepRunner) Close() {
+	if r.cgroup == nil {
+		return
+	}
+	if err := r.cgroup.Close(); err != nil {
+		r.logger.WithError(err).Warn("Couldn't remove the cgroup of the calculation")
+	}
+}
@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+)
+
+func TestStepRunnerWithoutCgroups(t *testing.T) {
+	dir := t.TempDir()
+	recorder := record.NewFakeRecorder(10)
+	r := &stepRunner{
+		logger:     logrus.WithField("test-name", t.Name()),
+		recorder:   recorder,
+		calc:       &v1.Calculation{ObjectMeta: metav1.ObjectMeta{Name: "calc", Namespace: "vega"}},
+		pipeline:   pipelineLabel(""),
+		dir:        dir,
+		newCommand: exec.CommandContext,
+	}
+
+	out, usage, err := r.RunStep(context.Background(), 1, v1.Step{Command: "/bin/sh", Args: []string{"-c", "pwd"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != dir+"\n" {
+		t.Errorf("expected the step to run in %s, got %q", dir, out)
+	}
+	if usage.Step != 1 || usage.WallTime.Duration <= 0 || usage.PeakMemoryBytes <= 0 {
+		t.Errorf("expected the usage of the step to be measured, got %+v", usage)
+	}
+
+	_, usage, err = r.RunStep(context.Background(), 2, v1.Step{Command: "/bin/sh", Args: []string{"-c", "exit 1"}})
+	if err == nil {
+		t.Fatal("expected the step to fail")
+	}
+	if usage == nil || usage.Step != 2 {
+		t.Errorf("expected the usage of the failed step, got %+v", usage)
+	}
+
+	close(recorder.Events)
+	var reasons []string
+	for event := range recorder.Events {
+		reasons = append(reasons, strings.Join(strings.Fields(event)[:2], " "))
+	}
+	expected := []string{"Normal StepStarted", "Normal StepFinished", "Normal StepStarted", "Warning StepFailed"}
+	if diff := cmp.Diff(expected, reasons); diff != "" {
+		t.Errorf("unexpected events: %s", diff)
+	}
+}
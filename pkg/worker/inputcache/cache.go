@@ -0,0 +1,362 @@
+package inputcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+)
+
+var (
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "worker_input_cache",
+		Name:      "requests_total",
+		Help:      "Number of input files staged through the cache, by result (hit or miss)",
+	}, []string{"result"})
+	cacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "worker_input_cache",
+		Name:      "evictions_total",
+		Help:      "Number of input files evicted from the cache to stay within its size",
+	})
+	cacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vega",
+		Subsystem: "worker_input_cache",
+		Name:      "bytes",
+		Help:      "Size in bytes of the cached input files",
+	})
+	cacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vega",
+		Subsystem: "worker_input_cache",
+		Name:      "entries",
+		Help:      "Number of cached input files",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(cacheRequests, cacheEvictions, cacheBytes, cacheEntries)
+}
+
+var digestRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// entry is a cached input file, named after the sha256 sum of its content.
+type entry struct {
+	size     int64
+	modTime  time.Time
+	lastUsed time.Time
+	// verified is false for the entries found on startup, whose content is checked on first use.
+	verified bool
+}
+
+// Cache keeps the input files of the calculations on the local disk of the node, keyed by the
+// sha256 sum of their content, so the same inputs are fetched from the artifact store only once.
+// The least recently used files are evicted when the cache exceeds its size.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	store    artifacts.ArtifactStore
+	logger   *logrus.Entry
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	size    int64
+}
+
+// New returns a Cache that keeps at most maxBytes of input files in the given directory. The
+// files cached by previous runs are kept.
+func New(dir string, maxBytes int64, store artifacts.ArtifactStore) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("couldn't create the input cache directory: %w", err)
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		store:    store,
+		logger:   logrus.WithField("component", "input-cache"),
+		now:      time.Now,
+		entries:  make(map[string]*entry),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.evict("")
+	c.updateMetrics()
+	return c, nil
+}
+
+// load indexes the files that are already in the cache directory, and removes the partially fetched ones.
+func (c *Cache) load() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("couldn't list the input cache directory: %w", err)
+	}
+	for _, f := range files {
+		file := filepath.Join(c.dir, f.Name())
+		if !digestRegexp.MatchString(f.Name()) {
+			if err := os.RemoveAll(file); err != nil {
+				return fmt.Errorf("couldn't clean up the input cache directory: %w", err)
+			}
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return err
+		}
+		c.entries[f.Name()] = &entry{size: info.Size(), modTime: info.ModTime(), lastUsed: info.ModTime()}
+		c.size += info.Size()
+	}
+	return nil
+}
+
+// Link makes the artifact available at the given local path as a hard link to the cached file.
+// The file must not be modified. If the cache is on another filesystem, the file is reflinked or copied.
+func (c *Cache) Link(ctx context.Context, key, dest string) error {
+	return c.stage(ctx, key, dest, true)
+}
+
+// Copy makes a private copy of the artifact at the given local path, reflinked from the cached
+// file when the filesystem supports it.
+func (c *Cache) Copy(ctx context.Context, key, dest string) error {
+	return c.stage(ctx, key, dest, false)
+}
+
+func (c *Cache) stage(ctx context.Context, key, dest string, link bool) error {
+	digest, err := c.digest(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.updateMetrics()
+
+	if c.lookup(digest) {
+		cacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		cacheRequests.WithLabelValues("miss").Inc()
+		tmp, size, err := c.fetch(ctx, key, digest)
+		if err != nil {
+			return err
+		}
+		if size > c.maxBytes {
+			c.logger.WithFields(logrus.Fields{"key": key, "size": size}).Warn("Input file is larger than the cache, staging it without caching")
+			defer os.Remove(tmp)
+			return linkFile(tmp, dest)
+		}
+		if err := c.insert(tmp, digest, size); err != nil {
+			return err
+		}
+		c.evict(digest)
+	}
+
+	if err := c.touch(digest); err != nil {
+		return err
+	}
+	if link {
+		return linkFile(c.path(digest), dest)
+	}
+	return copyFile(c.path(digest), dest)
+}
+
+// digest returns the sha256 sum of the artifact, which the cached file is named after.
+func (c *Cache) digest(ctx context.Context, key string) (string, error) {
+	name := path.Base(key)
+	digests, err := c.store.Digests(ctx, path.Dir(key), []string{name})
+	if err != nil {
+		return "", err
+	}
+	digest, ok := digests[name]
+	if !ok || len(digests) != 1 {
+		return "", fmt.Errorf("%s is not a file", key)
+	}
+	return digest, nil
+}
+
+func (c *Cache) path(digest string) string {
+	return filepath.Join(c.dir, digest)
+}
+
+// lookup reports whether the file is cached with the expected content. Files that were modified
+// through their hard links are removed.
+func (c *Cache) lookup(digest string) bool {
+	e, ok := c.entries[digest]
+	if !ok {
+		return false
+	}
+
+	info, err := os.Stat(c.path(digest))
+	valid := err == nil && info.Size() == e.size && info.ModTime().Equal(e.modTime)
+	if valid && !e.verified {
+		sum, err := fileChecksum(c.path(digest))
+		valid = err == nil && sum == digest
+	}
+	if !valid {
+		c.logger.WithField("digest", digest).Warn("Cached input file was modified, fetching it again")
+		c.remove(digest)
+		return false
+	}
+	e.verified = true
+	return true
+}
+
+// fetch downloads the artifact next to the cached files and verifies its content.
+func (c *Cache) fetch(ctx context.Context, key, digest string) (string, int64, error) {
+	tmp := filepath.Join(c.dir, "."+digest+".tmp")
+	if err := c.store.Download(ctx, key, tmp); err != nil {
+		return "", 0, fmt.Errorf("couldn't fetch %s: %w", key, err)
+	}
+
+	sum, err := fileChecksum(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+	if sum != digest {
+		os.Remove(tmp)
+		return "", 0, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", key, digest, sum)
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+	return tmp, info.Size(), nil
+}
+
+func (c *Cache) insert(tmp, digest string, size int64) error {
+	// The cached files are read-only, since they are hard linked into the calculation folders.
+	if err := os.Chmod(tmp, 0444); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path(digest)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.entries[digest] = &entry{size: size, verified: true}
+	c.size += size
+	return nil
+}
+
+// touch marks the file as used. The modification time is updated too, so the order of use is
+// known after a restart.
+func (c *Cache) touch(digest string) error {
+	now := c.now()
+	if err := os.Chtimes(c.path(digest), now, now); err != nil {
+		return err
+	}
+	info, err := os.Stat(c.path(digest))
+	if err != nil {
+		return err
+	}
+	e := c.entries[digest]
+	e.modTime = info.ModTime()
+	e.lastUsed = now
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits in its size, keeping the given file.
+func (c *Cache) evict(keep string) {
+	if c.size <= c.maxBytes {
+		return
+	}
+
+	digests := make([]string, 0, len(c.entries))
+	for digest := range c.entries {
+		if digest != keep {
+			digests = append(digests, digest)
+		}
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return c.entries[digests[i]].lastUsed.Before(c.entries[digests[j]].lastUsed)
+	})
+
+	for _, digest := range digests {
+		if c.size <= c.maxBytes {
+			return
+		}
+		c.logger.WithField("digest", digest).Info("Evicting input file from the cache")
+		c.remove(digest)
+		cacheEvictions.Inc()
+	}
+}
+
+func (c *Cache) remove(digest string) {
+	if err := os.Remove(c.path(digest)); err != nil && !os.IsNotExist(err) {
+		c.logger.WithError(err).WithField("digest", digest).Error("Couldn't remove the cached input file")
+	}
+	c.size -= c.entries[digest].size
+	delete(c.entries, digest)
+}
+
+func (c *Cache) updateMetrics() {
+	cacheBytes.Set(float64(c.size))
+	cacheEntries.Set(float64(len(c.entries)))
+}
+
+// linkFile hard links the file, or reflinks or copies it if the destination is on another filesystem.
+func linkFile(src, dest string) error {
+	err := os.Link(src, dest)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	return copyFile(src, dest)
+}
+
+// copyFile reflinks the file if the filesystem supports it, and copies it otherwise.
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			os.Remove(dest)
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,317 @@
+package inputcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+)
+
+// newTestStore returns a local artifact store with the given files, and its root folder.
+func newTestStore(t *testing.T, files map[string]string) (artifacts.ArtifactStore, string) {
+	root := t.TempDir()
+	for key, content := range files {
+		writeFile(t, filepath.Join(root, key), content)
+	}
+	return artifacts.NewLocalStore(root), root
+}
+
+func newTestCache(t *testing.T, dir string, maxBytes int64, store artifacts.ArtifactStore) *Cache {
+	c, err := New(dir, maxBytes, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return c
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func digestOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func cachedDigests(c *Cache) []string {
+	var digests []string
+	for digest := range c.entries {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	return digests
+}
+
+func requests(result string) float64 {
+	return testutil.ToFloat64(cacheRequests.WithLabelValues(result))
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	ctx := context.Background()
+	store, root := newTestStore(t, map[string]string{"root/data/molecules.dat": "molecules"})
+	c := newTestCache(t, t.TempDir(), 1024, store)
+	calcPath := t.TempDir()
+	hits, misses := requests("hit"), requests("miss")
+
+	first := filepath.Join(calcPath, "first")
+	if err := c.Link(ctx, "root/data/molecules.dat", first); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(calcPath, "second")
+	if err := c.Link(ctx, "root/data/molecules.dat", second); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := requests("miss") - misses; got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+	if got := requests("hit") - hits; got != 1 {
+		t.Errorf("expected 1 hit, got %v", got)
+	}
+	for _, path := range []string{first, second} {
+		if content := readFile(t, path); content != "molecules" {
+			t.Errorf("expected the content of the artifact in %s, got %q", path, content)
+		}
+	}
+
+	firstInfo, err := os.Stat(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedInfo, err := os.Stat(c.path(digestOf("molecules")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(firstInfo, cachedInfo) {
+		t.Error("expected the staged file to be hard linked to the cached file")
+	}
+
+	// A new version of the artifact has another digest.
+	writeFile(t, filepath.Join(root, "root/data/molecules.dat"), "new molecules")
+	third := filepath.Join(calcPath, "third")
+	if err := c.Link(ctx, "root/data/molecules.dat", third); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests("miss") - misses; got != 2 {
+		t.Errorf("expected 2 misses, got %v", got)
+	}
+	if content := readFile(t, third); content != "new molecules" {
+		t.Errorf("expected the new content, got %q", content)
+	}
+	expected := []string{digestOf("molecules"), digestOf("new molecules")}
+	sort.Strings(expected)
+	if diff := cmp.Diff(expected, cachedDigests(c)); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestCacheCopiesArePrivate(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t, map[string]string{"root/input": "input"})
+	c := newTestCache(t, t.TempDir(), 1024, store)
+	calcPath := t.TempDir()
+
+	dest := filepath.Join(calcPath, "input")
+	if err := c.Copy(ctx, "root/input", dest); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dest, "modified by the calculation")
+
+	if content := readFile(t, c.path(digestOf("input"))); content != "input" {
+		t.Fatalf("expected the cached file to be unchanged, got %q", content)
+	}
+	hits := requests("hit")
+	again := filepath.Join(calcPath, "again")
+	if err := c.Copy(ctx, "root/input", again); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests("hit") - hits; got != 1 {
+		t.Errorf("expected a hit, got %v", got)
+	}
+	if content := readFile(t, again); content != "input" {
+		t.Errorf("expected the content of the artifact, got %q", content)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t, map[string]string{"a": "aaaa", "b": "bbbb", "c": "cccc"})
+	c := newTestCache(t, t.TempDir(), 10, store)
+	calcPath := t.TempDir()
+	evictions := testutil.ToFloat64(cacheEvictions)
+
+	for i, key := range []string{"a", "b", "a", "c"} {
+		if err := c.Link(ctx, key, filepath.Join(calcPath, fmt.Sprintf("%s-%d", key, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{digestOf("aaaa"), digestOf("cccc")}
+	sort.Strings(expected)
+	if diff := cmp.Diff(expected, cachedDigests(c)); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := os.Stat(c.path(digestOf("bbbb"))); !os.IsNotExist(err) {
+		t.Errorf("expected the evicted file to be removed, got %v", err)
+	}
+	if got := testutil.ToFloat64(cacheEvictions) - evictions; got != 1 {
+		t.Errorf("expected 1 eviction, got %v", got)
+	}
+	if c.size != 8 {
+		t.Errorf("expected a cache size of 8 bytes, got %d", c.size)
+	}
+}
+
+func TestCacheFetchesModifiedFilesAgain(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t, map[string]string{"root/input": "input"})
+	c := newTestCache(t, t.TempDir(), 1024, store)
+	calcPath := t.TempDir()
+
+	linked := filepath.Join(calcPath, "linked")
+	if err := c.Link(ctx, "root/input", linked); err != nil {
+		t.Fatal(err)
+	}
+	// A calculation that writes to a hard linked input modifies the cached file.
+	if err := os.Chmod(linked, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, linked, "corrupted")
+
+	misses := requests("miss")
+	again := filepath.Join(calcPath, "again")
+	if err := c.Link(ctx, "root/input", again); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests("miss") - misses; got != 1 {
+		t.Errorf("expected a miss, got %v", got)
+	}
+	if content := readFile(t, again); content != "input" {
+		t.Errorf("expected the content of the artifact, got %q", content)
+	}
+}
+
+func TestCacheVerifiesFilesFromPreviousRuns(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t, map[string]string{"root/input": "input"})
+	dir := t.TempDir()
+	calcPath := t.TempDir()
+
+	if err := newTestCache(t, dir, 1024, store).Link(ctx, "root/input", filepath.Join(calcPath, "first")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The content changes without changing the size and the modification time.
+	cached := filepath.Join(dir, digestOf("input"))
+	info, err := os.Stat(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(cached, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, cached, "INPUT")
+	if err := os.Chtimes(cached, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "."+digestOf("other")+".tmp"), "partially fetched")
+
+	c := newTestCache(t, dir, 1024, store)
+	if diff := cmp.Diff([]string{digestOf("input")}, cachedDigests(c)); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "."+digestOf("other")+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("expected the partially fetched file to be removed, got %v", err)
+	}
+
+	misses := requests("miss")
+	second := filepath.Join(calcPath, "second")
+	if err := c.Link(ctx, "root/input", second); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests("miss") - misses; got != 1 {
+		t.Errorf("expected a miss, got %v", got)
+	}
+	if content := readFile(t, second); content != "input" {
+		t.Errorf("expected the content of the artifact, got %q", content)
+	}
+}
+
+// corruptingStore reports a digest that doesn't match the content of the artifacts.
+type corruptingStore struct {
+	artifacts.ArtifactStore
+}
+
+func (s corruptingStore) Digests(ctx context.Context, root string, paths []string) (map[string]string, error) {
+	digests := make(map[string]string)
+	for _, path := range paths {
+		digests[path] = digestOf("something else")
+	}
+	return digests, nil
+}
+
+func TestCacheRejectsChecksumMismatch(t *testing.T) {
+	store, _ := newTestStore(t, map[string]string{"root/input": "input"})
+	dir := t.TempDir()
+	c := newTestCache(t, dir, 1024, corruptingStore{store})
+
+	if err := c.Link(context.Background(), "root/input", filepath.Join(t.TempDir(), "input")); err == nil {
+		t.Fatal("expected a checksum mismatch error")
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || len(c.entries) != 0 {
+		t.Fatalf("expected nothing to be cached, got %v", files)
+	}
+}
+
+func TestCacheDoesNotCacheLargerFiles(t *testing.T) {
+	store, _ := newTestStore(t, map[string]string{"root/input": "large input"})
+	dir := t.TempDir()
+	c := newTestCache(t, dir, 4, store)
+
+	dest := filepath.Join(t.TempDir(), "input")
+	if err := c.Link(context.Background(), "root/input", dest); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFile(t, dest); content != "large input" {
+		t.Errorf("expected the content of the artifact, got %q", content)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || c.size != 0 {
+		t.Fatalf("expected nothing to be cached, got %v", files)
+	}
+}
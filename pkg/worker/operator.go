@@ -3,18 +3,24 @@ package worker
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"k8s.io/client-go/rest"
 
 	calculationsv1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
 	"github.com/vega-project/ccb-operator/pkg/grpc"
 	"github.com/vega-project/ccb-operator/pkg/util"
+	"github.com/vega-project/ccb-operator/pkg/worker/cgroups"
 	"github.com/vega-project/ccb-operator/pkg/worker/executor"
+	"github.com/vega-project/ccb-operator/pkg/worker/inputcache"
+	"github.com/vega-project/ccb-operator/pkg/worker/spool"
 	"github.com/vega-project/ccb-operator/pkg/worker/workerpools"
 )
 
@@ -25,25 +31,43 @@ type Operator struct {
 	cfg                    *rest.Config
 	calculationsController *Controller
 	executor               *executor.Executor
+	drainer                *workerpools.Drainer
 	hostname               string
 	nodename               string
 	namespace              string
 	workerPool             string
-	nfsPath                string
-	grpcAddress            string
+	artifacts              artifacts.ArtifactStore
+	inputCacheDir          string
+	inputCacheSize         int64
+	sandboxHiddenPaths     []string
+	cgroupRoot             string
+	grpcOptions            grpc.Options
+	spool                  *spool.Spool
+	spoolDir               string
+	spoolReplayInterval    time.Duration
+	metricsPort            int
+	maxDrainTime           time.Duration
 }
 
-func NewMainOperator(ctx context.Context, hostname, nodename, namespace, workerPool, nfsPath string, cfg *rest.Config, grpcAddress string) *Operator {
+func NewMainOperator(ctx context.Context, hostname, nodename, namespace, workerPool, spoolDir, inputCacheDir string, inputCacheSize int64, sandboxHiddenPaths []string, cgroupRoot string, artifactStore artifacts.ArtifactStore, spoolReplayInterval time.Duration, metricsPort int, maxDrainTime time.Duration, cfg *rest.Config, grpcOptions grpc.Options) *Operator {
 	return &Operator{
-		ctx:         ctx,
-		logger:      logrus.WithField("name", "operator"),
-		cfg:         cfg,
-		hostname:    hostname,
-		nodename:    nodename,
-		namespace:   namespace,
-		workerPool:  workerPool,
-		nfsPath:     nfsPath,
-		grpcAddress: grpcAddress,
+		ctx:                 ctx,
+		logger:              logrus.WithField("name", "operator"),
+		cfg:                 cfg,
+		hostname:            hostname,
+		nodename:            nodename,
+		namespace:           namespace,
+		workerPool:          workerPool,
+		artifacts:           artifactStore,
+		inputCacheDir:       inputCacheDir,
+		inputCacheSize:      inputCacheSize,
+		sandboxHiddenPaths:  sandboxHiddenPaths,
+		cgroupRoot:          cgroupRoot,
+		grpcOptions:         grpcOptions,
+		spoolDir:            spoolDir,
+		spoolReplayInterval: spoolReplayInterval,
+		metricsPort:         metricsPort,
+		maxDrainTime:        maxDrainTime,
 	}
 }
 
@@ -64,26 +88,50 @@ func (op *Operator) Initialize() error {
 			}
 			return cache.New(cfg, opts)
 		},
+		Metrics: metricsserver.Options{BindAddress: fmt.Sprintf(":%d", op.metricsPort)},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to construct manager: %w", err)
 	}
 
 	op.mgr = mgr
-	grpcClient, err := grpc.NewClient(op.grpcAddress)
+	grpcClient, err := grpc.NewClient(op.grpcOptions)
 	if err != nil {
 		return fmt.Errorf("failed to construct grpc client: %w", err)
 	}
 
-	op.executor = executor.NewExecutor(op.ctx, mgr.GetClient(), executeChan, calcErrorChan, stepUpdaterChan, op.nfsPath, op.nodename, op.namespace, op.workerPool, grpcClient)
-	op.calculationsController = NewController(op.ctx, mgr, executeChan, calcErrorChan, stepUpdaterChan, op.hostname, op.nodename, op.namespace, op.workerPool)
+	op.spool, err = spool.New(op.spoolDir, grpcClient, op.grpcOptions.StreamThreshold())
+	if err != nil {
+		return fmt.Errorf("failed to construct the results spool: %w", err)
+	}
+
+	var inputCache *inputcache.Cache
+	if op.inputCacheDir != "" {
+		if inputCache, err = inputcache.New(op.inputCacheDir, op.inputCacheSize, op.artifacts); err != nil {
+			return fmt.Errorf("failed to construct the input cache: %w", err)
+		}
+	}
+
+	// The calculations still run without cgroups, but their resource limits can't be enforced.
+	var cgroupManager *cgroups.Manager
+	if op.cgroupRoot != "" {
+		if cgroupManager, err = cgroups.NewManager(op.cgroupRoot); err != nil {
+			op.logger.WithError(err).Warn("Couldn't set up the cgroups of the calculations, running them without")
+		}
+	}
+
+	op.executor = executor.NewExecutor(op.ctx, mgr.GetClient(), mgr.GetEventRecorderFor(util.WorkerEventSource), executeChan, calcErrorChan, stepUpdaterChan, op.artifacts, inputCache, op.sandboxHiddenPaths, cgroupManager, op.nodename, op.namespace, op.workerPool, grpcClient, op.grpcOptions.StreamThreshold(), op.spool)
+	op.drainer = workerpools.NewDrainer(op.ctx, mgr.GetClient(), op.executor, op.artifacts, op.hostname, op.nodename, op.namespace, op.workerPool, op.maxDrainTime)
+	op.calculationsController = NewController(op.ctx, mgr, executeChan, op.executor, op.drainer, op.artifacts, calcErrorChan, stepUpdaterChan, op.hostname, op.nodename, op.namespace, op.workerPool)
 	return nil
 }
 
+// Run runs the controllers and the executor until the stop channel is closed, and then drains the
+// worker. The context of the operator has to outlive the drain, which needs the controllers.
 func (op *Operator) Run(stopCh <-chan struct{}) error {
 	var err error
 	// TODO pass waitgroup
-	go func() { err = op.calculationsController.Run(stopCh) }()
+	go func() { err = op.calculationsController.Run(op.ctx.Done()) }()
 	if err != nil {
 		return fmt.Errorf("failed to run Calculations controller: %s", err.Error())
 	}
@@ -91,11 +139,13 @@ func (op *Operator) Run(stopCh <-chan struct{}) error {
 	// TODO pass waitgroup
 	go func() { op.executor.Run() }()
 
+	go op.spool.Start(op.ctx, op.spoolReplayInterval)
+
 	<-stopCh
+	op.logger.Info("Draining the worker before shutting down")
+	op.drainer.Drain()
+	<-op.drainer.Done()
 	op.logger.Info("Shutting down controllers")
-	if err := workerpools.RemoveWorkerFromPool(op.ctx, op.logger, op.mgr.GetClient(), op.workerPool, op.nodename, op.namespace); err != nil {
-		op.logger.WithError(err).Error("Failed to deregister worker from pool")
-	}
 
 	return nil
 }
@@ -0,0 +1,216 @@
+package outputs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+)
+
+// LegacyRuleName is the name of the rule made from the OutputFilesRegex of a calculation.
+const LegacyRuleName = "output-files-regex"
+
+// rule is an output rule with its compiled matcher.
+type rule struct {
+	v1.OutputRule
+	match func(rel string) bool
+}
+
+// compileRules returns the output rules of the calculation. The OutputFilesRegex is an optional
+// rule matching the file names, which keeps every file when it's empty, except for the vega
+// pipeline whose results are sent to the results store instead.
+func compileRules(calc *v1.Calculation) ([]rule, error) {
+	var rules []rule
+	names := sets.New[string]()
+	for _, r := range calc.OutputRules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("output rule without a name")
+		}
+		if names.Has(r.Name) {
+			return nil, fmt.Errorf("duplicate output rule %s", r.Name)
+		}
+		names.Insert(r.Name)
+
+		compiled, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output rule %s: %w", r.Name, err)
+		}
+		rules = append(rules, compiled)
+	}
+
+	if calc.OutputFilesRegex != "" || calc.Pipeline != v1.VegaPipeline {
+		regex, err := regexp.Compile(calc.OutputFilesRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output files regex: %w", err)
+		}
+		rules = append(rules, rule{
+			OutputRule: v1.OutputRule{Name: LegacyRuleName, Regex: calc.OutputFilesRegex},
+			match:      func(rel string) bool { return regex.MatchString(path.Base(rel)) },
+		})
+	}
+	return rules, nil
+}
+
+func compileRule(r v1.OutputRule) (rule, error) {
+	compiled := rule{OutputRule: r}
+	switch {
+	case (r.Glob == "") == (r.Regex == ""):
+		return compiled, fmt.Errorf("exactly one of glob and regex must be set")
+	case r.Glob != "":
+		if _, err := path.Match(r.Glob, ""); err != nil {
+			return compiled, err
+		}
+		// A glob without a slash matches the file names in every subfolder.
+		matchBase := !strings.Contains(r.Glob, "/")
+		compiled.match = func(rel string) bool {
+			if matchBase {
+				rel = path.Base(rel)
+			}
+			matched, _ := path.Match(r.Glob, rel)
+			return matched
+		}
+	default:
+		regex, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.match = regex.MatchString
+	}
+
+	if r.Rename != "" && (path.IsAbs(r.Rename) || path.Clean(r.Rename) != r.Rename || r.Rename == ".." || strings.HasPrefix(r.Rename, "../")) {
+		return compiled, fmt.Errorf("rename %q must be a clean relative path", r.Rename)
+	}
+	return compiled, nil
+}
+
+// output is a file of the calculation folder that is kept.
+type output struct {
+	rule string
+	src  string
+	size int64
+}
+
+// Collect uploads the files of the calculation folder that match the output rules of the
+// calculation to the given folder of the artifact store, keeping their relative paths, and returns
+// their manifest. The excluded files, relative to the calculation folder, are never kept. Nothing
+// is uploaded if a required rule matches no file, a renaming rule matches several files or two
+// files would be kept at the same path.
+func Collect(ctx context.Context, store artifacts.ArtifactStore, calc *v1.Calculation, calcPath, folder string, exclude sets.Set[string], logger *logrus.Entry) (*v1.OutputManifest, error) {
+	rules, err := compileRules(calc)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make(map[string]output)
+	matches := make(map[string]int)
+	err = filepath.WalkDir(calcPath, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(calcPath, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if exclude.Has(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		for _, r := range rules {
+			if !r.match(rel) {
+				continue
+			}
+			if r.MaxSize != nil && info.Size() > r.MaxSize.Value() {
+				logger.WithFields(logrus.Fields{"rule": r.Name, "file": rel, "size": info.Size()}).Warn("Output file is larger than the maximum size of the rule, skipping it")
+				continue
+			}
+			matches[r.Name]++
+
+			dest := rel
+			if r.Rename != "" {
+				dest = r.Rename
+			}
+			if existing, ok := outputs[dest]; ok {
+				if existing.src == rel {
+					continue
+				}
+				return fmt.Errorf("output files %s and %s are both kept as %s", existing.src, rel, dest)
+			}
+			outputs[dest] = output{rule: r.Name, src: rel, size: info.Size()}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		if r.Required && matches[r.Name] == 0 {
+			return nil, fmt.Errorf("required output %s is missing", r.Name)
+		}
+		if r.Rename != "" && matches[r.Name] > 1 {
+			return nil, fmt.Errorf("output rule %s renames %d files to %s", r.Name, matches[r.Name], r.Rename)
+		}
+	}
+
+	dests := make([]string, 0, len(outputs))
+	for dest := range outputs {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	manifest := &v1.OutputManifest{Files: []v1.OutputFile{}}
+	for _, dest := range dests {
+		out := outputs[dest]
+		src := filepath.Join(calcPath, filepath.FromSlash(out.src))
+		sum, err := fileChecksum(src)
+		if err != nil {
+			return nil, err
+		}
+
+		key := artifacts.Key(folder, dest)
+		if err := store.Upload(ctx, src, key); err != nil {
+			return nil, fmt.Errorf("couldn't upload the output file %s: %w", out.src, err)
+		}
+		logger.WithFields(logrus.Fields{"file": out.src, "key": key}).Info("Uploaded output file")
+
+		manifest.Files = append(manifest.Files, v1.OutputFile{Rule: out.rule, Path: dest, Size: out.size, SHA256: sum})
+	}
+	return manifest, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
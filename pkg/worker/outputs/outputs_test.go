@@ -0,0 +1,210 @@
+package outputs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+)
+
+func TestCollect(t *testing.T) {
+	maxSize := resource.MustParse("4")
+	testCases := []struct {
+		name          string
+		calculation   *v1.Calculation
+		files         map[string]string
+		exclude       sets.Set[string]
+		expected      []v1.OutputFile
+		expectedError bool
+	}{
+		{
+			name:        "the output files regex keeps the matching file names with their relative paths",
+			calculation: &v1.Calculation{OutputFilesRegex: `\.out$`},
+			files:       map[string]string{"spectrum.out": "spectrum", "models/model.out": "model", "step-0": "log"},
+			expected: []v1.OutputFile{
+				{Rule: LegacyRuleName, Path: "models/model.out", Size: 5},
+				{Rule: LegacyRuleName, Path: "spectrum.out", Size: 8},
+			},
+		},
+		{
+			name:        "every file is kept without rules, except the excluded ones",
+			calculation: &v1.Calculation{},
+			files:       map[string]string{"input.dat": "input", "step-0": "log"},
+			exclude:     sets.New("input.dat"),
+			expected:    []v1.OutputFile{{Rule: LegacyRuleName, Path: "step-0", Size: 3}},
+		},
+		{
+			name:        "the vega pipeline keeps no files without rules",
+			calculation: &v1.Calculation{Pipeline: v1.VegaPipeline},
+			files:       map[string]string{"fort.7": "results"},
+			expected:    []v1.OutputFile{},
+		},
+		{
+			name: "globs without a slash match the file names, and globs with a slash match the paths",
+			calculation: &v1.Calculation{
+				Pipeline: v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{
+					{Name: "logs", Glob: "*.log"},
+					{Name: "models", Glob: "models/*.dat"},
+				},
+			},
+			files: map[string]string{"run.log": "run", "nested/step.log": "step", "models/a.dat": "a", "models/nested/b.dat": "b", "c.dat": "c"},
+			expected: []v1.OutputFile{
+				{Rule: "models", Path: "models/a.dat", Size: 1},
+				{Rule: "logs", Path: "nested/step.log", Size: 4},
+				{Rule: "logs", Path: "run.log", Size: 3},
+			},
+		},
+		{
+			name: "regexes match the paths and files can be renamed",
+			calculation: &v1.Calculation{
+				Pipeline: v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{
+					{Name: "results", Regex: `^fort\.7$`, Rename: "results/spectrum.dat", Required: true},
+					{Name: "models", Regex: `^models/`},
+				},
+			},
+			files: map[string]string{"fort.7": "spectrum", "models/model": "model", "fort.8": "other"},
+			expected: []v1.OutputFile{
+				{Rule: "models", Path: "models/model", Size: 5},
+				{Rule: "results", Path: "results/spectrum.dat", Size: 8},
+			},
+		},
+		{
+			name: "files matched by several rules are kept once",
+			calculation: &v1.Calculation{
+				Pipeline: v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{
+					{Name: "spectra", Glob: "*.out"},
+					{Name: "everything", Regex: `.*`},
+				},
+			},
+			files:    map[string]string{"spectrum.out": "spectrum"},
+			expected: []v1.OutputFile{{Rule: "spectra", Path: "spectrum.out", Size: 8}},
+		},
+		{
+			name: "files larger than the maximum size are skipped",
+			calculation: &v1.Calculation{
+				Pipeline:    v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{{Name: "small", Glob: "*", MaxSize: &maxSize}},
+			},
+			files:    map[string]string{"small": "abc", "large": "abcdef"},
+			expected: []v1.OutputFile{{Rule: "small", Path: "small", Size: 3}},
+		},
+		{
+			name: "a missing required output fails",
+			calculation: &v1.Calculation{
+				Pipeline:    v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{{Name: "results", Glob: "fort.7", Required: true}},
+			},
+			files:         map[string]string{"fort.8": "other"},
+			expectedError: true,
+		},
+		{
+			name: "a required output that is too large fails",
+			calculation: &v1.Calculation{
+				Pipeline:    v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{{Name: "results", Glob: "fort.7", Required: true, MaxSize: &maxSize}},
+			},
+			files:         map[string]string{"fort.7": "too large"},
+			expectedError: true,
+		},
+		{
+			name: "renaming several files fails",
+			calculation: &v1.Calculation{
+				Pipeline:    v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{{Name: "results", Glob: "*.out", Rename: "result.out"}},
+			},
+			files:         map[string]string{"a.out": "a", "b.out": "b"},
+			expectedError: true,
+		},
+		{
+			name: "files kept at the same path fail",
+			calculation: &v1.Calculation{
+				Pipeline: v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{
+					{Name: "results", Glob: "fort.7", Rename: "spectrum.out"},
+					{Name: "spectra", Glob: "*.out"},
+				},
+			},
+			files:         map[string]string{"fort.7": "results", "spectrum.out": "spectrum"},
+			expectedError: true,
+		},
+		{
+			name: "renaming outside of the output folder fails",
+			calculation: &v1.Calculation{
+				Pipeline:    v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{{Name: "results", Glob: "fort.7", Rename: "../fort.7"}},
+			},
+			files:         map[string]string{"fort.7": "results"},
+			expectedError: true,
+		},
+		{
+			name: "rules with both a glob and a regex fail",
+			calculation: &v1.Calculation{
+				Pipeline:    v1.VegaPipeline,
+				OutputRules: []v1.OutputRule{{Name: "results", Glob: "fort.7", Regex: `fort\.7`}},
+			},
+			files:         map[string]string{"fort.7": "results"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calcPath := t.TempDir()
+			for name, content := range tc.files {
+				path := filepath.Join(calcPath, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			storePath := t.TempDir()
+			store := artifacts.NewLocalStore(storePath)
+
+			manifest, err := Collect(context.Background(), store, tc.calculation, calcPath, "root/.outputs/calc", tc.exclude, logrus.WithField("test-name", tc.name))
+			if tc.expectedError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				keys, err := store.List(context.Background(), "root")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(keys) != 0 {
+					t.Fatalf("expected nothing to be uploaded, got %v", keys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.expected, manifest.Files, cmpopts.IgnoreFields(v1.OutputFile{}, "SHA256")); diff != "" {
+				t.Fatal(diff)
+			}
+			for _, file := range manifest.Files {
+				sum, err := fileChecksum(filepath.Join(storePath, "root/.outputs/calc", file.Path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sum != file.SHA256 {
+					t.Errorf("expected checksum %s for %s, got %s", sum, file.Path, file.SHA256)
+				}
+			}
+		})
+	}
+}
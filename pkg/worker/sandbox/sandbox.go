@@ -0,0 +1,250 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// initName is the name the worker binary is executed with to set up the sandbox of a command.
+	initName = "vega-sandbox-init"
+	// configEnv passes the Config to the sandbox init.
+	configEnv = "VEGA_SANDBOX_CONFIG"
+)
+
+// serviceAccountPaths are where the token of the service account of the worker pod is mounted.
+var serviceAccountPaths = []string{"/var/run/secrets/kubernetes.io", "/run/secrets/kubernetes.io"}
+
+// Config describes the sandbox of the commands of a calculation.
+type Config struct {
+	// Dir is the folder of the calculation, the only writable path in the sandbox.
+	Dir string `json:"dir"`
+	// ReadOnly are the paths of the input files, which are mounted read-only. Symlinks are followed,
+	// so their targets are visible even inside hidden paths.
+	ReadOnly []string `json:"read_only,omitempty"`
+	// Hidden are the paths replaced by empty folders, like the shared storage. The token of the
+	// service account is always hidden.
+	Hidden []string `json:"hidden,omitempty"`
+	// Network keeps the network of the node, otherwise the commands only get a loopback interface.
+	Network bool `json:"network,omitempty"`
+	// UID and GID are the ids on the node that the commands run as.
+	UID int `json:"-"`
+	GID int `json:"-"`
+}
+
+// CommandContext returns a command that runs in new user, mount, pid, ipc and uts namespaces, and
+// a new network namespace unless the network is kept. The command sees a read-only view of the
+// filesystem where only the folder of the calculation is writable, the hidden paths are empty and
+// the environment of the worker is dropped. The worker binary sets up the sandbox, so Init must
+// be called first in its main function.
+func (c Config) CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	// The config only has strings and booleans, so it can always be marshaled.
+	config, _ := json.Marshal(c)
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", append([]string{name}, args...)...)
+	cmd.Args[0] = initName
+	cmd.Dir = c.Dir
+	cmd.Env = []string{configEnv + "=" + string(config), "PATH=" + os.Getenv("PATH")}
+
+	flags := uintptr(unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS)
+	if !c.Network {
+		flags |= unix.CLONE_NEWNET
+	}
+	// Only privileged workers can drop their supplementary groups, unprivileged ones can't map
+	// groups unless setgroups is denied.
+	privileged := os.Geteuid() == 0
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 flags,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: c.UID, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: c.GID, Size: 1}},
+		GidMappingsEnableSetgroups: privileged,
+		// The ids are switched inside the user namespace, so the command runs as UID and GID on the node.
+		Credential: &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: !privileged},
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	return cmd
+}
+
+// Init sets up the sandbox and executes the command when the process is the sandbox init of a
+// command, and returns otherwise.
+func Init() {
+	if filepath.Base(os.Args[0]) != initName {
+		return
+	}
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't set up the sandbox: %v\n", err)
+		os.Exit(127)
+	}
+}
+
+func run() error {
+	if len(os.Args) < 2 {
+		return fmt.Errorf("no command to run")
+	}
+	var c Config
+	if err := json.Unmarshal([]byte(os.Getenv(configEnv)), &c); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Symlinks are resolved before the filesystem is replaced, since they point to the paths of the node.
+	dir, err := filepath.EvalSymlinks(c.Dir)
+	if err != nil {
+		return err
+	}
+	readOnly, err := resolve(c.ReadOnly)
+	if err != nil {
+		return err
+	}
+	hidden, err := resolve(append(c.Hidden, serviceAccountPaths...))
+	if err != nil {
+		return err
+	}
+
+	if err := setupRoot(dir, readOnly, hidden); err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(os.Args[1])
+	if err != nil {
+		return err
+	}
+	env := []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir}
+	return syscall.Exec(path, os.Args[1:], env)
+}
+
+// resolve returns the existing paths with their symlinks evaluated.
+func resolve(paths []string) ([]string, error) {
+	var resolved []string
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		r, err := filepath.EvalSymlinks(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// setupRoot replaces the root filesystem with a read-only view of it, where the hidden paths are
+// empty, the calculation folder is writable and the read-only paths are mounted on top.
+func setupRoot(dir string, readOnly, hidden []string) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("couldn't make the mounts private: %w", err)
+	}
+
+	// The new root is prepared in a tmpfs, with the filesystem of the node in /oldroot.
+	base := os.TempDir()
+	if err := unix.Mount("tmpfs", base, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("couldn't mount the base of the sandbox: %w", err)
+	}
+	for _, d := range []string{"oldroot", "newroot"} {
+		if err := os.Mkdir(filepath.Join(base, d), 0755); err != nil {
+			return err
+		}
+	}
+	if err := unix.PivotRoot(base, filepath.Join(base, "oldroot")); err != nil {
+		return fmt.Errorf("couldn't pivot to the base of the sandbox: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+
+	if err := bindReadOnly("/oldroot", "/newroot"); err != nil {
+		return err
+	}
+	for _, p := range hidden {
+		if err := unix.Mount("tmpfs", "/newroot"+p, "tmpfs", 0, "mode=0755"); err != nil {
+			return fmt.Errorf("couldn't hide %s: %w", p, err)
+		}
+	}
+	if err := createMountpoint("/oldroot"+dir, "/newroot"+dir); err != nil {
+		return fmt.Errorf("couldn't mount the calculation folder: %w", err)
+	}
+	if err := unix.Mount("/oldroot"+dir, "/newroot"+dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("couldn't mount the calculation folder: %w", err)
+	}
+	for _, p := range readOnly {
+		if err := createMountpoint("/oldroot"+p, "/newroot"+p); err != nil {
+			return fmt.Errorf("couldn't mount %s: %w", p, err)
+		}
+		if err := bindReadOnly("/oldroot"+p, "/newroot"+p); err != nil {
+			return err
+		}
+	}
+	// The mounts in the hidden paths are left as they are.
+	for _, p := range hidden {
+		if err := setReadOnly("/newroot"+p, 0); err != nil {
+			return err
+		}
+	}
+	// The processes of the node stay visible through the read-only /proc if it can't be mounted,
+	// e.g. when parts of it are masked by the container runtime.
+	_ = unix.Mount("proc", "/newroot/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+
+	if err := os.Chdir("/newroot"); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("couldn't pivot to the root of the sandbox: %w", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("couldn't unmount the filesystem of the node: %w", err)
+	}
+	return os.Chdir("/")
+}
+
+// createMountpoint creates an empty file or folder like the source at the target, if it doesn't
+// exist yet because it's in a hidden path.
+func createMountpoint(source, target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		return nil
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return os.Mkdir(target, 0755)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func bindReadOnly(source, target string) error {
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("couldn't mount %s: %w", strings.TrimPrefix(source, "/oldroot"), err)
+	}
+	return setReadOnly(target, unix.AT_RECURSIVE)
+}
+
+// setReadOnly makes the mount read-only, and the mounts below it with AT_RECURSIVE. The other
+// flags of the mounts are kept, since the mounts of the node can't change them in a user namespace.
+func setReadOnly(target string, flags uint) error {
+	if err := unix.MountSetattr(-1, target, flags, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}); err != nil {
+		return fmt.Errorf("couldn't make %s read-only: %w", target, err)
+	}
+	return nil
+}
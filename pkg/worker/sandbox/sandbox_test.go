@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+// newTestConfig returns a sandbox for a calculation folder with a linked input in a hidden folder
+// and a copied input, which runs the commands as the current user.
+func newTestConfig(t *testing.T) (Config, string) {
+	calcDir := t.TempDir()
+	hiddenDir := t.TempDir()
+	for path, content := range map[string]string{
+		filepath.Join(hiddenDir, "secret"):             "secret",
+		filepath.Join(hiddenDir, "root", "linked.dat"): "linked",
+		filepath.Join(calcDir, "copied.dat"):           "copied",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(hiddenDir, "root", "linked.dat"), filepath.Join(calcDir, "linked.dat")); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		Dir:      calcDir,
+		ReadOnly: []string{filepath.Join(calcDir, "linked.dat"), filepath.Join(calcDir, "copied.dat")},
+		Hidden:   []string{hiddenDir},
+		UID:      os.Getuid(),
+		GID:      os.Getgid(),
+	}
+
+	// The sandbox needs user namespaces, which can be disabled on the node or by seccomp.
+	if out, err := config.CommandContext(context.Background(), "true").CombinedOutput(); err != nil {
+		t.Skipf("the sandbox is not supported: %v: %s", err, out)
+	}
+	return config, hiddenDir
+}
+
+func TestSandbox(t *testing.T) {
+	outside := t.TempDir()
+	testCases := []struct {
+		name           string
+		script         string
+		expectedOutput string
+		expectedError  bool
+	}{
+		{
+			name:           "the calculation folder is writable",
+			script:         "echo output > output.txt && cat output.txt",
+			expectedOutput: "output",
+		},
+		{
+			name:           "the input files can be read",
+			script:         "cat linked.dat copied.dat",
+			expectedOutput: "linkedcopied",
+		},
+		{
+			name:          "linked input files are read-only",
+			script:        "echo modified >> linked.dat",
+			expectedError: true,
+		},
+		{
+			name:          "copied input files are read-only",
+			script:        "echo modified >> copied.dat",
+			expectedError: true,
+		},
+		{
+			name:          "paths outside of the calculation folder are read-only",
+			script:        fmt.Sprintf("touch %s/file", outside),
+			expectedError: true,
+		},
+		{
+			name:           "hidden paths are empty except for the linked input files",
+			script:         "ls -A $HIDDEN",
+			expectedOutput: "root",
+		},
+		{
+			name:           "the commands run in their own pid namespace",
+			script:         "echo $$",
+			expectedOutput: "1",
+		},
+		{
+			name:           "the commands have no network",
+			script:         "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '",
+			expectedOutput: "lo",
+		},
+		{
+			name:           "the environment of the worker is dropped",
+			script:         "echo \"$WORKER_SECRET\"",
+			expectedOutput: "",
+		},
+	}
+
+	t.Setenv("WORKER_SECRET", "secret")
+	config, hiddenDir := newTestConfig(t)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			script := strings.ReplaceAll(tc.script, "$HIDDEN", hiddenDir)
+			out, err := config.CommandContext(context.Background(), "/bin/sh", "-c", script).CombinedOutput()
+			if tc.expectedError {
+				if err == nil {
+					t.Fatalf("expected the command to fail, got output %q", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("command failed: %v: %s", err, out)
+			}
+			if output := strings.Join(strings.Fields(string(out)), ""); output != tc.expectedOutput {
+				t.Fatalf("expected output %q, got %q", tc.expectedOutput, output)
+			}
+		})
+	}
+
+	if content, err := os.ReadFile(filepath.Join(hiddenDir, "root", "linked.dat")); err != nil || string(content) != "linked" {
+		t.Errorf("expected the linked input file to be unchanged, got %q: %v", content, err)
+	}
+}
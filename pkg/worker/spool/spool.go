@@ -0,0 +1,382 @@
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/vega-project/ccb-operator/pkg/grpc"
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+const (
+	entrySuffix = ".json"
+	dataSuffix  = ".data"
+	// The entries that are moved aside keep one of these suffixes.
+	corruptedSuffix = ".corrupted"
+	rejectedSuffix  = ".rejected"
+
+	// maxBatchEntries and maxBatchBytes limit the spooled results that are delivered in a single
+	// batch call. Larger results are delivered on their own.
+	maxBatchEntries = 100
+	maxBatchBytes   = 2 * 1024 * 1024
+)
+
+var (
+	spoolEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vega",
+		Subsystem: "worker_spool",
+		Name:      "entries",
+		Help:      "Number of results waiting in the spool to be delivered",
+	})
+	spoolBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vega",
+		Subsystem: "worker_spool",
+		Name:      "bytes",
+		Help:      "Size in bytes of the results waiting in the spool",
+	})
+	spoolOldestAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "vega",
+		Subsystem: "worker_spool",
+		Name:      "oldest_entry_age_seconds",
+		Help:      "Age of the oldest results waiting in the spool, 0 if the spool is empty",
+	})
+	spoolReplays = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vega",
+		Subsystem: "worker_spool",
+		Name:      "replays_total",
+		Help:      "Number of attempts to deliver spooled results, by result",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(spoolEntries, spoolBytes, spoolOldestAge, spoolReplays)
+}
+
+// entry is the metadata of spooled results. The results themselves are kept in a separate file,
+// and the entry is written last, so only complete results are replayed.
+type entry struct {
+	Calculation string            `json:"calculation"`
+	Parameters  map[string]string `json:"parameters"`
+	InputHash   string            `json:"input_hash,omitempty"`
+	Size        int               `json:"size"`
+	SpooledAt   time.Time         `json:"spooled_at"`
+}
+
+// Spool persists the results that couldn't be delivered to the results-handler, and replays
+// them in the background until they are acknowledged.
+type Spool struct {
+	dir             string
+	client          grpc.Client
+	streamThreshold int
+	logger          *logrus.Entry
+	now             func() time.Time
+
+	// mu serializes the replays, so spooled results are never delivered concurrently.
+	mu sync.Mutex
+}
+
+// New returns a Spool that keeps the results in the given directory.
+func New(dir string, client grpc.Client, streamThreshold int) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("couldn't create the spool directory: %w", err)
+	}
+
+	s := &Spool{
+		dir:             dir,
+		client:          client,
+		streamThreshold: streamThreshold,
+		logger:          logrus.WithField("component", "spool"),
+		now:             time.Now,
+	}
+	s.updateMetrics()
+	return s, nil
+}
+
+// Add persists the results of the given calculation, to be delivered later.
+func (s *Spool) Add(calculation string, parameters map[string]string, inputHash string, data []byte) error {
+	id := fmt.Sprintf("%d-%s", s.now().UnixNano(), calculation)
+
+	if err := writeFileAtomic(filepath.Join(s.dir, id+dataSuffix), data); err != nil {
+		return fmt.Errorf("couldn't spool the results: %w", err)
+	}
+
+	e, err := json.Marshal(entry{
+		Calculation: calculation,
+		Parameters:  parameters,
+		InputHash:   inputHash,
+		Size:        len(data),
+		SpooledAt:   s.now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, id+entrySuffix), e); err != nil {
+		os.Remove(filepath.Join(s.dir, id+dataSuffix))
+		return fmt.Errorf("couldn't spool the results: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{"calculation": calculation, "id": id}).Info("Results spooled")
+	s.updateMetrics()
+	return nil
+}
+
+// Start replays the spooled results periodically until the context is cancelled.
+func (s *Spool) Start(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.Replay(ctx); err != nil {
+			s.logger.WithError(err).Warn("Couldn't deliver the spooled results, retrying later")
+		}
+	}, interval)
+}
+
+// Replay delivers the spooled results, oldest first. Small results are delivered in batches.
+// The results that the results-handler rejects are moved aside, and the replay stops at the
+// first other failure, since the results-handler is most probably still unavailable.
+func (s *Spool) Replay(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.updateMetrics()
+
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+
+	var batch spooledBatch
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		e, data, err := s.read(id)
+		if err != nil {
+			// A corrupted entry would block the spool forever, keep it aside for inspection.
+			s.logger.WithError(err).WithField("id", id).Error("Couldn't read the spooled results, moving them aside")
+			s.quarantine(id, corruptedSuffix)
+			continue
+		}
+
+		if len(data) > maxBatchBytes {
+			if err := s.deliverBatch(ctx, &batch); err != nil {
+				return err
+			}
+			if err := s.deliver(ctx, id, e, data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(batch.ids) == maxBatchEntries || batch.size+len(data) > maxBatchBytes {
+			if err := s.deliverBatch(ctx, &batch); err != nil {
+				return err
+			}
+		}
+		batch.add(id, e, data)
+	}
+	return s.deliverBatch(ctx, &batch)
+}
+
+// spooledBatch is a group of small spooled results that are delivered in a single call.
+type spooledBatch struct {
+	ids      []string
+	entries  []*entry
+	requests []*proto.StoreRequest
+	size     int
+}
+
+func (b *spooledBatch) add(id string, e *entry, data []byte) {
+	b.ids = append(b.ids, id)
+	b.entries = append(b.entries, e)
+	b.requests = append(b.requests, &proto.StoreRequest{Parameters: e.Parameters, InputHash: e.InputHash, Results: string(data)})
+	b.size += len(data)
+}
+
+func (s *Spool) deliver(ctx context.Context, id string, e *entry, data []byte) error {
+	if _, err := grpc.StoreResults(ctx, s.client, s.streamThreshold, e.Parameters, e.InputHash, data); err != nil {
+		if rejected(err) {
+			// Retrying results that are rejected would block the spool forever, keep them aside for inspection.
+			spoolReplays.WithLabelValues("rejected").Inc()
+			s.logger.WithError(err).WithFields(logrus.Fields{"calculation": e.Calculation, "id": id}).Error("The spooled results were rejected, moving them aside")
+			s.quarantine(id, rejectedSuffix)
+			return nil
+		}
+		spoolReplays.WithLabelValues("error").Inc()
+		return fmt.Errorf("couldn't deliver the results of calculation %s: %w", e.Calculation, err)
+	}
+	spoolReplays.WithLabelValues("delivered").Inc()
+
+	s.remove(id)
+	s.logger.WithFields(logrus.Fields{"calculation": e.Calculation, "id": id, "spooled-at": e.SpooledAt}).Info("Spooled results delivered")
+	return nil
+}
+
+// deliverBatch delivers and removes the results of the batch, and empties it.
+func (s *Spool) deliverBatch(ctx context.Context, b *spooledBatch) error {
+	if len(b.ids) == 0 {
+		return nil
+	}
+
+	if _, err := s.client.StoreDataBatch(ctx, b.requests); err != nil {
+		if rejected(err) {
+			// The batch is stored entirely or not at all, the results are delivered one by one
+			// to find the ones that are rejected.
+			return s.deliverEach(ctx, b)
+		}
+		spoolReplays.WithLabelValues("error").Add(float64(len(b.ids)))
+		return fmt.Errorf("couldn't deliver the results of %d calculations: %w", len(b.ids), err)
+	}
+	spoolReplays.WithLabelValues("delivered").Add(float64(len(b.ids)))
+
+	for i, id := range b.ids {
+		s.remove(id)
+		s.logger.WithFields(logrus.Fields{"calculation": b.entries[i].Calculation, "id": id, "spooled-at": b.entries[i].SpooledAt}).Info("Spooled results delivered")
+	}
+	*b = spooledBatch{}
+	return nil
+}
+
+// deliverEach delivers the results of the batch one by one, and empties it.
+func (s *Spool) deliverEach(ctx context.Context, b *spooledBatch) error {
+	for i, id := range b.ids {
+		if err := s.deliver(ctx, id, b.entries[i], []byte(b.requests[i].Results)); err != nil {
+			return err
+		}
+	}
+	*b = spooledBatch{}
+	return nil
+}
+
+// rejected reports whether the results-handler rejected the results, so delivering them
+// again would fail the same way.
+func rejected(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.ResourceExhausted, codes.PermissionDenied:
+		return true
+	}
+	return false
+}
+
+// ids returns the IDs of the complete entries, oldest first.
+func (s *Spool) ids() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list the spool directory: %w", err)
+	}
+
+	var ids []string
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), entrySuffix) || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(f.Name(), entrySuffix))
+	}
+	// The IDs start with the spooling time in nanoseconds, all with the same number of digits.
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *Spool) read(id string) (*entry, []byte, error) {
+	raw, err := os.ReadFile(filepath.Join(s.dir, id+entrySuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+	var e entry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, id+dataSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) != e.Size {
+		return nil, nil, fmt.Errorf("expected %d bytes of results, found %d", e.Size, len(data))
+	}
+	return &e, data, nil
+}
+
+func (s *Spool) remove(id string) {
+	// The entry goes first, so a partially removed entry is never replayed.
+	for _, suffix := range []string{entrySuffix, dataSuffix} {
+		if err := os.Remove(filepath.Join(s.dir, id+suffix)); err != nil && !os.IsNotExist(err) {
+			s.logger.WithError(err).WithField("id", id).Error("Couldn't remove the delivered results from the spool")
+		}
+	}
+}
+
+// quarantine moves the files of the entry aside, with the given suffix added to their names.
+func (s *Spool) quarantine(id, reason string) {
+	for _, suffix := range []string{entrySuffix, dataSuffix} {
+		path := filepath.Join(s.dir, id+suffix)
+		if err := os.Rename(path, path+reason); err != nil && !os.IsNotExist(err) {
+			s.logger.WithError(err).WithField("id", id).Error("Couldn't move the results aside")
+		}
+	}
+}
+
+// updateMetrics exports the number, size and age of the spooled results.
+func (s *Spool) updateMetrics() {
+	ids, err := s.ids()
+	if err != nil {
+		s.logger.WithError(err).Warn("Couldn't update the spool metrics")
+		return
+	}
+
+	var size int64
+	var oldest time.Time
+	for _, id := range ids {
+		if info, err := os.Stat(filepath.Join(s.dir, id+dataSuffix)); err == nil {
+			size += info.Size()
+		}
+		if info, err := os.Stat(filepath.Join(s.dir, id+entrySuffix)); err == nil && (oldest.IsZero() || info.ModTime().Before(oldest)) {
+			oldest = info.ModTime()
+		}
+	}
+
+	spoolEntries.Set(float64(len(ids)))
+	spoolBytes.Set(float64(size))
+	if oldest.IsZero() {
+		spoolOldestAge.Set(0)
+	} else {
+		spoolOldestAge.Set(s.now().Sub(oldest).Seconds())
+	}
+}
+
+// writeFileAtomic writes the file through a temporary file, so it is either complete or missing.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,233 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/vega-project/ccb-operator/proto"
+)
+
+type storedResults struct {
+	parameters map[string]string
+	inputHash  string
+	results    string
+}
+
+type fakeGRPCClient struct {
+	err error
+	// rejected are the results that fail validation.
+	rejected string
+	stored   []storedResults
+}
+
+func (f *fakeGRPCClient) StoreData(ctx context.Context, parameters map[string]string, inputHash, results string) (*proto.StoreResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.rejected != "" && results == f.rejected {
+		return nil, status.Error(codes.InvalidArgument, "invalid parameters")
+	}
+	f.stored = append(f.stored, storedResults{parameters: parameters, inputHash: inputHash, results: results})
+	return &proto.StoreResponse{Message: "Data stored successfully"}, nil
+}
+
+func (f *fakeGRPCClient) StoreDataStream(ctx context.Context, parameters map[string]string, inputHash string, results []byte) (*proto.StoreResponse, error) {
+	return f.StoreData(ctx, parameters, inputHash, string(results))
+}
+
+func (f *fakeGRPCClient) GetData(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeGRPCClient) GetDataStream(ctx context.Context, parameters map[string]string) (*proto.GetDataResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeGRPCClient) LookupByHash(ctx context.Context, inputHash string) (*proto.GetDataResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeGRPCClient) GetDataBatch(ctx context.Context, queries []*proto.DataQuery, metadataOnly bool) ([]*proto.GetDataResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeGRPCClient) StoreDataBatch(ctx context.Context, requests []*proto.StoreRequest) ([]*proto.StoreResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	// The batch is stored entirely or not at all.
+	for _, in := range requests {
+		if f.rejected != "" && in.Results == f.rejected {
+			return nil, status.Error(codes.InvalidArgument, "invalid parameters")
+		}
+	}
+	responses := make([]*proto.StoreResponse, 0, len(requests))
+	for _, in := range requests {
+		response, err := f.StoreData(ctx, in.Parameters, in.InputHash, in.Results)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+func (f *fakeGRPCClient) Close() error {
+	return nil
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeGRPCClient{err: errors.New("connection refused")}
+	s, err := New(dir, client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	if err := s.Add("calc-1", map[string]string{"teff": "10000.000000"}, "hash1", []byte("results 1")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	if err := s.Add("calc-2", map[string]string{"teff": "11000.000000"}, "", []byte("results 2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Replay(context.Background()); err == nil {
+		t.Fatal("expected an error while the results-handler is unavailable")
+	}
+	if entries := testutil.ToFloat64(spoolEntries); entries != 2 {
+		t.Fatalf("expected 2 spooled entries, got %v", entries)
+	}
+	if size := testutil.ToFloat64(spoolBytes); size != 18 {
+		t.Fatalf("expected 18 spooled bytes, got %v", size)
+	}
+
+	client.err = nil
+	if err := s.Replay(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []storedResults{
+		{parameters: map[string]string{"teff": "10000.000000"}, inputHash: "hash1", results: "results 1"},
+		{parameters: map[string]string{"teff": "11000.000000"}, results: "results 2"},
+	}
+	if diff := cmp.Diff(expected, client.stored, cmp.AllowUnexported(storedResults{})); diff != "" {
+		t.Fatal(diff)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected the spool to be empty, found %d files", len(files))
+	}
+	if entries := testutil.ToFloat64(spoolEntries); entries != 0 {
+		t.Fatalf("expected no spooled entries, got %v", entries)
+	}
+}
+
+func TestSpoolQuarantinesCorruptedEntries(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeGRPCClient{}
+	s, err := New(dir, client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Add("calc-1", map[string]string{"teff": "10000.000000"}, "", []byte("results 1")); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := s.ids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ids[0]+dataSuffix), []byte("trunc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("calc-2", map[string]string{"teff": "11000.000000"}, "", []byte("results 2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Replay(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.stored) != 1 || client.stored[0].results != "results 2" {
+		t.Fatalf("expected only the complete results to be delivered, got %v", client.stored)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ids[0]+entrySuffix+".corrupted")); err != nil {
+		t.Fatalf("expected the corrupted entry to be moved aside: %v", err)
+	}
+}
+
+func TestSpoolQuarantinesRejectedResults(t *testing.T) {
+	testCases := []struct {
+		name   string
+		poison []byte
+	}{
+		{
+			name:   "rejected results in a batch",
+			poison: []byte("poison"),
+		},
+		{
+			name:   "rejected results delivered on their own",
+			poison: make([]byte, maxBatchBytes+1),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			client := &fakeGRPCClient{rejected: string(tc.poison)}
+			s, err := New(dir, client, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			s.now = func() time.Time { return now }
+
+			if err := s.Add("calc-1", map[string]string{"teff": "10000.000000"}, "", tc.poison); err != nil {
+				t.Fatal(err)
+			}
+			poisonIDs, err := s.ids()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, results := range []string{"results 2", "results 3"} {
+				now = now.Add(time.Second)
+				if err := s.Add(fmt.Sprintf("calc-%d", i+2), map[string]string{"teff": "11000.000000"}, "", []byte(results)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.Replay(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			expected := []storedResults{
+				{parameters: map[string]string{"teff": "11000.000000"}, results: "results 2"},
+				{parameters: map[string]string{"teff": "11000.000000"}, results: "results 3"},
+			}
+			if diff := cmp.Diff(expected, client.stored, cmp.AllowUnexported(storedResults{})); diff != "" {
+				t.Fatal(diff)
+			}
+			if _, err := os.Stat(filepath.Join(dir, poisonIDs[0]+entrySuffix+rejectedSuffix)); err != nil {
+				t.Fatalf("expected the rejected entry to be moved aside: %v", err)
+			}
+			if ids, err := s.ids(); err != nil || len(ids) != 0 {
+				t.Fatalf("expected no entries left to replay, got %v: %v", ids, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package workerpools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+const (
+	// drainPollInterval is how often the drainer checks whether the worker finished its calculations.
+	drainPollInterval = 5 * time.Second
+	// interruptTimeout is how long the drainer waits for the interrupted calculation to stop.
+	interruptTimeout = time.Minute
+)
+
+// Executions interrupts the calculation that the worker runs.
+type Executions interface {
+	// Running returns the name of the running calculation, empty if none runs.
+	Running() string
+	// Interrupt stops the running calculation without reporting it, and returns its name.
+	Interrupt() string
+}
+
+// Drainer takes the worker out of its pool without losing the work of its calculation. The worker
+// is set to draining, so the dispatcher doesn't assign it calculations anymore, and it leaves the
+// pool once its calculations are finished. The calculations that don't finish within the maximum
+// drain time are interrupted and rescheduled in a new attempt.
+type Drainer struct {
+	ctx          context.Context
+	logger       *logrus.Entry
+	client       ctrlruntimeclient.Client
+	executions   Executions
+	artifacts    util.ArtifactRemover
+	hostname     string
+	nodename     string
+	namespace    string
+	workerPool   string
+	maxDrainTime time.Duration
+	pollInterval time.Duration
+
+	once     sync.Once
+	draining atomic.Bool
+	done     chan struct{}
+}
+
+func NewDrainer(ctx context.Context, client ctrlruntimeclient.Client, executions Executions, artifacts util.ArtifactRemover, hostname, nodename, namespace, workerPool string, maxDrainTime time.Duration) *Drainer {
+	return &Drainer{
+		ctx:          ctx,
+		logger:       logrus.WithField("name", "drainer"),
+		client:       client,
+		executions:   executions,
+		artifacts:    artifacts,
+		hostname:     hostname,
+		nodename:     nodename,
+		namespace:    namespace,
+		workerPool:   workerPool,
+		maxDrainTime: maxDrainTime,
+		pollInterval: drainPollInterval,
+		done:         make(chan struct{}),
+	}
+}
+
+// Drain starts draining the worker, if it isn't draining already.
+func (d *Drainer) Drain() {
+	d.once.Do(func() {
+		d.draining.Store(true)
+		go func() {
+			defer close(d.done)
+			d.drain()
+		}()
+	})
+}
+
+// Draining returns whether the worker started draining.
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// Done is closed once the worker left the pool.
+func (d *Drainer) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *Drainer) drain() {
+	logger := d.logger.WithFields(logrus.Fields{"pod-name": d.hostname, "node-name": d.nodename, "max-drain-time": d.maxDrainTime})
+	logger.Info("Draining the worker")
+	if err := util.UpdateWorkerStatusInPool(d.ctx, d.client, d.workerPool, d.nodename, d.namespace, workersv1.WorkerDrainingState); err != nil {
+		logger.WithError(err).Error("Couldn't set the worker to draining")
+	}
+
+	if err := wait.PollUntilContextTimeout(d.ctx, d.pollInterval, d.maxDrainTime, true, d.drained); err != nil {
+		if name := d.executions.Interrupt(); name != "" {
+			logger.WithField("calculation", name).Warn("The worker didn't drain in time, interrupting its calculation")
+		}
+		if err := wait.PollUntilContextTimeout(d.ctx, d.pollInterval, interruptTimeout, true, func(context.Context) (bool, error) {
+			return d.executions.Running() == "", nil
+		}); err != nil {
+			logger.WithError(err).Error("The interrupted calculation didn't stop")
+		}
+		if err := d.reschedule(); err != nil {
+			logger.WithError(err).Error("Couldn't reschedule the calculations of the worker")
+		}
+	}
+
+	if err := RemoveWorkerFromPool(d.ctx, logger, d.client, d.workerPool, d.nodename, d.namespace); err != nil {
+		logger.WithError(err).Error("Failed to deregister worker from pool")
+		return
+	}
+	logger.Info("The worker was drained")
+}
+
+// drained returns whether the worker has no calculations to finish.
+func (d *Drainer) drained(ctx context.Context) (bool, error) {
+	if d.executions.Running() != "" {
+		return false, nil
+	}
+	calculations, err := d.assigned(ctx)
+	if err != nil {
+		// The worker keeps draining until the calculations can be listed again.
+		d.logger.WithError(err).Warn("Couldn't list the calculations of the worker")
+		return false, nil
+	}
+	return len(calculations) == 0, nil
+}
+
+// assigned returns the calculations of the worker that didn't finish.
+func (d *Drainer) assigned(ctx context.Context) ([]v1.Calculation, error) {
+	calcList := &v1.CalculationList{}
+	if err := d.client.List(ctx, calcList, ctrlruntimeclient.InNamespace(d.namespace), ctrlruntimeclient.MatchingLabels{util.AssignWorkerLabel: d.hostname}); err != nil {
+		return nil, fmt.Errorf("couldn't get a list of calculations: %w", err)
+	}
+	var assigned []v1.Calculation
+	for _, calc := range calcList.Items {
+		if calc.DeletionTimestamp == nil && (calc.Phase == v1.CreatedPhase || calc.Phase == v1.ProcessingPhase) {
+			assigned = append(assigned, calc)
+		}
+	}
+	return assigned, nil
+}
+
+// reschedule reschedules the calculations that the worker didn't finish.
+func (d *Drainer) reschedule() error {
+	calculations, err := d.assigned(d.ctx)
+	if err != nil {
+		return err
+	}
+	for _, calc := range calculations {
+		d.logger.WithField("calculation", calc.Name).Info("Rescheduling the calculation of the draining worker")
+		if err := util.RescheduleCalculation(d.ctx, d.client, d.artifacts, &calc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
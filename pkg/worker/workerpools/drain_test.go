@@ -0,0 +1,152 @@
+package workerpools
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bulkv1 "github.com/vega-project/ccb-operator/pkg/apis/calculationbulk/v1"
+	v1 "github.com/vega-project/ccb-operator/pkg/apis/calculations/v1"
+	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/artifacts"
+	"github.com/vega-project/ccb-operator/pkg/util"
+)
+
+type fakeExecutions struct {
+	mu          sync.Mutex
+	running     string
+	interrupted []string
+}
+
+func (f *fakeExecutions) Running() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running
+}
+
+func (f *fakeExecutions) Interrupt() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := f.running
+	if name != "" {
+		f.interrupted = append(f.interrupted, name)
+	}
+	f.running = ""
+	return name
+}
+
+func TestDrain(t *testing.T) {
+	calculation := func(phase v1.CalculationPhase) *v1.Calculation {
+		return &v1.Calculation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "calc-1",
+				Namespace:  "vega",
+				Finalizers: []string{util.CleanupFinalizer},
+				Labels: map[string]string{
+					util.AssignWorkerLabel:    "test-worker",
+					util.BulkLabel:            "bulk",
+					util.CalculationNameLabel: "calc",
+				},
+			},
+			Assign: "test-worker",
+			Phase:  phase,
+		}
+	}
+
+	testCases := []struct {
+		name                string
+		running             string
+		calculation         *v1.Calculation
+		expectedInterrupted []string
+		expectedRescheduled bool
+	}{
+		{
+			name:        "an idle worker leaves the pool",
+			calculation: calculation(v1.CompletedPhase),
+		},
+		{
+			name:                "the calculation that doesn't finish in time is interrupted and rescheduled",
+			running:             "calc-1",
+			calculation:         calculation(v1.ProcessingPhase),
+			expectedInterrupted: []string{"calc-1"},
+			expectedRescheduled: true,
+		},
+		{
+			name:                "the calculation that wasn't executed yet is rescheduled",
+			calculation:         calculation(v1.CreatedPhase),
+			expectedRescheduled: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pool := &workersv1.WorkerPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "vega-workers", Namespace: "vega"},
+				Spec: workersv1.WorkerPoolSpec{
+					Workers: map[string]workersv1.Worker{
+						"test-node-1": {Name: "test-worker", Node: "test-node-1", State: workersv1.WorkerProcessingState},
+						"test-node-2": {Name: "test-another-worker", Node: "test-node-2", State: workersv1.WorkerAvailableState},
+					},
+				},
+			}
+			bulk := &bulkv1.CalculationBulk{
+				ObjectMeta:   metav1.ObjectMeta{Name: "bulk", Namespace: "vega"},
+				Calculations: map[string]bulkv1.Calculation{"calc": {Phase: tc.calculation.Phase}},
+				Status: bulkv1.CalculationBulkStatus{
+					Calculations: map[string]bulkv1.CalculationReference{"calc": {Name: "calc-1"}},
+				},
+			}
+			client := fakectrlruntimeclient.NewClientBuilder().WithObjects(pool, bulk, tc.calculation).Build()
+			executions := &fakeExecutions{running: tc.running}
+
+			d := NewDrainer(context.Background(), client, executions, artifacts.NewLocalStore(t.TempDir()), "test-worker", "test-node-1", "vega", "vega-workers", 50*time.Millisecond)
+			d.pollInterval = 10 * time.Millisecond
+			d.Drain()
+			d.Drain()
+			if !d.Draining() {
+				t.Fatal("expected the worker to be draining")
+			}
+			select {
+			case <-d.Done():
+			case <-time.After(10 * time.Second):
+				t.Fatal("the worker didn't drain")
+			}
+
+			if diff := cmp.Diff(tc.expectedInterrupted, executions.interrupted); diff != "" {
+				t.Fatal(diff)
+			}
+			actualPool := &workersv1.WorkerPool{}
+			if err := client.Get(context.Background(), ctrlruntimeclient.ObjectKeyFromObject(pool), actualPool); err != nil {
+				t.Fatal(err)
+			}
+			if _, exists := actualPool.Spec.Workers["test-node-1"]; exists || len(actualPool.Spec.Workers) != 1 {
+				t.Fatalf("expected only the drained worker to leave the pool, got %v", actualPool.Spec.Workers)
+			}
+
+			err := client.Get(context.Background(), types.NamespacedName{Namespace: "vega", Name: "calc-1"}, &v1.Calculation{})
+			if rescheduled := kerrors.IsNotFound(err); rescheduled != tc.expectedRescheduled {
+				t.Fatalf("expected the calculation to be rescheduled: %t, got %v", tc.expectedRescheduled, err)
+			}
+			actualBulk := &bulkv1.CalculationBulk{}
+			if err := client.Get(context.Background(), ctrlruntimeclient.ObjectKeyFromObject(bulk), actualBulk); err != nil {
+				t.Fatal(err)
+			}
+			expectedAttempt := 0
+			if tc.expectedRescheduled {
+				expectedAttempt = 1
+			}
+			if attempt := actualBulk.Status.Calculations["calc"].Attempt; attempt != expectedAttempt {
+				t.Fatalf("expected the attempt %d of the calculation in the bulk, got %d", expectedAttempt, attempt)
+			}
+		})
+	}
+}
@@ -20,13 +20,20 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	workersv1 "github.com/vega-project/ccb-operator/pkg/apis/workers/v1"
+	"github.com/vega-project/ccb-operator/pkg/util"
 )
 
 const (
 	controllerName = "workerpools"
 )
 
-func AddToManager(ctx context.Context, mgr manager.Manager, ns, hostname, nodename string, workerPool, namespace string) error {
+// drainer drains the worker when it's set to draining in its pool.
+type drainer interface {
+	Drain()
+	Draining() bool
+}
+
+func AddToManager(ctx context.Context, mgr manager.Manager, ns, hostname, nodename string, workerPool, namespace string, drainer drainer) error {
 	logger := logrus.WithField("controller", controllerName)
 	c, err := controller.New(controllerName, mgr, controller.Options{
 		MaxConcurrentReconciles: 1,
@@ -37,13 +44,14 @@ func AddToManager(ctx context.Context, mgr manager.Manager, ns, hostname, nodena
 			hostname:   hostname,
 			workerPool: workerPool,
 			namespace:  namespace,
+			drainer:    drainer,
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to construct controller: %w", err)
 	}
 
-	if err := c.Watch(source.Kind(mgr.GetCache(), &workersv1.WorkerPool{}, &workerPoolsHandler{namespace: ns})); err != nil {
+	if err := c.Watch(source.Kind(mgr.GetCache(), &workersv1.WorkerPool{}, &workerPoolsHandler{namespace: ns, hostname: hostname, nodename: nodename})); err != nil {
 		return fmt.Errorf("failed to create watch for clusterpools: %w", err)
 	}
 
@@ -52,6 +60,8 @@ func AddToManager(ctx context.Context, mgr manager.Manager, ns, hostname, nodena
 
 type workerPoolsHandler struct {
 	namespace string
+	hostname  string
+	nodename  string
 }
 
 func (h *workerPoolsHandler) Create(ctx context.Context, e event.TypedCreateEvent[*workersv1.WorkerPool], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
@@ -61,7 +71,16 @@ func (h *workerPoolsHandler) Create(ctx context.Context, e event.TypedCreateEven
 	q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: e.Object.Namespace, Name: e.Object.Name}})
 }
 
+// Update requeues the pool when the worker is set to draining in it.
 func (h *workerPoolsHandler) Update(ctx context.Context, e event.TypedUpdateEvent[*workersv1.WorkerPool], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if h.namespace != e.ObjectNew.Namespace {
+		return
+	}
+	worker, exists := e.ObjectNew.Spec.Workers[h.nodename]
+	if !exists || worker.Name != h.hostname || worker.State != workersv1.WorkerDrainingState {
+		return
+	}
+	q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: e.ObjectNew.Namespace, Name: e.ObjectNew.Name}})
 }
 
 func (h *workerPoolsHandler) Delete(ctx context.Context, e event.TypedDeleteEvent[*workersv1.WorkerPool], q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
@@ -103,6 +122,7 @@ func registerWorkerInPool(ctx context.Context, logger *logrus.Entry, client ctrl
 			}
 		}
 		pool.Spec.Workers[nodename] = worker
+		util.SetWorkerPoolConditions(pool)
 
 		logger.WithField("pod-name", hostname).WithField("node-name", nodename).Info("Updating WorkerPool...")
 		if err := client.Update(ctx, pool); err != nil {
@@ -126,6 +146,7 @@ func RemoveWorkerFromPool(ctx context.Context, logger *logrus.Entry, client ctrl
 
 		if pool.Spec.Workers != nil {
 			delete(pool.Spec.Workers, nodename)
+			util.SetWorkerPoolConditions(pool)
 			logger.WithField("node-name", nodename).Info("Removing worker from WorkerPool...")
 			if err := client.Update(ctx, pool); err != nil {
 				return fmt.Errorf("failed to update WorkerPool %s: %w", pool.Name, err)
@@ -145,6 +166,7 @@ type reconciler struct {
 	nodename   string
 	namespace  string
 	workerPool string
+	drainer    drainer
 }
 
 func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -161,6 +183,21 @@ func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reco
 func (r *reconciler) reconcile(ctx context.Context, req reconcile.Request, logger *logrus.Entry) error {
 	logger.Info("Starting reconciliation")
 
+	// A draining worker leaves the pool, and registers again once it's restarted.
+	if r.drainer.Draining() {
+		logger.Info("The worker is draining, not registering it")
+		return nil
+	}
+	pool := &workersv1.WorkerPool{}
+	if err := r.client.Get(ctx, ctrlruntimeclient.ObjectKey{Namespace: r.namespace, Name: r.workerPool}, pool); err != nil {
+		return fmt.Errorf("failed to get workerpool %s in namespace %s: %w", r.workerPool, r.namespace, err)
+	}
+	// The worker entries that a previous pod left draining are registered again.
+	if worker, exists := pool.Spec.Workers[r.nodename]; exists && worker.Name == r.hostname && worker.State == workersv1.WorkerDrainingState {
+		r.drainer.Drain()
+		return nil
+	}
+
 	if err := registerWorkerInPool(ctx, logger, r.client, r.workerPool, r.nodename, r.hostname, r.namespace); err != nil {
 		return fmt.Errorf("couldn't register worker in worker pool: %w", err)
 	}
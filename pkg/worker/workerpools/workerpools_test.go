@@ -24,7 +24,10 @@ func TestReconcile(t *testing.T) {
 		workerName string
 		nodename   string
 		workerPool []ctrlruntimeclient.Object
+		draining   bool
 		expected   []workersv1.WorkerPool
+		// expectedDrain is whether the worker is drained instead of registered.
+		expectedDrain bool
 	}{
 		{
 			name:       "basic case, no worker was previously registered",
@@ -51,6 +54,13 @@ func TestReconcile(t *testing.T) {
 							},
 						},
 					},
+					Status: workersv1.WorkerPoolStatus{
+						Conditions: []metav1.Condition{
+							{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Idle", Message: "1 of 1 workers available"},
+							{Type: "Progressing", Status: metav1.ConditionFalse, Reason: "Idle", Message: "1 of 1 workers available"},
+							{Type: "Degraded", Status: metav1.ConditionFalse, Reason: "AsExpected"},
+						},
+					},
 				},
 			},
 		},
@@ -115,6 +125,118 @@ func TestReconcile(t *testing.T) {
 							},
 						},
 					},
+					Status: workersv1.WorkerPoolStatus{
+						Conditions: []metav1.Condition{
+							{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Idle", Message: "3 of 3 workers available"},
+							{Type: "Progressing", Status: metav1.ConditionFalse, Reason: "Idle", Message: "3 of 3 workers available"},
+							{Type: "Degraded", Status: metav1.ConditionFalse, Reason: "AsExpected"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:       "the worker is set to draining, it is drained",
+			workerName: "test-worker",
+			nodename:   "test-node-1",
+			workerPool: []ctrlruntimeclient.Object{
+				&workersv1.WorkerPool{
+					ObjectMeta: metav1.ObjectMeta{Name: "vega-workers", Namespace: "vega"},
+					Spec: workersv1.WorkerPoolSpec{
+						Workers: map[string]workersv1.Worker{
+							"test-node-1": {
+								Name:                  "test-worker",
+								Node:                  "test-node-1",
+								RegisteredTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								LastUpdateTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								CalculationsProcessed: 0,
+								State:                 workersv1.WorkerDrainingState,
+							},
+						},
+					},
+				},
+			},
+			expected: []workersv1.WorkerPool{
+				{
+					TypeMeta:   metav1.TypeMeta{Kind: "WorkerPool", APIVersion: "vegaproject.io/v1"},
+					ObjectMeta: metav1.ObjectMeta{Name: "vega-workers", Namespace: "vega"},
+					Spec: workersv1.WorkerPoolSpec{
+						Workers: map[string]workersv1.Worker{
+							"test-node-1": {
+								Name:                  "test-worker",
+								Node:                  "test-node-1",
+								RegisteredTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								LastUpdateTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								CalculationsProcessed: 0,
+								State:                 workersv1.WorkerDrainingState,
+							},
+						},
+					},
+				},
+			},
+			expectedDrain: true,
+		},
+		{
+			name:       "a draining worker isn't registered again",
+			workerName: "test-worker",
+			nodename:   "test-node-1",
+			draining:   true,
+			workerPool: []ctrlruntimeclient.Object{
+				&workersv1.WorkerPool{
+					ObjectMeta: metav1.ObjectMeta{Name: "vega-workers", Namespace: "vega"},
+				},
+			},
+			expected: []workersv1.WorkerPool{
+				{
+					TypeMeta:   metav1.TypeMeta{Kind: "WorkerPool", APIVersion: "vegaproject.io/v1"},
+					ObjectMeta: metav1.ObjectMeta{Name: "vega-workers", Namespace: "vega"},
+				},
+			},
+		},
+		{
+			name:       "a worker that a previous pod left draining is registered again",
+			workerName: "test-worker",
+			nodename:   "test-node-1",
+			workerPool: []ctrlruntimeclient.Object{
+				&workersv1.WorkerPool{
+					ObjectMeta: metav1.ObjectMeta{Name: "vega-workers", Namespace: "vega"},
+					Spec: workersv1.WorkerPoolSpec{
+						Workers: map[string]workersv1.Worker{
+							"test-node-1": {
+								Name:                  "test-previous-worker",
+								Node:                  "test-node-1",
+								RegisteredTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								LastUpdateTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								CalculationsProcessed: 0,
+								State:                 workersv1.WorkerDrainingState,
+							},
+						},
+					},
+				},
+			},
+			expected: []workersv1.WorkerPool{
+				{
+					TypeMeta:   metav1.TypeMeta{Kind: "WorkerPool", APIVersion: "vegaproject.io/v1"},
+					ObjectMeta: metav1.ObjectMeta{Name: "vega-workers", Namespace: "vega"},
+					Spec: workersv1.WorkerPoolSpec{
+						Workers: map[string]workersv1.Worker{
+							"test-node-1": {
+								Name:                  "test-worker",
+								Node:                  "test-node-1",
+								RegisteredTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								LastUpdateTime:        &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)},
+								CalculationsProcessed: 0,
+								State:                 workersv1.WorkerAvailableState,
+							},
+						},
+					},
+					Status: workersv1.WorkerPoolStatus{
+						Conditions: []metav1.Condition{
+							{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Idle", Message: "1 of 1 workers available"},
+							{Type: "Progressing", Status: metav1.ConditionFalse, Reason: "Idle", Message: "1 of 1 workers available"},
+							{Type: "Degraded", Status: metav1.ConditionFalse, Reason: "AsExpected"},
+						},
+					},
 				},
 			},
 		},
@@ -122,6 +244,7 @@ func TestReconcile(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
+			drainer := &fakeDrainer{draining: tc.draining}
 			r := &reconciler{
 				logger:     logrus.WithField("test-name", tc.name),
 				client:     fakectrlruntimeclient.NewClientBuilder().WithObjects(tc.workerPool...).Build(),
@@ -129,6 +252,7 @@ func TestReconcile(t *testing.T) {
 				nodename:   tc.nodename,
 				namespace:  "vega",
 				workerPool: "vega-workers",
+				drainer:    drainer,
 			}
 			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "vega", Name: "vega-workers"}}
 			if err := r.reconcile(context.Background(), req, r.logger); err != nil {
@@ -140,8 +264,12 @@ func TestReconcile(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			if drainer.drained != tc.expectedDrain {
+				t.Fatalf("expected the worker to be drained: %t, got %t", tc.expectedDrain, drainer.drained)
+			}
+
 			reconcileWorkerPoolsForTests(actualWorkerPoolList.Items)
-			if diff := cmp.Diff(actualWorkerPoolList.Items, tc.expected, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(metav1.TypeMeta{}, "APIVersion", "Kind")); diff != "" {
+			if diff := cmp.Diff(actualWorkerPoolList.Items, tc.expected, cmpopts.IgnoreFields(metav1.ObjectMeta{}, "ResourceVersion"), cmpopts.IgnoreFields(metav1.TypeMeta{}, "APIVersion", "Kind"), cmpopts.IgnoreFields(metav1.Condition{}, "LastTransitionTime")); diff != "" {
 				t.Fatal(diff)
 			}
 
@@ -149,6 +277,19 @@ func TestReconcile(t *testing.T) {
 	}
 }
 
+type fakeDrainer struct {
+	draining bool
+	drained  bool
+}
+
+func (f *fakeDrainer) Drain() {
+	f.drained = true
+}
+
+func (f *fakeDrainer) Draining() bool {
+	return f.draining
+}
+
 func reconcileWorkerPoolsForTests(pools []workersv1.WorkerPool) {
 	zeroTime := &metav1.Time{Time: time.Date(1970, time.January, 1, 1, 0, 0, 0, time.Local)}
 	for i, pool := range pools {
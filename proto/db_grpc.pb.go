@@ -19,8 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	DbService_StoreData_FullMethodName = "/db.DbService/StoreData"
-	DbService_GetData_FullMethodName   = "/db.DbService/GetData"
+	DbService_StoreData_FullMethodName       = "/db.DbService/StoreData"
+	DbService_GetData_FullMethodName         = "/db.DbService/GetData"
+	DbService_StoreDataStream_FullMethodName = "/db.DbService/StoreDataStream"
+	DbService_GetDataStream_FullMethodName   = "/db.DbService/GetDataStream"
+	DbService_LookupByHash_FullMethodName    = "/db.DbService/LookupByHash"
+	DbService_GetDataBatch_FullMethodName    = "/db.DbService/GetDataBatch"
+	DbService_StoreDataBatch_FullMethodName  = "/db.DbService/StoreDataBatch"
 )
 
 // DbServiceClient is the client API for DbService service.
@@ -29,6 +34,17 @@ const (
 type DbServiceClient interface {
 	StoreData(ctx context.Context, in *StoreRequest, opts ...grpc.CallOption) (*StoreResponse, error)
 	GetData(ctx context.Context, in *GetDataRequest, opts ...grpc.CallOption) (*GetDataResponse, error)
+	// StoreDataStream uploads results that are too large for a single message.
+	StoreDataStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[StoreDataChunk, StoreResponse], error)
+	// GetDataStream downloads results that are too large for a single message.
+	GetDataStream(ctx context.Context, in *GetDataRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetDataChunk], error)
+	// LookupByHash retrieves the results that were produced by the exact same inputs.
+	LookupByHash(ctx context.Context, in *LookupByHashRequest, opts ...grpc.CallOption) (*GetDataResponse, error)
+	// GetDataBatch retrieves the results of many calculations in a single call.
+	GetDataBatch(ctx context.Context, in *GetDataBatchRequest, opts ...grpc.CallOption) (*GetDataBatchResponse, error)
+	// StoreDataBatch stores the results of many calculations in a single call. Either all the
+	// results are stored, or none of them.
+	StoreDataBatch(ctx context.Context, in *StoreDataBatchRequest, opts ...grpc.CallOption) (*StoreDataBatchResponse, error)
 }
 
 type dbServiceClient struct {
@@ -59,12 +75,85 @@ func (c *dbServiceClient) GetData(ctx context.Context, in *GetDataRequest, opts
 	return out, nil
 }
 
+func (c *dbServiceClient) StoreDataStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[StoreDataChunk, StoreResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DbService_ServiceDesc.Streams[0], DbService_StoreDataStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StoreDataChunk, StoreResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DbService_StoreDataStreamClient = grpc.ClientStreamingClient[StoreDataChunk, StoreResponse]
+
+func (c *dbServiceClient) GetDataStream(ctx context.Context, in *GetDataRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetDataChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DbService_ServiceDesc.Streams[1], DbService_GetDataStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetDataRequest, GetDataChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DbService_GetDataStreamClient = grpc.ServerStreamingClient[GetDataChunk]
+
+func (c *dbServiceClient) LookupByHash(ctx context.Context, in *LookupByHashRequest, opts ...grpc.CallOption) (*GetDataResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetDataResponse)
+	err := c.cc.Invoke(ctx, DbService_LookupByHash_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dbServiceClient) GetDataBatch(ctx context.Context, in *GetDataBatchRequest, opts ...grpc.CallOption) (*GetDataBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetDataBatchResponse)
+	err := c.cc.Invoke(ctx, DbService_GetDataBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dbServiceClient) StoreDataBatch(ctx context.Context, in *StoreDataBatchRequest, opts ...grpc.CallOption) (*StoreDataBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StoreDataBatchResponse)
+	err := c.cc.Invoke(ctx, DbService_StoreDataBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DbServiceServer is the server API for DbService service.
 // All implementations should embed UnimplementedDbServiceServer
 // for forward compatibility.
 type DbServiceServer interface {
 	StoreData(context.Context, *StoreRequest) (*StoreResponse, error)
 	GetData(context.Context, *GetDataRequest) (*GetDataResponse, error)
+	// StoreDataStream uploads results that are too large for a single message.
+	StoreDataStream(grpc.ClientStreamingServer[StoreDataChunk, StoreResponse]) error
+	// GetDataStream downloads results that are too large for a single message.
+	GetDataStream(*GetDataRequest, grpc.ServerStreamingServer[GetDataChunk]) error
+	// LookupByHash retrieves the results that were produced by the exact same inputs.
+	LookupByHash(context.Context, *LookupByHashRequest) (*GetDataResponse, error)
+	// GetDataBatch retrieves the results of many calculations in a single call.
+	GetDataBatch(context.Context, *GetDataBatchRequest) (*GetDataBatchResponse, error)
+	// StoreDataBatch stores the results of many calculations in a single call. Either all the
+	// results are stored, or none of them.
+	StoreDataBatch(context.Context, *StoreDataBatchRequest) (*StoreDataBatchResponse, error)
 }
 
 // UnimplementedDbServiceServer should be embedded to have
@@ -80,6 +169,21 @@ func (UnimplementedDbServiceServer) StoreData(context.Context, *StoreRequest) (*
 func (UnimplementedDbServiceServer) GetData(context.Context, *GetDataRequest) (*GetDataResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetData not implemented")
 }
+func (UnimplementedDbServiceServer) StoreDataStream(grpc.ClientStreamingServer[StoreDataChunk, StoreResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StoreDataStream not implemented")
+}
+func (UnimplementedDbServiceServer) GetDataStream(*GetDataRequest, grpc.ServerStreamingServer[GetDataChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method GetDataStream not implemented")
+}
+func (UnimplementedDbServiceServer) LookupByHash(context.Context, *LookupByHashRequest) (*GetDataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupByHash not implemented")
+}
+func (UnimplementedDbServiceServer) GetDataBatch(context.Context, *GetDataBatchRequest) (*GetDataBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDataBatch not implemented")
+}
+func (UnimplementedDbServiceServer) StoreDataBatch(context.Context, *StoreDataBatchRequest) (*StoreDataBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StoreDataBatch not implemented")
+}
 func (UnimplementedDbServiceServer) testEmbeddedByValue() {}
 
 // UnsafeDbServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -136,6 +240,78 @@ func _DbService_GetData_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DbService_StoreDataStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DbServiceServer).StoreDataStream(&grpc.GenericServerStream[StoreDataChunk, StoreResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DbService_StoreDataStreamServer = grpc.ClientStreamingServer[StoreDataChunk, StoreResponse]
+
+func _DbService_GetDataStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetDataRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DbServiceServer).GetDataStream(m, &grpc.GenericServerStream[GetDataRequest, GetDataChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DbService_GetDataStreamServer = grpc.ServerStreamingServer[GetDataChunk]
+
+func _DbService_LookupByHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupByHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DbServiceServer).LookupByHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DbService_LookupByHash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DbServiceServer).LookupByHash(ctx, req.(*LookupByHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DbService_GetDataBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDataBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DbServiceServer).GetDataBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DbService_GetDataBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DbServiceServer).GetDataBatch(ctx, req.(*GetDataBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DbService_StoreDataBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StoreDataBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DbServiceServer).StoreDataBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DbService_StoreDataBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DbServiceServer).StoreDataBatch(ctx, req.(*StoreDataBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DbService_ServiceDesc is the grpc.ServiceDesc for DbService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -151,7 +327,30 @@ var DbService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetData",
 			Handler:    _DbService_GetData_Handler,
 		},
+		{
+			MethodName: "LookupByHash",
+			Handler:    _DbService_LookupByHash_Handler,
+		},
+		{
+			MethodName: "GetDataBatch",
+			Handler:    _DbService_GetDataBatch_Handler,
+		},
+		{
+			MethodName: "StoreDataBatch",
+			Handler:    _DbService_StoreDataBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StoreDataStream",
+			Handler:       _DbService_StoreDataStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetDataStream",
+			Handler:       _DbService_GetDataStream_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/db.proto",
 }